@@ -4,6 +4,7 @@ import io.muun.apollo.data.external.Globals;
 import io.muun.apollo.domain.errors.InvalidPaymentRequestError;
 import io.muun.apollo.domain.libwallet.errors.AddressDerivationError;
 import io.muun.apollo.domain.libwallet.errors.InvoiceParsingError;
+import io.muun.apollo.domain.libwallet.errors.LibwalletInitError;
 import io.muun.apollo.domain.libwallet.errors.LibwalletEmergencyKitError;
 import io.muun.apollo.domain.libwallet.errors.LibwalletSigningError;
 import io.muun.apollo.domain.libwallet.errors.LibwalletVerificationError;
@@ -52,13 +53,18 @@ import java.util.Locale;
 public class LibwalletBridge {
 
     /**
-     * Initialize libwallet.
+     * Initialize libwallet. An invalid configuration is a programming error, so it's surfaced
+     * right away instead of failing every later call.
      */
     public static void init(String dataDir) {
         final Config config = new Config();
         config.setDataDir(dataDir);
 
-        Libwallet.init(config);
+        try {
+            Libwallet.init(config);
+        } catch (Exception e) {
+            throw new LibwalletInitError(e);
+        }
     }
 
     /**
@@ -0,0 +1,6 @@
+package io.muun.apollo.domain.libwallet.errors
+
+import io.muun.apollo.domain.errors.MuunError
+
+class LibwalletInitError(cause: Throwable):
+    MuunError("Libwallet rejected its configuration", cause)
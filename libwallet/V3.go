@@ -8,6 +8,7 @@ import (
 	"github.com/muun/libwallet/addresses"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 )
 
@@ -21,6 +22,7 @@ type coinV3 struct {
 	KeyPath       string
 	Amount        btcutil.Amount
 	MuunSignature []byte
+	SigHashes     *txscript.TxSigHashes // shared by the inputs of a batch, may be nil
 }
 
 func (c *coinV3) SignInput(index int, tx *wire.MsgTx, userKey *HDPrivateKey, muunKey *HDPublicKey) error {
@@ -99,5 +101,5 @@ func (c *coinV3) signature(index int, tx *wire.MsgTx, userKey *HDPublicKey, muun
 	}
 
 	return signNonNativeSegwitInput(
-		index, tx, signingKey, redeemScript, witnessScript, c.Amount)
+		index, tx, c.SigHashes, signingKey, redeemScript, witnessScript, c.Amount)
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/muun/libwallet/addresses"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 )
 
@@ -21,6 +22,7 @@ type coinV4 struct {
 	KeyPath       string
 	Amount        btcutil.Amount
 	MuunSignature []byte
+	SigHashes     *txscript.TxSigHashes // shared by the inputs of a batch, may be nil
 }
 
 func (c *coinV4) SignInput(index int, tx *wire.MsgTx, userKey *HDPrivateKey, muunKey *HDPublicKey) error {
@@ -86,7 +88,7 @@ func (c *coinV4) signature(index int, tx *wire.MsgTx, userKey *HDPublicKey, muun
 	}
 
 	return signNativeSegwitInput(
-		index, tx, signingKey, witnessScript, c.Amount)
+		index, tx, c.SigHashes, signingKey, witnessScript, c.Amount)
 }
 
 func createWitnessScriptV4(userKey, muunKey *HDPublicKey) ([]byte, error) {
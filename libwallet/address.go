@@ -1,6 +1,7 @@
 package libwallet
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/muun/libwallet/addresses"
 	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/retry"
 
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcutil"
@@ -138,15 +140,26 @@ func DoPaymentRequestCall(url string, network *Network) (*MuunPaymentURI, error)
 	req.Header.Set("Accept", "application/bitcoin-paymentrequest")
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, errors.Errorf(ErrNetwork, "failed to make request to: %s", url)
-	}
-	defer resp.Body.Close()
+	var body []byte
+	err = retry.Do(context.Background(), retry.DefaultPolicy, func() error {
+		resp, err := client.Do(req)
+		if err != nil {
+			return errors.Errorf(ErrNetwork, "failed to make request to: %s", url)
+		}
+		defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return retry.Permanent(errors.Errorf(ErrNetwork, "request to %s failed with status %d", url, resp.StatusCode))
+		}
+
+		body, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return errors.Errorf(ErrNetwork, "Failed to read body response: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, errors.Errorf(ErrNetwork, "Failed to read body response: %w", err)
+		return nil, err
 	}
 
 	payReq := &PaymentRequest{}
@@ -209,3 +222,63 @@ func buildUriFromString(rawInput string, targetScheme string) (string, *url.URL)
 
 	return newUri, components
 }
+
+// PaymentURIOptions are the optional BIP21 parameters of a unified payment
+// URI.
+type PaymentURIOptions struct {
+	Label   string
+	Message string
+}
+
+// BuildUnifiedPaymentURI returns a BIP21 "bitcoin:" URI paying to the
+// on-chain address, with the lightning invoice in its lightning parameter,
+// so a single QR can be paid by any wallet, whether it supports lightning or
+// not. The amount is the one of the invoice, if it has one, in BTC with msat
+// fractions rounded down. Bech32 addresses and the invoice are upper cased
+// so the QR can be encoded in alphanumeric mode, which is denser.
+func BuildUnifiedPaymentURI(address, invoice string, network *Network, opts *PaymentURIOptions) (_ string, err error) {
+	defer recordErrors("BuildUnifiedPaymentURI", &err)
+
+	decoded, err := btcutil.DecodeAddress(address, network.network)
+	if err != nil {
+		return "", errors.Errorf(ErrInvalidURI, "invalid address: %v", err)
+	}
+	if !decoded.IsForNet(network.network) {
+		return "", errors.New(ErrInvalidURI, "Network mismatch")
+	}
+	parsed, err := ParseInvoice(invoice, network)
+	if err != nil {
+		return "", err
+	}
+	if opts == nil {
+		opts = &PaymentURIOptions{}
+	}
+
+	scheme := bitcoinScheme
+	encodedAddress := decoded.EncodeAddress()
+	encodedInvoice := parsed.RawInvoice
+	switch decoded.(type) {
+	case *btcutil.AddressWitnessPubKeyHash, *btcutil.AddressWitnessScriptHash:
+		scheme = strings.ToUpper(scheme)
+		encodedAddress = strings.ToUpper(encodedAddress)
+		encodedInvoice = strings.ToUpper(encodedInvoice)
+	}
+
+	// BIP21 params are percent encoded, spaces included
+	escape := func(s string) string {
+		return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	}
+	var params []string
+	if parsed.Amount != nil {
+		params = append(params, "amount="+parsed.Amount.FormatBtc())
+	}
+	if opts.Label != "" {
+		params = append(params, "label="+escape(opts.Label))
+	}
+	if opts.Message != "" {
+		params = append(params, "message="+escape(opts.Message))
+	}
+	params = append(params, "lightning="+encodedInvoice)
+
+	return scheme + encodedAddress + "?" + strings.Join(params, "&"), nil
+}
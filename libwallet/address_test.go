@@ -319,3 +319,74 @@ func TestDoPaymentRequestCall(t *testing.T) {
 		t.Fatalf("decoded URI struct does not match expected, %+v != %+v", paymentURI, expected)
 	}
 }
+
+func TestBuildUnifiedPaymentURI(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri, err := BuildUnifiedPaymentURI(address, invoice, network, &PaymentURIOptions{
+		Label:   "coffee shop",
+		Message: "latte & croissant",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "bitcoin:" + address + "?amount=0.00001500&label=coffee%20shop&message=latte%20%26%20croissant&lightning=" + invoice
+	if uri != expected {
+		t.Fatalf("expected %v, got %v", expected, uri)
+	}
+
+	// wallets with lightning support pay the invoice
+	parsed, err := GetPaymentURI(uri, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Invoice == nil || parsed.Invoice.Sats != 1500 {
+		t.Fatalf("expected the invoice to be parsed, got %+v", parsed)
+	}
+
+	// bech32 addresses are upper cased along with the invoice
+	segwitAddress := "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"
+	uri, err = BuildUnifiedPaymentURI(segwitAddress, invoice, network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri != strings.ToUpper("bitcoin:"+segwitAddress)+"?amount=0.00001500&lightning="+strings.ToUpper(invoice) {
+		t.Fatalf("unexpected upper cased uri %v", uri)
+	}
+	if parsed, err := GetPaymentURI(uri, network); err != nil || parsed.Invoice == nil {
+		t.Fatalf("expected the upper cased uri to be parsed, got %v", err)
+	}
+
+	if _, err := BuildUnifiedPaymentURI(invalidAddress, invoice, network, nil); ErrorCode(err) != ErrInvalidURI {
+		t.Errorf("expected invalid address to fail, got %v", err)
+	}
+	if _, err := BuildUnifiedPaymentURI(address, randomText, network, nil); err == nil {
+		t.Error("expected invalid invoice to fail")
+	}
+	if _, err := BuildUnifiedPaymentURI(address, invoice, Mainnet(), nil); err == nil {
+		t.Error("expected network mismatch to fail")
+	}
+}
@@ -15,6 +15,8 @@ const (
 	SubmarineSwapV1 = 101
 	SubmarineSwapV2 = 102
 	IncomingSwap    = 201
+	Vault           = 301
+	Unvault         = 302
 )
 
 type WalletAddress struct {
@@ -0,0 +1,102 @@
+package addresses
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// CreateAddressVault returns a P2WSH WalletAddress that can be spent by the
+// user and Muun together, or by the cold key alone to claw funds back.
+func CreateAddressVault(userKey, muunKey, coldKey *hdkeychain.ExtendedKey, path string, network *chaincfg.Params) (*WalletAddress, error) {
+	witnessScript, err := CreateWitnessScriptVault(userKey, muunKey, coldKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate witness script vault: %w", err)
+	}
+	return createWitnessScriptAddress(witnessScript, Vault, path, network)
+}
+
+// CreateAddressUnvault returns a P2WSH WalletAddress that can be spent by the
+// user and Muun together once delay blocks passed since it was funded, or
+// by the cold key alone at any time.
+func CreateAddressUnvault(userKey, muunKey, coldKey *hdkeychain.ExtendedKey, delay int64, path string, network *chaincfg.Params) (*WalletAddress, error) {
+	witnessScript, err := CreateWitnessScriptUnvault(userKey, muunKey, coldKey, delay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate witness script unvault: %w", err)
+	}
+	return createWitnessScriptAddress(witnessScript, Unvault, path, network)
+}
+
+func CreateWitnessScriptVault(userKey, muunKey, coldKey *hdkeychain.ExtendedKey) ([]byte, error) {
+	return createVaultScript(userKey, muunKey, coldKey, 0)
+}
+
+func CreateWitnessScriptUnvault(userKey, muunKey, coldKey *hdkeychain.ExtendedKey, delay int64) ([]byte, error) {
+	if delay <= 0 || delay > 0xffff {
+		return nil, fmt.Errorf("invalid unvault delay %v", delay)
+	}
+	return createVaultScript(userKey, muunKey, coldKey, delay)
+}
+
+// createVaultScript creates the script
+//
+//	OP_IF
+//	  [<delay> OP_CHECKSEQUENCEVERIFY OP_DROP]
+//	  2 <user> <muun> 2 OP_CHECKMULTISIG
+//	OP_ELSE
+//	  <cold> OP_CHECKSIG
+//	OP_ENDIF
+//
+// where the delay is only included if not zero.
+func createVaultScript(userKey, muunKey, coldKey *hdkeychain.ExtendedKey, delay int64) ([]byte, error) {
+	userPublicKey, err := userKey.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	muunPublicKey, err := muunKey.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	coldPublicKey, err := coldKey.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+
+	builder := txscript.NewScriptBuilder()
+	builder.AddOp(txscript.OP_IF)
+	if delay != 0 {
+		builder.AddInt64(delay)
+		builder.AddOp(txscript.OP_CHECKSEQUENCEVERIFY)
+		builder.AddOp(txscript.OP_DROP)
+	}
+	builder.AddOp(txscript.OP_2)
+	builder.AddData(userPublicKey.SerializeCompressed())
+	builder.AddData(muunPublicKey.SerializeCompressed())
+	builder.AddOp(txscript.OP_2)
+	builder.AddOp(txscript.OP_CHECKMULTISIG)
+	builder.AddOp(txscript.OP_ELSE)
+	builder.AddData(coldPublicKey.SerializeCompressed())
+	builder.AddOp(txscript.OP_CHECKSIG)
+	builder.AddOp(txscript.OP_ENDIF)
+
+	return builder.Script()
+}
+
+func createWitnessScriptAddress(witnessScript []byte, version int, path string, network *chaincfg.Params) (*WalletAddress, error) {
+	witnessScript256 := sha256.Sum256(witnessScript)
+
+	address, err := btcutil.NewAddressWitnessScriptHash(witnessScript256[:], network)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WalletAddress{
+		address:        address.EncodeAddress(),
+		version:        version,
+		derivationPath: path,
+	}, nil
+}
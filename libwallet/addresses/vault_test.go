@@ -0,0 +1,48 @@
+package addresses
+
+import (
+	"testing"
+)
+
+func TestCreateAddressVault(t *testing.T) {
+	const (
+		addressPath = "m/schema:1'/recovery:1'/external:1/2"
+
+		basePK          = "tpubDBf5wCeqg3KrLJiXaveDzD5JtFJ1ss9NVvFMx4RYS73SjwPEEawcAQ7V1B5DGM4gunWDeYNrnkc49sUaf7mS1wUKiJJQD6WEctExUQoLvrg"
+		baseCosigningPK = "tpubDB22PFkUaHoB7sgxh7exCivV5rAevVSzbB8WkFCCdbHq39r8xnYexiot4NGbi8PM6E1ySVeaHsoDeMYb6EMndpFrzVmuX8iQNExzwNpU61B"
+		basePath        = "m/schema:1'/recovery:1'"
+	)
+
+	userKey := derive(parseKey(basePK), basePath, addressPath)
+	muunKey := derive(parseKey(baseCosigningPK), basePath, addressPath)
+	coldKey := derive(parseKey(basePK), basePath, "m/schema:1'/recovery:1'/external:1/3")
+
+	vault, err := CreateAddressVault(userKey, muunKey, coldKey, addressPath, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unvault, err := CreateAddressUnvault(userKey, muunKey, coldKey, 144, addressPath, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDelay, err := CreateAddressUnvault(userKey, muunKey, coldKey, 145, addressPath, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vault.Version() != Vault || unvault.Version() != Unvault {
+		t.Errorf("unexpected versions %v and %v", vault.Version(), unvault.Version())
+	}
+	if vault.DerivationPath() != addressPath || unvault.DerivationPath() != addressPath {
+		t.Errorf("unexpected derivation paths %v and %v", vault.DerivationPath(), unvault.DerivationPath())
+	}
+	if vault.Address() == unvault.Address() || unvault.Address() == otherDelay.Address() {
+		t.Errorf("expected different addresses for each script")
+	}
+
+	for _, delay := range []int64{0, -1, 0x10000} {
+		if _, err := CreateAddressUnvault(userKey, muunKey, coldKey, delay, addressPath, network); err == nil {
+			t.Errorf("expected delay %v to be rejected", delay)
+		}
+	}
+}
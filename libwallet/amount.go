@@ -0,0 +1,129 @@
+package libwallet
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/muun/libwallet/errors"
+)
+
+const (
+	msatsPerSat = 1000
+	satsPerBtc  = 100000000
+)
+
+// MaxMoneySat is the total supply of bitcoin in sats. No valid amount is
+// larger, which also guarantees amounts can be converted to msats.
+const MaxMoneySat = 21000000 * satsPerBtc
+
+// Amount is an amount of bitcoin with millisatoshi precision, as required by
+// lightning. Apps should prefer it over raw sat or msat integers.
+type Amount struct {
+	msats int64
+}
+
+// NewAmountFromSats returns an amount of the given sats, failing with
+// ErrInvalidAmount if it's negative or larger than MaxMoneySat.
+func NewAmountFromSats(sats int64) (*Amount, error) {
+	if err := validateAmountSat(sats); err != nil {
+		return nil, err
+	}
+	return &Amount{sats * msatsPerSat}, nil
+}
+
+// NewAmountFromMsats returns an amount of the given msats.
+func NewAmountFromMsats(msats int64) *Amount {
+	return &Amount{msats}
+}
+
+// Msats returns the amount in msats.
+func (a *Amount) Msats() int64 {
+	return a.msats
+}
+
+// Sats returns the amount in sats, rounding msats down.
+func (a *Amount) Sats() int64 {
+	return a.msats / msatsPerSat
+}
+
+// Add returns the sum of both amounts, failing on overflow.
+func (a *Amount) Add(b *Amount) (*Amount, error) {
+	sum := a.msats + b.msats
+	if (b.msats > 0 && sum < a.msats) || (b.msats < 0 && sum > a.msats) {
+		return nil, fmt.Errorf("adding %v to %v overflows", b, a)
+	}
+	return &Amount{sum}, nil
+}
+
+// FormatSats returns the amount in sats, with decimals only if it has a msat
+// fraction, eg "1500" or "1500.123".
+func (a *Amount) FormatSats() string {
+	if a.msats%msatsPerSat == 0 {
+		return strconv.FormatInt(a.Sats(), 10)
+	}
+	return strconv.FormatFloat(float64(a.msats)/msatsPerSat, 'f', -1, 64)
+}
+
+// FormatBtc returns the amount in BTC with 8 decimals, eg "0.00001500".
+// Msat fractions are rounded down.
+func (a *Amount) FormatBtc() string {
+	sats := a.Sats()
+	sign := ""
+	if sats < 0 {
+		sign = "-"
+		sats = -sats
+	}
+	return fmt.Sprintf("%v%d.%08d", sign, sats/satsPerBtc, sats%satsPerBtc)
+}
+
+// FormatFiat returns the amount converted to a fiat currency at the given
+// price of 1 BTC, with 2 decimals and the currency code, eg "12.34 USD".
+func (a *Amount) FormatFiat(pricePerBtc float64, currencyCode string) string {
+	value := float64(a.msats) / (msatsPerSat * satsPerBtc) * pricePerBtc
+	return fmt.Sprintf("%.2f %v", value, currencyCode)
+}
+
+// String returns the amount in sats.
+func (a *Amount) String() string {
+	return a.FormatSats() + " sats"
+}
+
+func (a *Amount) toMilliSatoshi() lnwire.MilliSatoshi {
+	return lnwire.MilliSatoshi(a.msats)
+}
+
+// validateAmountSat checks that a user supplied amount in sats is in range.
+func validateAmountSat(sats int64) error {
+	if sats < 0 {
+		return errors.Errorf(ErrInvalidAmount, "amount can't be negative: %v sats", sats)
+	}
+	if sats > MaxMoneySat {
+		return errors.Errorf(ErrInvalidAmount, "amount exceeds the bitcoin supply: %v sats", sats)
+	}
+	return nil
+}
+
+// validateInvoiceAmount checks that a user supplied invoice amount is in
+// range and at least 1 sat, since invoices store their amount in sats and 0
+// means any amount.
+func validateInvoiceAmount(a *Amount) error {
+	if err := validateAmount(a); err != nil {
+		return err
+	}
+	if a.msats < msatsPerSat {
+		return errors.Errorf(ErrInvalidAmount, "amount below 1 sat: %v msats", a.msats)
+	}
+	return nil
+}
+
+// validateAmount checks that a user supplied amount is in range.
+func validateAmount(a *Amount) error {
+	if a.msats < 0 {
+		return errors.Errorf(ErrInvalidAmount, "amount can't be negative: %v msats", a.msats)
+	}
+	if a.msats > MaxMoneySat*msatsPerSat {
+		return errors.Errorf(ErrInvalidAmount, "amount exceeds the bitcoin supply: %v msats", a.msats)
+	}
+	return nil
+}
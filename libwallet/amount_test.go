@@ -0,0 +1,141 @@
+package libwallet
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		desc  string
+		msats int64
+		sats  string
+		btc   string
+		fiat  string
+	}{
+		{"zero", 0, "0", "0.00000000", "0.00 USD"},
+		{"whole sats", 1500000, "1500", "0.00001500", "0.15 USD"},
+		{"msat fraction", 1500123, "1500.123", "0.00001500", "0.15 USD"},
+		{"one btc", 100000000000, "100000000", "1.00000000", "10000.00 USD"},
+		{"negative", -2500000, "-2500", "-0.00002500", "-0.25 USD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			amount := NewAmountFromMsats(tt.msats)
+			if got := amount.FormatSats(); got != tt.sats {
+				t.Errorf("FormatSats() = %v, want %v", got, tt.sats)
+			}
+			if got := amount.FormatBtc(); got != tt.btc {
+				t.Errorf("FormatBtc() = %v, want %v", got, tt.btc)
+			}
+			if got := amount.FormatFiat(10000, "USD"); got != tt.fiat {
+				t.Errorf("FormatFiat() = %v, want %v", got, tt.fiat)
+			}
+		})
+	}
+
+	t.Run("from sats", func(t *testing.T) {
+		amount, err := NewAmountFromSats(1234)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if amount.Msats() != 1234000 || amount.Sats() != 1234 {
+			t.Fatalf("unexpected amount %v", amount)
+		}
+		if _, err := NewAmountFromSats(math.MaxInt64 / 10); ErrorCode(err) != ErrInvalidAmount {
+			t.Fatalf("expected ErrInvalidAmount, got %v", err)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		valid := []int64{0, 1, MaxMoneySat}
+		for _, sats := range valid {
+			if err := validateAmountSat(sats); err != nil {
+				t.Errorf("expected %v sats to be valid, got %v", sats, err)
+			}
+		}
+		invalid := []int64{-1, MaxMoneySat + 1, math.MaxInt64, math.MinInt64}
+		for _, sats := range invalid {
+			if err := validateAmountSat(sats); ErrorCode(err) != ErrInvalidAmount {
+				t.Errorf("expected %v sats to be invalid, got %v", sats, err)
+			}
+		}
+		if err := validateAmount(NewAmountFromMsats(-1)); ErrorCode(err) != ErrInvalidAmount {
+			t.Errorf("expected negative msats to be invalid, got %v", err)
+		}
+	})
+
+	t.Run("add", func(t *testing.T) {
+		sum, err := NewAmountFromMsats(1500).Add(NewAmountFromMsats(600))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum.Msats() != 2100 {
+			t.Fatalf("unexpected sum %v", sum)
+		}
+		if _, err := NewAmountFromMsats(math.MaxInt64).Add(NewAmountFromMsats(1)); err == nil {
+			t.Fatal("expected overflow error")
+		}
+	})
+}
+
+func TestCreateInvoiceWithMsatAmount(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{
+		AmountSat: 1,
+		Amount:    NewAmountFromMsats(1500500),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := ParseInvoice(raw, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invoice.Amount == nil || invoice.Amount.Msats() != 1500500 {
+		t.Fatalf("unexpected invoice amount %v", invoice.Amount)
+	}
+	if invoice.Sats != 1500 {
+		t.Fatalf("unexpected invoice sats %v", invoice.Sats)
+	}
+
+	_, err = CreateInvoice(network, userKey, &RouteHints{
+		Pubkey: "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+	}, &InvoiceOptions{AmountSat: -1})
+	if ErrorCode(err) != ErrInvalidAmount {
+		t.Fatalf("expected ErrInvalidAmount, got %v", err)
+	}
+
+	_, err = CreateInvoice(network, userKey, &RouteHints{
+		Pubkey: "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+	}, &InvoiceOptions{Amount: NewAmountFromMsats(999)})
+	if ErrorCode(err) != ErrInvalidAmount {
+		t.Fatalf("expected ErrInvalidAmount for a sub-sat amount, got %v", err)
+	}
+
+	swap := &IncomingSwap{PaymentHash: randomBytes(32), PaymentAmountSat: math.MaxInt64}
+	if err := swap.VerifyFulfillable(userKey, network); ErrorCode(err) != ErrInvalidAmount {
+		t.Fatalf("expected ErrInvalidAmount, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/sphinx"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// ampRequired is the option_amp feature bit of amp invoices. The lnd version
+// we use doesn't know it.
+const ampRequired lnwire.FeatureBit = 30
+
+// recordAmpShard stores the swap as a shard of a payment to an amp invoice.
+// Shards have their own payment hashes, unknown to the wallet until every
+// shard of the set arrived and their preimages can be derived. Then an
+// invoice is stored for each shard, so they're verified and fulfilled like
+// payments to regular invoices. Until then, an error with the
+// ErrIncompleteAmpSet code is returned, and the swaps should be verified
+// again once more shards arrive. Swaps for payment hashes already stored, or
+// not addressed to amp invoices, are left alone.
+func (s *IncomingSwap) recordAmpShard(userKey *HDPrivateKey, net *Network) error {
+	if len(s.SphinxPacket) == 0 {
+		return nil
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	found, err := db.HasInvoice(s.PaymentHash)
+	if err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+
+	invoices, err := db.FindAmpInvoices()
+	if err != nil {
+		return err
+	}
+
+	// The sphinx only decodes with the identity key of the invoice paid
+	for i := range invoices {
+		invoice := &invoices[i]
+
+		identityKeyPath := invoiceIdentityKeyPath(invoice)
+		nodeKey, err := deriveIdentityKey(userKey, identityKeyPath.String())
+		if err != nil {
+			return fmt.Errorf("VerifyFulfillable: failed to derive key: %w", err)
+		}
+
+		payload, amp, err := sphinx.DecodeAMP(s.SphinxPacket, s.PaymentHash, nodeKey, 0, net.network)
+		if err != nil {
+			continue
+		}
+		if amp == nil {
+			return nil
+		}
+
+		if payload.MPP == nil {
+			return fmt.Errorf("VerifyFulfillable: amp shard has no payment secret")
+		}
+		paymentAddr := payload.MPP.PaymentAddr()
+		if !bytes.Equal(paymentAddr[:], invoice.PaymentSecret) {
+			return fmt.Errorf("VerifyFulfillable: amp shard payment secret does not match")
+		}
+
+		err = db.SaveAmpShard(&walletdb.AmpShard{
+			PaymentHash:        s.PaymentHash,
+			InvoicePaymentHash: invoice.PaymentHash,
+			SetId:              amp.SetID[:],
+			ChildIndex:         amp.ChildIndex,
+			Share:              amp.RootShare[:],
+			AmountMsat:         uint64(payload.ForwardingInfo().AmountToForward),
+			TotalMsat:          uint64(payload.MultiPath().TotalMsat()),
+		})
+		if err != nil {
+			return fmt.Errorf("VerifyFulfillable: %w", err)
+		}
+
+		return completeAmpSet(db, invoice, amp.SetID[:])
+	}
+
+	return nil
+}
+
+// completeAmpSet stores an invoice for each shard of the set if all of them
+// arrived. Shards of a hold invoice are held until it's settled, see
+// SettleInvoice.
+func completeAmpSet(db *walletdb.DB, invoice *walletdb.Invoice, setId []byte) error {
+	shards, err := db.ListAmpShards(setId)
+	if err != nil {
+		return fmt.Errorf("VerifyFulfillable: %w", err)
+	}
+
+	total := shards[0].TotalMsat
+	var received uint64
+	for _, shard := range shards {
+		if shard.TotalMsat != total || !bytes.Equal(shard.InvoicePaymentHash, invoice.PaymentHash) {
+			return fmt.Errorf("VerifyFulfillable: amp shards of set %x don't match", setId)
+		}
+		received += shard.AmountMsat
+	}
+	if received < total {
+		return errors.Errorf(ErrIncompleteAmpSet,
+			"VerifyFulfillable: amp set incomplete, received %v of %v msat", received, total)
+	}
+	if invoice.AmountSat != 0 && total < uint64(invoice.AmountSat)*1000 {
+		return fmt.Errorf("VerifyFulfillable: amp set amount (%v msat) does not match invoice amount (%v)",
+			total, invoice.AmountSat)
+	}
+
+	var root [32]byte
+	for _, shard := range shards {
+		for i := range root {
+			root[i] ^= shard.Share[i]
+		}
+	}
+
+	now := walletNow()
+	return db.Transaction(func(tx *walletdb.DB) error {
+		for _, shard := range shards {
+			preimage := ampChildPreimage(root, shard.ChildIndex)
+			hash := sha256.Sum256(preimage)
+			if !bytes.Equal(hash[:], shard.PaymentHash) {
+				return fmt.Errorf("VerifyFulfillable: amp shard %v preimage does not match payment hash", shard.ChildIndex)
+			}
+
+			err := tx.CreateInvoice(&walletdb.Invoice{
+				Preimage:        preimage,
+				PaymentHash:     shard.PaymentHash,
+				PaymentSecret:   invoice.PaymentSecret,
+				KeyPath:         invoice.KeyPath,
+				IdentityKeyPath: invoice.IdentityKeyPath,
+				ShortChanId:     invoice.ShortChanId,
+				AmountSat:       int64(lnwire.MilliSatoshi(shard.AmountMsat).ToSatoshis()),
+				CltvExpiry:      invoice.CltvExpiry,
+				Metadata:        invoice.Metadata,
+				Network:         invoice.Network,
+				DisplayCurrency: invoice.DisplayCurrency,
+				Locale:          invoice.Locale,
+				Hold:            invoice.Hold,
+				State:           walletdb.InvoiceStateUsed,
+				UsedAt:          &now,
+			})
+			if err != nil && err != walletdb.ErrDuplicatePaymentHash {
+				return fmt.Errorf("VerifyFulfillable: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ampShardInvoices returns the invoices stored for the shards of the amp
+// invoice received so far.
+func ampShardInvoices(db *walletdb.DB, invoice *walletdb.Invoice) ([]*walletdb.Invoice, error) {
+	if !invoice.Amp {
+		return nil, nil
+	}
+	shards, err := db.ListInvoiceAmpShards(invoice.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	var invoices []*walletdb.Invoice
+	for _, shard := range shards {
+		found, err := db.HasInvoice(shard.PaymentHash)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			// the set of the shard isn't complete yet
+			continue
+		}
+		shardInvoice, err := db.FindByPaymentHash(shard.PaymentHash)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, shardInvoice)
+	}
+	return invoices, nil
+}
+
+// ampChildPreimage derives the preimage of the shard with the given index from
+// the xor of the shares of the set.
+func ampChildPreimage(root [32]byte, childIndex uint32) []byte {
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], childIndex)
+
+	h := sha256.New()
+	h.Write(root[:])
+	h.Write(index[:])
+	return h.Sum(nil)
+}
@@ -0,0 +1,219 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	lndsphinx "github.com/lightningnetwork/lightning-onion"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/muun/libwallet/sphinx"
+)
+
+func createAmpSphinxPacket(nodePublicKey *btcec.PublicKey, paymentHash, paymentSecret []byte, amt, total, lockTime int64, amp *sphinx.AMP) []byte {
+	var paymentPath lndsphinx.PaymentPath
+	paymentPath[0].NodePub = *nodePublicKey
+
+	var secret [32]byte
+	copy(secret[:], paymentSecret)
+	uintAmount := uint64(amt * 1000) // msat are expected
+	uintLocktime := uint32(lockTime)
+	tlvRecords := []tlv.Record{
+		record.NewAmtToFwdRecord(&uintAmount),
+		record.NewLockTimeRecord(&uintLocktime),
+		record.NewMPP(lnwire.MilliSatoshi(total*1000), secret).Record(),
+		amp.Record(),
+	}
+
+	b := &bytes.Buffer{}
+	tlv.MustNewStream(tlvRecords...).Encode(b)
+	hopPayload, err := lndsphinx.NewHopPayload(nil, b.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	paymentPath[0].HopPayload = hopPayload
+
+	ephemeralKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		panic(err)
+	}
+
+	pkt, err := lndsphinx.NewOnionPacket(
+		&paymentPath, ephemeralKey, paymentHash, lndsphinx.BlankPacketFiller)
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	err = pkt.Encode(&buf)
+	if err != nil {
+		panic(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestAmpInvoice(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 10000, Amp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !payreq.Features.IsSet(ampRequired) {
+		t.Fatal("expected invoice to require amp")
+	}
+
+	_, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+
+	var setID, share1, share2, root [32]byte
+	copy(setID[:], randomBytes(32))
+	copy(share1[:], randomBytes(32))
+	copy(share2[:], randomBytes(32))
+	for i := range root {
+		root[i] = share1[i] ^ share2[i]
+	}
+
+	shard := func(share [32]byte, index uint32, amt int64, secret []byte) (*IncomingSwap, []byte) {
+		preimage := ampChildPreimage(root, index)
+		paymentHash := sha256.Sum256(preimage)
+		amp := &sphinx.AMP{RootShare: share, SetID: setID, ChildIndex: index}
+		return &IncomingSwap{
+			SphinxPacket:     createAmpSphinxPacket(nodePublicKey, paymentHash[:], secret, amt, 10000, 1000, amp),
+			PaymentHash:      paymentHash[:],
+			PaymentAmountSat: amt,
+		}, preimage
+	}
+
+	forged, _ := shard(share1, 7, 6000, randomBytes(32))
+	err = forged.VerifyFulfillable(userKey, network)
+	if err == nil || ErrorCode(err) == ErrIncompleteAmpSet {
+		t.Fatalf("expected shard with wrong payment secret to fail, got %v", err)
+	}
+
+	first, firstPreimage := shard(share1, 0, 6000, paymentSecret)
+	second, _ := shard(share2, 1, 4000, paymentSecret)
+
+	err = first.VerifyFulfillable(userKey, network)
+	if ErrorCode(err) != ErrIncompleteAmpSet {
+		t.Fatalf("expected incomplete set, got %v", err)
+	}
+
+	if err := second.VerifyFulfillable(userKey, network); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.VerifyFulfillable(userKey, network); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := first.FulfillFullDebt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.Preimage, firstPreimage) {
+		t.Fatal("expected the shard preimage to be handed out")
+	}
+
+	details, err := GetInvoiceByPaymentHash(second.PaymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.AmountSat != 4000 {
+		t.Fatalf("expected shard invoice of 4000 sats, got %v", details.AmountSat)
+	}
+}
+
+func TestAmpHoldInvoice(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 10000, Amp: true, Hold: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+
+	var setID, share [32]byte
+	copy(setID[:], randomBytes(32))
+	copy(share[:], randomBytes(32))
+
+	preimage := ampChildPreimage(share, 0)
+	paymentHash := sha256.Sum256(preimage)
+	amp := &sphinx.AMP{RootShare: share, SetID: setID, ChildIndex: 0}
+	swap := &IncomingSwap{
+		SphinxPacket:     createAmpSphinxPacket(nodePublicKey, paymentHash[:], paymentSecret, 10000, 10000, 1000, amp),
+		PaymentHash:      paymentHash[:],
+		PaymentAmountSat: 10000,
+	}
+
+	if err := swap.VerifyFulfillable(userKey, network); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swap.FulfillFullDebt(); ErrorCode(err) != ErrInvoiceHeld {
+		t.Fatalf("expected the shard to be held, got %v", err)
+	}
+
+	if err := SettleInvoice(payreq.PaymentHash[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := swap.FulfillFullDebt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.Preimage, preimage) {
+		t.Fatal("expected the shard preimage to be handed out")
+	}
+}
@@ -0,0 +1,160 @@
+package libwallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/muun/libwallet/descriptors"
+	"github.com/muun/libwallet/hdpath"
+)
+
+// AuditBundleVersion is the version of the document produced by
+// ExportAuditBundle.
+const AuditBundleVersion = 1
+
+// auditDescriptorFormats are the output descriptors of the address versions
+// in use, relative to the keys at hdpath.BasePath. Like in the emergency kit,
+// legacy versions are left out.
+var auditDescriptorFormats = []string{
+	"sh(wsh(multi(2,%[1]s/0/*,%[2]s/0/*)))", // V3 change
+	"sh(wsh(multi(2,%[1]s/1/*,%[2]s/1/*)))", // V3 external
+	"wsh(multi(2,%[1]s/0/*,%[2]s/0/*))",     // V4 change
+	"wsh(multi(2,%[1]s/1/*,%[2]s/1/*))",     // V4 external
+}
+
+// AuditBundle is the document produced by ExportAuditBundle. Its JSON
+// encoding is:
+//
+//	{
+//	  "version": 1,
+//	  "network": "mainnet",
+//	  "exportedAt": 1600000000,
+//	  "userXpub": "xpub...",          // at m/schema:1'/recovery:1'
+//	  "muunXpub": "xpub...",          // at m/schema:1'/recovery:1'
+//	  "descriptors": [
+//	    "wsh(multi(2,xpub.../1/*,xpub.../1/*))#checksum"
+//	  ],
+//	  "invoices": [
+//	    {
+//	      "paymentHash": "<hex>",
+//	      "amountSat": 1000,          // 0 for invoices without amount
+//	      "state": "settled",
+//	      "timestamp": 1600000000,    // when the invoice was issued, or paid if imported
+//	      "settledAt": 1600000000     // only if settled
+//	    }
+//	  ],
+//	  "operations": [
+//	    {
+//	      "txId": "<hex>",
+//	      "state": "confirmed"
+//	    }
+//	  ]
+//	}
+//
+// It lets a third party verify the on-chain history and the invoices paid to
+// the wallet without being able to spend its funds or claim its payments.
+type AuditBundle struct {
+	Version     int               `json:"version"`
+	Network     string            `json:"network"`
+	ExportedAt  int64             `json:"exportedAt"`
+	UserXpub    string            `json:"userXpub"`
+	MuunXpub    string            `json:"muunXpub"`
+	Descriptors []string          `json:"descriptors"`
+	Invoices    []*AuditInvoice   `json:"invoices"`
+	Operations  []*AuditOperation `json:"operations"`
+}
+
+// AuditInvoice is an invoice in an AuditBundle. Unlike in an InvoiceExport,
+// the preimage isn't included.
+type AuditInvoice struct {
+	PaymentHash string `json:"paymentHash"`
+	AmountSat   int64  `json:"amountSat"`
+	State       string `json:"state"`
+	Timestamp   int64  `json:"timestamp"`
+	SettledAt   int64  `json:"settledAt,omitempty"`
+}
+
+// AuditOperation is a tx broadcast by the wallet in an AuditBundle.
+type AuditOperation struct {
+	TxId  string `json:"txId"`
+	State string `json:"state"`
+}
+
+// ExportAuditBundle returns the AuditBundle JSON document for the given
+// wallet keys, which must be at hdpath.BasePath. Only invoices that were
+// handed out are included, so unused secrets stay private.
+func ExportAuditBundle(userKey, muunKey *HDPublicKey) (_ string, err error) {
+	defer recordErrors("ExportAuditBundle", &err)
+
+	userKey, err = userKey.DeriveTo(hdpath.BasePath)
+	if err != nil {
+		return "", fmt.Errorf("ExportAuditBundle: failed to derive user key: %w", err)
+	}
+	muunKey, err = muunKey.DeriveTo(hdpath.BasePath)
+	if err != nil {
+		return "", fmt.Errorf("ExportAuditBundle: failed to derive muun key: %w", err)
+	}
+
+	bundle := &AuditBundle{
+		Version:     AuditBundleVersion,
+		Network:     userKey.Network.Name(),
+		ExportedAt:  walletNow().Unix(),
+		UserXpub:    userKey.String(),
+		MuunXpub:    muunKey.String(),
+		Descriptors: make([]string, 0, len(auditDescriptorFormats)),
+		Invoices:    make([]*AuditInvoice, 0),
+		Operations:  make([]*AuditOperation, 0),
+	}
+	for _, format := range auditDescriptorFormats {
+		descriptor := fmt.Sprintf(format, bundle.UserXpub, bundle.MuunXpub)
+		checksum, err := descriptors.Checksum(descriptor)
+		if err != nil {
+			return "", fmt.Errorf("ExportAuditBundle: %w", err)
+		}
+		bundle.Descriptors = append(bundle.Descriptors, descriptor+"#"+checksum)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	invoices, err := db.ListInvoices()
+	if err != nil {
+		return "", fmt.Errorf("ExportAuditBundle: %w", err)
+	}
+	for _, invoice := range invoices {
+		if invoice.UsedAt == nil {
+			continue
+		}
+		record := &AuditInvoice{
+			PaymentHash: hex.EncodeToString(invoice.PaymentHash),
+			AmountSat:   invoice.AmountSat,
+			State:       string(invoice.State),
+			Timestamp:   invoice.UsedAt.Unix(),
+		}
+		if invoice.SettledAt != nil {
+			record.SettledAt = invoice.SettledAt.Unix()
+		}
+		bundle.Invoices = append(bundle.Invoices, record)
+	}
+
+	txs, err := db.ListTrackedTransactions()
+	if err != nil {
+		return "", fmt.Errorf("ExportAuditBundle: %w", err)
+	}
+	for _, tx := range txs {
+		bundle.Operations = append(bundle.Operations, &AuditOperation{
+			TxId:  tx.TxId,
+			State: string(tx.State),
+		})
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return "", fmt.Errorf("ExportAuditBundle: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,136 @@
+package libwallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muun/libwallet/descriptors"
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestExportAuditBundle(t *testing.T) {
+	setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, _, _ := getInvoiceSecrets(invoice, userKey)
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := issued.TransitionTo(walletdb.InvoiceStateSettled, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveInvoice(issued); err != nil {
+		t.Fatal(err)
+	}
+	txId := hex.EncodeToString(randomBytes(32))
+	if err := db.SaveTrackedTransaction(&walletdb.TrackedTransaction{
+		TxId:  txId,
+		State: walletdb.TrackedTxStateConfirmed,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	unused, err := db.ListInvoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	export, err := ExportAuditBundle(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, invoice := range unused {
+		if strings.Contains(export, hex.EncodeToString(invoice.Preimage)) {
+			t.Fatal("expected no preimages in the bundle")
+		}
+	}
+
+	var bundle AuditBundle
+	if err := json.Unmarshal([]byte(export), &bundle); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Version != AuditBundleVersion || bundle.Network != network.Name() {
+		t.Fatalf("unexpected bundle header %+v", bundle)
+	}
+	if bundle.UserXpub != userKey.PublicKey().String() || bundle.MuunXpub != muunKey.PublicKey().String() {
+		t.Fatal("expected the xpubs of the wallet keys")
+	}
+
+	if len(bundle.Invoices) != 1 {
+		t.Fatalf("expected only the issued invoice, got %v", len(bundle.Invoices))
+	}
+	if record := bundle.Invoices[0]; record.PaymentHash != hex.EncodeToString(paymentHash) ||
+		record.AmountSat != 1000 || record.State != string(walletdb.InvoiceStateSettled) || record.SettledAt == 0 {
+		t.Fatalf("unexpected invoice %+v", record)
+	}
+
+	if len(bundle.Operations) != 1 || bundle.Operations[0].TxId != txId ||
+		bundle.Operations[0].State != string(walletdb.TrackedTxStateConfirmed) {
+		t.Fatalf("unexpected operations %+v", bundle.Operations)
+	}
+
+	// the V4 external descriptor derives the wallet addresses
+	if len(bundle.Descriptors) != len(auditDescriptorFormats) {
+		t.Fatalf("expected %v descriptors, got %v", len(auditDescriptorFormats), len(bundle.Descriptors))
+	}
+	for _, descriptor := range bundle.Descriptors {
+		if _, err := descriptors.Parse(descriptor, network.network); err != nil {
+			t.Fatalf("invalid descriptor %v: %v", descriptor, err)
+		}
+	}
+	external, err := descriptors.Parse(bundle.Descriptors[3], network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []int64{0, 7} {
+		userChild, _ := userKey.PublicKey().DeriveTo("m/schema:1'/recovery:1'/external:1")
+		userChild, _ = userChild.DerivedAt(index)
+		muunChild, _ := muunKey.PublicKey().DeriveTo("m/schema:1'/recovery:1'/external:1")
+		muunChild, _ = muunChild.DerivedAt(index)
+		expected, err := CreateAddressV4(userChild, muunChild)
+		if err != nil {
+			t.Fatal(err)
+		}
+		address, err := external.Address(uint32(index))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address.EncodeAddress() != expected.Address() {
+			t.Fatalf("expected address %v at %v, got %v", expected.Address(), index, address.EncodeAddress())
+		}
+	}
+
+	userKey.Path = "m/schema:1'/recovery:1'/external:1"
+	if _, err := ExportAuditBundle(userKey.PublicKey(), muunKey.PublicKey()); err == nil {
+		t.Fatal("expected keys below the base path to fail")
+	}
+}
@@ -0,0 +1,195 @@
+package libwallet
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BatchSigner signs all the inputs of a transaction, such as a sweep or a
+// batch of fulfillments, computing its segwit sighash midstate only once. The
+// midstate hashes every prevout, sequence and output of the tx, so computing it
+// for each input makes signing quadratic in the number of inputs.
+type BatchSigner struct {
+	tx        *wire.MsgTx
+	inputs    []Input
+	sigHashes *txscript.TxSigHashes
+	workers   int
+}
+
+func NewBatchSigner(inputs *InputList, rawTx []byte) (*BatchSigner, error) {
+
+	tx := wire.NewMsgTx(0)
+	err := tx.Deserialize(bytes.NewReader(rawTx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode tx: %w", err)
+	}
+
+	return newBatchSigner(tx, inputs.Inputs()), nil
+}
+
+// NewParallelBatchSigner returns a BatchSigner that signs the inputs
+// concurrently with up to GOMAXPROCS workers. Signing each input is dominated
+// by key derivation and ECDSA, so sweeps of many inputs, like the ones built
+// during recovery, take a fraction of the time on multicore machines.
+func NewParallelBatchSigner(inputs *InputList, rawTx []byte) (*BatchSigner, error) {
+	signer, err := NewBatchSigner(inputs, rawTx)
+	if err != nil {
+		return nil, err
+	}
+	signer.workers = runtime.GOMAXPROCS(0)
+	return signer, nil
+}
+
+func newBatchSigner(tx *wire.MsgTx, inputs []Input) *BatchSigner {
+	return &BatchSigner{
+		tx:        tx,
+		inputs:    inputs,
+		sigHashes: txscript.NewTxSigHashes(tx),
+		workers:   1,
+	}
+}
+
+func (s *BatchSigner) Sign(userKey *HDPrivateKey, muunKey *HDPublicKey) (_ *Transaction, err error) {
+	defer recordErrors("BatchSigner.Sign", &err)
+
+	return s.sign(userKey, muunKey)
+}
+
+func (s *BatchSigner) FullySign(userKey, muunKey *HDPrivateKey) (_ *Transaction, err error) {
+	defer recordErrors("BatchSigner.FullySign", &err)
+
+	return s.fullySign(userKey, muunKey)
+}
+
+func (s *BatchSigner) sign(userKey *HDPrivateKey, muunKey *HDPublicKey) (*Transaction, error) {
+	coins, err := s.coins(userKey.Network)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert input data to coin: %w", err)
+	}
+
+	err = s.signInputs(coins, func(c coin, index int, tx *wire.MsgTx) error {
+		return c.SignInput(index, tx, userKey, muunKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySignatures(userKey.PublicKey(), muunKey); err != nil {
+		return nil, err
+	}
+
+	return newTransaction(s.tx)
+}
+
+func (s *BatchSigner) fullySign(userKey, muunKey *HDPrivateKey) (*Transaction, error) {
+	coins, err := s.coins(userKey.Network)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert input data to coin: %w", err)
+	}
+
+	err = s.signInputs(coins, func(c coin, index int, tx *wire.MsgTx) error {
+		return c.FullySignInput(index, tx, userKey, muunKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySignatures(userKey.PublicKey(), muunKey.PublicKey()); err != nil {
+		return nil, err
+	}
+
+	return newTransaction(s.tx)
+}
+
+// signInputs calls signInput for every coin, spreading them among the workers.
+func (s *BatchSigner) signInputs(coins []coin, signInput func(c coin, index int, tx *wire.MsgTx) error) error {
+	workers := s.workers
+	if workers > len(coins) {
+		workers = len(coins)
+	}
+
+	if workers <= 1 {
+		for i, coin := range coins {
+			if err := signInput(coin, i, s.tx); err != nil {
+				return fmt.Errorf("failed to sign input: %w", err)
+			}
+		}
+		return nil
+	}
+
+	// Legacy sighashes read the scripts of every input of the tx, so each
+	// worker signs on its own copy and the scripts are copied back at the end.
+	indexes := make(chan int, len(coins))
+	for i := range coins {
+		indexes <- i
+	}
+	close(indexes)
+
+	signedIn := make([]*wire.MsgTx, len(coins))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(tx *wire.MsgTx) {
+			defer wg.Done()
+			for i := range indexes {
+				err := signInput(coins[i], i, tx)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to sign input: %w", err)
+				}
+				failed := firstErr != nil
+				mu.Unlock()
+
+				if failed {
+					return
+				}
+				signedIn[i] = tx
+			}
+		}(s.tx.Copy())
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	for i, tx := range signedIn {
+		s.tx.TxIn[i].SignatureScript = tx.TxIn[i].SignatureScript
+		s.tx.TxIn[i].Witness = tx.TxIn[i].Witness
+	}
+	return nil
+}
+
+func (s *BatchSigner) coins(net *Network) ([]coin, error) {
+	var coins []coin
+	for _, input := range s.inputs {
+		coin, err := createCoin(input, net, s.sigHashes)
+		if err != nil {
+			return nil, err
+		}
+		coins = append(coins, coin)
+	}
+	return coins, nil
+}
+
+// verifySignatures checks the signed tx with the script VM.
+func (s *BatchSigner) verifySignatures(userKey, muunKey *HDPublicKey) error {
+	prevOuts, err := prevOutputs(s.inputs, userKey, muunKey)
+	if err != nil {
+		return fmt.Errorf("could not get spent outputs: %w", err)
+	}
+	if err := verifySignedInputs(s.tx, prevOuts); err != nil {
+		return fmt.Errorf("signed tx is invalid: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package libwallet
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/muun/libwallet/addresses"
+)
+
+// createSweep returns keys and an unsigned tx spending n V4 outputs of them
+// into a single output.
+func createSweep(tb testing.TB, n int) (*HDPrivateKey, *HDPrivateKey, *InputList, []byte) {
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = basePath
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = basePath
+
+	inputs := &InputList{}
+	tx := wire.NewMsgTx(2)
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("%v/external:1/%v", basePath, i)
+		userPublicKey, err := userKey.PublicKey().DeriveTo(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		muunPublicKey, err := muunKey.PublicKey().DeriveTo(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		address, err := CreateAddressV4(userPublicKey, muunPublicKey)
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		prevOutHash, _ := chainhash.NewHash(randomBytes(32))
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevOutHash, 0), nil, nil))
+		inputs.Add(&input{
+			outpoint: outpoint{txId: prevOutHash[:], index: 0, amount: 10000},
+			address:  address,
+		})
+	}
+
+	script, err := addressToScript(inputs.Inputs()[0].Address().Address(), network)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tx.AddTxOut(wire.NewTxOut(int64(n)*10000-1000, script))
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		tb.Fatal(err)
+	}
+	return userKey, muunKey, inputs, buf.Bytes()
+}
+
+func TestBatchSigner(t *testing.T) {
+	userKey, muunKey, inputs, rawTx := createSweep(t, 5)
+
+	signer, err := NewBatchSigner(inputs, rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.FullySign(userKey, muunKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedTx := wire.NewMsgTx(0)
+	if err := signedTx.Deserialize(bytes.NewReader(signed.Bytes)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signatures are deterministic, so signing each input on its own must
+	// produce the same witnesses
+	tx := wire.NewMsgTx(0)
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		t.Fatal(err)
+	}
+	for i, input := range inputs.Inputs() {
+		coin, err := createCoin(input, userKey.Network, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := coin.FullySignInput(i, tx, userKey, muunKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range tx.TxIn {
+		for j, item := range tx.TxIn[i].Witness {
+			if !bytes.Equal(item, signedTx.TxIn[i].Witness[j]) {
+				t.Fatalf("witness item %v of input %v differs from signing it on its own", j, i)
+			}
+		}
+	}
+
+	// The muun signatures produced can be used to cosign in a new batch
+	cosigned := &InputList{}
+	for i, in := range inputs.Inputs() {
+		withSig := *in.(*input)
+		withSig.muunSignature = signedTx.TxIn[i].Witness[2]
+		cosigned.Add(&withSig)
+	}
+	signer, err = NewBatchSigner(cosigned, rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Sign(userKey, muunKey.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParallelBatchSigner(t *testing.T) {
+	userKey, muunKey, inputs, rawTx := createSweep(t, 20)
+
+	signer, err := NewBatchSigner(inputs, rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := signer.FullySign(userKey, muunKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err = NewParallelBatchSigner(inputs, rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.workers = 4
+	signed, err := signer.FullySign(userKey, muunKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signed.Bytes, expected.Bytes) {
+		t.Fatal("expected parallel signing to produce the same tx")
+	}
+
+	// A failure in any input fails the whole tx
+	broken := &InputList{}
+	for i, in := range inputs.Inputs() {
+		if i == 13 {
+			in = &input{
+				outpoint: in.(*input).outpoint,
+				address:  addresses.New(addresses.V4, "m/schema:2'/recovery:1'/external:1/13", in.Address().Address()),
+			}
+		}
+		broken.Add(in)
+	}
+	signer, err = NewParallelBatchSigner(broken, rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.workers = 4
+	if _, err := signer.FullySign(userKey, muunKey); err == nil {
+		t.Fatal("expected error with an invalid input")
+	}
+}
+
+func BenchmarkBatchSignerSweep(b *testing.B) {
+	userKey, muunKey, inputs, rawTx := createSweep(b, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		signer, err := NewBatchSigner(inputs, rawTx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := signer.FullySign(userKey, muunKey); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSignSweepPerInput signs and verifies the same sweep computing the
+// sighash midstate for every input, to compare against BenchmarkBatchSignerSweep.
+func BenchmarkSignSweepPerInput(b *testing.B) {
+	userKey, muunKey, inputs, rawTx := createSweep(b, 100)
+	prevOuts, err := prevOutputs(inputs.Inputs(), userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx := wire.NewMsgTx(0)
+		if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+			b.Fatal(err)
+		}
+		for j, input := range inputs.Inputs() {
+			coin, err := createCoin(input, userKey.Network, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := coin.FullySignInput(j, tx, userKey, muunKey); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := verifySignedInputs(tx, prevOuts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParallelBatchSignerSweep(b *testing.B) {
+	userKey, muunKey, inputs, rawTx := createSweep(b, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		signer, err := NewParallelBatchSigner(inputs, rawTx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := signer.FullySign(userKey, muunKey); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
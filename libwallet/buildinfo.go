@@ -0,0 +1,45 @@
+package libwallet
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build information, stamped at compile time using
+// -ldflags "-X github.com/muun/libwallet.buildVersion=<version> ..." by the
+// android, iOS and desktop build scripts, see tools/libwallet-stamp.sh.
+// buildFlags holds the go flags of the build, joined by commas. No timestamps
+// are embedded so that builds remain reproducible.
+var (
+	buildVersion = "dev"
+	buildCommit  = "unknown"
+	buildFlags   = ""
+)
+
+// BuildInfo identifies the exact libwallet build in use, so bug reports can
+// be mapped back to the code that produced them.
+type BuildInfo struct {
+	Version    string
+	Commit     string
+	BuildFlags string
+	GoVersion  string
+}
+
+// GetBuildInfo returns the information stamped into this libwallet build.
+func GetBuildInfo() *BuildInfo {
+	return &BuildInfo{
+		Version:    buildVersion,
+		Commit:     buildCommit,
+		BuildFlags: buildFlags,
+		GoVersion:  runtime.Version(),
+	}
+}
+
+// String returns a single line description of the build.
+func (b *BuildInfo) String() string {
+	s := fmt.Sprintf("libwallet %v (%v, %v)", b.Version, b.Commit, b.GoVersion)
+	if b.BuildFlags != "" {
+		s += " " + b.BuildFlags
+	}
+	return s
+}
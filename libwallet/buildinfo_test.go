@@ -0,0 +1,34 @@
+package libwallet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/muun/libwallet/errors"
+)
+
+func TestGetBuildInfo(t *testing.T) {
+	info := GetBuildInfo()
+	if info.Version != "dev" {
+		t.Fatalf("expected unstamped build to be a dev version, got %v", info.Version)
+	}
+	if info.GoVersion == "" {
+		t.Fatal("expected go version to be set")
+	}
+	if !strings.HasPrefix(info.String(), "libwallet dev") {
+		t.Fatalf("unexpected build description %v", info.String())
+	}
+}
+
+func TestErrorReport(t *testing.T) {
+	report := ErrorReport(errors.New(ErrInvalidInvoice, "bad invoice"))
+	if !strings.Contains(report, "bad invoice") {
+		t.Fatalf("expected report to contain the error message, got %v", report)
+	}
+	if !strings.Contains(report, "code 6") {
+		t.Fatalf("expected report to contain the error code, got %v", report)
+	}
+	if !strings.Contains(report, GetBuildInfo().String()) {
+		t.Fatalf("expected report to contain the build info, got %v", report)
+	}
+}
@@ -0,0 +1,114 @@
+package libwallet
+
+import (
+	"github.com/muun/libwallet/errors"
+)
+
+// Capabilities that can be granted to a WalletHandle. They are bit flags and
+// can be combined with a bitwise or.
+const (
+	CapabilityRead    = 1 << 0
+	CapabilityReceive = 1 << 1
+	CapabilitySign    = 1 << 2
+
+	CapabilityAll = CapabilityRead | CapabilityReceive | CapabilitySign
+)
+
+// WalletHandle is a restricted entry point to the wallet that the host app can
+// pass to less-trusted components (eg widgets or watch apps). The handle holds
+// the wallet keys, but only exposes the operations allowed by its
+// capabilities.
+type WalletHandle struct {
+	capabilities int64
+	userKey      *HDPrivateKey
+	muunKey      *HDPublicKey
+}
+
+// NewWalletHandle creates a handle with the given capabilities.
+func NewWalletHandle(capabilities int64, userKey *HDPrivateKey, muunKey *HDPublicKey) *WalletHandle {
+	return &WalletHandle{
+		capabilities: capabilities & CapabilityAll,
+		userKey:      userKey,
+		muunKey:      muunKey,
+	}
+}
+
+// Restrict returns a new handle holding only the capabilities present both in
+// this handle and in the given ones. A handle can never be widened.
+func (h *WalletHandle) Restrict(capabilities int64) *WalletHandle {
+	return &WalletHandle{
+		capabilities: h.capabilities & capabilities,
+		userKey:      h.userKey,
+		muunKey:      h.muunKey,
+	}
+}
+
+// HasCapability returns true if all the given capabilities were granted to
+// this handle.
+func (h *WalletHandle) HasCapability(capabilities int64) bool {
+	return h.capabilities&capabilities == capabilities
+}
+
+// Capabilities returns the capabilities granted to this handle.
+func (h *WalletHandle) Capabilities() int64 {
+	return h.capabilities
+}
+
+// InvoiceState returns the state of the invoice matching the payment hash.
+func (h *WalletHandle) InvoiceState(paymentHash []byte) (string, error) {
+	if err := h.require(CapabilityRead, "InvoiceState"); err != nil {
+		return "", err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return "", err
+	}
+	return string(invoice.State), nil
+}
+
+// CountUnusedInvoiceSecrets returns the number of secrets available to
+// create new invoices.
+func (h *WalletHandle) CountUnusedInvoiceSecrets() (int, error) {
+	if err := h.require(CapabilityRead, "CountUnusedInvoiceSecrets"); err != nil {
+		return 0, err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	return db.CountUnusedInvoices()
+}
+
+// CreateInvoice creates a new invoice using the handle's keys. See CreateInvoice.
+func (h *WalletHandle) CreateInvoice(routeHints *RouteHints, opts *InvoiceOptions) (string, error) {
+	if err := h.require(CapabilityReceive, "CreateInvoice"); err != nil {
+		return "", err
+	}
+	return CreateInvoice(h.userKey.Network, h.userKey, routeHints, opts)
+}
+
+// Sign signs the transaction using the handle's keys. See
+// PartiallySignedTransaction.Sign.
+func (h *WalletHandle) Sign(tx *PartiallySignedTransaction) (*Transaction, error) {
+	if err := h.require(CapabilitySign, "Sign"); err != nil {
+		return nil, err
+	}
+	return tx.Sign(h.userKey, h.muunKey)
+}
+
+func (h *WalletHandle) require(capabilities int64, operation string) error {
+	if !h.HasCapability(capabilities) {
+		return errors.Errorf(ErrPermissionDenied, "%v: handle lacks the required capabilities", operation)
+	}
+	return nil
+}
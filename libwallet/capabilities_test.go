@@ -0,0 +1,79 @@
+package libwallet
+
+import (
+	"testing"
+)
+
+func TestWalletHandle(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handle := NewWalletHandle(CapabilityAll, userKey, muunKey.PublicKey())
+	readOnly := handle.Restrict(CapabilityRead)
+
+	if !readOnly.HasCapability(CapabilityRead) {
+		t.Fatal("expected read only handle to be able to read")
+	}
+	if readOnly.HasCapability(CapabilityRead | CapabilitySign) {
+		t.Fatal("expected read only handle to not be able to sign")
+	}
+	if readOnly.Restrict(CapabilityAll).Capabilities() != CapabilityRead {
+		t.Fatal("expected restricting a handle to never widen it")
+	}
+
+	count, err := readOnly.CountUnusedInvoiceSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Fatalf("expected 5 unused secrets, got %v", count)
+	}
+
+	state, err := readOnly.InvoiceState(secrets.Get(0).PaymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "registered" {
+		t.Fatalf("expected invoice to be registered, got %v", state)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	_, err = readOnly.CreateInvoice(routeHints, &InvoiceOptions{})
+	if ErrorCode(err) != ErrPermissionDenied {
+		t.Fatalf("expected permission denied creating invoice, got %v", err)
+	}
+
+	_, err = readOnly.Sign(&PartiallySignedTransaction{})
+	if ErrorCode(err) != ErrPermissionDenied {
+		t.Fatalf("expected permission denied signing, got %v", err)
+	}
+
+	invoice, err := handle.CreateInvoice(routeHints, &InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invoice == "" {
+		t.Fatal("expected non-empty invoice string")
+	}
+}
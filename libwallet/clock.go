@@ -0,0 +1,138 @@
+package libwallet
+
+import (
+	"sync"
+	"time"
+
+	"github.com/muun/libwallet/errors"
+)
+
+// DefaultMaxClockSkewSeconds is the difference between the device clock and
+// the estimated real time tolerated when none is configured.
+const DefaultMaxClockSkewSeconds = 5 * 60
+
+// maxBlockTimeAhead is how far ahead of the real time a block timestamp can
+// be and still be valid, per consensus rules.
+const maxBlockTimeAhead = 2 * time.Hour
+
+// Clock is implemented by the apps to provide the device time, eg from a
+// source the user can't change, instead of the system clock.
+type Clock interface {
+	// NowMillis returns the current time in unix milliseconds.
+	NowMillis() int64
+}
+
+// clockOffsets keeps the estimated difference between the real time and the
+// device clock, from the last server time and chain tip reported.
+var clockOffsets = struct {
+	sync.Mutex
+	server    time.Duration
+	hasServer bool
+	chain     time.Duration
+}{}
+
+// ReportServerTime records the time reported by the server, in unix
+// seconds, to detect a skewed device clock. It's the most precise reference,
+// so it takes precedence over chain tip times.
+func ReportServerTime(serverTime int64) {
+	clockOffsets.Lock()
+	defer clockOffsets.Unlock()
+
+	clockOffsets.server = time.Unix(serverTime, 0).Sub(deviceNow())
+	clockOffsets.hasServer = true
+}
+
+// ReportChainTipTime records the timestamp of the chain tip block, in unix
+// seconds, to detect a skewed device clock when the server time is unknown.
+// Block timestamps can be up to 2 hours ahead of the real time and blocks
+// can take long to be found, so it only detects clocks that are more than 2
+// hours behind. The time is also reported by HealthCheck, to tell whether
+// the app's chain data is stale.
+func ReportChainTipTime(blockTime int64) {
+	dataTimes.Lock()
+	dataTimes.chainTip = time.Unix(blockTime, 0)
+	dataTimes.Unlock()
+
+	clockOffsets.Lock()
+	defer clockOffsets.Unlock()
+
+	clockOffsets.chain = 0
+	if behind := time.Unix(blockTime, 0).Sub(deviceNow()) - maxBlockTimeAhead; behind > 0 {
+		clockOffsets.chain = behind
+	}
+}
+
+// ClockSkewSeconds returns how far behind the real time the device clock is
+// estimated to be, negative if it's ahead, or 0 if it's unknown.
+func ClockSkewSeconds() int64 {
+	return int64(clockOffset().Seconds())
+}
+
+// CheckClockSkew returns an error with the ErrClockSkew code if the device
+// clock is skewed more than the configured MaxClockSkewSeconds. Invoices
+// created while the clock is skewed use the estimated real time, so apps
+// should only warn the user about it.
+func CheckClockSkew() error {
+	skew := ClockSkewSeconds()
+	if skew > maxClockSkewSeconds() || -skew > maxClockSkewSeconds() {
+		return errors.Errorf(ErrClockSkew, "device clock is skewed by %v seconds", skew)
+	}
+	return nil
+}
+
+func clockOffset() time.Duration {
+	clockOffsets.Lock()
+	defer clockOffsets.Unlock()
+
+	if clockOffsets.hasServer {
+		return clockOffsets.server
+	}
+	return clockOffsets.chain
+}
+
+func resetClockOffsets() {
+	clockOffsets.Lock()
+	defer clockOffsets.Unlock()
+
+	clockOffsets.server = 0
+	clockOffsets.hasServer = false
+	clockOffsets.chain = 0
+}
+
+func maxClockSkewSeconds() int64 {
+	if cfg.MaxClockSkewSeconds > 0 {
+		return cfg.MaxClockSkewSeconds
+	}
+	return DefaultMaxClockSkewSeconds
+}
+
+// deviceNow returns the time of the configured Clock, or the system time if
+// there's none.
+func deviceNow() time.Time {
+	if cfg != nil && cfg.Clock != nil {
+		return time.Unix(0, cfg.Clock.NowMillis()*int64(time.Millisecond))
+	}
+	return time.Now()
+}
+
+// walletNow returns the estimated real time, which is the device time unless
+// its clock is skewed more than tolerated, or ahead of the server time. It's
+// used for invoice timestamps and expiries, since payers check them against
+// their own clocks, and some reject invoices from the future.
+func walletNow() time.Time {
+	now := deviceNow()
+	if offset := clockOffset(); offset < 0 || CheckClockSkew() != nil {
+		return now.Add(offset)
+	}
+	return now
+}
+
+// ValidateTimestamp returns an error with the ErrClockSkew code if the
+// timestamp, in unix seconds, is ahead of the estimated real time, so payers
+// with a correct clock would see it in the future.
+func ValidateTimestamp(timestamp int64) error {
+	if now := walletNow().Unix(); timestamp > now {
+		return errors.Errorf(ErrClockSkew, "timestamp %v is %v seconds in the future", timestamp, timestamp-now)
+	}
+	return nil
+}
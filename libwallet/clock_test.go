@@ -0,0 +1,141 @@
+package libwallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+func TestClockSkew(t *testing.T) {
+	setup()
+	defer setup()
+
+	if err := CheckClockSkew(); err != nil {
+		t.Fatalf("expected no skew without references, got %v", err)
+	}
+
+	ReportChainTipTime(time.Now().Add(-30 * time.Minute).Unix())
+	if ClockSkewSeconds() != 0 {
+		t.Fatalf("expected old chain tip not to be skew, got %v", ClockSkewSeconds())
+	}
+	ReportChainTipTime(time.Now().Add(90 * time.Minute).Unix())
+	if ClockSkewSeconds() != 0 {
+		t.Fatalf("expected chain tip within the block time tolerance not to be skew, got %v", ClockSkewSeconds())
+	}
+	ReportChainTipTime(time.Now().Add(5 * time.Hour).Unix())
+	if skew := ClockSkewSeconds(); skew < 3*60*60-5 || skew > 3*60*60 {
+		t.Fatalf("expected about 3 hours of skew from the chain tip, got %v", skew)
+	}
+
+	// the server time takes precedence
+	ReportServerTime(time.Now().Add(-2 * time.Minute).Unix())
+	if err := CheckClockSkew(); err != nil {
+		t.Fatalf("expected skew within tolerance, got %v", err)
+	}
+	cfg.MaxClockSkewSeconds = 60
+	if err := CheckClockSkew(); ErrorCode(err) != ErrClockSkew {
+		t.Fatalf("expected clock skew error, got %v", err)
+	}
+
+	t.Run("invoice timestamp", func(t *testing.T) {
+		ReportServerTime(time.Now().Add(24 * time.Hour).Unix())
+
+		userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+		userKey.Path = "m/schema:1'/recovery:1'"
+		muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+		muunKey.Path = "m/schema:1'/recovery:1'"
+
+		secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PersistInvoiceSecrets(secrets); err != nil {
+			t.Fatal(err)
+		}
+		invoice, err := CreateInvoice(network, userKey, &RouteHints{
+			Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: 1000,
+			CltvExpiryDelta:           8,
+		}, &InvoiceOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payreq, err := zpay32.Decode(invoice, network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payreq.Timestamp.Before(time.Now().Add(23 * time.Hour)) {
+			t.Fatalf("expected invoice timestamp to be corrected, got %v", payreq.Timestamp)
+		}
+
+		report := HealthCheck()
+		for i := 0; i < report.Length(); i++ {
+			if module := report.Get(i); module.Module == "clock" && module.Status != HealthStatusWarning {
+				t.Fatalf("expected clock warning, got %v", module.Status)
+			}
+		}
+	})
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) NowMillis() int64 {
+	return c.now.UnixNano() / int64(time.Millisecond)
+}
+
+func TestClockSource(t *testing.T) {
+	setup()
+	defer setup()
+
+	clock := &fixedClock{now: time.Now().Add(-10 * time.Minute).Truncate(time.Millisecond)}
+	cfg.Clock = clock
+	if now := walletNow(); !now.Equal(clock.now) {
+		t.Fatalf("expected the configured clock time, got %v", now)
+	}
+
+	// skew is measured against the configured clock
+	ReportServerTime(time.Now().Unix())
+	if skew := ClockSkewSeconds(); skew < 10*60-5 || skew > 10*60+5 {
+		t.Fatalf("expected about 10 minutes of skew, got %v", skew)
+	}
+	if err := CheckClockSkew(); ErrorCode(err) != ErrClockSkew {
+		t.Fatalf("expected clock skew error, got %v", err)
+	}
+}
+
+func TestValidateTimestamp(t *testing.T) {
+	setup()
+	defer setup()
+
+	now := time.Now()
+	if err := ValidateTimestamp(now.Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateTimestamp(now.Add(time.Minute).Unix()); ErrorCode(err) != ErrClockSkew {
+		t.Fatalf("expected clock skew error, got %v", err)
+	}
+
+	// a device clock ahead of the server is corrected even within the
+	// tolerance, so invoices are never from the future
+	ReportServerTime(now.Add(-time.Minute).Unix())
+	if err := CheckClockSkew(); err != nil {
+		t.Fatalf("expected skew within tolerance, got %v", err)
+	}
+	if walletNow().After(now.Add(-time.Minute + time.Second)) {
+		t.Fatalf("expected the server time, got %v", walletNow())
+	}
+	if err := ValidateTimestamp(now.Unix()); ErrorCode(err) != ErrClockSkew {
+		t.Fatalf("expected clock skew error, got %v", err)
+	}
+
+	// a device clock behind within the tolerance is left as is
+	ReportServerTime(now.Add(time.Minute).Unix())
+	if walletNow().After(now.Add(time.Second)) {
+		t.Fatalf("expected the device time, got %v", walletNow())
+	}
+}
@@ -0,0 +1,195 @@
+package cloudsync
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+func randomBytes(count int) []byte {
+	buf := make([]byte, count)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func TestEnvelope(t *testing.T) {
+	keys, err := NewKeys(randomBytes(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := keys.Seal([]byte("record"), 42, []byte("plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := keys.Open(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Revision != 42 || string(opened.Plaintext) != "plaintext" ||
+		!bytes.Equal(opened.RecordID, keys.RecordID([]byte("record"))) {
+		t.Fatalf("unexpected envelope content %+v", opened)
+	}
+
+	// sealing the same revision converges, changing anything doesn't
+	again, _ := keys.Seal([]byte("record"), 42, []byte("plaintext"))
+	if !bytes.Equal(envelope, again) {
+		t.Fatal("expected sealing the same revision to give the same envelope")
+	}
+	other, _ := keys.Seal([]byte("record"), 42, []byte("other plaintext"))
+	if bytes.Equal(envelope[headerSize:headerSize+nonceSize], other[headerSize:headerSize+nonceSize]) {
+		t.Fatal("expected different plaintexts to get different nonces")
+	}
+
+	for i := 1; i < len(envelope); i++ {
+		tampered := append([]byte(nil), envelope...)
+		tampered[i] ^= 1
+		if _, err := keys.Open(tampered); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("expected byte %v tampered to fail, got %v", i, err)
+		}
+	}
+	tampered := append([]byte(nil), envelope...)
+	tampered[0] = Version + 1
+	if _, err := keys.Open(tampered); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	otherKeys, _ := NewKeys(randomBytes(32))
+	if _, err := otherKeys.Open(envelope); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("expected envelope of other keys to fail, got %v", err)
+	}
+	if _, err := NewKeys(randomBytes(16)); err == nil {
+		t.Fatal("expected short secret to fail")
+	}
+}
+
+func TestInvoiceEnvelope(t *testing.T) {
+	keys, _ := NewKeys(randomBytes(32))
+
+	record := &InvoiceRecord{
+		PaymentHash: randomBytes(32),
+		Preimage:    randomBytes(32),
+		KeyPath:     "m/schema:1'/recovery:1'/invoices:4/1/2",
+		State:       walletdb.InvoiceStateUsed,
+		Metadata:    []byte("order 1"),
+		UpdatedAt:   1600000000000,
+		Device:      "a",
+	}
+	envelope, err := keys.SealInvoice(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := keys.OpenInvoice(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(record, opened) {
+		t.Fatalf("expected %+v, got %+v", record, opened)
+	}
+
+	// the relay can't pass an envelope off as another record
+	swapped, _ := keys.Seal(invoiceRecordKey(randomBytes(32)), 1, []byte(`{"paymentHash":"AA=="}`))
+	if _, err := keys.OpenInvoice(swapped); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("expected ErrInvalidEnvelope, got %v", err)
+	}
+
+	// nor make the wallet derive keys out of its paths
+	for _, keyPath := range []string{"", "m/1/2", "m/schema:1'/recovery:1'/invoice:4/1/2"} {
+		invalid := *record
+		invalid.KeyPath = keyPath
+		envelope, err := keys.SealInvoice(&invalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keys.OpenInvoice(envelope); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("expected key path %q to fail, got %v", keyPath, err)
+		}
+	}
+}
+
+func TestMergeInvoice(t *testing.T) {
+	base := InvoiceRecord{
+		PaymentHash: randomBytes(32),
+		Preimage:    randomBytes(32),
+		KeyPath:     "m/schema:1'/recovery:1'/invoices:4/1/2",
+	}
+	record := func(state walletdb.InvoiceState, updatedAt int64, device string) *InvoiceRecord {
+		r := base
+		r.State = state
+		r.UpdatedAt = updatedAt
+		r.Device = device
+		return &r
+	}
+
+	merge := func(a, b *InvoiceRecord) *InvoiceRecord {
+		ab, err := MergeInvoice(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ba, err := MergeInvoice(b, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ab, ba) {
+			t.Fatalf("expected merge to be commutative, got %+v and %+v", ab, ba)
+		}
+		return ab
+	}
+
+	tests := []struct {
+		a, b     walletdb.InvoiceState
+		expected walletdb.InvoiceState
+	}{
+		{walletdb.InvoiceStateRegistered, walletdb.InvoiceStateUsed, walletdb.InvoiceStateUsed},
+		{walletdb.InvoiceStateRegistered, walletdb.InvoiceStatePendingUse, walletdb.InvoiceStatePendingUse},
+		{walletdb.InvoiceStatePendingUse, walletdb.InvoiceStateUsed, walletdb.InvoiceStateUsed},
+		{walletdb.InvoiceStateUsed, walletdb.InvoiceStateSettled, walletdb.InvoiceStateSettled},
+		{walletdb.InvoiceStateSettled, walletdb.InvoiceStateRefunded, walletdb.InvoiceStateRefunded},
+		{walletdb.InvoiceStateCancelled, walletdb.InvoiceStateSettled, walletdb.InvoiceStateSettled},
+		{walletdb.InvoiceStateExpired, walletdb.InvoiceStateSettled, walletdb.InvoiceStateSettled},
+		{walletdb.InvoiceStateExpired, walletdb.InvoiceStateCancelled, walletdb.InvoiceStateCancelled},
+	}
+	for _, test := range tests {
+		// the state doesn't depend on which was written last
+		if got := merge(record(test.a, 2, "a"), record(test.b, 1, "b")).State; got != test.expected {
+			t.Errorf("merging %v and %v: expected %v, got %v", test.a, test.b, test.expected, got)
+		}
+	}
+
+	a := record(walletdb.InvoiceStateSettled, 1, "a")
+	a.UsedAt = 10
+	a.SettledAt = 20
+	a.Metadata = []byte("old")
+	b := record(walletdb.InvoiceStateUsed, 2, "b")
+	b.UsedAt = 15
+	b.Metadata = []byte("new")
+	merged := merge(a, b)
+	if merged.UsedAt != 10 || merged.SettledAt != 20 || string(merged.Metadata) != "new" || merged.Device != "b" {
+		t.Fatalf("unexpected merge %+v", merged)
+	}
+
+	// ties are broken by device
+	a.UpdatedAt = 2
+	if merged := merge(a, b); string(merged.Metadata) != "new" {
+		t.Fatalf("expected device b to win the tie, got %+v", merged)
+	}
+
+	// settling a hold invoice and accepting overpayments can't be undone
+	a.Hold, b.Hold = false, true
+	a.OverpaymentAcceptedSat, b.OverpaymentAcceptedSat = 500, 100
+	if merged := merge(a, b); merged.Hold || merged.OverpaymentAcceptedSat != 500 {
+		t.Fatalf("unexpected merge %+v", merged)
+	}
+
+	forged := record(walletdb.InvoiceStateUsed, 1, "c")
+	forged.Preimage = randomBytes(32)
+	if _, err := MergeInvoice(a, forged); !errors.Is(err, ErrConflictingSecrets) {
+		t.Fatalf("expected ErrConflictingSecrets, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// Package cloudsync seals wallet records in end-to-end encrypted envelopes
+// that devices of the same wallet sync through an untrusted cloud relay, and
+// merges the records received from other devices.
+//
+// An envelope is
+//
+//	version (1) | record id (32) | revision (8) | nonce (12) | ciphertext
+//
+// sealed with AES-256-GCM, with everything before the nonce as additional
+// data. The record id is a keyed hash of the record key, eg the payment hash
+// of an invoice, so the relay can keep only the last revision of each record
+// without learning which one it is. The revision is a big endian unix time
+// in milliseconds of the last change of the record.
+//
+// Nonces are convergent: they are a keyed hash of the header and the
+// plaintext, so devices sealing the same record revision get the same
+// envelope, and since the nonce commits to the plaintext it's never reused
+// for different ones, even across devices that share no state. Envelopes
+// whose nonce doesn't match their content are rejected when opened.
+package cloudsync
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Version is the version of the envelopes sealed by this package.
+const Version = 1
+
+const (
+	recordIDSize = sha256.Size
+	revisionSize = 8
+	nonceSize    = 12
+	headerSize   = 1 + recordIDSize + revisionSize
+)
+
+var (
+	// ErrUnsupportedVersion is returned when opening an envelope sealed with
+	// an unknown version, eg by a newer app.
+	ErrUnsupportedVersion = errors.New("unsupported envelope version")
+	// ErrInvalidEnvelope is returned when opening an envelope that wasn't
+	// sealed with the keys of the wallet or was modified by the relay.
+	ErrInvalidEnvelope = errors.New("invalid envelope")
+)
+
+// Keys are the keys envelopes are sealed with, derived from a secret shared
+// by every device of the wallet.
+type Keys struct {
+	encryption []byte
+	nonce      []byte
+	recordID   []byte
+}
+
+// NewKeys derives the envelope keys from the given secret, which must be at
+// least 32 bytes long.
+func NewKeys(secret []byte) (*Keys, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("sync secret must be at least 32 bytes, got %v", len(secret))
+	}
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	return &Keys{
+		encryption: derive("cloudsync/encryption"),
+		nonce:      derive("cloudsync/nonce"),
+		recordID:   derive("cloudsync/record-id"),
+	}, nil
+}
+
+// RecordID returns the id the relay knows the record with the given key by.
+func (k *Keys) RecordID(recordKey []byte) []byte {
+	mac := hmac.New(sha256.New, k.recordID)
+	mac.Write(recordKey)
+	return mac.Sum(nil)
+}
+
+// Seal returns the envelope of the given revision of the record.
+func (k *Keys) Seal(recordKey []byte, revision uint64, plaintext []byte) ([]byte, error) {
+	header := make([]byte, headerSize)
+	header[0] = Version
+	copy(header[1:], k.RecordID(recordKey))
+	binary.BigEndian.PutUint64(header[1+recordIDSize:], revision)
+
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := k.convergentNonce(header, plaintext)
+
+	envelope := make([]byte, 0, headerSize+nonceSize+len(plaintext)+aead.Overhead())
+	envelope = append(envelope, header...)
+	envelope = append(envelope, nonce...)
+	return aead.Seal(envelope, nonce, plaintext, header), nil
+}
+
+// Opened is the content of an envelope.
+type Opened struct {
+	RecordID  []byte
+	Revision  uint64
+	Plaintext []byte
+}
+
+// Open authenticates and decrypts the envelope.
+func (k *Keys) Open(envelope []byte) (*Opened, error) {
+	if len(envelope) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidEnvelope)
+	}
+	if envelope[0] != Version {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedVersion, envelope[0])
+	}
+	if len(envelope) < headerSize+nonceSize {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidEnvelope)
+	}
+	header := envelope[:headerSize]
+	nonce := envelope[headerSize : headerSize+nonceSize]
+
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, envelope[headerSize+nonceSize:], header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	if !bytes.Equal(nonce, k.convergentNonce(header, plaintext)) {
+		return nil, fmt.Errorf("%w: nonce doesn't match its content", ErrInvalidEnvelope)
+	}
+
+	return &Opened{
+		RecordID:  append([]byte(nil), header[1:1+recordIDSize]...),
+		Revision:  binary.BigEndian.Uint64(header[1+recordIDSize:]),
+		Plaintext: plaintext,
+	}, nil
+}
+
+func (k *Keys) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.encryption)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k *Keys) convergentNonce(header, plaintext []byte) []byte {
+	mac := hmac.New(sha256.New, k.nonce)
+	mac.Write(header)
+	mac.Write(plaintext)
+	return mac.Sum(nil)[:nonceSize]
+}
@@ -0,0 +1,223 @@
+package cloudsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/muun/libwallet/hdpath"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// invoiceRecordPrefix namespaces the record keys of invoices, so other kinds
+// of records can be synced later without colliding.
+const invoiceRecordPrefix = "invoice:"
+
+// ErrConflictingSecrets is returned when merging records of the same invoice
+// whose secrets differ, which is never legitimate.
+var ErrConflictingSecrets = errors.New("conflicting invoice secrets")
+
+// InvoiceRecord is the synced state of an invoice: its secrets, which never
+// change, its state and the operation metadata set when it was handed out.
+// Times are unix milliseconds, 0 if unknown.
+type InvoiceRecord struct {
+	PaymentHash   []byte `json:"paymentHash"`
+	Preimage      []byte `json:"preimage"`
+	PaymentSecret []byte `json:"paymentSecret"`
+	KeyPath       string `json:"keyPath"`
+	// IdentityKeyPath is empty if the identity key is derived from KeyPath
+	IdentityKeyPath string `json:"identityKeyPath,omitempty"`
+	ShortChanId     uint64 `json:"shortChanId"`
+	Network         string `json:"network,omitempty"`
+
+	State     walletdb.InvoiceState `json:"state"`
+	UsedAt    int64                 `json:"usedAt,omitempty"`
+	SettledAt int64                 `json:"settledAt,omitempty"`
+
+	AmountSat       int64  `json:"amountSat,omitempty"`
+	FallbackAddress string `json:"fallbackAddress,omitempty"`
+	CltvExpiry      int64  `json:"cltvExpiry,omitempty"`
+	ExpiresAt       int64  `json:"expiresAt,omitempty"`
+	Amp             bool   `json:"amp,omitempty"`
+	Metadata        []byte `json:"metadata,omitempty"`
+	DisplayCurrency string `json:"displayCurrency,omitempty"`
+	Locale          string `json:"locale,omitempty"`
+
+	// Hold is cleared once the invoice is settled and never set again
+	Hold                   bool  `json:"hold,omitempty"`
+	OverpaymentAcceptedSat int64 `json:"overpaymentAcceptedSat,omitempty"`
+
+	UpdatedAt int64  `json:"updatedAt"`
+	Device    string `json:"device"` // that made the last change
+}
+
+// SealInvoice returns the envelope of the record, its revision being the
+// time of its last change.
+func (k *Keys) SealInvoice(record *InvoiceRecord) ([]byte, error) {
+	plaintext, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return k.Seal(invoiceRecordKey(record.PaymentHash), uint64(record.UpdatedAt), plaintext)
+}
+
+// IsSyncedState returns whether invoices in the state are synced. Secrets
+// that were never handed out stay on the device that generated them, or both
+// devices could hand out the same one.
+func IsSyncedState(state walletdb.InvoiceState) bool {
+	switch state {
+	case walletdb.InvoiceStateRegistered, walletdb.InvoiceStatePendingUse, walletdb.InvoiceStateSuperseded:
+		return false
+	}
+	return true
+}
+
+// OpenInvoice returns the invoice record sealed in the envelope. Records
+// with invalid key paths are rejected like tampered ones.
+func (k *Keys) OpenInvoice(envelope []byte) (*InvoiceRecord, error) {
+	opened, err := k.Open(envelope)
+	if err != nil {
+		return nil, err
+	}
+	var record InvoiceRecord
+	if err := json.Unmarshal(opened.Plaintext, &record); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	// the relay could swap the ids of envelopes it can't open
+	if !bytes.Equal(opened.RecordID, k.RecordID(invoiceRecordKey(record.PaymentHash))) {
+		return nil, fmt.Errorf("%w: record id doesn't match its invoice", ErrInvalidEnvelope)
+	}
+	if err := validateKeyPaths(&record); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	return &record, nil
+}
+
+// validateKeyPaths checks the key paths of the record are wallet paths, the
+// same way the wallet db does. Only imported invoices have none.
+func validateKeyPaths(record *InvoiceRecord) error {
+	if record.KeyPath == "" && record.State != walletdb.InvoiceStateImported {
+		return errors.New("empty key path")
+	}
+	for _, path := range []string{record.KeyPath, record.IdentityKeyPath} {
+		if path == "" {
+			continue
+		}
+		if err := hdpath.Validate(path); err != nil {
+			return fmt.Errorf("invalid key path: %w", err)
+		}
+	}
+	return nil
+}
+
+func invoiceRecordKey(paymentHash []byte) []byte {
+	return append([]byte(invoiceRecordPrefix), paymentHash...)
+}
+
+// MergeInvoice returns the result of merging two records of the same invoice.
+// Merging is commutative, so every device converges to the same record
+// whatever the order they see the changes in:
+//
+//   - secrets must match, or ErrConflictingSecrets is returned.
+//   - the state is the one further along the invoice lifecycle. If neither
+//     can reach the other, a paid state wins over one that isn't, then the
+//     first of refunded, settled, imported, accepted, cancelled, expired.
+//   - the settled time goes with the state and the used time is the earliest.
+//   - the invoice is held only if it's held in both, and the accepted
+//     overpayment is the largest.
+//   - the amount, expiry and operation metadata are the last written, ties
+//     broken by device.
+func MergeInvoice(a, b *InvoiceRecord) (*InvoiceRecord, error) {
+	if !bytes.Equal(a.PaymentHash, b.PaymentHash) ||
+		!bytes.Equal(a.Preimage, b.Preimage) ||
+		!bytes.Equal(a.PaymentSecret, b.PaymentSecret) ||
+		a.KeyPath != b.KeyPath ||
+		a.IdentityKeyPath != b.IdentityKeyPath ||
+		a.ShortChanId != b.ShortChanId {
+		return nil, fmt.Errorf("%w for payment hash %x", ErrConflictingSecrets, a.PaymentHash)
+	}
+
+	merged := *lastWritten(a, b)
+
+	stateSource := b
+	if mergeState(a.State, b.State) == a.State {
+		stateSource = a
+	}
+	merged.State = stateSource.State
+	merged.SettledAt = stateSource.SettledAt
+
+	merged.UsedAt = a.UsedAt
+	if merged.UsedAt == 0 || (b.UsedAt != 0 && b.UsedAt < merged.UsedAt) {
+		merged.UsedAt = b.UsedAt
+	}
+	merged.Hold = a.Hold && b.Hold
+	merged.OverpaymentAcceptedSat = a.OverpaymentAcceptedSat
+	if b.OverpaymentAcceptedSat > merged.OverpaymentAcceptedSat {
+		merged.OverpaymentAcceptedSat = b.OverpaymentAcceptedSat
+	}
+	if merged.Network == "" {
+		merged.Network = a.Network
+		if merged.Network == "" {
+			merged.Network = b.Network
+		}
+	}
+	return &merged, nil
+}
+
+func lastWritten(a, b *InvoiceRecord) *InvoiceRecord {
+	if a.UpdatedAt != b.UpdatedAt {
+		if a.UpdatedAt > b.UpdatedAt {
+			return a
+		}
+		return b
+	}
+	if a.Device >= b.Device {
+		return a
+	}
+	return b
+}
+
+// divergedStatePrecedence decides between states that can't reach each
+// other, eg an invoice cancelled on a device and paid on another.
+var divergedStatePrecedence = []walletdb.InvoiceState{
+	walletdb.InvoiceStateRefunded,
+	walletdb.InvoiceStateSettled,
+	walletdb.InvoiceStateImported,
+	walletdb.InvoiceStateAccepted,
+	walletdb.InvoiceStateAbandoned,
+	walletdb.InvoiceStateCancelled,
+	walletdb.InvoiceStateSuperseded,
+	walletdb.InvoiceStateExpired,
+}
+
+func mergeState(a, b walletdb.InvoiceState) walletdb.InvoiceState {
+	switch {
+	case a.CanTransitionTo(b) && b.CanTransitionTo(a):
+		// registered and pending use can reach each other, keeping the secret
+		// reserved is safer since the invoice may have been handed out
+		if a == walletdb.InvoiceStateRegistered {
+			return b
+		}
+		return a
+	case a.CanTransitionTo(b):
+		return b
+	case b.CanTransitionTo(a):
+		return a
+	case a.IsPaid() != b.IsPaid():
+		if a.IsPaid() {
+			return a
+		}
+		return b
+	}
+	for _, state := range divergedStatePrecedence {
+		if a == state || b == state {
+			return state
+		}
+	}
+	// unknown states, eg written by a newer app
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,180 @@
+// Command apollo-recover is a guided recovery tool built on libwallet. It
+// decrypts the keys in an emergency kit, lists the wallet addresses that must
+// be scanned for funds and builds a fully signed transaction sweeping them to
+// an address of the user's choosing. The decrypted private keys are never
+// printed, only the public ones.
+//
+// There is no chain backend in this repository, so the tool doesn't look up
+// balances itself. The unspent outputs of the addresses listed by scan must be
+// looked up elsewhere, eg with a block explorer or a bitcoind node, and given
+// to the sweep command as txid:index:amount:path:version, where txid and index
+// identify the output, amount is its value in satoshis, and path and version
+// are the ones scan printed for its address. The sweep tx is printed in hex
+// and must be broadcast the same way. Its fee is checked to be between
+// minSweepFeeRate and maxSweepFeeRate sats/vbyte, so a typo can't burn the
+// funds or produce a tx that doesn't relay.
+//
+// The scan command also accepts output descriptors (eg exported from another
+// wallet) to list addresses of custom or legacy scripts, in which case the
+// emergency kit is optional. Outputs of pkh, wpkh and sh(wpkh) descriptors
+// with private keys can be swept with the sweep-descriptor command.
+//
+// Usage:
+//
+//	apollo-recover decrypt -code <recovery code> -user-key <key> -muun-key <key>
+//	apollo-recover scan -code <recovery code> -user-key <key> -muun-key <key> [-gap 100] \
+//		[-descriptor <descriptor> ...]
+//	apollo-recover sweep -code <recovery code> -user-key <key> -muun-key <key> \
+//		-utxo <txid:index:amount:path:version> [-utxo ...] -to <address> -fee <sats>
+//	apollo-recover sweep-descriptor -descriptor <descriptor> \
+//		-utxo <txid:index:amount:address index> [-utxo ...] -to <address> -fee <sats>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/muun/libwallet"
+	"github.com/muun/libwallet/hdpath"
+)
+
+// recoveryKeyPath is the path of the keys exported in the emergency kit.
+const recoveryKeyPath = hdpath.BasePath
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "decrypt":
+		err = runDecrypt(os.Args[2:])
+	case "scan":
+		err = runScan(os.Args[2:])
+	case "sweep":
+		err = runSweep(os.Args[2:])
+	case "sweep-descriptor":
+		err = runSweepDescriptor(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: apollo-recover <decrypt|scan|sweep|sweep-descriptor> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "scan lists the addresses to look up. Their unspent outputs must be given to")
+	fmt.Fprintln(os.Stderr, "sweep as -utxo txid:index:amount:path:version, with the path and version scan")
+	fmt.Fprintln(os.Stderr, "printed for the address. Run a command with -h for its flags.")
+	os.Exit(2)
+}
+
+// keyFlags are the flags shared by every command to obtain the wallet keys.
+type keyFlags struct {
+	code    string
+	userKey string
+	muunKey string
+	testnet bool
+	regtest bool
+}
+
+func (f *keyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.code, "code", "", "recovery code")
+	fs.StringVar(&f.userKey, "user-key", "", "first encrypted key from the emergency kit")
+	fs.StringVar(&f.muunKey, "muun-key", "", "second encrypted key from the emergency kit")
+	fs.BoolVar(&f.testnet, "testnet", false, "use testnet")
+	fs.BoolVar(&f.regtest, "regtest", false, "use regtest")
+}
+
+func (f *keyFlags) params() *chaincfg.Params {
+	switch {
+	case f.testnet:
+		return &chaincfg.TestNet3Params
+	case f.regtest:
+		return &chaincfg.RegressionNetParams
+	default:
+		return &chaincfg.MainNetParams
+	}
+}
+
+func (f *keyFlags) network() *libwallet.Network {
+	switch {
+	case f.testnet:
+		return libwallet.Testnet()
+	case f.regtest:
+		return libwallet.Regtest()
+	default:
+		return libwallet.Mainnet()
+	}
+}
+
+// decryptKeys returns the user and muun keys decrypted with the recovery code,
+// along with the wallet birthday.
+func (f *keyFlags) decryptKeys() (userKey, muunKey *libwallet.HDPrivateKey, birthday int, err error) {
+	if f.code == "" || f.userKey == "" || f.muunKey == "" {
+		return nil, nil, 0, fmt.Errorf("the recovery code and both encrypted keys are required")
+	}
+
+	if err := libwallet.ValidateRecoveryCode(f.code); err != nil {
+		return nil, nil, 0, fmt.Errorf("invalid recovery code: %w", err)
+	}
+
+	decryptedUserKey, err := decryptKey(f.code, f.userKey, f.network())
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to decrypt first key: %w", err)
+	}
+
+	decryptedMuunKey, err := decryptKey(f.code, f.muunKey, f.network())
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to decrypt second key: %w", err)
+	}
+
+	return decryptedUserKey.Key, decryptedMuunKey.Key, decryptedMuunKey.Birthday, nil
+}
+
+func decryptKey(code, encryptedKey string, network *libwallet.Network) (*libwallet.DecryptedPrivateKey, error) {
+	info, err := libwallet.DecodeEncryptedPrivateKey(encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	challengeKey, err := libwallet.RecoveryCodeToKey(code, info.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := challengeKey.DecryptKey(info, network)
+	if err != nil {
+		return nil, err
+	}
+	decrypted.Key.Path = recoveryKeyPath
+
+	return decrypted, nil
+}
+
+func runDecrypt(args []string) error {
+	var keys keyFlags
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keys.register(fs)
+	fs.Parse(args)
+
+	userKey, muunKey, birthday, err := keys.decryptKeys()
+	if err != nil {
+		return err
+	}
+
+	// Only the public keys are shown, to check the kit decrypts to the right
+	// wallet without leaving the private keys in the terminal history
+	fmt.Printf("user key:  %v\n", userKey.PublicKey().String())
+	fmt.Printf("muun key:  %v\n", muunKey.PublicKey().String())
+	fmt.Printf("birthday:  %v\n", birthday)
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/muun/libwallet"
+	"github.com/muun/libwallet/descriptors"
+	"github.com/muun/libwallet/hdpath"
+)
+
+// descriptorFlags collects the repeated -descriptor flags.
+type descriptorFlags []string
+
+func (f *descriptorFlags) String() string {
+	return fmt.Sprintf("%v descriptors", len(*f))
+}
+
+func (f *descriptorFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func runScan(args []string) error {
+	var keys keyFlags
+	var descs descriptorFlags
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	keys.register(fs)
+	fs.Var(&descs, "descriptor", "output descriptor to scan in addition to the wallet, can be repeated")
+	gap := fs.Int("gap", 100, "number of addresses to list for each branch")
+	fs.Parse(args)
+
+	// Descriptors can be scanned on their own, without an emergency kit
+	if len(descs) == 0 || keys.code != "" || keys.userKey != "" || keys.muunKey != "" {
+		userKey, muunKey, _, err := keys.decryptKeys()
+		if err != nil {
+			return err
+		}
+		if err := scanWallet(userKey, muunKey, *gap); err != nil {
+			return err
+		}
+	}
+
+	for n, desc := range descs {
+		if err := scanDescriptor(n, desc, keys.params(), *gap); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func scanWallet(userKey, muunKey *libwallet.HDPrivateKey, gap int) error {
+	branches := []hdpath.Branch{hdpath.ChangeBranch, hdpath.ExternalBranch}
+
+	for _, branch := range branches {
+		for i := 0; i < gap; i++ {
+			path := hdpath.MustParse(recoveryKeyPath).Branch(branch).Child(uint32(i))
+
+			addrs, err := deriveAddresses(userKey.PublicKey(), muunKey.PublicKey(), path.String())
+			if err != nil {
+				return err
+			}
+			for _, addr := range addrs {
+				fmt.Printf("%v\t%v\t%v\n", addr.DerivationPath(), addr.Version(), addr.Address())
+			}
+		}
+	}
+
+	return nil
+}
+
+// scanDescriptor lists the addresses of the n-th externally supplied
+// descriptor, one per index for ranged descriptors. Funds found there can be
+// swept with sweep-descriptor if the descriptor has private keys, which is
+// why descriptors are identified by their position instead of echoed.
+func scanDescriptor(n int, desc string, params *chaincfg.Params, gap int) error {
+	d, err := descriptors.Parse(desc, params)
+	if err != nil {
+		return fmt.Errorf("invalid descriptor %v: %w", n, err)
+	}
+
+	count := 1
+	if d.IsRange() {
+		count = gap
+	}
+
+	for i := 0; i < count; i++ {
+		addr, err := d.Address(uint32(i))
+		if err != nil {
+			return fmt.Errorf("failed to derive descriptor %v at %v: %w", n, i, err)
+		}
+		fmt.Printf("descriptor %v\t%v\t%v\n", n, i, addr.EncodeAddress())
+	}
+
+	return nil
+}
+
+// deriveAddresses returns every address version the wallet may have used at
+// the given path.
+func deriveAddresses(userKey, muunKey *libwallet.HDPublicKey, path string) ([]libwallet.MuunAddress, error) {
+	derivedUserKey, err := userKey.DeriveTo(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive user key to %v: %w", path, err)
+	}
+	derivedMuunKey, err := muunKey.DeriveTo(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive muun key to %v: %w", path, err)
+	}
+
+	v2, err := libwallet.CreateAddressV2(derivedUserKey, derivedMuunKey)
+	if err != nil {
+		return nil, err
+	}
+	v3, err := libwallet.CreateAddressV3(derivedUserKey, derivedMuunKey)
+	if err != nil {
+		return nil, err
+	}
+	v4, err := libwallet.CreateAddressV4(derivedUserKey, derivedMuunKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return []libwallet.MuunAddress{v2, v3, v4}, nil
+}
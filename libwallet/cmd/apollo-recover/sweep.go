@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/muun/libwallet"
+	"github.com/muun/libwallet/addresses"
+	"github.com/muun/libwallet/descriptors"
+	"github.com/muun/libwallet/desktop"
+)
+
+// Bounds of the fee rate of sweep txs, in sats/vbyte. Below the minimum the
+// tx doesn't relay, and a rate above the maximum is most likely a typo.
+const (
+	minSweepFeeRate = 1
+	maxSweepFeeRate = 1000
+)
+
+// utxoFlags collects the repeated -utxo flags.
+type utxoFlags []*utxo
+
+func (f *utxoFlags) String() string {
+	return fmt.Sprintf("%v utxos", len(*f))
+}
+
+func (f *utxoFlags) Set(value string) error {
+	u, err := parseUtxo(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, u)
+	return nil
+}
+
+func runSweep(args []string) error {
+	var keys keyFlags
+	var utxos utxoFlags
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	keys.register(fs)
+	fs.Var(&utxos, "utxo", "unspent output as txid:index:amount:path:version, with the path and version printed by scan, can be repeated")
+	to := fs.String("to", "", "destination address")
+	fee := fs.Int64("fee", 0, "total fee in satoshis")
+	fs.Parse(args)
+
+	if len(utxos) == 0 {
+		return fmt.Errorf("at least one utxo is required")
+	}
+
+	userKey, muunKey, _, err := keys.decryptKeys()
+	if err != nil {
+		return err
+	}
+
+	signed, err := signSweep(utxos, *to, *fee, userKey, muunKey, keys.params())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("txid: %v\n", signed.Hash)
+	fmt.Printf("%v\n", hex.EncodeToString(signed.Bytes))
+	return nil
+}
+
+// signSweep returns a tx spending the wallet utxos to the destination address
+// minus the fee, signed with the decrypted keys.
+func signSweep(utxos []*utxo, to string, fee int64, userKey, muunKey *libwallet.HDPrivateKey, params *chaincfg.Params) (*libwallet.Transaction, error) {
+	var inputs []libwallet.Input
+	var outPoints []wire.OutPoint
+	var total int64
+	for _, u := range utxos {
+		inputs = append(inputs, u)
+		outPoints = append(outPoints, wire.OutPoint{Hash: u.txID, Index: uint32(u.index)})
+		total += u.amount
+	}
+
+	tx, err := buildSweepTx(outPoints, total, to, fee, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return nil, fmt.Errorf("failed to serialize sweep tx: %w", err)
+	}
+
+	signed, err := desktop.FullySignSweep(context.Background(), inputs, buf.Bytes(), userKey, muunKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign sweep tx: %w", err)
+	}
+
+	signedTx := wire.NewMsgTx(2)
+	if err := signedTx.Deserialize(bytes.NewReader(signed.Bytes)); err != nil {
+		return nil, fmt.Errorf("failed to parse signed sweep tx: %w", err)
+	}
+	if err := checkSweepFeeRate(signedTx, fee); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// runSweepDescriptor sweeps outputs of a descriptor with private keys, eg
+// funds sent to a legacy script found with scan -descriptor.
+func runSweepDescriptor(args []string) error {
+	var keys keyFlags
+	var utxos descriptorUtxoFlags
+	fs := flag.NewFlagSet("sweep-descriptor", flag.ExitOnError)
+	keys.register(fs)
+	desc := fs.String("descriptor", "", "output descriptor with private keys")
+	fs.Var(&utxos, "utxo", "unspent output as txid:index:amount:address index, can be repeated")
+	to := fs.String("to", "", "destination address")
+	fee := fs.Int64("fee", 0, "total fee in satoshis")
+	fs.Parse(args)
+
+	if len(utxos) == 0 {
+		return fmt.Errorf("at least one utxo is required")
+	}
+
+	d, err := descriptors.Parse(*desc, keys.params())
+	if err != nil {
+		return fmt.Errorf("invalid descriptor: %w", err)
+	}
+
+	var outPoints []wire.OutPoint
+	var total int64
+	for _, u := range utxos {
+		outPoints = append(outPoints, wire.OutPoint{Hash: u.txID, Index: uint32(u.index)})
+		total += u.amount
+	}
+
+	tx, err := buildSweepTx(outPoints, total, *to, *fee, keys.params())
+	if err != nil {
+		return err
+	}
+	for i, u := range utxos {
+		if err := d.Sign(tx, i, u.amount, u.addressIndex); err != nil {
+			return fmt.Errorf("failed to sign input %v: %w", i, err)
+		}
+	}
+	if err := checkSweepFeeRate(tx, *fee); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return fmt.Errorf("failed to serialize sweep tx: %w", err)
+	}
+
+	fmt.Printf("txid: %v\n", tx.TxHash())
+	fmt.Printf("%v\n", hex.EncodeToString(buf.Bytes()))
+	return nil
+}
+
+// buildSweepTx returns an unsigned tx spending the outpoints, worth total, to
+// the destination address minus the fee.
+func buildSweepTx(outPoints []wire.OutPoint, total int64, to string, fee int64, params *chaincfg.Params) (*wire.MsgTx, error) {
+	destination, err := btcutil.DecodeAddress(to, params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination address: %w", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination address: %w", err)
+	}
+
+	if total-fee <= 0 {
+		return nil, fmt.Errorf("fee (%v) exceeds the swept amount (%v)", fee, total)
+	}
+
+	tx := wire.NewMsgTx(2)
+	for i := range outPoints {
+		tx.AddTxIn(wire.NewTxIn(&outPoints[i], nil, nil))
+	}
+	tx.AddTxOut(wire.NewTxOut(total-fee, pkScript))
+	return tx, nil
+}
+
+// checkSweepFeeRate checks the fee of the signed tx pays a rate between
+// minSweepFeeRate and maxSweepFeeRate.
+func checkSweepFeeRate(tx *wire.MsgTx, fee int64) error {
+	weight := blockchain.GetTransactionWeight(btcutil.NewTx(tx))
+	vsize := (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor
+	rate := float64(fee) / float64(vsize)
+
+	if rate < minSweepFeeRate {
+		return fmt.Errorf("fee rate %.2f sats/vbyte is below the minimum of %v, the tx wouldn't relay", rate, minSweepFeeRate)
+	}
+	if rate > maxSweepFeeRate {
+		return fmt.Errorf("fee rate %.2f sats/vbyte is above the maximum of %v, check the fee", rate, maxSweepFeeRate)
+	}
+	return nil
+}
+
+// descriptorUtxoFlags collects the repeated -utxo flags of sweep-descriptor.
+type descriptorUtxoFlags []*descriptorUtxo
+
+func (f *descriptorUtxoFlags) String() string {
+	return fmt.Sprintf("%v utxos", len(*f))
+}
+
+func (f *descriptorUtxoFlags) Set(value string) error {
+	u, err := parseDescriptorUtxo(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, u)
+	return nil
+}
+
+// descriptorUtxo is an unspent output found while scanning the addresses of
+// a descriptor.
+type descriptorUtxo struct {
+	txID         chainhash.Hash
+	index        int
+	amount       int64
+	addressIndex uint32 // index of the address in the descriptor range
+}
+
+func parseDescriptorUtxo(value string) (*descriptorUtxo, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid utxo %v, expected txid:index:amount:address index", value)
+	}
+
+	txID, err := chainhash.NewHashFromStr(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid utxo txid: %w", err)
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid utxo index: %w", err)
+	}
+	amount, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid utxo amount: %w", err)
+	}
+	addressIndex, err := strconv.ParseUint(parts[3], 10, 31)
+	if err != nil {
+		return nil, fmt.Errorf("invalid utxo address index: %w", err)
+	}
+
+	return &descriptorUtxo{
+		txID:         *txID,
+		index:        index,
+		amount:       amount,
+		addressIndex: uint32(addressIndex),
+	}, nil
+}
+
+// utxo is an unspent output found while scanning the wallet addresses. It
+// implements libwallet.Input so it can be signed by libwallet.
+type utxo struct {
+	txID    chainhash.Hash
+	index   int
+	amount  int64
+	path    string
+	version int
+}
+
+func parseUtxo(value string) (*utxo, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 5 {
+		return nil, fmt.Errorf("invalid utxo %v, expected txid:index:amount:path:version", value)
+	}
+
+	txID, err := chainhash.NewHashFromStr(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid utxo txid: %w", err)
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid utxo index: %w", err)
+	}
+	amount, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid utxo amount: %w", err)
+	}
+	version, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid utxo version: %w", err)
+	}
+	// The derivation path contains colons itself, so it spans the middle parts
+	path := strings.Join(parts[3:len(parts)-1], ":")
+
+	return &utxo{
+		txID:    *txID,
+		index:   index,
+		amount:  amount,
+		path:    path,
+		version: version,
+	}, nil
+}
+
+func (u *utxo) OutPoint() libwallet.Outpoint { return u }
+func (u *utxo) TxId() []byte                 { return u.txID[:] }
+func (u *utxo) Index() int                   { return u.index }
+func (u *utxo) Amount() int64                { return u.amount }
+
+func (u *utxo) Address() libwallet.MuunAddress {
+	return addresses.New(u.version, u.path, "")
+}
+
+func (u *utxo) UserSignature() []byte                           { return nil }
+func (u *utxo) MuunSignature() []byte                           { return nil }
+func (u *utxo) SubmarineSwapV1() libwallet.InputSubmarineSwapV1 { return nil }
+func (u *utxo) SubmarineSwapV2() libwallet.InputSubmarineSwapV2 { return nil }
+func (u *utxo) IncomingSwap() libwallet.InputIncomingSwap       { return nil }
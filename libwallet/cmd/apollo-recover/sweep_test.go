@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/muun/libwallet"
+	"github.com/muun/libwallet/addresses"
+)
+
+const recoveryPath = "m/schema:1'/recovery:1'"
+
+func TestSignSweep(t *testing.T) {
+	network := libwallet.Regtest()
+	params := &chaincfg.RegressionNetParams
+
+	userKey, err := libwallet.NewHDPrivateKey(bytes.Repeat([]byte{1}, 32), network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey.Path = recoveryPath
+	muunKey, err := libwallet.NewHDPrivateKey(bytes.Repeat([]byte{2}, 32), network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	muunKey.Path = recoveryPath
+
+	// utxos as given on the command line, which carry no address
+	var utxos []*utxo
+	for i, version := range []int{addresses.V3, addresses.V4} {
+		value := fmt.Sprintf(
+			"%064x:%v:%v:%v/external:1/%v:%v", i+1, i, 10000, recoveryPath, i, version,
+		)
+		u, err := parseUtxo(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		utxos = append(utxos, u)
+	}
+
+	destinationKey, err := userKey.PublicKey().DeriveTo(recoveryPath + "/external:1/100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	destination, err := libwallet.CreateAddressV1(destinationKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := signSweep(utxos, destination.Address(), 1000, userKey, muunKey, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := wire.NewMsgTx(2)
+	if err := tx.Deserialize(bytes.NewReader(signed.Bytes)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.TxIn) != 2 || len(tx.TxOut) != 1 {
+		t.Fatalf("expected 2 inputs and 1 output, got %v and %v", len(tx.TxIn), len(tx.TxOut))
+	}
+	if tx.TxOut[0].Value != 19000 {
+		t.Fatalf("expected the swept amount minus the fee, got %v", tx.TxOut[0].Value)
+	}
+
+	// the fee rate bounds are still enforced
+	if _, err := signSweep(utxos, destination.Address(), 1, userKey, muunKey, params); err == nil {
+		t.Fatal("expected error with a fee rate below the minimum")
+	}
+}
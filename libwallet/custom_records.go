@@ -0,0 +1,73 @@
+package libwallet
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CustomRecord is a custom tlv record sent by the payer in the onion, eg to
+// identify the order being paid.
+type CustomRecord struct {
+	Type  int64
+	Value []byte
+}
+
+// CustomRecordList is a wrapper around a CustomRecord slice to be able to pass
+// through the gomobile bridge.
+type CustomRecordList struct {
+	records []*CustomRecord
+}
+
+// Length returns the number of records in the list.
+func (l *CustomRecordList) Length() int {
+	return len(l.records)
+}
+
+// Get returns the record at the given index.
+func (l *CustomRecordList) Get(i int) *CustomRecord {
+	return l.records[i]
+}
+
+// InvoiceMetadata returns the metadata given in InvoiceOptions when creating
+// the invoice paid by the swap, or nil if there's none.
+func (s *IncomingSwap) InvoiceMetadata() (_ []byte, err error) {
+	defer recordErrors("InvoiceMetadata", &err)
+
+	invoice, err := s.getInvoice()
+	if err != nil {
+		return nil, fmt.Errorf("InvoiceMetadata: could not find invoice data for payment hash: %w", err)
+	}
+	return invoice.Metadata, nil
+}
+
+// CustomRecords returns the custom tlv records included by the payer in the
+// sphinx of the swap, sorted by type.
+func (s *IncomingSwap) CustomRecords(userKey *HDPrivateKey, net *Network) (_ *CustomRecordList, err error) {
+	defer recordErrors("CustomRecords", &err)
+
+	if len(s.SphinxPacket) == 0 {
+		return &CustomRecordList{}, nil
+	}
+
+	invoice, err := s.getInvoice()
+	if err != nil {
+		return nil, fmt.Errorf("CustomRecords: could not find invoice data for payment hash: %w", err)
+	}
+
+	payload, err := decodeSwapSphinx(s, invoiceIdentityKeyPath(invoice), userKey, net)
+	if err != nil {
+		return nil, fmt.Errorf("CustomRecords: invalid sphinx: %w", err)
+	}
+
+	list := &CustomRecordList{}
+	for recordType, value := range payload.CustomRecords() {
+		list.records = append(list.records, &CustomRecord{
+			Type:  int64(recordType),
+			Value: value,
+		})
+	}
+	sort.Slice(list.records, func(i, j int) bool {
+		return list.records[i].Type < list.records[j].Type
+	})
+	return list, nil
+}
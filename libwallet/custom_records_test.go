@@ -0,0 +1,154 @@
+package libwallet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	sphinx "github.com/lightningnetwork/lightning-onion"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+func createSphinxPacketWithRecords(nodePublicKey *btcec.PublicKey, paymentHash, paymentSecret []byte, amt, lockTime int64, records record.CustomSet) []byte {
+	var paymentPath sphinx.PaymentPath
+	paymentPath[0].NodePub = *nodePublicKey
+
+	var secret [32]byte
+	copy(secret[:], paymentSecret)
+	uintAmount := uint64(amt * 1000) // msat are expected
+	uintLocktime := uint32(lockTime)
+	tlvRecords := []tlv.Record{
+		record.NewAmtToFwdRecord(&uintAmount),
+		record.NewLockTimeRecord(&uintLocktime),
+		record.NewMPP(lnwire.MilliSatoshi(uintAmount), secret).Record(),
+	}
+	tlvRecords = append(tlvRecords, tlv.MapToRecords(records)...)
+	tlv.SortRecords(tlvRecords)
+
+	b := &bytes.Buffer{}
+	tlv.MustNewStream(tlvRecords...).Encode(b)
+	hopPayload, err := sphinx.NewHopPayload(nil, b.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	paymentPath[0].HopPayload = hopPayload
+
+	ephemeralKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		panic(err)
+	}
+
+	pkt, err := sphinx.NewOnionPacket(
+		&paymentPath, ephemeralKey, paymentHash, sphinx.BlankPacketFiller)
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	err = pkt.Encode(&buf)
+	if err != nil {
+		panic(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestIncomingSwapCustomRecords(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	metadata := []byte("order-1234")
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		AmountSat: 1000,
+		Metadata:  metadata,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var invoiceSecrets *InvoiceSecrets
+	for i := 0; i < secrets.Length(); i++ {
+		if bytes.Equal(secrets.Get(i).PaymentHash, payreq.PaymentHash[:]) {
+			invoiceSecrets = secrets.Get(i)
+		}
+	}
+	nodePublicKey, err := invoiceSecrets.IdentityKey.key.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := record.CustomSet{
+		100001: []byte("second"),
+		65537:  []byte("first"),
+	}
+	swap := &IncomingSwap{
+		SphinxPacket:     createSphinxPacketWithRecords(nodePublicKey, invoiceSecrets.PaymentHash, invoiceSecrets.paymentSecret, 1000, 800, records),
+		PaymentHash:      invoiceSecrets.PaymentHash,
+		PaymentAmountSat: 1000,
+	}
+
+	if err := swap.VerifyFulfillable(userKey, network); err != nil {
+		t.Fatal(err)
+	}
+
+	gotMetadata, err := swap.InvoiceMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotMetadata, metadata) {
+		t.Fatalf("expected metadata %q, got %q", metadata, gotMetadata)
+	}
+
+	list, err := swap.CustomRecords(userKey, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Length() != 2 {
+		t.Fatalf("expected 2 custom records, got %v", list.Length())
+	}
+	if list.Get(0).Type != 65537 || string(list.Get(0).Value) != "first" {
+		t.Fatalf("unexpected first record %+v", list.Get(0))
+	}
+	if list.Get(1).Type != 100001 || string(list.Get(1).Value) != "second" {
+		t.Fatalf("unexpected second record %+v", list.Get(1))
+	}
+
+	// swaps without sphinx have no records
+	swap.SphinxPacket = nil
+	list, err = swap.CustomRecords(userKey, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Length() != 0 {
+		t.Fatalf("expected no custom records, got %v", list.Length())
+	}
+}
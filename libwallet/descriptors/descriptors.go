@@ -0,0 +1,559 @@
+// Package descriptors parses output script descriptors (BIP380) and derives
+// the addresses they describe, so funds sent to script variants the wallet
+// doesn't produce itself can still be found.
+//
+// Supported are pkh, wpkh, sh, wsh, multi, sortedmulti and addr expressions
+// over hex public keys, WIF private keys and extended keys with optional key
+// origins and a trailing unhardened wildcard. Hex keys keep their
+// serialization, so uncompressed keys describe the legacy pkh scripts made
+// with them. Descriptors with private keys can sign spends of their single
+// key outputs, see Sign. Hardened derivation and taproot are not supported.
+package descriptors
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+const (
+	inputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	checksumLength  = 8
+)
+
+// Descriptor is a parsed output descriptor.
+type Descriptor struct {
+	root *expr
+	net  *chaincfg.Params
+}
+
+type expr struct {
+	fn        string // pkh, wpkh, sh, wsh, multi, sortedmulti or addr
+	keys      []*key
+	threshold int
+	sub       *expr
+	addr      btcutil.Address
+}
+
+type key struct {
+	pubKey     *btcec.PublicKey
+	compressed bool              // serialization of pubKey
+	privKey    *btcec.PrivateKey // nil unless given as WIF
+	xkey       *hdkeychain.ExtendedKey
+	path       []uint32
+	wildcard   bool
+}
+
+// Parse parses a descriptor for the given network. If the descriptor has a
+// checksum, it's verified.
+func Parse(descriptor string, net *chaincfg.Params) (*Descriptor, error) {
+	descriptor = strings.TrimSpace(descriptor)
+
+	if i := strings.LastIndex(descriptor, "#"); i >= 0 {
+		body, checksum := descriptor[:i], descriptor[i+1:]
+		expected, err := Checksum(body)
+		if err != nil {
+			return nil, err
+		}
+		if checksum != expected {
+			return nil, fmt.Errorf("invalid checksum %v, expected %v", checksum, expected)
+		}
+		descriptor = body
+	}
+
+	p := &parser{input: descriptor, net: net}
+	root, err := p.parseExpr(true, false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %v", p.input[p.pos:], p.pos)
+	}
+
+	return &Descriptor{root: root, net: net}, nil
+}
+
+// Checksum returns the BIP380 checksum of a descriptor without one.
+func Checksum(descriptor string) (string, error) {
+	c := uint64(1)
+	cls := 0
+	clsCount := 0
+	for _, ch := range descriptor {
+		pos := strings.IndexRune(inputCharset, ch)
+		if pos < 0 {
+			return "", fmt.Errorf("invalid character %q in descriptor", ch)
+		}
+		c = polymod(c, uint64(pos&31))
+		cls = cls*3 + (pos >> 5)
+		clsCount++
+		if clsCount == 3 {
+			c = polymod(c, uint64(cls))
+			cls = 0
+			clsCount = 0
+		}
+	}
+	if clsCount > 0 {
+		c = polymod(c, uint64(cls))
+	}
+	for i := 0; i < checksumLength; i++ {
+		c = polymod(c, 0)
+	}
+	c ^= 1
+
+	var result [checksumLength]byte
+	for i := range result {
+		result[i] = checksumCharset[(c>>(5*(7-uint(i))))&31]
+	}
+	return string(result[:]), nil
+}
+
+func polymod(c, val uint64) uint64 {
+	c0 := c >> 35
+	c = ((c & 0x7ffffffff) << 5) ^ val
+	if c0&1 != 0 {
+		c ^= 0xf5dee51989
+	}
+	if c0&2 != 0 {
+		c ^= 0xa9fdca3312
+	}
+	if c0&4 != 0 {
+		c ^= 0x1bab10e32d
+	}
+	if c0&8 != 0 {
+		c ^= 0x3706b1677a
+	}
+	if c0&16 != 0 {
+		c ^= 0x644d626ffd
+	}
+	return c
+}
+
+// IsRange reports whether the descriptor has a wildcard, describing a range
+// of addresses instead of a single one.
+func (d *Descriptor) IsRange() bool {
+	return d.root.isRange()
+}
+
+func (e *expr) isRange() bool {
+	for _, k := range e.keys {
+		if k.wildcard {
+			return true
+		}
+	}
+	return e.sub != nil && e.sub.isRange()
+}
+
+// Address returns the address at the given index. The index is ignored for
+// descriptors without a wildcard.
+func (d *Descriptor) Address(index uint32) (btcutil.Address, error) {
+	e := d.root
+	switch e.fn {
+	case "addr":
+		return e.addr, nil
+
+	case "pkh":
+		pubKey, err := e.keys[0].derive(index)
+		if err != nil {
+			return nil, err
+		}
+		return btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubKey), d.net)
+
+	case "wpkh":
+		pubKey, err := e.keys[0].derive(index)
+		if err != nil {
+			return nil, err
+		}
+		return btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pubKey), d.net)
+
+	case "sh":
+		script, err := e.sub.script(index)
+		if err != nil {
+			return nil, err
+		}
+		return btcutil.NewAddressScriptHash(script, d.net)
+
+	case "wsh":
+		script, err := e.sub.script(index)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(script)
+		return btcutil.NewAddressWitnessScriptHash(hash[:], d.net)
+	}
+
+	return nil, fmt.Errorf("%v() can't be used at the top level", e.fn)
+}
+
+// script returns the script of an expression nested in sh() or wsh().
+func (e *expr) script(index uint32) ([]byte, error) {
+	switch e.fn {
+	case "wpkh":
+		pubKey, err := e.keys[0].derive(index)
+		if err != nil {
+			return nil, err
+		}
+		return txscript.NewScriptBuilder().
+			AddOp(txscript.OP_0).
+			AddData(btcutil.Hash160(pubKey)).
+			Script()
+
+	case "wsh":
+		script, err := e.sub.script(index)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(script)
+		return txscript.NewScriptBuilder().
+			AddOp(txscript.OP_0).
+			AddData(hash[:]).
+			Script()
+
+	case "pkh":
+		pubKey, err := e.keys[0].derive(index)
+		if err != nil {
+			return nil, err
+		}
+		return txscript.NewScriptBuilder().
+			AddOp(txscript.OP_DUP).
+			AddOp(txscript.OP_HASH160).
+			AddData(btcutil.Hash160(pubKey)).
+			AddOp(txscript.OP_EQUALVERIFY).
+			AddOp(txscript.OP_CHECKSIG).
+			Script()
+
+	case "multi", "sortedmulti":
+		pubKeys := make([][]byte, len(e.keys))
+		for i, k := range e.keys {
+			pubKey, err := k.derive(index)
+			if err != nil {
+				return nil, err
+			}
+			pubKeys[i] = pubKey
+		}
+		if e.fn == "sortedmulti" {
+			sort.Slice(pubKeys, func(i, j int) bool {
+				return bytes.Compare(pubKeys[i], pubKeys[j]) < 0
+			})
+		}
+
+		builder := txscript.NewScriptBuilder().AddInt64(int64(e.threshold))
+		for _, pubKey := range pubKeys {
+			builder.AddData(pubKey)
+		}
+		return builder.
+			AddInt64(int64(len(pubKeys))).
+			AddOp(txscript.OP_CHECKMULTISIG).
+			Script()
+	}
+
+	return nil, fmt.Errorf("%v() can't be nested", e.fn)
+}
+
+// Sign signs the input of tx spending amount from the address at the given
+// index. Only pkh, wpkh and sh(wpkh) descriptors with a private key can sign.
+func (d *Descriptor) Sign(tx *wire.MsgTx, inputIndex int, amount int64, index uint32) error {
+	if inputIndex < 0 || inputIndex >= len(tx.TxIn) {
+		return fmt.Errorf("invalid input index %v", inputIndex)
+	}
+
+	e := d.root
+	if e.fn == "sh" && e.sub.fn == "wpkh" {
+		e = e.sub
+	} else if e.fn != "pkh" && e.fn != "wpkh" {
+		return errors.New("only pkh(), wpkh() and sh(wpkh()) descriptors can sign")
+	}
+	k := e.keys[0]
+	privKey, err := k.derivePrivate(index)
+	if err != nil {
+		return err
+	}
+
+	txIn := tx.TxIn[inputIndex]
+	if e.fn == "pkh" {
+		addr, err := d.Address(index)
+		if err != nil {
+			return err
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return err
+		}
+		txIn.SignatureScript, err = txscript.SignatureScript(tx, inputIndex, pkScript, txscript.SigHashAll, privKey, k.compressed)
+		return err
+	}
+
+	witnessProgram, err := e.script(index)
+	if err != nil {
+		return err
+	}
+	sigHashes := txscript.NewTxSigHashes(tx)
+	txIn.Witness, err = txscript.WitnessSignature(tx, sigHashes, inputIndex, amount, witnessProgram, txscript.SigHashAll, privKey, true)
+	if err != nil {
+		return err
+	}
+	if d.root.fn == "sh" {
+		txIn.SignatureScript, err = txscript.NewScriptBuilder().AddData(witnessProgram).Script()
+	}
+	return err
+}
+
+// derive returns the serialized public key at the given index.
+func (k *key) derive(index uint32) ([]byte, error) {
+	if k.pubKey != nil {
+		if !k.compressed {
+			return k.pubKey.SerializeUncompressed(), nil
+		}
+		return k.pubKey.SerializeCompressed(), nil
+	}
+
+	derived, err := k.deriveExtended(index)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := derived.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.SerializeCompressed(), nil
+}
+
+// derivePrivate returns the private key at the given index.
+func (k *key) derivePrivate(index uint32) (*btcec.PrivateKey, error) {
+	if k.privKey != nil {
+		return k.privKey, nil
+	}
+	if k.xkey == nil || !k.xkey.IsPrivate() {
+		return nil, errors.New("descriptor has no private key")
+	}
+
+	derived, err := k.deriveExtended(index)
+	if err != nil {
+		return nil, err
+	}
+	return derived.ECPrivKey()
+}
+
+func (k *key) deriveExtended(index uint32) (*hdkeychain.ExtendedKey, error) {
+	derived := k.xkey
+	path := k.path
+	if k.wildcard {
+		path = append(append([]uint32{}, path...), index)
+	}
+	for _, i := range path {
+		var err error
+		derived, err = derived.Child(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+	}
+	return derived, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+	net   *chaincfg.Params
+}
+
+func (p *parser) parseExpr(top, inWsh bool) (*expr, error) {
+	start := p.pos
+	i := strings.IndexByte(p.input[p.pos:], '(')
+	if i < 0 {
+		return nil, fmt.Errorf("expected a function at position %v", start)
+	}
+	fn := p.input[p.pos : p.pos+i]
+	p.pos += i + 1
+
+	e := &expr{fn: fn}
+	var err error
+	switch fn {
+	case "addr":
+		if !top {
+			return nil, errors.New("addr() can only be used at the top level")
+		}
+		arg := p.until(')')
+		e.addr, err = btcutil.DecodeAddress(arg, p.net)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %v: %w", arg, err)
+		}
+		if !e.addr.IsForNet(p.net) {
+			return nil, fmt.Errorf("address %v is not for network %v", arg, p.net.Name)
+		}
+
+	case "pkh", "wpkh":
+		if fn == "wpkh" && inWsh {
+			return nil, errors.New("wpkh() can't be nested in wsh()")
+		}
+		k, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if fn == "wpkh" && !k.compressed {
+			return nil, errors.New("wpkh() can't use uncompressed keys")
+		}
+		e.keys = []*key{k}
+
+	case "sh":
+		if !top {
+			return nil, errors.New("sh() can only be used at the top level")
+		}
+		e.sub, err = p.parseExpr(false, false)
+		if err != nil {
+			return nil, err
+		}
+
+	case "wsh":
+		if inWsh {
+			return nil, errors.New("wsh() can't be nested in wsh()")
+		}
+		e.sub, err = p.parseExpr(false, true)
+		if err != nil {
+			return nil, err
+		}
+		if e.sub.fn == "sh" {
+			return nil, errors.New("sh() can't be nested in wsh()")
+		}
+
+	case "multi", "sortedmulti":
+		if top {
+			return nil, fmt.Errorf("%v() must be nested in sh() or wsh()", fn)
+		}
+		digits := p.pos
+		for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+			p.pos++
+		}
+		e.threshold, err = strconv.Atoi(p.input[digits:p.pos])
+		if err != nil {
+			return nil, fmt.Errorf("invalid multisig threshold: %w", err)
+		}
+		for p.pos < len(p.input) && p.input[p.pos] == ',' {
+			p.pos++
+			k, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if inWsh && !k.compressed {
+				return nil, fmt.Errorf("%v() in wsh() can't use uncompressed keys", fn)
+			}
+			e.keys = append(e.keys, k)
+		}
+		if e.threshold < 1 || e.threshold > len(e.keys) || len(e.keys) > 16 {
+			return nil, fmt.Errorf("invalid %v of %v multisig", e.threshold, len(e.keys))
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported function %q", fn)
+	}
+
+	if fn != "addr" {
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, fmt.Errorf("expected ) at position %v", p.pos)
+		}
+		p.pos++
+	}
+
+	return e, nil
+}
+
+// until consumes the input up to and including the given separator, and
+// returns the consumed text without it.
+func (p *parser) until(sep byte) string {
+	i := strings.IndexByte(p.input[p.pos:], sep)
+	if i < 0 {
+		i = len(p.input) - p.pos
+		s := p.input[p.pos:]
+		p.pos = len(p.input)
+		return s
+	}
+	s := p.input[p.pos : p.pos+i]
+	p.pos += i + 1
+	return s
+}
+
+func (p *parser) parseKey() (*key, error) {
+	end := strings.IndexAny(p.input[p.pos:], ",)")
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated key at position %v", p.pos)
+	}
+	text := p.input[p.pos : p.pos+end]
+	p.pos += end
+
+	// The key origin only documents where the key comes from
+	if strings.HasPrefix(text, "[") {
+		i := strings.IndexByte(text, ']')
+		if i < 0 {
+			return nil, fmt.Errorf("unterminated key origin in %v", text)
+		}
+		text = text[i+1:]
+	}
+
+	parts := strings.Split(text, "/")
+	k := &key{compressed: true}
+
+	if raw, err := hex.DecodeString(parts[0]); err == nil {
+		if len(parts) > 1 {
+			return nil, fmt.Errorf("hex key %v can't have a derivation path", parts[0])
+		}
+		k.pubKey, err = btcec.ParsePubKey(raw, btcec.S256())
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %v: %w", parts[0], err)
+		}
+		k.compressed = len(raw) == btcec.PubKeyBytesLenCompressed
+		return k, nil
+	}
+
+	if wif, err := btcutil.DecodeWIF(parts[0]); err == nil {
+		if len(parts) > 1 {
+			return nil, errors.New("WIF keys can't have a derivation path")
+		}
+		if !wif.IsForNet(p.net) {
+			return nil, fmt.Errorf("WIF key is not for network %v", p.net.Name)
+		}
+		k.privKey = wif.PrivKey
+		k.pubKey = wif.PrivKey.PubKey()
+		k.compressed = wif.CompressPubKey
+		return k, nil
+	}
+
+	xkey, err := hdkeychain.NewKeyFromString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %v: %w", parts[0], err)
+	}
+	if !xkey.IsForNet(p.net) {
+		return nil, fmt.Errorf("extended key is not for network %v", p.net.Name)
+	}
+	k.xkey = xkey
+
+	for i, step := range parts[1:] {
+		if step == "*" {
+			if i != len(parts)-2 {
+				return nil, errors.New("wildcard must be the last step of the path")
+			}
+			k.wildcard = true
+			continue
+		}
+		if strings.HasSuffix(step, "'") || strings.HasSuffix(step, "h") {
+			return nil, fmt.Errorf("hardened derivation is not supported in step %v", step)
+		}
+		index, err := strconv.ParseUint(step, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path step %v: %w", step, err)
+		}
+		k.path = append(k.path, uint32(index))
+	}
+
+	return k, nil
+}
@@ -0,0 +1,204 @@
+package descriptors
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		descriptor string
+		checksum   string
+	}{
+		{
+			"sh(multi(2,[00000000/111'/222]xprvA1RpRA33e1JQ7ifknakTFpgNXPmW2YvmhqLQYMmrj4xJXXWYpDPS3xz7iAxn8L39njGVyuoseXzU6rcxFLJ8HFsTjSyQbLYnMpCqE2VbFWc,xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L/0))",
+			"ggrsrxfy",
+		},
+		{
+			"sh(multi(2,[00000000/111'/222]xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL,xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y/0))",
+			"tjg09x5t",
+		},
+	}
+	for _, tt := range tests {
+		checksum, err := Checksum(tt.descriptor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checksum != tt.checksum {
+			t.Errorf("Checksum() = %v, want %v", checksum, tt.checksum)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	const (
+		key1 = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+		key2 = "02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"
+		// key1 uncompressed
+		key3 = "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+		// key1 private key, as WIF
+		wif = "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn"
+	)
+
+	tests := []struct {
+		descriptor string
+		index      uint32
+		want       string
+	}{
+		{"pkh(" + key1 + ")", 0, "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"},
+		{"pkh(" + key2 + ")", 0, "1cMh228HTCiwS8ZsaakH8A8wze1JR5ZsP"},
+		{"pkh(" + key3 + ")", 0, "1EHNa6Q4Jz2uvNExL497mE43ikXhwF6kZm"},
+		{"pkh(" + wif + ")", 0, "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"},
+		{"wpkh(" + key1 + ")", 0, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		{"sh(wpkh(" + key1 + "))", 0, "3JvL6Ymt8MVWiCNHC7oWU6nLeHNJKLZGLN"},
+		{"addr(1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH)", 5, "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.descriptor, func(t *testing.T) {
+			d, err := Parse(tt.descriptor, &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr, err := d.Address(tt.index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr.EncodeAddress() != tt.want {
+				t.Fatalf("Address() = %v, want %v", addr.EncodeAddress(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRanges(t *testing.T) {
+	const xpub = "xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y"
+	const xpub2 = "xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL"
+
+	descriptors := []string{
+		"wpkh([d34db33f/84'/0'/0']" + xpub + "/0/*)",
+		"sh(wsh(multi(1," + xpub + "/1/*," + xpub2 + "/1/*)))",
+		"wsh(sortedmulti(2," + xpub + "/*," + xpub2 + "/*))",
+	}
+	for _, descriptor := range descriptors {
+		d, err := Parse(descriptor, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.IsRange() {
+			t.Fatalf("expected %v to be a range", descriptor)
+		}
+		first, err := d.Address(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := d.Address(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.EncodeAddress() == second.EncodeAddress() {
+			t.Fatalf("expected different addresses for %v", descriptor)
+		}
+	}
+
+	// sortedmulti doesn't depend on the order of the keys
+	a, _ := Parse("wsh(sortedmulti(1,"+xpub+"/*,"+xpub2+"/*))", &chaincfg.MainNetParams)
+	b, _ := Parse("wsh(sortedmulti(1,"+xpub2+"/*,"+xpub+"/*))", &chaincfg.MainNetParams)
+	addrA, _ := a.Address(7)
+	addrB, _ := b.Address(7)
+	if addrA.EncodeAddress() != addrB.EncodeAddress() {
+		t.Fatal("expected sortedmulti to sort keys")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	const key1 = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	const key3 = "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+
+	invalid := []string{
+		"",
+		"pkh(" + key1 + ")#00000000",
+		"multi(1," + key1 + ")",
+		"sh(sh(wpkh(" + key1 + ")))",
+		"wsh(wpkh(" + key1 + "))",
+		"wsh(multi(2," + key1 + "))",
+		"tr(" + key1 + ")",
+		"pkh(" + key1 + "/0)",
+		"pkh(xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y/0'/*)",
+		"pkh(" + key1 + ")extra",
+		"addr(tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx)",
+		"wpkh(" + key3 + ")",
+		"wsh(multi(1," + key3 + "))",
+		"wpkh(tpubD6NzVbkrYhZ4XgiXtGrdW5XDAPFCL9h7we1vwNCpn8tGbBcgfVYjXyhWo4E1xkh56hjod1RhGjxbaTLV3X4FyWuejifB9jusQ46QzG87VKp/*)",
+		"wpkh(cMahea7zqjxrtgAbB7LSGbcQUr1uX1ojuat9jZodMN87JcbXMTcA)",
+	}
+	for _, descriptor := range invalid {
+		if _, err := Parse(descriptor, &chaincfg.MainNetParams); err == nil {
+			t.Errorf("expected %q to be invalid", descriptor)
+		}
+	}
+
+	checksum, _ := Checksum("pkh(" + key1 + ")")
+	if _, err := Parse("pkh("+key1+")#"+checksum, &chaincfg.MainNetParams); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	const (
+		wif             = "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn"
+		wifUncompressed = "5HpHagT65TZzG1PH3CSu63k8DbpvD8s5ip4nEB3kEsreAnchuDf"
+		xprv            = "xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L"
+		amount          = 10000
+	)
+
+	descriptors := []string{
+		"pkh(" + wif + ")",
+		"pkh(" + wifUncompressed + ")",
+		"wpkh(" + wif + ")",
+		"sh(wpkh(" + wif + "))",
+		"wpkh(" + xprv + "/0/*)",
+	}
+	for _, descriptor := range descriptors {
+		t.Run(descriptor, func(t *testing.T) {
+			d, err := Parse(descriptor, &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr, err := d.Address(3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkScript, err := txscript.PayToAddrScript(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tx := wire.NewMsgTx(2)
+			tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}}, nil, nil))
+			tx.AddTxOut(wire.NewTxOut(amount-1000, pkScript))
+			if err := d.Sign(tx, 0, amount, 3); err != nil {
+				t.Fatal(err)
+			}
+
+			engine, err := txscript.NewEngine(pkScript, tx, 0, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx), amount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := engine.Execute(); err != nil {
+				t.Fatalf("signed input doesn't verify: %v", err)
+			}
+		})
+	}
+
+	// public descriptors can't sign
+	d, _ := Parse("wpkh(xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y/*)", &chaincfg.MainNetParams)
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	if err := d.Sign(tx, 0, amount, 0); err == nil {
+		t.Fatal("expected error signing without private keys")
+	}
+}
@@ -0,0 +1,184 @@
+// Package desktop exposes libwallet functionality using idiomatic Go types for
+// consumers that are not bound through gomobile, such as server-side tools and
+// the recovery CLI. It uses slices instead of wrapper list types, takes a
+// context in every operation and returns errors that can be inspected with
+// errors.Is.
+package desktop
+
+import (
+	"context"
+
+	"github.com/muun/libwallet"
+)
+
+// GenerateInvoiceSecrets returns new secrets to register with the remote
+// server. See libwallet.GenerateInvoiceSecrets.
+func GenerateInvoiceSecrets(
+	ctx context.Context,
+	userKey, muunKey *libwallet.HDPublicKey,
+) ([]*libwallet.InvoiceSecrets, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	list, err := libwallet.GenerateInvoiceSecrets(userKey, muunKey)
+	if err != nil {
+		return nil, wrap(err)
+	}
+
+	secrets := make([]*libwallet.InvoiceSecrets, 0, list.Length())
+	for i := 0; i < list.Length(); i++ {
+		secrets = append(secrets, list.Get(i))
+	}
+	return secrets, nil
+}
+
+// PersistInvoiceSecrets stores secrets registered with the remote server.
+// See libwallet.PersistInvoiceSecrets.
+func PersistInvoiceSecrets(ctx context.Context, secrets []*libwallet.InvoiceSecrets) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	list := &libwallet.InvoiceSecretsList{}
+	for _, s := range secrets {
+		list.Add(s)
+	}
+	return wrap(libwallet.PersistInvoiceSecrets(list))
+}
+
+// CreateInvoice returns a new lightning invoice. See libwallet.CreateInvoice.
+func CreateInvoice(
+	ctx context.Context,
+	net *libwallet.Network,
+	userKey *libwallet.HDPrivateKey,
+	routeHints *libwallet.RouteHints,
+	opts *libwallet.InvoiceOptions,
+) (string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	invoice, err := libwallet.CreateInvoice(net, userKey, routeHints, opts)
+	return invoice, wrap(err)
+}
+
+// ParseInvoice parses a lightning invoice. See libwallet.ParseInvoice.
+func ParseInvoice(ctx context.Context, rawInput string, net *libwallet.Network) (*libwallet.Invoice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	invoice, err := libwallet.ParseInvoice(rawInput, net)
+	return invoice, wrap(err)
+}
+
+// GetPaymentURI parses a bitcoin uri or address. See libwallet.GetPaymentURI.
+func GetPaymentURI(ctx context.Context, rawInput string, net *libwallet.Network) (*libwallet.MuunPaymentURI, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	uri, err := libwallet.GetPaymentURI(rawInput, net)
+	return uri, wrap(err)
+}
+
+// SignTransaction signs the inputs of the raw transaction with the user key.
+// See libwallet.PartiallySignedTransaction.Sign.
+func SignTransaction(
+	ctx context.Context,
+	inputs []libwallet.Input,
+	rawTx []byte,
+	userKey *libwallet.HDPrivateKey,
+	muunKey *libwallet.HDPublicKey,
+) (*libwallet.Transaction, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pst, err := newPartiallySignedTransaction(inputs, rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := pst.Sign(userKey, muunKey)
+	return tx, wrap(err)
+}
+
+// FullySignTransaction signs the inputs of the raw transaction with both
+// private keys. See libwallet.PartiallySignedTransaction.FullySign.
+func FullySignTransaction(
+	ctx context.Context,
+	inputs []libwallet.Input,
+	rawTx []byte,
+	userKey, muunKey *libwallet.HDPrivateKey,
+) (*libwallet.Transaction, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pst, err := newPartiallySignedTransaction(inputs, rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := pst.FullySign(userKey, muunKey)
+	return tx, wrap(err)
+}
+
+// FullySignSweep signs the inputs of the raw transaction with both private
+// keys, signing several inputs concurrently. It's meant for sweeps of many
+// inputs. See libwallet.NewParallelBatchSigner.
+func FullySignSweep(
+	ctx context.Context,
+	inputs []libwallet.Input,
+	rawTx []byte,
+	userKey, muunKey *libwallet.HDPrivateKey,
+) (*libwallet.Transaction, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	list := &libwallet.InputList{}
+	for _, input := range inputs {
+		list.Add(input)
+	}
+
+	signer, err := libwallet.NewParallelBatchSigner(list, rawTx)
+	if err != nil {
+		return nil, wrap(err)
+	}
+
+	tx, err := signer.FullySign(userKey, muunKey)
+	return tx, wrap(err)
+}
+
+// ComputeSwapFees calculates the fees for a submarine swap. See
+// libwallet.ComputeSwapFees.
+func ComputeSwapFees(
+	amount int64,
+	bestRouteFees []*libwallet.BestRouteFees,
+	policies *libwallet.FundingOutputPolicies,
+) *libwallet.SwapFees {
+
+	list := &libwallet.BestRouteFeesList{}
+	for _, f := range bestRouteFees {
+		list.Add(f)
+	}
+	return libwallet.ComputeSwapFees(amount, list, policies)
+}
+
+func newPartiallySignedTransaction(inputs []libwallet.Input, rawTx []byte) (*libwallet.PartiallySignedTransaction, error) {
+	list := &libwallet.InputList{}
+	for _, input := range inputs {
+		list.Add(input)
+	}
+
+	pst, err := libwallet.NewPartiallySignedTransaction(list, rawTx)
+	return pst, wrap(err)
+}
@@ -0,0 +1,122 @@
+package desktop
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"github.com/muun/libwallet"
+)
+
+func setup() {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+
+	err = libwallet.Init(&libwallet.Config{
+		DataDir: dir,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestInvoiceSecrets(t *testing.T) {
+	setup()
+
+	ctx := context.Background()
+	network := libwallet.Regtest()
+
+	userKey, _ := libwallet.NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := libwallet.NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(ctx, userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != libwallet.MaxUnusedSecrets {
+		t.Fatalf("expected %v new secrets, got %v", libwallet.MaxUnusedSecrets, len(secrets))
+	}
+
+	err = PersistInvoiceSecrets(ctx, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	more, err := GenerateInvoiceSecrets(ctx, userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(more) != 0 {
+		t.Fatal("expected no new secrets to be created")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = GenerateInvoiceSecrets(cancelled, userKey.PublicKey(), muunKey.PublicKey())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancelled error, got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, err := ParseInvoice(context.Background(), "lightning:invalid", libwallet.Regtest())
+	if !errors.Is(err, ErrInvalidInvoice) {
+		t.Fatalf("expected invalid invoice error, got %v", err)
+	}
+	if errors.Is(err, ErrInvalidURI) {
+		t.Fatal("expected error to not match a different code")
+	}
+}
+
+// TestErrorsByCode checks every libwallet error code has a sentinel error.
+func TestErrorsByCode(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "../errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			for i, name := range value.Names {
+				if name.Name == "ErrUnknown" {
+					continue
+				}
+				lit, ok := value.Values[i].(*ast.BasicLit)
+				if !ok {
+					t.Fatalf("expected %v to be a literal code", name.Name)
+				}
+				code, err := strconv.ParseInt(lit.Value, 10, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := errorsByCode[code]; !ok {
+					t.Errorf("missing sentinel error for %v", name.Name)
+				}
+			}
+		}
+	}
+}
+
+func randomBytes(count int) []byte {
+	buf := make([]byte, count)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
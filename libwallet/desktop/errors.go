@@ -0,0 +1,85 @@
+package desktop
+
+import (
+	"errors"
+
+	"github.com/muun/libwallet"
+)
+
+// Sentinel errors matching the libwallet error codes. Errors returned by this
+// package can be checked against them with errors.Is.
+var (
+	ErrUnknown               = errors.New("unknown error")
+	ErrInvalidURI            = errors.New("invalid uri")
+	ErrNetwork               = errors.New("network error")
+	ErrInvalidPrivateKey     = errors.New("invalid private key")
+	ErrInvalidDerivationPath = errors.New("invalid derivation path")
+	ErrInvalidInvoice        = errors.New("invalid invoice")
+	ErrPermissionDenied      = errors.New("permission denied")
+	ErrInvalidIncomingSwap   = errors.New("invalid incoming swap")
+	ErrMigrationsPending     = errors.New("migrations pending")
+	ErrInvalidAmount         = errors.New("invalid amount")
+	ErrInvalidFeeRate        = errors.New("invalid fee rate")
+	ErrIncompleteAmpSet      = errors.New("incomplete amp set")
+	ErrInvoiceHeld           = errors.New("invoice held")
+	ErrIncompleteMppSet      = errors.New("incomplete mpp set")
+	ErrNetworkMismatch       = errors.New("network mismatch")
+	ErrUntrustedRouteHint    = errors.New("untrusted route hint")
+	ErrClockSkew             = errors.New("clock skew")
+	ErrNoUnusedSecrets       = errors.New("no unused secrets")
+	ErrOverpayment           = errors.New("overpayment")
+	ErrInvalidSphinx         = errors.New("invalid sphinx packet")
+)
+
+var errorsByCode = map[int64]error{
+	libwallet.ErrInvalidURI:            ErrInvalidURI,
+	libwallet.ErrNetwork:               ErrNetwork,
+	libwallet.ErrInvalidPrivateKey:     ErrInvalidPrivateKey,
+	libwallet.ErrInvalidDerivationPath: ErrInvalidDerivationPath,
+	libwallet.ErrInvalidInvoice:        ErrInvalidInvoice,
+	libwallet.ErrPermissionDenied:      ErrPermissionDenied,
+	libwallet.ErrInvalidIncomingSwap:   ErrInvalidIncomingSwap,
+	libwallet.ErrMigrationsPending:     ErrMigrationsPending,
+	libwallet.ErrInvalidAmount:         ErrInvalidAmount,
+	libwallet.ErrInvalidFeeRate:        ErrInvalidFeeRate,
+	libwallet.ErrIncompleteAmpSet:      ErrIncompleteAmpSet,
+	libwallet.ErrInvoiceHeld:           ErrInvoiceHeld,
+	libwallet.ErrIncompleteMppSet:      ErrIncompleteMppSet,
+	libwallet.ErrNetworkMismatch:       ErrNetworkMismatch,
+	libwallet.ErrUntrustedRouteHint:    ErrUntrustedRouteHint,
+	libwallet.ErrClockSkew:             ErrClockSkew,
+	libwallet.ErrNoUnusedSecrets:       ErrNoUnusedSecrets,
+	libwallet.ErrOverpayment:           ErrOverpayment,
+	libwallet.ErrInvalidSphinx:         ErrInvalidSphinx,
+}
+
+// Error wraps an error returned by libwallet, making its code available
+// through errors.Is.
+type Error struct {
+	kind error
+	err  error
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+// Is reports whether target is the sentinel error matching this error code.
+func (e *Error) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+func wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	kind, ok := errorsByCode[libwallet.ErrorCode(err)]
+	if !ok {
+		kind = ErrUnknown
+	}
+	return &Error{kind: kind, err: err}
+}
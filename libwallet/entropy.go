@@ -0,0 +1,42 @@
+package libwallet
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// entropyMixInfo binds the mixed secrets to their use in libwallet.
+const entropyMixInfo = "muun libwallet secret"
+
+// EntropySource is implemented by the apps to contribute entropy from the
+// platform, eg its SecureRandom or sensor noise, as a defense against a
+// broken system random number generator.
+type EntropySource interface {
+	// Entropy returns unpredictable bytes, of any length.
+	Entropy() ([]byte, error)
+}
+
+// secretBytes returns count random bytes for secrets such as preimages. The
+// bytes read from crypto/rand are mixed through HKDF with the entropy of the
+// configured EntropySource, so they stay unpredictable if either source is.
+// If the source fails only crypto/rand is used, since it's the primary one.
+func secretBytes(count int) []byte {
+	random := randomBytes(count)
+	if cfg == nil || cfg.EntropySource == nil {
+		return random
+	}
+
+	extra, err := cfg.EntropySource.Entropy()
+	if err != nil || len(extra) == 0 {
+		return random
+	}
+
+	secret := make([]byte, count)
+	mixer := hkdf.New(sha256.New, append(random, extra...), nil, []byte(entropyMixInfo))
+	if _, err := io.ReadFull(mixer, secret); err != nil {
+		panic("couldn't mix random bytes")
+	}
+	return secret
+}
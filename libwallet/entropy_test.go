@@ -0,0 +1,62 @@
+package libwallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type countingEntropySource struct {
+	calls int
+	err   error
+}
+
+func (s *countingEntropySource) Entropy() ([]byte, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []byte("sensor noise"), nil
+}
+
+func TestSecretBytes(t *testing.T) {
+	setup()
+	defer setup()
+
+	if len(secretBytes(32)) != 32 {
+		t.Fatal("expected 32 bytes without entropy source")
+	}
+
+	source := &countingEntropySource{}
+	cfg.EntropySource = source
+
+	first := secretBytes(32)
+	second := secretBytes(32)
+	if len(first) != 32 || bytes.Equal(first, second) {
+		t.Fatal("expected different secrets with a constant entropy source")
+	}
+	if source.calls != 2 {
+		t.Fatalf("expected entropy source to be used, got %v calls", source.calls)
+	}
+
+	cfg.EntropySource = &countingEntropySource{err: errors.New("unavailable")}
+	if len(secretBytes(32)) != 32 {
+		t.Fatal("expected 32 bytes with a failing entropy source")
+	}
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	source = &countingEntropySource{}
+	cfg.EntropySource = source
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.calls != 2*secrets.Length() {
+		t.Fatalf("expected preimages and payment secrets to be mixed, got %v calls", source.calls)
+	}
+}
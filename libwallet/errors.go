@@ -1,5 +1,7 @@
 package libwallet
 
+import "fmt"
+
 const (
 	ErrUnknown               = 1
 	ErrInvalidURI            = 2
@@ -7,6 +9,20 @@ const (
 	ErrInvalidPrivateKey     = 4
 	ErrInvalidDerivationPath = 5
 	ErrInvalidInvoice        = 6
+	ErrPermissionDenied      = 7
+	ErrInvalidIncomingSwap   = 8
+	ErrMigrationsPending     = 9
+	ErrInvalidAmount         = 10
+	ErrInvalidFeeRate        = 11
+	ErrIncompleteAmpSet      = 12
+	ErrInvoiceHeld           = 13
+	ErrIncompleteMppSet      = 14
+	ErrNetworkMismatch       = 15
+	ErrUntrustedRouteHint    = 16
+	ErrClockSkew             = 17
+	ErrNoUnusedSecrets       = 18
+	ErrOverpayment           = 19
+	ErrInvalidSphinx         = 20
 )
 
 func ErrorCode(err error) int64 {
@@ -20,3 +36,9 @@ func ErrorCode(err error) int64 {
 		return ErrUnknown
 	}
 }
+
+// ErrorReport returns a description of the error suitable for bug reports,
+// including its code and the libwallet build it originated in.
+func ErrorReport(err error) string {
+	return fmt.Sprintf("%v (code %v, %v)", err, ErrorCode(err), GetBuildInfo())
+}
@@ -18,6 +18,12 @@ func (e *Error) Code() int64 {
 	return e.code
 }
 
+// Unwrap returns the wrapped error, so errors.Is and errors.As see through
+// the code.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
 func New(code int64, msg string) error {
 	return &Error{errors.New(msg), code}
 }
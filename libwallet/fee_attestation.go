@@ -0,0 +1,179 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/muun/libwallet/errors"
+)
+
+// DefaultFeeRateTolerance is the factor by which a server fee rate may differ
+// from the local estimate when no tolerance is configured.
+const DefaultFeeRateTolerance = 3.0
+
+// maxFeeAttestationAge is how old an attestation can be, so stale rates from
+// a different fee market can't be replayed.
+const maxFeeAttestationAge = 1 * time.Hour
+
+// fulfillmentFeeRateSlack is how much the fee rate of a fulfillment tx may
+// exceed its attested rate, since the server estimates the size of the
+// signatures before they're made and rounds the fee up.
+const fulfillmentFeeRateSlack = 1.05
+
+// feeAttestationTag prefixes the signed attestation data, so signatures over
+// other messages can't be passed as attestations.
+const feeAttestationTag = "muun fee rate attestation"
+
+// FeeEstimator is implemented by the apps to provide their own fee rate
+// estimates, used to cross check the fee rates dictated by the server.
+type FeeEstimator interface {
+	// EstimateFeeRate returns the fee rate in sats/vbyte needed to confirm
+	// within the given number of blocks.
+	EstimateFeeRate(confirmationTarget int64) (float64, error)
+}
+
+// FeeRateAttestation is a fee rate dictated by the server for a fulfillment
+// or swap, signed with its attestation key.
+type FeeRateAttestation struct {
+	FeeRateSatPerVByte float64
+	ConfirmationTarget int64
+	Timestamp          int64  // unix seconds
+	Signature          []byte // DER ecdsa signature over the digest
+}
+
+// digest returns the sha256 hash of the attested data.
+func (a *FeeRateAttestation) digest() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(feeAttestationTag)
+	binary.Write(&buf, binary.BigEndian, math.Float64bits(a.FeeRateSatPerVByte))
+	binary.Write(&buf, binary.BigEndian, a.ConfirmationTarget)
+	binary.Write(&buf, binary.BigEndian, a.Timestamp)
+
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+// VerifyFeeRateAttestation checks the attestation was signed by the configured
+// FeeAttestationKey and is recent, and that the rate is within the configured
+// tolerance of the local FeeEstimator. Attestations from the future fail with
+// the ErrClockSkew code, since the device clock is likely behind. The cross
+// check is skipped if no estimator is configured or it has no estimate, since
+// refusing then would block fulfillments whenever the app can't reach its fee
+// source.
+func VerifyFeeRateAttestation(a *FeeRateAttestation) (err error) {
+	defer recordErrors("VerifyFeeRateAttestation", &err)
+
+	return verifyFeeRateAttestation(a, walletNow())
+}
+
+func verifyFeeRateAttestation(a *FeeRateAttestation, now time.Time) error {
+	if len(cfg.FeeAttestationKey) == 0 {
+		return errors.Errorf(ErrInvalidFeeRate, "no fee attestation key configured")
+	}
+	serverKey, err := btcec.ParsePubKey(cfg.FeeAttestationKey, btcec.S256())
+	if err != nil {
+		return errors.Errorf(ErrInvalidFeeRate, "invalid fee attestation key: %v", err)
+	}
+
+	sig, err := btcec.ParseDERSignature(a.Signature, btcec.S256())
+	if err != nil {
+		return errors.Errorf(ErrInvalidFeeRate, "invalid fee attestation signature: %v", err)
+	}
+	if !sig.Verify(a.digest(), serverKey) {
+		countSecurityEvent(SecurityEventSignatureVerification)
+		return errors.Errorf(ErrInvalidFeeRate, "fee attestation signature does not verify")
+	}
+
+	attestedAt := time.Unix(a.Timestamp, 0)
+	if attestedAt.Sub(now) > time.Duration(maxClockSkewSeconds())*time.Second {
+		return errors.Errorf(ErrClockSkew, "fee attestation is from the future: %v", attestedAt)
+	}
+	if now.Sub(attestedAt) > maxFeeAttestationAge {
+		return errors.Errorf(ErrInvalidFeeRate, "fee attestation is too old: %v", attestedAt)
+	}
+
+	rate := a.FeeRateSatPerVByte
+	if math.IsNaN(rate) || math.IsInf(rate, 0) || rate <= 0 {
+		return errors.Errorf(ErrInvalidFeeRate, "invalid attested fee rate: %v", rate)
+	}
+
+	if cfg.FeeEstimator == nil {
+		return nil
+	}
+	localRate, err := cfg.FeeEstimator.EstimateFeeRate(a.ConfirmationTarget)
+	if err != nil || localRate <= 0 {
+		return nil
+	}
+
+	tolerance := feeRateTolerance()
+	if a.FeeRateSatPerVByte > localRate*tolerance || a.FeeRateSatPerVByte < localRate/tolerance {
+		return errors.Errorf(
+			ErrInvalidFeeRate,
+			"attested fee rate %v sat/vbyte is off the local estimate %v sat/vbyte for target %v",
+			a.FeeRateSatPerVByte, localRate, a.ConfirmationTarget,
+		)
+	}
+	return nil
+}
+
+// verifyFeeRate checks the fee rate attestation of the fulfillment matches
+// its confirmation target and is valid. Attestations are required once a
+// FeeAttestationKey is configured.
+func (d *IncomingSwapFulfillmentData) verifyFeeRate() error {
+	if d.FeeRateAttestation == nil {
+		if len(cfg.FeeAttestationKey) != 0 {
+			return errors.Errorf(ErrInvalidFeeRate, "fulfillment has no fee attestation")
+		}
+		return nil
+	}
+	if d.FeeRateAttestation.ConfirmationTarget != d.ConfirmationTarget {
+		return errors.Errorf(
+			ErrInvalidFeeRate,
+			"fee attestation is for target %v, expected %v",
+			d.FeeRateAttestation.ConfirmationTarget, d.ConfirmationTarget,
+		)
+	}
+	return verifyFeeRateAttestation(d.FeeRateAttestation, walletNow())
+}
+
+// verifyFulfillmentFee checks the signed fulfillment tx pays the attested fee
+// rate, if any, for its actual size. The fee is what the htlc output pays
+// beyond the tx output and the collected debt.
+func (d *IncomingSwapFulfillmentData) verifyFulfillmentFee(tx *wire.MsgTx, prevOut *wire.TxOut, collectSat int64) error {
+	if d.FeeRateAttestation == nil {
+		return nil
+	}
+	fee := prevOut.Value - collectSat
+	for _, out := range tx.TxOut {
+		fee -= out.Value
+	}
+	if fee < 0 {
+		return errors.Errorf(ErrInvalidFeeRate, "fulfillment tx pays more than the htlc output")
+	}
+
+	weight := blockchain.GetTransactionWeight(btcutil.NewTx(tx))
+	vsize := (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor
+	rate := float64(fee) / float64(vsize)
+	if rate > d.FeeRateAttestation.FeeRateSatPerVByte*fulfillmentFeeRateSlack {
+		return errors.Errorf(
+			ErrInvalidFeeRate,
+			"fulfillment tx pays %v sat/vbyte (%v sats for %v vbytes), attested %v sat/vbyte",
+			rate, fee, vsize, d.FeeRateAttestation.FeeRateSatPerVByte,
+		)
+	}
+	return nil
+}
+
+func feeRateTolerance() float64 {
+	if cfg.FeeRateTolerance > 1 {
+		return cfg.FeeRateTolerance
+	}
+	return DefaultFeeRateTolerance
+}
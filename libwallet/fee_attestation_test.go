@@ -0,0 +1,142 @@
+package libwallet
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+type fixedFeeEstimator struct {
+	feeRate float64
+	err     error
+}
+
+func (e *fixedFeeEstimator) EstimateFeeRate(confirmationTarget int64) (float64, error) {
+	return e.feeRate, e.err
+}
+
+func signFeeRateAttestation(key *btcec.PrivateKey, feeRate float64, target int64, at time.Time) *FeeRateAttestation {
+	a := &FeeRateAttestation{
+		FeeRateSatPerVByte: feeRate,
+		ConfirmationTarget: target,
+		Timestamp:          at.Unix(),
+	}
+	sig, err := key.Sign(a.digest())
+	if err != nil {
+		panic(err)
+	}
+	a.Signature = sig.Serialize()
+	return a
+}
+
+func TestVerifyFeeRateAttestation(t *testing.T) {
+	setup()
+	defer setup()
+
+	serverKey, _ := btcec.NewPrivateKey(btcec.S256())
+	otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+	now := time.Now()
+
+	if err := verifyFeeRateAttestation(signFeeRateAttestation(serverKey, 10, 6, now), now); err == nil {
+		t.Fatal("expected error without attestation key")
+	}
+
+	cfg.FeeAttestationKey = serverKey.PubKey().SerializeCompressed()
+
+	tampered := signFeeRateAttestation(serverKey, 10, 6, now)
+	tampered.FeeRateSatPerVByte = 100
+
+	tests := []struct {
+		desc        string
+		attestation *FeeRateAttestation
+		estimator   FeeEstimator
+		tolerance   float64
+		valid       bool
+	}{
+		{"no estimator", signFeeRateAttestation(serverKey, 10, 6, now), nil, 0, true},
+		{"within tolerance", signFeeRateAttestation(serverKey, 25, 6, now), &fixedFeeEstimator{feeRate: 10}, 0, true},
+		{"below tolerance", signFeeRateAttestation(serverKey, 4, 6, now), &fixedFeeEstimator{feeRate: 10}, 0, true},
+		{"estimator error", signFeeRateAttestation(serverKey, 500, 6, now), &fixedFeeEstimator{err: errors.New("offline")}, 0, true},
+		{"too high", signFeeRateAttestation(serverKey, 31, 6, now), &fixedFeeEstimator{feeRate: 10}, 0, false},
+		{"too low", signFeeRateAttestation(serverKey, 3, 6, now), &fixedFeeEstimator{feeRate: 10}, 0, false},
+		{"custom tolerance", signFeeRateAttestation(serverKey, 25, 6, now), &fixedFeeEstimator{feeRate: 10}, 1.5, false},
+		{"wrong key", signFeeRateAttestation(otherKey, 10, 6, now), nil, 0, false},
+		{"tampered", tampered, nil, 0, false},
+		{"stale", signFeeRateAttestation(serverKey, 10, 6, now.Add(-2*time.Hour)), nil, 0, false},
+		{"zero rate", signFeeRateAttestation(serverKey, 0, 6, now), nil, 0, false},
+		{"nan rate", signFeeRateAttestation(serverKey, math.NaN(), 6, now), nil, 0, false},
+		{"infinite rate", signFeeRateAttestation(serverKey, math.Inf(1), 6, now), nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg.FeeEstimator = tt.estimator
+			cfg.FeeRateTolerance = tt.tolerance
+
+			err := verifyFeeRateAttestation(tt.attestation, now)
+			if tt.valid && err != nil {
+				t.Fatalf("expected attestation to be valid, got %v", err)
+			}
+			if !tt.valid {
+				if err == nil {
+					t.Fatal("expected attestation to be invalid")
+				}
+				if ErrorCode(err) != ErrInvalidFeeRate {
+					t.Fatalf("expected ErrInvalidFeeRate, got %v", ErrorCode(err))
+				}
+			}
+		})
+	}
+
+	future := signFeeRateAttestation(serverKey, 10, 6, now.Add(2*time.Hour))
+	if err := verifyFeeRateAttestation(future, now); ErrorCode(err) != ErrClockSkew {
+		t.Fatalf("expected ErrClockSkew for an attestation from the future, got %v", err)
+	}
+
+	data := &IncomingSwapFulfillmentData{
+		ConfirmationTarget: 1,
+		FeeRateAttestation: signFeeRateAttestation(serverKey, 10, 6, now),
+	}
+	if err := data.verifyFeeRate(); err == nil {
+		t.Fatal("expected error with mismatched confirmation target")
+	}
+
+	data.FeeRateAttestation = nil
+	if err := data.verifyFeeRate(); ErrorCode(err) != ErrInvalidFeeRate {
+		t.Fatalf("expected attestation to be required with an attestation key, got %v", err)
+	}
+}
+
+func TestVerifyFulfillmentFee(t *testing.T) {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{Witness: wire.TxWitness{make([]byte, 32), make([]byte, 72), make([]byte, 72), make([]byte, 140)}})
+	tx.AddTxOut(&wire.TxOut{Value: 10000, PkScript: make([]byte, 34)})
+
+	weight := blockchain.GetTransactionWeight(btcutil.NewTx(tx))
+	vsize := (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor
+
+	data := &IncomingSwapFulfillmentData{
+		FeeRateAttestation: &FeeRateAttestation{FeeRateSatPerVByte: 10},
+	}
+	const collect = 500
+
+	fair := &wire.TxOut{Value: 10000 + collect + 10*vsize}
+	if err := data.verifyFulfillmentFee(tx, fair, collect); err != nil {
+		t.Fatal(err)
+	}
+
+	overpaying := &wire.TxOut{Value: 10000 + collect + 20*vsize}
+	if err := data.verifyFulfillmentFee(tx, overpaying, collect); ErrorCode(err) != ErrInvalidFeeRate {
+		t.Fatalf("expected fee above the attested rate to fail, got %v", err)
+	}
+
+	short := &wire.TxOut{Value: 10000}
+	if err := data.verifyFulfillmentFee(tx, short, collect); ErrorCode(err) != ErrInvalidFeeRate {
+		t.Fatalf("expected tx paying more than its input to fail, got %v", err)
+	}
+}
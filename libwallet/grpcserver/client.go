@@ -0,0 +1,53 @@
+package grpcserver
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Client is a client for the wallet service, to be used by integration suites.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// NewClient returns a client using the given connection.
+func NewClient(conn *grpc.ClientConn) *Client {
+	return &Client{conn}
+}
+
+func (c *Client) GenerateInvoiceSecrets(ctx context.Context, req *GenerateInvoiceSecretsRequest) (*GenerateInvoiceSecretsResponse, error) {
+	res := new(GenerateInvoiceSecretsResponse)
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/GenerateInvoiceSecrets", req, res, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) CreateInvoice(ctx context.Context, req *CreateInvoiceRequest) (*CreateInvoiceResponse, error) {
+	res := new(CreateInvoiceResponse)
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/CreateInvoice", req, res, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) ParseInvoice(ctx context.Context, req *ParseInvoiceRequest) (*ParseInvoiceResponse, error) {
+	res := new(ParseInvoiceResponse)
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/ParseInvoice", req, res, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) VerifyFulfillable(ctx context.Context, req *VerifyFulfillableRequest) (*VerifyFulfillableResponse, error) {
+	res := new(VerifyFulfillableResponse)
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/VerifyFulfillable", req, res, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
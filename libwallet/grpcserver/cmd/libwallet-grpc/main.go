@@ -0,0 +1,47 @@
+// Command libwallet-grpc serves the libwallet gRPC service, so integration
+// suites can drive the wallet logic without building the mobile apps.
+//
+// Usage:
+//
+//	libwallet-grpc -listen localhost:9090 -datadir /tmp/libwallet
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/muun/libwallet"
+	"github.com/muun/libwallet/grpcserver"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	listen := flag.String("listen", "localhost:9090", "address to listen on")
+	dataDir := flag.String("datadir", "", "directory for the wallet database")
+	flag.Parse()
+
+	if *dataDir == "" {
+		log.Fatal("the -datadir flag is required")
+	}
+
+	err := libwallet.Init(&libwallet.Config{
+		DataDir: *dataDir,
+	})
+	if err != nil {
+		log.Fatalf("failed to init libwallet: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	s := grpc.NewServer()
+	grpcserver.Register(s, &grpcserver.Server{})
+
+	log.Printf("serving libwallet on %v", lis.Addr())
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/muun/libwallet/grpcserver
+
+go 1.14
+
+require (
+	github.com/muun/libwallet v0.0.0
+	google.golang.org/grpc v1.27.0
+)
+
+// The service wraps the libwallet of this repository
+replace github.com/muun/libwallet => ../
+
+// Fork that includes the -cache flag for quicker builds
+replace golang.org/x/mobile => github.com/champo/mobile v0.0.0-20201226003606-ef8e5756cda7
@@ -0,0 +1,71 @@
+package grpcserver
+
+// Keys identify the wallet keys used by a request. Keys are base58-encoded
+// extended keys, Path is the derivation path both keys are at and Network is
+// one of mainnet, testnet3 or regtest.
+type Keys struct {
+	UserKey string
+	MuunKey string
+	Path    string
+	Network string
+}
+
+type GenerateInvoiceSecretsRequest struct {
+	Keys Keys
+}
+
+// InvoiceSecret holds the data of a secret needed to register it with the
+// remote server.
+type InvoiceSecret struct {
+	PaymentHash string
+	IdentityKey string
+	UserHtlcKey string
+	MuunHtlcKey string
+	ShortChanId int64
+}
+
+type GenerateInvoiceSecretsResponse struct {
+	Secrets []InvoiceSecret
+}
+
+type RouteHints struct {
+	Pubkey                    string
+	FeeBaseMsat               int64
+	FeeProportionalMillionths int64
+	CltvExpiryDelta           int32
+	FinalCltvExpiryDelta      int64
+}
+
+type CreateInvoiceRequest struct {
+	Keys        Keys
+	RouteHints  RouteHints
+	AmountSat   int64
+	Description string
+}
+
+type CreateInvoiceResponse struct {
+	Invoice string
+}
+
+type ParseInvoiceRequest struct {
+	Invoice string
+	Network string
+}
+
+type ParseInvoiceResponse struct {
+	PaymentHash string
+	Destination string
+	Sats        int64
+	Description string
+	Expiry      int64
+}
+
+type VerifyFulfillableRequest struct {
+	Keys             Keys
+	PaymentHash      string
+	SphinxPacket     string
+	PaymentAmountSat int64
+	BlockHeight      int64
+}
+
+type VerifyFulfillableResponse struct{}
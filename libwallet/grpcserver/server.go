@@ -0,0 +1,218 @@
+// Package grpcserver exposes the main libwallet operations as a gRPC service,
+// so integration suites can drive the real wallet logic without building the
+// mobile apps. Messages are JSON encoded, see codec.go.
+//
+// The service operates on the global libwallet configuration, which must be
+// set with libwallet.Init before serving requests.
+//
+// It's a module of its own, along with the libwallet-grpc command, so the
+// gRPC dependencies don't end up in the libwallet module the apps build.
+package grpcserver
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/muun/libwallet"
+	"github.com/muun/libwallet/desktop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const serviceName = "libwallet.Wallet"
+
+// Server implements the wallet service.
+type Server struct{}
+
+// Register adds the wallet service to the given grpc server.
+func Register(s *grpc.Server, srv WalletServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+func (s *Server) GenerateInvoiceSecrets(
+	ctx context.Context,
+	req *GenerateInvoiceSecretsRequest,
+) (*GenerateInvoiceSecretsResponse, error) {
+
+	userKey, muunKey, err := req.Keys.publicKeys()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	secrets, err := desktop.GenerateInvoiceSecrets(ctx, userKey, muunKey)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	// Secrets are persisted right away, as the private parts can't leave the
+	// wallet to be persisted in a later call
+	err = desktop.PersistInvoiceSecrets(ctx, secrets)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	res := &GenerateInvoiceSecretsResponse{}
+	for _, s := range secrets {
+		res.Secrets = append(res.Secrets, InvoiceSecret{
+			PaymentHash: hex.EncodeToString(s.PaymentHash),
+			IdentityKey: s.IdentityKey.String(),
+			UserHtlcKey: s.UserHtlcKey.String(),
+			MuunHtlcKey: s.MuunHtlcKey.String(),
+			ShortChanId: s.ShortChanId,
+		})
+	}
+	return res, nil
+}
+
+func (s *Server) CreateInvoice(ctx context.Context, req *CreateInvoiceRequest) (*CreateInvoiceResponse, error) {
+	userKey, err := req.Keys.privateUserKey()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	invoice, err := desktop.CreateInvoice(
+		ctx,
+		userKey.Network,
+		userKey,
+		&libwallet.RouteHints{
+			Pubkey:                    req.RouteHints.Pubkey,
+			FeeBaseMsat:               req.RouteHints.FeeBaseMsat,
+			FeeProportionalMillionths: req.RouteHints.FeeProportionalMillionths,
+			CltvExpiryDelta:           req.RouteHints.CltvExpiryDelta,
+			FinalCltvExpiryDelta:      req.RouteHints.FinalCltvExpiryDelta,
+		},
+		&libwallet.InvoiceOptions{
+			AmountSat:   req.AmountSat,
+			Description: req.Description,
+		},
+	)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if invoice == "" {
+		return nil, status.Error(codes.FailedPrecondition, "no unused invoice secrets available")
+	}
+
+	return &CreateInvoiceResponse{Invoice: invoice}, nil
+}
+
+func (s *Server) ParseInvoice(ctx context.Context, req *ParseInvoiceRequest) (*ParseInvoiceResponse, error) {
+	network, err := parseNetwork(req.Network)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	invoice, err := desktop.ParseInvoice(ctx, req.Invoice, network)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &ParseInvoiceResponse{
+		PaymentHash: hex.EncodeToString(invoice.PaymentHash),
+		Destination: hex.EncodeToString(invoice.Destination),
+		Sats:        invoice.Sats,
+		Description: invoice.Description,
+		Expiry:      invoice.Expiry,
+	}, nil
+}
+
+func (s *Server) VerifyFulfillable(
+	ctx context.Context,
+	req *VerifyFulfillableRequest,
+) (*VerifyFulfillableResponse, error) {
+
+	userKey, err := req.Keys.privateUserKey()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	paymentHash, err := hex.DecodeString(req.PaymentHash)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid payment hash: %v", err)
+	}
+	sphinxPacket, err := hex.DecodeString(req.SphinxPacket)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid sphinx packet: %v", err)
+	}
+
+	swap := &libwallet.IncomingSwap{
+		PaymentHash:      paymentHash,
+		SphinxPacket:     sphinxPacket,
+		PaymentAmountSat: req.PaymentAmountSat,
+		BlockHeight:      req.BlockHeight,
+	}
+	err = swap.VerifyFulfillable(userKey, userKey.Network)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return &VerifyFulfillableResponse{}, nil
+}
+
+func (k *Keys) privateUserKey() (*libwallet.HDPrivateKey, error) {
+	network, err := parseNetwork(k.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	userKey, err := libwallet.NewHDPrivateKeyFromString(k.UserKey, k.Path, network)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user key: %w", err)
+	}
+	return userKey, nil
+}
+
+// publicKeys returns the public user and muun keys. The user key may be
+// given either as a private or a public key.
+func (k *Keys) publicKeys() (*libwallet.HDPublicKey, *libwallet.HDPublicKey, error) {
+	network, err := parseNetwork(k.Network)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userKey, err := libwallet.NewHDPublicKeyFromString(k.UserKey, k.Path, network)
+	if err != nil {
+		userPrivateKey, privErr := k.privateUserKey()
+		if privErr != nil {
+			return nil, nil, fmt.Errorf("invalid user key: %w", err)
+		}
+		userKey = userPrivateKey.PublicKey()
+	}
+
+	muunKey, err := libwallet.NewHDPublicKeyFromString(k.MuunKey, k.Path, network)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid muun key: %w", err)
+	}
+
+	return userKey, muunKey, nil
+}
+
+func parseNetwork(name string) (*libwallet.Network, error) {
+	for _, network := range []*libwallet.Network{libwallet.Mainnet(), libwallet.Testnet(), libwallet.Regtest()} {
+		if network.Name() == name {
+			return network, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network %v", name)
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, desktop.ErrInvalidInvoice), errors.Is(err, desktop.ErrInvalidURI):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, desktop.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
+
+// Assert Server fulfills the WalletServer interface
+var _ WalletServer = (*Server)(nil)
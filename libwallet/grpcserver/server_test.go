@@ -0,0 +1,116 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/muun/libwallet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setup(t *testing.T) *Client {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+
+	err = libwallet.Init(&libwallet.Config{
+		DataDir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := grpc.NewServer()
+	Register(s, &Server{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return NewClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	client := setup(t)
+	ctx := context.Background()
+
+	network := libwallet.Regtest()
+	userKey, _ := libwallet.NewHDPrivateKey(randomBytes(32), network)
+	muunKey, _ := libwallet.NewHDPrivateKey(randomBytes(32), network)
+
+	keys := Keys{
+		UserKey: userKey.String(),
+		MuunKey: muunKey.PublicKey().String(),
+		Path:    "m/schema:1'/recovery:1'",
+		Network: network.Name(),
+	}
+
+	secrets, err := client.GenerateInvoiceSecrets(ctx, &GenerateInvoiceSecretsRequest{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Secrets) != libwallet.MaxUnusedSecrets {
+		t.Fatalf("expected %v secrets, got %v", libwallet.MaxUnusedSecrets, len(secrets.Secrets))
+	}
+
+	created, err := client.CreateInvoice(ctx, &CreateInvoiceRequest{
+		Keys: keys,
+		RouteHints: RouteHints{
+			Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: 1000,
+			CltvExpiryDelta:           8,
+		},
+		AmountSat:   1000,
+		Description: "hello world",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := client.ParseInvoice(ctx, &ParseInvoiceRequest{
+		Invoice: created.Invoice,
+		Network: network.Name(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Sats != 1000 {
+		t.Fatalf("expected invoice amount to be 1000 sats, got %v", parsed.Sats)
+	}
+	if parsed.PaymentHash != secrets.Secrets[0].PaymentHash {
+		t.Fatal("expected invoice to use the first generated secret")
+	}
+
+	_, err = client.ParseInvoice(ctx, &ParseInvoiceRequest{
+		Invoice: "invalid",
+		Network: network.Name(),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument error, got %v", err)
+	}
+}
+
+func randomBytes(count int) []byte {
+	buf := make([]byte, count)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
@@ -0,0 +1,113 @@
+package grpcserver
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// WalletServer is the server API for the wallet service.
+type WalletServer interface {
+	GenerateInvoiceSecrets(context.Context, *GenerateInvoiceSecretsRequest) (*GenerateInvoiceSecretsResponse, error)
+	CreateInvoice(context.Context, *CreateInvoiceRequest) (*CreateInvoiceResponse, error)
+	ParseInvoice(context.Context, *ParseInvoiceRequest) (*ParseInvoiceResponse, error)
+	VerifyFulfillable(context.Context, *VerifyFulfillableRequest) (*VerifyFulfillableResponse, error)
+}
+
+// The service is described by hand instead of generated from a proto file,
+// since messages are plain structs encoded with the json codec.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*WalletServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GenerateInvoiceSecrets",
+			Handler:    handleGenerateInvoiceSecrets,
+		},
+		{
+			MethodName: "CreateInvoice",
+			Handler:    handleCreateInvoice,
+		},
+		{
+			MethodName: "ParseInvoice",
+			Handler:    handleParseInvoice,
+		},
+		{
+			MethodName: "VerifyFulfillable",
+			Handler:    handleVerifyFulfillable,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func handleGenerateInvoiceSecrets(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GenerateInvoiceSecretsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).GenerateInvoiceSecrets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + serviceName + "/GenerateInvoiceSecrets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).GenerateInvoiceSecrets(ctx, req.(*GenerateInvoiceSecretsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func handleCreateInvoice(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateInvoiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).CreateInvoice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + serviceName + "/CreateInvoice",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).CreateInvoice(ctx, req.(*CreateInvoiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func handleParseInvoice(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ParseInvoiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).ParseInvoice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + serviceName + "/ParseInvoice",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).ParseInvoice(ctx, req.(*ParseInvoiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func handleVerifyFulfillable(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyFulfillableRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalletServer).VerifyFulfillable(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + serviceName + "/VerifyFulfillable",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalletServer).VerifyFulfillable(ctx, req.(*VerifyFulfillableRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
@@ -0,0 +1,88 @@
+package hdpath
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// SchemaPath is a path being built with Schema.
+type SchemaPath struct {
+	path Path
+}
+
+// RecoveryPath is a path being built with Schema, up to the recovery index.
+type RecoveryPath struct {
+	path Path
+}
+
+// Schema starts building a wallet key path, eg
+// Schema(1).Recovery(1).Invoices(4).Child(l1).Child(l2). Each step only has
+// the steps that can follow it, so paths with names out of place don't
+// compile.
+func Schema(i uint32) SchemaPath {
+	return SchemaPath{Path("m").NamedChild("schema", i+hdkeychain.HardenedKeyStart)}
+}
+
+// Path returns the path built so far.
+func (p SchemaPath) Path() Path {
+	return p.path
+}
+
+// Recovery adds the hardened recovery index.
+func (p SchemaPath) Recovery(i uint32) RecoveryPath {
+	return RecoveryPath{p.path.NamedChild("recovery", i+hdkeychain.HardenedKeyStart)}
+}
+
+// Path returns the path built so far.
+func (p RecoveryPath) Path() Path {
+	return p.path
+}
+
+// Change adds the change branch, whose index must be ChangeBranch.Index.
+func (p RecoveryPath) Change(i uint32) Path {
+	return p.branch(ChangeBranch, i)
+}
+
+// External adds the external branch, whose index must be
+// ExternalBranch.Index.
+func (p RecoveryPath) External(i uint32) Path {
+	return p.branch(ExternalBranch, i)
+}
+
+// Contacts adds the contacts branch, whose index must be
+// ContactsBranch.Index.
+func (p RecoveryPath) Contacts(i uint32) Path {
+	return p.branch(ContactsBranch, i)
+}
+
+// Metadata adds the metadata branch, whose index must be
+// MetadataBranch.Index.
+func (p RecoveryPath) Metadata(i uint32) Path {
+	return p.branch(MetadataBranch, i)
+}
+
+// Invoices adds the invoices branch, whose index must be
+// InvoicesBranch.Index.
+func (p RecoveryPath) Invoices(i uint32) Path {
+	return p.branch(InvoicesBranch, i)
+}
+
+// Keysend adds the keysend branch, whose index must be KeysendBranch.Index.
+func (p RecoveryPath) Keysend(i uint32) Path {
+	return p.branch(KeysendBranch, i)
+}
+
+// Sync adds the sync branch, whose index must be SyncBranch.Index.
+func (p RecoveryPath) Sync(i uint32) Path {
+	return p.branch(SyncBranch, i)
+}
+
+// branch panics if the index isn't the one of the branch, since that would
+// derive keys of another branch.
+func (p RecoveryPath) branch(b Branch, i uint32) Path {
+	if i != b.Index {
+		panic(fmt.Sprintf("%v branch has index %v, got %v", b.Name, b.Index, i))
+	}
+	return p.path.Branch(b)
+}
@@ -0,0 +1,71 @@
+package hdpath
+
+import (
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		name string
+		path Path
+		want string
+	}{
+		{name: "schema", path: Schema(1).Path(), want: "m/schema:1'"},
+		{name: "recovery", path: Schema(1).Recovery(1).Path(), want: "m/schema:1'/recovery:1'"},
+		{name: "change", path: Schema(1).Recovery(1).Change(0).Child(3), want: "m/schema:1'/recovery:1'/change:0/3"},
+		{name: "external", path: Schema(1).Recovery(1).External(1).Child(3), want: "m/schema:1'/recovery:1'/external:1/3"},
+		{name: "contacts", path: Schema(1).Recovery(1).Contacts(2).Child(3), want: "m/schema:1'/recovery:1'/contacts:2/3"},
+		{name: "metadata", path: Schema(1).Recovery(1).Metadata(3).Child(3), want: "m/schema:1'/recovery:1'/metadata:3/3"},
+		{name: "invoices", path: Schema(1).Recovery(1).Invoices(4).Child(12).Child(34), want: "m/schema:1'/recovery:1'/invoices:4/12/34"},
+		{name: "keysend", path: Schema(1).Recovery(1).Keysend(5).Child(0), want: "m/schema:1'/recovery:1'/keysend:5/0"},
+		{name: "other schema", path: Schema(2).Recovery(3).Path(), want: "m/schema:2'/recovery:3'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.path.String() != tt.want {
+				t.Errorf("got %v, want %v", tt.path, tt.want)
+			}
+			if _, err := Parse(tt.path.String()); err != nil {
+				t.Errorf("Parse() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestBuilderMatchesBranches(t *testing.T) {
+	builders := map[Branch]func(RecoveryPath) Path{
+		ChangeBranch:   func(p RecoveryPath) Path { return p.Change(ChangeBranch.Index) },
+		ExternalBranch: func(p RecoveryPath) Path { return p.External(ExternalBranch.Index) },
+		ContactsBranch: func(p RecoveryPath) Path { return p.Contacts(ContactsBranch.Index) },
+		MetadataBranch: func(p RecoveryPath) Path { return p.Metadata(MetadataBranch.Index) },
+		InvoicesBranch: func(p RecoveryPath) Path { return p.Invoices(InvoicesBranch.Index) },
+		KeysendBranch:  func(p RecoveryPath) Path { return p.Keysend(KeysendBranch.Index) },
+		SyncBranch:     func(p RecoveryPath) Path { return p.Sync(SyncBranch.Index) },
+	}
+	if len(builders) != len(branches) {
+		t.Fatalf("expected a builder step for each of the %v branches, got %v", len(branches), len(builders))
+	}
+
+	for _, b := range branches {
+		build, ok := builders[b]
+		if !ok {
+			t.Fatalf("no builder step for branch %v", b.Name)
+		}
+		path := build(Schema(1).Recovery(1))
+		if path != b.Path() {
+			t.Errorf("built %v, want %v", path, b.Path())
+		}
+		if err := Validate(path.Child(0).String()); err != nil {
+			t.Errorf("Validate() error = %v", err)
+		}
+	}
+}
+
+func TestBuilderWrongBranchIndex(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a wrong branch index to panic")
+		}
+	}()
+	Schema(1).Recovery(1).Invoices(5)
+}
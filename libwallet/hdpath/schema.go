@@ -0,0 +1,98 @@
+package hdpath
+
+import (
+	"fmt"
+)
+
+// BasePath is the path of the wallet keys every branch is derived from.
+const BasePath = "m/schema:1'/recovery:1'"
+
+// schemaIndexName names the first index of BasePath, the number of the
+// derivation scheme. Later schemes bump it, see Validate.
+const schemaIndexName = "schema"
+
+// Branch is a named child of BasePath the wallet derives keys under.
+type Branch struct {
+	Name  string
+	Index uint32
+}
+
+// Branches of the wallet keys. Indexes must never be reused, or keys of
+// different purposes would collide.
+var (
+	ChangeBranch   = Branch{"change", 0}
+	ExternalBranch = Branch{"external", 1}
+	ContactsBranch = Branch{"contacts", 2}
+	MetadataBranch = Branch{"metadata", 3}
+	InvoicesBranch = Branch{"invoices", 4}
+	KeysendBranch  = Branch{"keysend", 5}
+	SyncBranch     = Branch{"sync", 7} // 6 is reserved for offers
+)
+
+var branches = []Branch{
+	ChangeBranch,
+	ExternalBranch,
+	ContactsBranch,
+	MetadataBranch,
+	InvoicesBranch,
+	KeysendBranch,
+	SyncBranch,
+}
+
+// Path returns the path of the branch under BasePath.
+func (b Branch) Path() Path {
+	return Path(BasePath).Branch(b)
+}
+
+// Branch returns the path of the branch under this path.
+func (p Path) Branch(b Branch) Path {
+	return p.NamedChild(b.Name, b.Index)
+}
+
+// Validate checks the path is one the wallet derives keys at: BasePath or one
+// of its parents, or a path under a known branch followed by any number of
+// unhardened, unnamed indexes. BasePath may have any schema number, so paths
+// moved to a new derivation scheme are valid too. It's meant for paths read
+// from stored data, so a typo can't silently derive the wrong key.
+func Validate(s string) error {
+	p, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	indexes := p.Indexes()
+	base := Path(BasePath).Indexes()
+	for i, index := range indexes {
+		if i == 0 && index.Name == schemaIndexName && index.Hardened {
+			continue
+		}
+		if i < len(base) {
+			if index != base[i] {
+				return fmt.Errorf("path is not under %v: `%s`", BasePath, s)
+			}
+			continue
+		}
+
+		if i == len(base) {
+			if !isBranch(index) {
+				return fmt.Errorf("path has an unknown branch: `%s`", s)
+			}
+			continue
+		}
+
+		if index.Hardened || index.Name != "" {
+			return fmt.Errorf("path has an unexpected index below its branch: `%s`", s)
+		}
+	}
+
+	return nil
+}
+
+func isBranch(index PathIndex) bool {
+	for _, b := range branches {
+		if index == (PathIndex{Index: b.Index, Name: b.Name}) {
+			return true
+		}
+	}
+	return false
+}
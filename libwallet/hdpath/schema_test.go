@@ -0,0 +1,52 @@
+package hdpath
+
+import (
+	"testing"
+)
+
+func TestBranchPath(t *testing.T) {
+	if p := InvoicesBranch.Path().Child(3); p.String() != "m/schema:1'/recovery:1'/invoices:4/3" {
+		t.Fatalf("unexpected invoices path %v", p)
+	}
+	if p := MustParse("m").Branch(ExternalBranch); p.String() != "m/external:1" {
+		t.Fatalf("unexpected external path %v", p)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "root", path: "m"},
+		{name: "schema", path: "m/schema:1'"},
+		{name: "base", path: "m/schema:1'/recovery:1'"},
+		{name: "change", path: "m/schema:1'/recovery:1'/change:0/12"},
+		{name: "external", path: "m/schema:1'/recovery:1'/external:1/7"},
+		{name: "contacts", path: "m/schema:1'/recovery:1'/contacts:2/3/1"},
+		{name: "invoices", path: "m/schema:1'/recovery:1'/invoices:4/1234/5678/1"},
+		{name: "keysend", path: "m/schema:1'/recovery:1'/keysend:5/0/1"},
+		{name: "other schema", path: "m/schema:2'/recovery:1'/invoices:4/1/2"},
+		{name: "reserved branch", path: "m/schema:1'/recovery:1'/offers:6/0", wantErr: true},
+		{name: "malformed", path: "m/schema:1'/recovery:1:1", wantErr: true},
+		{name: "typo in base", path: "m/schema:1'/recvery:1'/change:0/1", wantErr: true},
+		{name: "unhardened base", path: "m/schema:1/recovery:1'/change:0/1", wantErr: true},
+		{name: "unhardened schema", path: "m/schema:2/recovery:1'/change:0/1", wantErr: true},
+		{name: "not muun", path: "m/44'/1'/0'", wantErr: true},
+		{name: "typo in branch", path: "m/schema:1'/recovery:1'/invoice:4/1/2", wantErr: true},
+		{name: "wrong branch index", path: "m/schema:1'/recovery:1'/change:1/1", wantErr: true},
+		{name: "unnamed branch", path: "m/schema:1'/recovery:1'/4/1/2", wantErr: true},
+		{name: "hardened branch", path: "m/schema:1'/recovery:1'/external:1'/1", wantErr: true},
+		{name: "hardened child", path: "m/schema:1'/recovery:1'/external:1/1'", wantErr: true},
+		{name: "named child", path: "m/schema:1'/recovery:1'/external:1/change:0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package libwallet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// Health statuses, from best to worst.
+const (
+	HealthStatusOk      = "ok"
+	HealthStatusWarning = "warning"
+	HealthStatusError   = "error"
+)
+
+// recentErrorsWindow is how far back the error journal is checked.
+const recentErrorsWindow = 1 * time.Hour
+
+// Ages past which the chain tip and exchange rates reported by the apps are
+// considered stale. Blocks are found every 10 minutes on average, but hours
+// without one happen, so only much older tips are reported.
+const (
+	staleChainTipAge     = 2 * time.Hour
+	staleExchangeRateAge = 1 * time.Hour
+)
+
+// dataTimes keeps the times of the latest chain tip and exchange rates the
+// apps reported, so HealthCheck can tell how fresh their data is.
+var dataTimes = struct {
+	sync.Mutex
+	chainTip     time.Time
+	exchangeRate time.Time
+}{}
+
+// ReportExchangeRateTime records the time, in unix seconds, of the exchange
+// rates the app is showing, so HealthCheck reports when they're stale. See
+// ReportChainTipTime for the chain data.
+func ReportExchangeRateTime(rateTime int64) {
+	dataTimes.Lock()
+	defer dataTimes.Unlock()
+
+	dataTimes.exchangeRate = time.Unix(rateTime, 0)
+}
+
+func resetDataTimes() {
+	dataTimes.Lock()
+	defer dataTimes.Unlock()
+
+	dataTimes.chainTip = time.Time{}
+	dataTimes.exchangeRate = time.Time{}
+}
+
+// ModuleHealth is the status of a single libwallet subsystem.
+type ModuleHealth struct {
+	Module string
+	Status string
+	Detail string
+}
+
+// HealthReport aggregates the status of every libwallet subsystem, so apps
+// can display a wallet health screen.
+type HealthReport struct {
+	Status  string // the worst status among all modules
+	Build   *BuildInfo
+	modules []*ModuleHealth
+}
+
+// Length returns the number of modules in the report.
+func (r *HealthReport) Length() int {
+	return len(r.modules)
+}
+
+// Get returns the module status at the given index.
+func (r *HealthReport) Get(i int) *ModuleHealth {
+	return r.modules[i]
+}
+
+func (r *HealthReport) add(module, status, detail string) {
+	r.modules = append(r.modules, &ModuleHealth{
+		Module: module,
+		Status: status,
+		Detail: detail,
+	})
+	if healthSeverity(status) > healthSeverity(r.Status) {
+		r.Status = status
+	}
+}
+
+// HealthCheck reports the status of the wallet database, its migrations, the
+// pool of invoice secrets, the security events counted, the device clock, the
+// freshness of the chain tip and exchange rates reported by the apps and the
+// errors recently recorded in the journal.
+func HealthCheck() *HealthReport {
+	report := &HealthReport{
+		Status: HealthStatusOk,
+		Build:  GetBuildInfo(),
+	}
+
+	// With deferred migrations, attach to the db so pending migrations are
+	// reported below instead of failing to open it
+	open := openDB
+	if cfg.DeferMigrations {
+		open = attachDB
+	}
+	db, err := open()
+	if err != nil {
+		report.add("db", HealthStatusError, fmt.Sprintf("db is not reachable: %v", err))
+		return report
+	}
+	defer db.Close()
+	report.add("db", HealthStatusOk, "db is reachable")
+
+	applied, total, err := db.MigrationStatus()
+	switch {
+	case err != nil:
+		report.add("migrations", HealthStatusError, fmt.Sprintf("failed to read migrations: %v", err))
+	case applied < total:
+		report.add("migrations", HealthStatusError, fmt.Sprintf("%v of %v migrations applied", applied, total))
+	default:
+		report.add("migrations", HealthStatusOk, fmt.Sprintf("%v of %v migrations applied", applied, total))
+	}
+
+	unused, err := db.CountUnusedInvoices()
+	poolSize := unusedSecretsPoolSize()
+	switch {
+	case err != nil:
+		report.add("invoice secrets", HealthStatusError, fmt.Sprintf("failed to count secrets: %v", err))
+	case unused == 0:
+		report.add("invoice secrets", HealthStatusError, "no unused secrets left to create invoices")
+	case unused < poolSize:
+		report.add("invoice secrets", HealthStatusWarning, fmt.Sprintf("%v of %v unused secrets", unused, poolSize))
+	default:
+		report.add("invoice secrets", HealthStatusOk, fmt.Sprintf("%v of %v unused secrets", unused, poolSize))
+	}
+
+	counters, err := db.ListSecurityCounters()
+	if err != nil {
+		report.add("security events", HealthStatusError, fmt.Sprintf("failed to read security events: %v", err))
+	} else {
+		report.addSecurityEvents(counters)
+	}
+
+	if err := CheckClockSkew(); err != nil {
+		report.add("clock", HealthStatusWarning, err.Error())
+	} else {
+		report.add("clock", HealthStatusOk, fmt.Sprintf("clock skew of %v seconds", ClockSkewSeconds()))
+	}
+
+	dataTimes.Lock()
+	chainTip, exchangeRate := dataTimes.chainTip, dataTimes.exchangeRate
+	dataTimes.Unlock()
+	report.addFreshness("chain", "chain tip", chainTip, staleChainTipAge)
+	report.addFreshness("exchange rates", "exchange rates", exchangeRate, staleExchangeRateAge)
+
+	entries, err := db.RecentJournalEntries(MaxJournalEntries)
+	if err != nil {
+		report.add("errors", HealthStatusError, fmt.Sprintf("failed to read error journal: %v", err))
+	} else {
+		var recent int
+		since := time.Now().Add(-recentErrorsWindow)
+		for _, e := range entries {
+			if e.Level == walletdb.JournalLevelError && e.CreatedAt.After(since) {
+				recent++
+			}
+		}
+		if recent > 0 {
+			report.add("errors", HealthStatusWarning, fmt.Sprintf("%v errors in the last %v", recent, recentErrorsWindow))
+		} else {
+			report.add("errors", HealthStatusOk, fmt.Sprintf("no errors in the last %v", recentErrorsWindow))
+		}
+	}
+
+	return report
+}
+
+// addFreshness reports a warning for the module if the data was never
+// reported, or it's older than staleAge.
+func (r *HealthReport) addFreshness(module, data string, reported time.Time, staleAge time.Duration) {
+	if reported.IsZero() {
+		r.add(module, HealthStatusWarning, fmt.Sprintf("no %v reported", data))
+		return
+	}
+	// chain tips can be ahead of the real time, see ReportChainTipTime
+	age := walletNow().Sub(reported).Truncate(time.Second)
+	if age < 0 {
+		age = 0
+	}
+	if age > staleAge {
+		r.add(module, HealthStatusWarning, fmt.Sprintf("%v is %v old", data, age))
+	} else {
+		r.add(module, HealthStatusOk, fmt.Sprintf("%v is %v old", data, age))
+	}
+}
+
+func healthSeverity(status string) int {
+	switch status {
+	case HealthStatusOk:
+		return 0
+	case HealthStatusWarning:
+		return 1
+	default:
+		return 2
+	}
+}
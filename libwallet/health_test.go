@@ -0,0 +1,68 @@
+package libwallet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	setup()
+	resetDataTimes()
+	defer resetDataTimes()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	modules := func(report *HealthReport) map[string]string {
+		statuses := make(map[string]string)
+		for i := 0; i < report.Length(); i++ {
+			statuses[report.Get(i).Module] = report.Get(i).Status
+		}
+		return statuses
+	}
+
+	report := HealthCheck()
+	if report.Status != HealthStatusError {
+		t.Fatalf("expected error status without invoice secrets, got %v", report.Status)
+	}
+	statuses := modules(report)
+	if statuses["db"] != HealthStatusOk || statuses["migrations"] != HealthStatusOk {
+		t.Fatalf("expected db and migrations to be ok, got %v", statuses)
+	}
+	if statuses["invoice secrets"] != HealthStatusError {
+		t.Fatalf("expected invoice secrets error, got %v", statuses["invoice secrets"])
+	}
+	if statuses["chain"] != HealthStatusWarning || statuses["exchange rates"] != HealthStatusWarning {
+		t.Fatalf("expected warnings without chain and rate data, got %v", statuses)
+	}
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ReportChainTipTime(time.Now().Add(-10 * time.Minute).Unix())
+	ReportExchangeRateTime(time.Now().Add(-time.Minute).Unix())
+
+	report = HealthCheck()
+	if report.Status != HealthStatusOk {
+		t.Fatalf("expected ok status, got %v: %v", report.Status, modules(report))
+	}
+	if report.Build == nil {
+		t.Fatal("expected report to include build info")
+	}
+
+	ReportExchangeRateTime(time.Now().Add(-2 * time.Hour).Unix())
+	report = HealthCheck()
+	if report.Status != HealthStatusWarning || modules(report)["exchange rates"] != HealthStatusWarning {
+		t.Fatalf("expected warning for stale exchange rates, got %v", modules(report))
+	}
+}
@@ -0,0 +1,114 @@
+package libwallet
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// HistoricalRateProvider is implemented by the apps to look up past exchange
+// rates, eg from their rates service.
+type HistoricalRateProvider interface {
+	// PriceAt returns the price of a bitcoin in the currency at the given
+	// unix time.
+	PriceAt(currencyCode string, timestamp int64) (float64, error)
+}
+
+// HistoricalPrice returns the price of a bitcoin in the currency on the day
+// of the given unix time. Rates are daily and cached in the wallet db, so the
+// provider is asked at most once per currency and day.
+func HistoricalPrice(currencyCode string, timestamp int64, provider HistoricalRateProvider) (_ float64, err error) {
+	defer recordErrors("HistoricalPrice", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	return historicalPrice(db, currencyCode, timestamp, provider)
+}
+
+func historicalPrice(db *walletdb.DB, currencyCode string, timestamp int64, provider HistoricalRateProvider) (float64, error) {
+	day := time.Unix(timestamp, 0).UTC().Truncate(24 * time.Hour).Unix()
+
+	rate, err := db.FindHistoricalRate(currencyCode, day)
+	if err != nil {
+		return 0, fmt.Errorf("HistoricalPrice: %w", err)
+	}
+	if rate != nil {
+		return rate.PricePerBtc, nil
+	}
+
+	price, err := provider.PriceAt(currencyCode, day)
+	if err != nil {
+		return 0, fmt.Errorf("HistoricalPrice: failed to get %v price at %v: %w", currencyCode, day, err)
+	}
+	if math.IsNaN(price) || price <= 0 {
+		return 0, fmt.Errorf("HistoricalPrice: invalid %v price at %v: %v", currencyCode, day, price)
+	}
+
+	err = db.SaveHistoricalRate(&walletdb.HistoricalRate{
+		Currency:    currencyCode,
+		Day:         day,
+		PricePerBtc: price,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("HistoricalPrice: %w", err)
+	}
+	return price, nil
+}
+
+// BackfillFiatValues records the value in the currency of every settled or
+// imported invoice at the time it was paid, so the invoice export shows the
+// value at that time rather than at the current rate. Invoices that already
+// have a value in the currency are skipped, so an interrupted backfill can be
+// resumed by calling it again. Invoices settled before their settled time was
+// stored are skipped too, since when they were paid is unknown. It returns the
+// number of invoices updated.
+func BackfillFiatValues(currencyCode string, provider HistoricalRateProvider) (_ int64, err error) {
+	defer recordErrors("BackfillFiatValues", &err)
+
+	if currencyCode == "" {
+		return 0, fmt.Errorf("BackfillFiatValues: currency code can't be empty")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	invoices, err := db.ListInvoices()
+	if err != nil {
+		return 0, fmt.Errorf("BackfillFiatValues: %w", err)
+	}
+
+	var updated int64
+	for i := range invoices {
+		invoice := &invoices[i]
+		if invoice.State != walletdb.InvoiceStateSettled && invoice.State != walletdb.InvoiceStateImported {
+			continue
+		}
+		paidAt := invoicePaidAt(invoice)
+		if paidAt == nil || invoice.AmountSat == 0 || invoice.FiatCurrency == currencyCode {
+			continue
+		}
+
+		price, err := historicalPrice(db, currencyCode, paidAt.Unix(), provider)
+		if err != nil {
+			return updated, fmt.Errorf("BackfillFiatValues: %w", err)
+		}
+
+		invoice.FiatValue = float64(invoice.AmountSat) / satsPerBtc * price
+		invoice.FiatCurrency = currencyCode
+		if err := db.SaveInvoice(invoice); err != nil {
+			return updated, fmt.Errorf("BackfillFiatValues: %w", err)
+		}
+		updated++
+	}
+
+	return updated, nil
+}
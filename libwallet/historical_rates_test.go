@@ -0,0 +1,169 @@
+package libwallet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+type countingRateProvider struct {
+	calls int
+	err   error
+}
+
+func (p *countingRateProvider) PriceAt(currencyCode string, timestamp int64) (float64, error) {
+	p.calls++
+	if p.err != nil {
+		return 0, p.err
+	}
+	// a different price each day
+	return float64(10000 + timestamp/86400), nil
+}
+
+func TestHistoricalPrice(t *testing.T) {
+	setup()
+
+	provider := &countingRateProvider{}
+
+	morning := time.Date(2020, 9, 13, 9, 0, 0, 0, time.UTC).Unix()
+	evening := time.Date(2020, 9, 13, 21, 0, 0, 0, time.UTC).Unix()
+
+	price, err := HistoricalPrice("USD", morning, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price != 10000+18518 {
+		t.Fatalf("unexpected price %v", price)
+	}
+
+	cached, err := HistoricalPrice("USD", evening, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != price || provider.calls != 1 {
+		t.Fatalf("expected rate for the same day to be cached, got %v after %v calls", cached, provider.calls)
+	}
+
+	if _, err := HistoricalPrice("EUR", evening, provider); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 2 {
+		t.Fatalf("expected rates to be cached per currency, got %v calls", provider.calls)
+	}
+
+	_, err = HistoricalPrice("ARS", morning, &countingRateProvider{err: errors.New("offline")})
+	if err == nil {
+		t.Fatal("expected provider error to be returned")
+	}
+}
+
+func TestBackfillFiatValues(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settled, err := db.FindFirstUnusedInvoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// issued days before it was paid, so it's valued at its settled time
+	usedAt := time.Date(2020, 9, 1, 9, 0, 0, 0, time.UTC)
+	settledAt := time.Date(2020, 9, 13, 9, 0, 0, 0, time.UTC)
+	settled.State = walletdb.InvoiceStateSettled
+	settled.AmountSat = 50000000
+	settled.UsedAt = &usedAt
+	settled.SettledAt = &settledAt
+	if err := db.SaveInvoice(settled); err != nil {
+		t.Fatal(err)
+	}
+
+	// settled before the settled time was stored, so when it was paid is unknown
+	unknown, err := db.FindFirstUnusedInvoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown.State = walletdb.InvoiceStateSettled
+	unknown.AmountSat = 1000
+	unknown.UsedAt = &usedAt
+	if err := db.SaveInvoice(unknown); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	preimage := randomBytes(32)
+	hash := sha256.Sum256(preimage)
+	dump := fmt.Sprintf(`{"invoices": [{"r_preimage": %q, "r_hash": %q, "value": "1000", "settled": true, "settle_date": "1500000000"}]}`,
+		hex.EncodeToString(preimage), hex.EncodeToString(hash[:]))
+	if _, err := ImportNodeInvoices(InvoiceImportFormatLnd, []byte(dump)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := BackfillFiatValues("USD", &countingRateProvider{err: errors.New("offline")}); err == nil {
+		t.Fatal("expected provider error to be returned")
+	}
+
+	provider := &countingRateProvider{}
+	updated, err := BackfillFiatValues("USD", provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 2 || provider.calls != 2 {
+		t.Fatalf("expected 2 invoices updated with 2 lookups, got %v and %v", updated, provider.calls)
+	}
+
+	// backfilled invoices are skipped
+	updated, err = BackfillFiatValues("USD", provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 0 || provider.calls != 2 {
+		t.Fatalf("expected no invoices updated, got %v and %v lookups", updated, provider.calls)
+	}
+
+	export, err := ExportSettledInvoices("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := DecryptInvoiceExport(export, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc InvoiceExport
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	own := doc.Invoices[0]
+	if own.FiatCurrency != "USD" || own.FiatValue != float64(10000+18518)/2 {
+		t.Fatalf("unexpected fiat value %v %v", own.FiatValue, own.FiatCurrency)
+	}
+	if doc.Invoices[1].FiatCurrency != "" {
+		t.Fatalf("expected invoice without settled time not to be valued, got %v", doc.Invoices[1].FiatCurrency)
+	}
+	imported := doc.Invoices[2]
+	if imported.FiatCurrency != "USD" || imported.FiatValue == own.FiatValue {
+		t.Fatalf("expected imported invoice to be valued at its own date, got %v %v", imported.FiatValue, imported.FiatCurrency)
+	}
+}
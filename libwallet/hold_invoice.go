@@ -0,0 +1,131 @@
+package libwallet
+
+import (
+	"fmt"
+
+	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// SettleInvoice releases the preimage of a hold invoice, so the payment it's
+// holding (or the next one, if none arrived yet) can be fulfilled. Apps must
+// call Fulfill again for the held swap afterwards. For amp invoices, it
+// releases the shards of the payment too.
+func SettleInvoice(paymentHash []byte) (err error) {
+	defer recordErrors("SettleInvoice", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return fmt.Errorf("SettleInvoice: could not find invoice for payment hash: %w", err)
+	}
+	if !invoice.Hold {
+		return fmt.Errorf("SettleInvoice: invoice is not held")
+	}
+
+	switch invoice.State {
+	case walletdb.InvoiceStateUsed, walletdb.InvoiceStateAccepted:
+	default:
+		return fmt.Errorf("SettleInvoice: can't settle %v invoice", invoice.State)
+	}
+
+	err = db.Transaction(func(tx *walletdb.DB) error {
+		shards, err := ampShardInvoices(tx, invoice)
+		if err != nil {
+			return err
+		}
+		for _, shard := range append(shards, invoice) {
+			shard.Hold = false
+			if err := tx.SaveInvoice(shard); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("SettleInvoice: %w", err)
+	}
+	return nil
+}
+
+// CancelHeldInvoice rejects the payment held by a hold invoice, or the next
+// one if none arrived yet. The preimage is never handed out, so the swap
+// server reclaims the htlc once it expires. Cancelling twice is a no-op. For
+// amp invoices, the shards of the payment are cancelled too.
+func CancelHeldInvoice(paymentHash []byte) (err error) {
+	defer recordErrors("CancelHeldInvoice", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return fmt.Errorf("CancelHeldInvoice: could not find invoice for payment hash: %w", err)
+	}
+
+	switch {
+	case invoice.State == walletdb.InvoiceStateCancelled:
+		return nil
+	case !invoice.Hold:
+		return fmt.Errorf("CancelHeldInvoice: invoice is not held")
+	case invoice.State == walletdb.InvoiceStateUsed, invoice.State == walletdb.InvoiceStateAccepted:
+	default:
+		return fmt.Errorf("CancelHeldInvoice: can't cancel %v invoice", invoice.State)
+	}
+
+	shards, err := ampShardInvoices(db, invoice)
+	if err != nil {
+		return fmt.Errorf("CancelHeldInvoice: %w", err)
+	}
+	for _, shard := range shards {
+		if shard.State != walletdb.InvoiceStateUsed && shard.State != walletdb.InvoiceStateAccepted {
+			continue
+		}
+		if err := saveInvoiceState(db, shard, walletdb.InvoiceStateCancelled); err != nil {
+			return fmt.Errorf("CancelHeldInvoice: %w", err)
+		}
+	}
+	if err := saveInvoiceState(db, invoice, walletdb.InvoiceStateCancelled); err != nil {
+		return fmt.Errorf("CancelHeldInvoice: %w", err)
+	}
+	return nil
+}
+
+// checkHeld returns an error with the ErrInvoiceHeld code if the invoice paid
+// by the swap withholds its preimage, marking it as accepted so apps can
+// show there's a payment waiting to be settled or cancelled.
+func (s *IncomingSwap) checkHeld(operation string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(s.PaymentHash)
+	if err != nil {
+		return fmt.Errorf("%v: could not find invoice data for payment hash: %w", operation, err)
+	}
+	return checkInvoiceHeld(db, invoice, operation)
+}
+
+// checkInvoiceHeld is checkHeld for an invoice already looked up.
+func checkInvoiceHeld(db *walletdb.DB, invoice *walletdb.Invoice, operation string) error {
+	if !invoice.Hold {
+		return nil
+	}
+
+	if invoice.State == walletdb.InvoiceStateUsed {
+		if err := saveInvoiceState(db, invoice, walletdb.InvoiceStateAccepted); err != nil {
+			return fmt.Errorf("%v: %w", operation, err)
+		}
+	}
+	return errors.Errorf(ErrInvoiceHeld, "%v: invoice is held until settled or cancelled", operation)
+}
@@ -0,0 +1,160 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestHoldInvoice(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	newSwap := func(opts *InvoiceOptions) *IncomingSwap {
+		invoice, err := CreateInvoice(network, userKey, routeHints, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		return &IncomingSwap{
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 1000, 1000),
+			PaymentHash:      paymentHash,
+			PaymentAmountSat: 1000,
+		}
+	}
+
+	state := func(paymentHash []byte) string {
+		details, err := GetInvoiceByPaymentHash(paymentHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return details.State
+	}
+
+	t.Run("settle", func(t *testing.T) {
+		swap := newSwap(&InvoiceOptions{AmountSat: 1000, Hold: true})
+
+		if err := swap.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
+		}
+		_, err := swap.FulfillFullDebt()
+		if ErrorCode(err) != ErrInvoiceHeld {
+			t.Fatalf("expected invoice to be held, got %v", err)
+		}
+		if s := state(swap.PaymentHash); s != string(walletdb.InvoiceStateAccepted) {
+			t.Fatalf("expected invoice to be accepted, got %v", s)
+		}
+
+		if err := SettleInvoice(swap.PaymentHash); err != nil {
+			t.Fatal(err)
+		}
+		result, err := swap.FulfillFullDebt()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := sha256.Sum256(result.Preimage)
+		if !bytes.Equal(hash[:], swap.PaymentHash) {
+			t.Fatal("expected the invoice preimage to be handed out")
+		}
+		if s := state(swap.PaymentHash); s != string(walletdb.InvoiceStateSettled) {
+			t.Fatalf("expected invoice to be settled, got %v", s)
+		}
+
+		if err := SettleInvoice(swap.PaymentHash); err == nil {
+			t.Fatal("expected settling twice to fail")
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		swap := newSwap(&InvoiceOptions{AmountSat: 1000, Hold: true})
+
+		if _, err := swap.FulfillFullDebt(); ErrorCode(err) != ErrInvoiceHeld {
+			t.Fatalf("expected invoice to be held, got %v", err)
+		}
+		if err := CancelHeldInvoice(swap.PaymentHash); err != nil {
+			t.Fatal(err)
+		}
+		if err := CancelHeldInvoice(swap.PaymentHash); err != nil {
+			t.Fatalf("expected cancelling twice to be a no-op, got %v", err)
+		}
+
+		if err := swap.VerifyFulfillable(userKey, network); err == nil {
+			t.Fatal("expected payment to cancelled invoice to fail")
+		}
+		if _, err := swap.FulfillFullDebt(); err == nil {
+			t.Fatal("expected cancelled invoice not to hand out its preimage")
+		}
+		if err := SettleInvoice(swap.PaymentHash); err == nil {
+			t.Fatal("expected settling a cancelled invoice to fail")
+		}
+	})
+
+	t.Run("sign input", func(t *testing.T) {
+		swap := newSwap(&InvoiceOptions{AmountSat: 1000, Hold: true})
+
+		var htlcTx bytes.Buffer
+		prevTx := wire.NewMsgTx(2)
+		prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+		prevTx.AddTxOut(wire.NewTxOut(1000, nil))
+		if err := prevTx.Serialize(&htlcTx); err != nil {
+			t.Fatal(err)
+		}
+		coin := &coinIncomingSwap{
+			Network:        network.network,
+			HtlcTx:         htlcTx.Bytes(),
+			PaymentHash256: swap.PaymentHash,
+		}
+		tx := wire.NewMsgTx(2)
+
+		err := coin.SignInput(0, tx, userKey, muunKey.PublicKey())
+		if ErrorCode(err) != ErrInvoiceHeld {
+			t.Fatalf("expected signing a held invoice input to fail, got %v", err)
+		}
+
+		if err := CancelHeldInvoice(swap.PaymentHash); err != nil {
+			t.Fatal(err)
+		}
+		err = coin.SignInput(0, tx, userKey, muunKey.PublicKey())
+		if err == nil || ErrorCode(err) == ErrInvoiceHeld {
+			t.Fatalf("expected signing a cancelled invoice input to fail, got %v", err)
+		}
+	})
+
+	t.Run("regular invoice", func(t *testing.T) {
+		swap := newSwap(&InvoiceOptions{AmountSat: 1000})
+
+		if err := SettleInvoice(swap.PaymentHash); err == nil {
+			t.Fatal("expected settling a regular invoice to fail")
+		}
+		if err := CancelHeldInvoice(swap.PaymentHash); err == nil {
+			t.Fatal("expected cancelling a regular invoice as held to fail")
+		}
+		if _, err := swap.FulfillFullDebt(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -25,6 +25,7 @@ type coinIncomingSwap struct {
 	ExpirationHeight    int64
 	VerifyOutputAmount  bool // used only for fulfilling swaps through IncomingSwap
 	Collect             btcutil.Amount
+	SigHashes           *txscript.TxSigHashes // shared by the inputs of a batch, may be nil
 }
 
 func (c *coinIncomingSwap) SignInput(index int, tx *wire.MsgTx, userKey *HDPrivateKey, muunKey *HDPublicKey) error {
@@ -42,10 +43,15 @@ func (c *coinIncomingSwap) SignInput(index int, tx *wire.MsgTx, userKey *HDPriva
 	}
 	defer db.Close()
 
-	secrets, err := db.FindByPaymentHash(c.PaymentHash256)
+	secrets, err := findFulfillableInvoice(db, c.PaymentHash256)
 	if err != nil {
 		return fmt.Errorf("could not find invoice data for payment hash: %w", err)
 	}
+	// Hold invoices withhold the preimage until settled, however the swap
+	// is spent
+	if err := checkInvoiceHeld(db, secrets, "SignInput"); err != nil {
+		return err
+	}
 
 	// Recreate the HTLC script to verify it matches the transaction. For this
 	// we must derive the keys used in the HTLC script
@@ -76,7 +82,7 @@ func (c *coinIncomingSwap) SignInput(index int, tx *wire.MsgTx, userKey *HDPriva
 
 	// Next, we must validate the sphinx data. We derive the client identity
 	// key used by this invoice with the key path stored in the db.
-	identityKeyPath := hdpath.MustParse(secrets.KeyPath).Child(identityKeyChildIndex)
+	identityKeyPath := invoiceIdentityKeyPath(secrets)
 
 	nodeHDKey, err := userKey.DeriveTo(identityKeyPath.String())
 	if err != nil {
@@ -96,7 +102,7 @@ func (c *coinIncomingSwap) SignInput(index int, tx *wire.MsgTx, userKey *HDPriva
 		return fmt.Errorf("expected fulfillment tx input to point to correct htlc output")
 	}
 
-	sigHashes := txscript.NewTxSigHashes(tx)
+	sigHashes := txSigHashes(tx, c.SigHashes)
 
 	muunSigKey, err := muunPublicKey.key.ECPubKey()
 	if err != nil {
@@ -128,24 +134,33 @@ func (c *coinIncomingSwap) SignInput(index int, tx *wire.MsgTx, userKey *HDPriva
 		// This incoming swap might be collecting debt, which would be deducted from the outputAmount
 		// so we add it back up so the amount will match with the sphinx
 		expectedAmount := outputAmount + lnwire.NewMSatFromSatoshis(c.Collect)
-		err = sphinx.Validate(
+		part, err := sphinx.ValidatePart(
 			c.Sphinx,
 			c.PaymentHash256,
 			secrets.PaymentSecret,
 			nodeKey,
 			uint32(c.ExpirationHeight),
+			0, // The cltv safety check is only performed by VerifyFulfillable
 			expectedAmount,
 			c.Network,
 		)
 		if err != nil {
+			countSecurityEvent(SecurityEventSphinxValidation)
 			return fmt.Errorf("could not verify sphinx blob: %w", err)
 		}
+		if part != nil {
+			err = recordMppPart(db, c.PaymentHash256, c.Sphinx, part, 0)
+			if err != nil {
+				return fmt.Errorf("could not verify sphinx blob: %w", err)
+			}
+		}
 	}
 
 	// Sign the fulfillment tx
 	sig, err := signNativeSegwitInput(
 		index,
 		tx,
+		sigHashes,
 		userPrivateKey,
 		htlcScript,
 		btcutil.Amount(htlcOutputAmount),
@@ -224,6 +239,7 @@ func (c *coinIncomingSwap) signature(index int, tx *wire.MsgTx, userKey *HDPubli
 	sig, err := signNativeSegwitInput(
 		index,
 		tx,
+		c.SigHashes,
 		signingKey,
 		htlcScript,
 		btcutil.Amount(prevOutAmount),
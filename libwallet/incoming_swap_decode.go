@@ -0,0 +1,126 @@
+package libwallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/sphinx"
+)
+
+// IncomingSwapSchemaVersion is the latest incoming swap payload version this
+// build understands.
+const IncomingSwapSchemaVersion = 1
+
+type incomingSwapHtlcJson struct {
+	HtlcTx              string `json:"htlcTx"`
+	ExpirationHeight    int64  `json:"expirationHeight"`
+	SwapServerPublicKey string `json:"swapServerPublicKey"`
+}
+
+type incomingSwapJson struct {
+	Version          int                   `json:"version"`
+	Htlc             *incomingSwapHtlcJson `json:"htlc"`
+	SphinxPacket     string                `json:"sphinxPacket"`
+	PaymentHash      string                `json:"paymentHash"`
+	PaymentAmountSat int64                 `json:"paymentAmountSat"`
+	CollectSat       int64                 `json:"collectSat"`
+	BlockHeight      int64                 `json:"blockHeight"`
+}
+
+// DecodeIncomingSwap builds an IncomingSwap from the JSON payload sent by
+// houston, so the apps can forward it as is instead of copying each field.
+// Byte fields are hex encoded. The payload must declare a version no newer
+// than IncomingSwapSchemaVersion, and unknown fields are rejected.
+func DecodeIncomingSwap(data []byte) (*IncomingSwap, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var payload incomingSwapJson
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, errors.Errorf(ErrInvalidIncomingSwap, "DecodeIncomingSwap: malformed payload: %w", err)
+	}
+
+	if payload.Version < 1 || payload.Version > IncomingSwapSchemaVersion {
+		return nil, errors.Errorf(ErrInvalidIncomingSwap, "DecodeIncomingSwap: unsupported version %v", payload.Version)
+	}
+
+	swap := &IncomingSwap{
+		PaymentAmountSat: payload.PaymentAmountSat,
+		CollectSat:       payload.CollectSat,
+		BlockHeight:      payload.BlockHeight,
+	}
+
+	var err error
+	swap.PaymentHash, err = decodeHexField("paymentHash", payload.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(swap.PaymentHash) != 32 {
+		return nil, errors.Errorf(ErrInvalidIncomingSwap, "DecodeIncomingSwap: invalid paymentHash len %v", len(swap.PaymentHash))
+	}
+
+	swap.SphinxPacket, err = decodeHexField("sphinxPacket", payload.SphinxPacket)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateAmountSat(payload.PaymentAmountSat); err != nil {
+		return nil, err
+	}
+	if err := validateAmountSat(payload.CollectSat); err != nil {
+		return nil, err
+	}
+	if payload.BlockHeight < 0 {
+		return nil, errors.Errorf(ErrInvalidIncomingSwap, "DecodeIncomingSwap: block height must not be negative")
+	}
+
+	if payload.Htlc != nil {
+		htlc := &IncomingSwapHtlc{
+			ExpirationHeight: payload.Htlc.ExpirationHeight,
+		}
+
+		htlc.HtlcTx, err = decodeHexField("htlc.htlcTx", payload.Htlc.HtlcTx)
+		if err != nil {
+			return nil, err
+		}
+		if len(htlc.HtlcTx) == 0 {
+			return nil, errors.Errorf(ErrInvalidIncomingSwap, "DecodeIncomingSwap: missing htlc.htlcTx")
+		}
+
+		htlc.SwapServerPublicKey, err = decodeHexField("htlc.swapServerPublicKey", payload.Htlc.SwapServerPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := btcec.ParsePubKey(htlc.SwapServerPublicKey, btcec.S256()); err != nil {
+			return nil, errors.Errorf(ErrInvalidIncomingSwap, "DecodeIncomingSwap: invalid htlc.swapServerPublicKey: %w", err)
+		}
+
+		swap.Htlc = htlc
+	}
+
+	return swap, nil
+}
+
+// checkSphinxPacket returns an error with the ErrInvalidSphinx code if the
+// packet is malformed, see sphinx.CheckPacket.
+func checkSphinxPacket(op string, packet []byte) error {
+	if err := sphinx.CheckPacket(packet); err != nil {
+		countSecurityEvent(SecurityEventSphinxValidation)
+		return errors.Errorf(ErrInvalidSphinx, "%v: %w", op, err)
+	}
+	return nil
+}
+
+func decodeHexField(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, errors.Errorf(ErrInvalidIncomingSwap, "DecodeIncomingSwap: invalid hex in %v: %w", name, err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,96 @@
+package libwallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestDecodeIncomingSwap(t *testing.T) {
+	const serverKey = "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd"
+	paymentHash := randomBytes(32)
+	hash := hex.EncodeToString(paymentHash)
+
+	t.Run("full payload", func(t *testing.T) {
+		payload := fmt.Sprintf(`{
+			"version": 1,
+			"htlc": {"htlcTx": "0102", "expirationHeight": 700, "swapServerPublicKey": %q},
+			"sphinxPacket": "aabb",
+			"paymentHash": %q,
+			"paymentAmountSat": 1000,
+			"collectSat": 10,
+			"blockHeight": 600
+		}`, serverKey, hash)
+
+		swap, err := DecodeIncomingSwap([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(swap.PaymentHash, paymentHash) {
+			t.Fatal("payment hash mismatch")
+		}
+		if !bytes.Equal(swap.SphinxPacket, []byte{0xaa, 0xbb}) {
+			t.Fatal("sphinx packet mismatch")
+		}
+		if swap.PaymentAmountSat != 1000 || swap.CollectSat != 10 || swap.BlockHeight != 600 {
+			t.Fatalf("unexpected amounts %+v", swap)
+		}
+		if swap.Htlc == nil || swap.Htlc.ExpirationHeight != 700 || !bytes.Equal(swap.Htlc.HtlcTx, []byte{1, 2}) {
+			t.Fatalf("unexpected htlc %+v", swap.Htlc)
+		}
+	})
+
+	t.Run("without htlc", func(t *testing.T) {
+		payload := fmt.Sprintf(`{"version": 1, "paymentHash": %q, "paymentAmountSat": 1}`, hash)
+		swap, err := DecodeIncomingSwap([]byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if swap.Htlc != nil || swap.SphinxPacket != nil {
+			t.Fatal("expected empty htlc and sphinx")
+		}
+	})
+
+	invalid := []struct {
+		desc    string
+		payload string
+	}{
+		{"not json", `not json`},
+		{"missing version", fmt.Sprintf(`{"paymentHash": %q}`, hash)},
+		{"future version", fmt.Sprintf(`{"version": 2, "paymentHash": %q}`, hash)},
+		{"unknown field", fmt.Sprintf(`{"version": 1, "paymentHash": %q, "foo": 1}`, hash)},
+		{"short hash", `{"version": 1, "paymentHash": "aabb"}`},
+		{"bad hex", fmt.Sprintf(`{"version": 1, "paymentHash": %q, "sphinxPacket": "zz"}`, hash)},
+		{"missing htlc tx", fmt.Sprintf(`{"version": 1, "paymentHash": %q, "htlc": {"swapServerPublicKey": %q}}`, hash, serverKey)},
+		{"bad server key", fmt.Sprintf(`{"version": 1, "paymentHash": %q, "htlc": {"htlcTx": "01", "swapServerPublicKey": "0102"}}`, hash)},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := DecodeIncomingSwap([]byte(tt.payload))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if ErrorCode(err) != ErrInvalidIncomingSwap {
+				t.Fatalf("expected ErrInvalidIncomingSwap, got %v", ErrorCode(err))
+			}
+		})
+	}
+
+	invalidAmounts := []struct {
+		desc    string
+		payload string
+	}{
+		{"negative amount", fmt.Sprintf(`{"version": 1, "paymentHash": %q, "paymentAmountSat": -1}`, hash)},
+		{"amount above supply", fmt.Sprintf(`{"version": 1, "paymentHash": %q, "paymentAmountSat": 2100000000000001}`, hash)},
+		{"negative collect", fmt.Sprintf(`{"version": 1, "paymentHash": %q, "collectSat": -1}`, hash)},
+	}
+	for _, tt := range invalidAmounts {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := DecodeIncomingSwap([]byte(tt.payload))
+			if ErrorCode(err) != ErrInvalidAmount {
+				t.Fatalf("expected ErrInvalidAmount, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package libwallet
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/htlcswitch/hop"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/muun/libwallet/hdpath"
+	"github.com/muun/libwallet/sphinx"
+)
+
+// Results of a single diagnostic check.
+const (
+	DiagnosticPassed  = "passed"
+	DiagnosticFailed  = "failed"
+	DiagnosticSkipped = "skipped" // a check it depends on failed or it doesn't apply
+)
+
+// DiagnosticCheck is the outcome of one of the steps of VerifyFulfillable.
+type DiagnosticCheck struct {
+	Name   string
+	Result string
+	Detail string
+}
+
+// IncomingSwapDiagnostics is a report of every check VerifyFulfillable
+// performs on an incoming swap.
+type IncomingSwapDiagnostics struct {
+	Fulfillable bool // true if no check failed
+	checks      []*DiagnosticCheck
+}
+
+// Length returns the number of checks in the report.
+func (d *IncomingSwapDiagnostics) Length() int {
+	return len(d.checks)
+}
+
+// Get returns the check at the given index.
+func (d *IncomingSwapDiagnostics) Get(i int) *DiagnosticCheck {
+	return d.checks[i]
+}
+
+func (d *IncomingSwapDiagnostics) add(name, result, detail string) {
+	d.checks = append(d.checks, &DiagnosticCheck{
+		Name:   name,
+		Result: result,
+		Detail: detail,
+	})
+	if result == DiagnosticFailed {
+		d.Fulfillable = false
+	}
+}
+
+func (d *IncomingSwapDiagnostics) skip(detail string, names ...string) {
+	for _, name := range names {
+		d.add(name, DiagnosticSkipped, detail)
+	}
+}
+
+// DiagnoseIncomingSwap runs each validation step of VerifyFulfillable on its
+// own and reports the outcome of all of them, instead of stopping at the first
+// failure. It's meant for support tooling and never modifies the swap or the
+// invoice.
+func DiagnoseIncomingSwap(swap *IncomingSwap, userKey *HDPrivateKey, net *Network) *IncomingSwapDiagnostics {
+	d := &IncomingSwapDiagnostics{Fulfillable: true}
+
+	const (
+		checkHash      = "payment hash"
+		checkInvoice   = "invoice found"
+		checkAmount    = "invoice amount"
+		checkHmac      = "sphinx hmac"
+		checkFwdAmt    = "sphinx amount"
+		checkCltv      = "cltv expiry"
+		checkSecret    = "payment secret"
+		checkMultiPart = "single part"
+	)
+
+	if len(swap.PaymentHash) != 32 {
+		d.add(checkHash, DiagnosticFailed, fmt.Sprintf("invalid hash len %v", len(swap.PaymentHash)))
+		d.skip("invalid payment hash", checkInvoice, checkAmount, checkHmac, checkFwdAmt, checkCltv, checkSecret, checkMultiPart)
+		return d
+	}
+	d.add(checkHash, DiagnosticPassed, "")
+
+	invoice, err := swap.getInvoice()
+	if err != nil {
+		d.add(checkInvoice, DiagnosticFailed, err.Error())
+		d.skip("invoice not found", checkAmount, checkHmac, checkFwdAmt, checkCltv, checkSecret, checkMultiPart)
+		return d
+	}
+	d.add(checkInvoice, DiagnosticPassed, fmt.Sprintf("invoice in state %v, short channel id %v",
+		invoice.State, FormatShortChannelId(int64(invoice.ShortChanId))))
+
+	// Parts of a multi-part payment are checked against the invoice amount
+	// with the total of the payment, which only the sphinx tells
+	checkPaid := func(paidSat int64) {
+		if invoice.AmountSat != 0 && invoice.AmountSat > paidSat {
+			d.add(checkAmount, DiagnosticFailed, fmt.Sprintf(
+				"payment amount (%v) does not match invoice amount (%v)", paidSat, invoice.AmountSat))
+		} else {
+			d.add(checkAmount, DiagnosticPassed, "")
+		}
+	}
+
+	if len(swap.SphinxPacket) == 0 {
+		checkPaid(swap.PaymentAmountSat)
+		d.skip("no sphinx packet", checkHmac, checkFwdAmt, checkCltv, checkSecret, checkMultiPart)
+		return d
+	}
+
+	payload, err := decodeSwapSphinx(swap, invoiceIdentityKeyPath(invoice), userKey, net)
+	if err != nil {
+		d.add(checkHmac, DiagnosticFailed, err.Error())
+		d.skip("sphinx could not be decoded", checkAmount, checkFwdAmt, checkCltv, checkSecret, checkMultiPart)
+		return d
+	}
+	d.add(checkHmac, DiagnosticPassed, "")
+
+	amountToForward := payload.ForwardingInfo().AmountToForward
+	if payload.MPP != nil && payload.MultiPath().TotalMsat() > amountToForward {
+		checkPaid(int64(payload.MultiPath().TotalMsat().ToSatoshis()))
+	} else {
+		checkPaid(swap.PaymentAmountSat)
+	}
+	if amount, err := swap.PaymentAmount(); err != nil {
+		d.add(checkFwdAmt, DiagnosticFailed, err.Error())
+	} else if amountToForward > amount.toMilliSatoshi() {
+		d.add(checkFwdAmt, DiagnosticFailed, fmt.Sprintf(
+			"sphinx payment amount does not match (%v != %v)", amount.toMilliSatoshi(), amountToForward))
+	} else {
+		d.add(checkFwdAmt, DiagnosticPassed, "")
+	}
+
+	outgoingCltv := payload.ForwardingInfo().OutgoingCTLV
+	if swap.BlockHeight == 0 {
+		d.add(checkCltv, DiagnosticSkipped, "no block height given")
+	} else if minCltvExpiry := uint32(swap.BlockHeight + cltvSafetyDelta(invoice)); outgoingCltv < minCltvExpiry {
+		d.add(checkCltv, DiagnosticFailed, fmt.Sprintf(
+			"sphinx cltv expiry is too close to the chain tip (%v < %v)", outgoingCltv, minCltvExpiry))
+	} else {
+		d.add(checkCltv, DiagnosticPassed, "")
+	}
+
+	if payload.MPP == nil {
+		d.skip("no payment secret in sphinx", checkSecret, checkMultiPart)
+		return d
+	}
+
+	paymentAddr := payload.MPP.PaymentAddr()
+	if !bytes.Equal(paymentAddr[:], invoice.PaymentSecret) {
+		d.add(checkSecret, DiagnosticFailed, "sphinx payment secret does not match")
+	} else {
+		d.add(checkSecret, DiagnosticPassed, "")
+	}
+
+	total := payload.MultiPath().TotalMsat()
+	if amountToForward >= total {
+		d.add(checkMultiPart, DiagnosticPassed, "")
+	} else if received, err := mppReceivedFor(swap.PaymentHash, total, swap.BlockHeight); err != nil {
+		d.add(checkMultiPart, DiagnosticFailed, err.Error())
+	} else if received < total {
+		d.add(checkMultiPart, DiagnosticFailed, fmt.Sprintf(
+			"payment is multipart. forwarded amt = %v, total amt = %v, received amt = %v", amountToForward, total, received))
+	} else {
+		d.add(checkMultiPart, DiagnosticPassed, fmt.Sprintf("all parts received, total amt = %v", total))
+	}
+
+	return d
+}
+
+func decodeSwapSphinx(swap *IncomingSwap, identityKeyPath hdpath.Path, userKey *HDPrivateKey, net *Network) (*hop.Payload, error) {
+	nodeHDKey, err := userKey.DeriveTo(identityKeyPath.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	nodeKey, err := nodeHDKey.key.ECPrivKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get priv key: %w", err)
+	}
+
+	return sphinx.Decode(swap.SphinxPacket, swap.PaymentHash, nodeKey, 0, net.network)
+}
+
+// mppReceivedFor returns the amount paid by the parts of the multi-part
+// payment for the payment hash recorded so far, see mppReceived.
+func mppReceivedFor(paymentHash []byte, total lnwire.MilliSatoshi, blockHeight int64) (lnwire.MilliSatoshi, error) {
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	parts, err := db.ListMppParts(paymentHash)
+	if err != nil {
+		return 0, err
+	}
+	return mppReceived(parts, total, blockHeight)
+}
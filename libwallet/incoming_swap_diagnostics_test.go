@@ -0,0 +1,144 @@
+package libwallet
+
+import (
+	"testing"
+)
+
+func TestDiagnoseIncomingSwap(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	createInvoiceWithOptions := func(opts *InvoiceOptions) string {
+		invoice, err := CreateInvoice(network, userKey, &RouteHints{
+			Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: 1000,
+			CltvExpiryDelta:           8,
+		}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return invoice
+	}
+	createInvoice := func() string {
+		return createInvoiceWithOptions(&InvoiceOptions{})
+	}
+
+	results := func(d *IncomingSwapDiagnostics) map[string]string {
+		m := make(map[string]string)
+		for i := 0; i < d.Length(); i++ {
+			m[d.Get(i).Name] = d.Get(i).Result
+		}
+		return m
+	}
+
+	t.Run("fulfillable swap", func(t *testing.T) {
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(createInvoice(), userKey)
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 10000, 1000),
+			PaymentAmountSat: 10000,
+			BlockHeight:      900,
+		}
+
+		d := DiagnoseIncomingSwap(swap, userKey, network)
+		if !d.Fulfillable {
+			t.Fatalf("expected swap to be fulfillable: %v", results(d))
+		}
+		for name, result := range results(d) {
+			if result != DiagnosticPassed {
+				t.Errorf("expected %v to pass, got %v", name, result)
+			}
+		}
+	})
+
+	t.Run("every failure is reported", func(t *testing.T) {
+		paymentHash, _, nodePublicKey := getInvoiceSecrets(createInvoice(), userKey)
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, randomBytes(32), 10000, 1000),
+			PaymentAmountSat: 5000,
+			BlockHeight:      995,
+		}
+
+		d := DiagnoseIncomingSwap(swap, userKey, network)
+		if d.Fulfillable {
+			t.Fatal("expected swap not to be fulfillable")
+		}
+		if swap.VerifyFulfillable(userKey, network) == nil {
+			t.Fatal("expected VerifyFulfillable to agree with the diagnostics")
+		}
+
+		r := results(d)
+		if r["sphinx hmac"] != DiagnosticPassed {
+			t.Errorf("expected hmac to pass, got %v", r["sphinx hmac"])
+		}
+		for _, name := range []string{"sphinx amount", "cltv expiry", "payment secret"} {
+			if r[name] != DiagnosticFailed {
+				t.Errorf("expected %v to fail, got %v", name, r[name])
+			}
+		}
+	})
+
+	t.Run("multi part payment", func(t *testing.T) {
+		invoice := createInvoiceWithOptions(&InvoiceOptions{AmountSat: 10000})
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createMppSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 10000, 1000),
+			PaymentAmountSat: 5000,
+		}
+
+		// the part pays less than the invoice, but the whole payment doesn't
+		r := results(DiagnoseIncomingSwap(swap, userKey, network))
+		if r["invoice amount"] != DiagnosticPassed {
+			t.Errorf("expected amount check to pass, got %v", r["invoice amount"])
+		}
+		if r["single part"] != DiagnosticFailed {
+			t.Errorf("expected incomplete payment to fail, got %v", r["single part"])
+		}
+	})
+
+	t.Run("unknown invoice", func(t *testing.T) {
+		swap := &IncomingSwap{PaymentHash: randomBytes(32)}
+
+		r := results(DiagnoseIncomingSwap(swap, userKey, network))
+		if r["invoice found"] != DiagnosticFailed {
+			t.Errorf("expected invoice check to fail, got %v", r["invoice found"])
+		}
+		if r["sphinx hmac"] != DiagnosticSkipped {
+			t.Errorf("expected hmac check to be skipped, got %v", r["sphinx hmac"])
+		}
+	})
+
+	t.Run("tampered sphinx", func(t *testing.T) {
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(createInvoice(), userKey)
+		onion := createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 10000, 1000)
+		onion[len(onion)-1] ^= 0xff
+
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     onion,
+			PaymentAmountSat: 10000,
+		}
+
+		r := results(DiagnoseIncomingSwap(swap, userKey, network))
+		if r["sphinx hmac"] != DiagnosticFailed {
+			t.Errorf("expected hmac check to fail, got %v", r["sphinx hmac"])
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/muun/libwallet/hdpath"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// IncomingSwapRefundListener is notified when the swap server spends the htlc
+// of an incoming swap through the expiration path.
+type IncomingSwapRefundListener interface {
+	OnIncomingSwapRefunded(paymentHash []byte)
+}
+
+// IncomingSwapRefundWatcher detects swap server refunds of expired incoming
+// swap htlcs, so receives that will never be fulfilled stop showing as
+// pending. There is no chain backend in libwallet, so the app must feed the
+// watcher the transactions spending the watched htlcs as it sees them.
+type IncomingSwapRefundWatcher struct {
+	userKey  *HDPublicKey
+	muunKey  *HDPublicKey
+	net      *Network
+	listener IncomingSwapRefundListener
+
+	mu      sync.Mutex
+	watched map[wire.OutPoint]*watchedHtlc
+}
+
+type watchedHtlc struct {
+	paymentHash      []byte
+	script           []byte
+	expirationHeight int64
+}
+
+// NewIncomingSwapRefundWatcher returns a watcher for htlcs paying to the
+// given keys, which must be at the recovery key path.
+func NewIncomingSwapRefundWatcher(userKey, muunKey *HDPublicKey, net *Network, listener IncomingSwapRefundListener) *IncomingSwapRefundWatcher {
+	return &IncomingSwapRefundWatcher{
+		userKey:  userKey,
+		muunKey:  muunKey,
+		net:      net,
+		listener: listener,
+		watched:  make(map[wire.OutPoint]*watchedHtlc),
+	}
+}
+
+// Watch starts watching the htlc output of the swap.
+func (w *IncomingSwapRefundWatcher) Watch(swap *IncomingSwap) error {
+	if swap.Htlc == nil {
+		return fmt.Errorf("Watch: missing swap htlc data")
+	}
+	if len(swap.PaymentHash) != 32 {
+		return fmt.Errorf("Watch: received invalid hash len %v", len(swap.PaymentHash))
+	}
+
+	invoice, err := swap.getInvoice()
+	if err != nil {
+		return fmt.Errorf("Watch: could not find invoice data for payment hash: %w", err)
+	}
+
+	htlcKeyPath := hdpath.MustParse(invoice.KeyPath).Child(htlcKeyChildIndex)
+	userHtlcKey, err := w.userKey.DeriveTo(htlcKeyPath.String())
+	if err != nil {
+		return fmt.Errorf("Watch: failed to derive user htlc key: %w", err)
+	}
+	muunHtlcKey, err := w.muunKey.DeriveTo(htlcKeyPath.String())
+	if err != nil {
+		return fmt.Errorf("Watch: failed to derive muun htlc key: %w", err)
+	}
+
+	coin := &coinIncomingSwap{
+		Network:             w.net.network,
+		PaymentHash256:      swap.PaymentHash,
+		SwapServerPublicKey: swap.Htlc.SwapServerPublicKey,
+		ExpirationHeight:    swap.Htlc.ExpirationHeight,
+	}
+	script, err := coin.createHtlcScript(userHtlcKey, muunHtlcKey)
+	if err != nil {
+		return fmt.Errorf("Watch: could not create htlc script: %w", err)
+	}
+
+	htlcTx := wire.MsgTx{}
+	if err := htlcTx.Deserialize(bytes.NewReader(swap.Htlc.HtlcTx)); err != nil {
+		return fmt.Errorf("Watch: could not deserialize htlc tx: %w", err)
+	}
+	index, err := coin.findHtlcOutputIndex(&htlcTx, script)
+	if err != nil {
+		return fmt.Errorf("Watch: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	outpoint := wire.OutPoint{Hash: htlcTx.TxHash(), Index: uint32(index)}
+	w.watched[outpoint] = &watchedHtlc{
+		paymentHash:      swap.PaymentHash,
+		script:           script,
+		expirationHeight: swap.Htlc.ExpirationHeight,
+	}
+	return nil
+}
+
+// WatchedCount returns the number of htlcs still being watched.
+func (w *IncomingSwapRefundWatcher) WatchedCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.watched)
+}
+
+// PruneExpired stops watching the htlcs that expired long before the given
+// chain height without being spent, and returns how many. Their invoices are
+// marked as abandoned, see CleanupAbandonedSwaps.
+func (w *IncomingSwapRefundWatcher) PruneExpired(currentHeight int64) (_ int64, err error) {
+	defer recordErrors("PruneExpired", &err)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var expired []wire.OutPoint
+	for outpoint, htlc := range w.watched {
+		if htlc.expirationHeight+abandonedHtlcBlocks < currentHeight {
+			expired = append(expired, outpoint)
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var pruned int64
+	for _, outpoint := range expired {
+		if _, err := markSwapAbandoned(db, w.watched[outpoint].paymentHash); err != nil {
+			return pruned, fmt.Errorf("PruneExpired: %w", err)
+		}
+		delete(w.watched, outpoint)
+		pruned++
+	}
+	return pruned, nil
+}
+
+// ProcessTransaction checks whether the transaction spends a watched htlc,
+// and returns true if any was refunded to the swap server. Refunded invoices
+// are marked as such in the db and reported to the listener. Htlcs spent in
+// any other way, ie fulfilled, are just no longer watched.
+func (w *IncomingSwapRefundWatcher) ProcessTransaction(rawTx []byte) (_ bool, err error) {
+	defer recordErrors("ProcessTransaction", &err)
+
+	tx := wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return false, fmt.Errorf("ProcessTransaction: could not deserialize tx: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var refunded [][]byte
+	for _, in := range tx.TxIn {
+		htlc, ok := w.watched[in.PreviousOutPoint]
+		if !ok {
+			continue
+		}
+		if isHtlcRefund(in.Witness, htlc) {
+			refunded = append(refunded, htlc.paymentHash)
+		}
+		delete(w.watched, in.PreviousOutPoint)
+	}
+
+	if len(refunded) == 0 {
+		return false, nil
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	for _, paymentHash := range refunded {
+		invoice, err := db.FindByPaymentHash(paymentHash)
+		if err != nil {
+			return false, fmt.Errorf("ProcessTransaction: could not find invoice data for payment hash: %w", err)
+		}
+		if err := saveInvoiceState(db, invoice, walletdb.InvoiceStateRefunded); err != nil {
+			return false, fmt.Errorf("ProcessTransaction: could not save invoice: %w", err)
+		}
+		// the other parts of the payment, if any, can't complete it anymore
+		if _, err := db.DeleteMppParts(paymentHash); err != nil {
+			return false, fmt.Errorf("ProcessTransaction: %w", err)
+		}
+	}
+
+	if w.listener != nil {
+		for _, paymentHash := range refunded {
+			w.listener.OnIncomingSwapRefunded(paymentHash)
+		}
+	}
+
+	return true, nil
+}
+
+// isHtlcRefund returns whether the witness spends the htlc through the swap
+// server path. The script first checks the muun signature, which is empty in
+// that path, then expects the swap server signature and public key. The user
+// path instead reveals the preimage.
+func isHtlcRefund(witness wire.TxWitness, htlc *watchedHtlc) bool {
+	if len(witness) != 4 || !bytes.Equal(witness[3], htlc.script) {
+		return false
+	}
+	if len(witness[2]) != 0 {
+		return false
+	}
+	preimageHash := sha256.Sum256(witness[0])
+	return !bytes.Equal(preimageHash[:], htlc.paymentHash)
+}
@@ -0,0 +1,296 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/muun/libwallet/hdpath"
+	"github.com/muun/libwallet/walletdb"
+)
+
+type recordingRefundListener struct {
+	refunded [][]byte
+}
+
+func (l *recordingRefundListener) OnIncomingSwapRefunded(paymentHash []byte) {
+	l.refunded = append(l.refunded, paymentHash)
+}
+
+func TestIncomingSwapRefundWatcher(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	swapServerPublicKey := randomBytes(33)
+	lockTime := int64(1000)
+
+	newSwap := func(invoice *InvoiceSecrets) (*IncomingSwap, []byte) {
+		htlcKeyPath := hdpath.MustParse(invoice.keyPath).Child(htlcKeyChildIndex)
+		userHtlcKey, err := userKey.DeriveTo(htlcKeyPath.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		muunHtlcKey, err := muunKey.DeriveTo(htlcKeyPath.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		htlcScript, err := createHtlcScript(
+			userHtlcKey.PublicKey().Raw(),
+			muunHtlcKey.PublicKey().Raw(),
+			swapServerPublicKey,
+			lockTime,
+			invoice.PaymentHash,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		witnessHash := sha256.Sum256(htlcScript)
+		address, err := btcutil.NewAddressWitnessScriptHash(witnessHash[:], network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkScript, err := txscript.PayToAddrScript(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prevOutHash, err := chainhash.NewHash(randomBytes(32))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		htlcTx := wire.NewMsgTx(1)
+		htlcTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Hash: *prevOutHash},
+		})
+		htlcTx.AddTxOut(&wire.TxOut{
+			PkScript: pkScript,
+			Value:    10000,
+		})
+
+		swap := &IncomingSwap{
+			PaymentHash: invoice.PaymentHash,
+			Htlc: &IncomingSwapHtlc{
+				HtlcTx:              serializeTx(htlcTx),
+				ExpirationHeight:    lockTime,
+				SwapServerPublicKey: swapServerPublicKey,
+			},
+		}
+		return swap, htlcScript
+	}
+
+	spend := func(swap *IncomingSwap, witness wire.TxWitness) []byte {
+		htlcTx := wire.MsgTx{}
+		if err := htlcTx.Deserialize(bytes.NewReader(swap.Htlc.HtlcTx)); err != nil {
+			t.Fatal(err)
+		}
+		tx := wire.NewMsgTx(2)
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Hash: htlcTx.TxHash(), Index: 0},
+			Witness:          witness,
+		})
+		tx.AddTxOut(&wire.TxOut{PkScript: []byte{txscript.OP_TRUE}, Value: 9000})
+		return serializeTx(tx)
+	}
+
+	listener := &recordingRefundListener{}
+	watcher := NewIncomingSwapRefundWatcher(userKey.PublicKey(), muunKey.PublicKey(), network, listener)
+
+	refundedSwap, refundedScript := newSwap(secrets.Get(0))
+	fulfilledSwap, fulfilledScript := newSwap(secrets.Get(1))
+
+	if err := watcher.Watch(refundedSwap); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Watch(fulfilledSwap); err != nil {
+		t.Fatal(err)
+	}
+	if watcher.WatchedCount() != 2 {
+		t.Fatalf("expected 2 watched htlcs, got %v", watcher.WatchedCount())
+	}
+
+	// Unrelated transactions are ignored
+	unrelated, _ := newSwap(secrets.Get(2))
+	refunded, err := watcher.ProcessTransaction(spend(unrelated, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded || watcher.WatchedCount() != 2 {
+		t.Fatal("expected unrelated tx to be ignored")
+	}
+
+	// A fulfillment stops the watch without reporting a refund
+	fulfillment := spend(fulfilledSwap, wire.TxWitness{
+		secrets.Get(1).preimage,
+		randomBytes(71),
+		randomBytes(71),
+		fulfilledScript,
+	})
+	refunded, err = watcher.ProcessTransaction(fulfillment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded || watcher.WatchedCount() != 1 || len(listener.refunded) != 0 {
+		t.Fatal("expected fulfillment not to be reported as refund")
+	}
+
+	refund := spend(refundedSwap, wire.TxWitness{
+		randomBytes(71),
+		swapServerPublicKey,
+		nil,
+		refundedScript,
+	})
+	refunded, err = watcher.ProcessTransaction(refund)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refunded || watcher.WatchedCount() != 0 {
+		t.Fatal("expected refund to be detected")
+	}
+	if len(listener.refunded) != 1 || !bytes.Equal(listener.refunded[0], refundedSwap.PaymentHash) {
+		t.Fatalf("expected listener to be notified of the refund, got %v", listener.refunded)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := db.FindByPaymentHash(refundedSwap.PaymentHash)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invoice.State != walletdb.InvoiceStateRefunded {
+		t.Fatalf("expected invoice to be refunded, got %v", invoice.State)
+	}
+
+	// Refunded swaps can't be fulfilled nor watched again
+	if err := watcher.Watch(refundedSwap); err == nil {
+		t.Fatal("expected error watching a refunded swap")
+	}
+}
+
+func TestIncomingSwapRefundWatcherPruneExpired(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+	invoice := secrets.Get(0)
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbInvoice, err := db.FindByPaymentHash(invoice.PaymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveInvoiceState(db, dbInvoice, walletdb.InvoiceStateUsed); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	htlcKeyPath := hdpath.MustParse(invoice.keyPath).Child(htlcKeyChildIndex)
+	userHtlcKey, _ := userKey.DeriveTo(htlcKeyPath.String())
+	muunHtlcKey, _ := muunKey.DeriveTo(htlcKeyPath.String())
+	swapServerPublicKey := randomBytes(33)
+	lockTime := int64(1000)
+
+	htlcScript, err := createHtlcScript(
+		userHtlcKey.PublicKey().Raw(),
+		muunHtlcKey.PublicKey().Raw(),
+		swapServerPublicKey,
+		lockTime,
+		invoice.PaymentHash,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessHash := sha256.Sum256(htlcScript)
+	address, _ := btcutil.NewAddressWitnessScriptHash(witnessHash[:], network.network)
+	pkScript, _ := txscript.PayToAddrScript(address)
+	htlcTx := wire.NewMsgTx(1)
+	htlcTx.AddTxIn(&wire.TxIn{})
+	htlcTx.AddTxOut(&wire.TxOut{PkScript: pkScript, Value: 10000})
+
+	swap := &IncomingSwap{
+		PaymentHash: invoice.PaymentHash,
+		Htlc: &IncomingSwapHtlc{
+			HtlcTx:              serializeTx(htlcTx),
+			ExpirationHeight:    lockTime,
+			SwapServerPublicKey: swapServerPublicKey,
+		},
+	}
+
+	watcher := NewIncomingSwapRefundWatcher(userKey.PublicKey(), muunKey.PublicKey(), network, nil)
+	if err := watcher.Watch(swap); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := watcher.PruneExpired(lockTime + abandonedHtlcBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 0 || watcher.WatchedCount() != 1 {
+		t.Fatal("expected recently expired htlc to still be watched")
+	}
+
+	pruned, err = watcher.PruneExpired(lockTime + abandonedHtlcBlocks + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 || watcher.WatchedCount() != 0 {
+		t.Fatalf("expected long expired htlc to be pruned, got %v", pruned)
+	}
+
+	db, err = openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbInvoice, err = db.FindByPaymentHash(invoice.PaymentHash)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbInvoice.State != walletdb.InvoiceStateAbandoned {
+		t.Fatalf("expected invoice to be abandoned, got %v", dbInvoice.State)
+	}
+
+	// Abandoned swaps can't be fulfilled nor watched again
+	if err := watcher.Watch(swap); err == nil {
+		t.Fatal("expected error watching an abandoned swap")
+	}
+}
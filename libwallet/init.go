@@ -1,5 +1,12 @@
 package libwallet
 
+import (
+	"fmt"
+
+	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/walletdb"
+)
+
 // Listener is an interface implemented by the apps to receive notifications
 // of data changes from the libwallet code. Each change is reported with a
 // string tag identifying the type of change.
@@ -7,15 +14,210 @@ type Listener interface {
 	OnDataChanged(tag string)
 }
 
+// DefaultMinCltvSafetyDelta is the minimum number of blocks between the chain
+// tip and the expiry of an incoming payment used when none is configured.
+const DefaultMinCltvSafetyDelta = 18
+
 // Config defines the global libwallet configuration.
 type Config struct {
 	DataDir  string
 	Listener Listener
+
+	// MinCltvSafetyDelta is the minimum number of blocks between the chain
+	// tip and the expiry of an incoming payment for it to be fulfillable.
+	// If zero, DefaultMinCltvSafetyDelta is used. It must be below
+	// DefaultCltvExpiryBlocks, or payments to invoices created with the
+	// default cltv expiry could never be fulfilled.
+	MinCltvSafetyDelta int64
+
+	// DeviceSecret, if set, is used to authenticate the invoice secrets
+	// stored in the wallet db, so tampering or corruption is detected before
+	// they are used. It must be kept outside of DataDir, eg in the platform
+	// keystore, and never change once set.
+	DeviceSecret []byte
+
+	// DeferMigrations disables running the wallet db migrations when the db
+	// is opened, which can take long after big upgrades and exceed the time
+	// apps are given in the background. When set, apps must call
+	// RunMigrations themselves, and operations using the db fail with
+	// ErrMigrationsPending until they do.
+	DeferMigrations bool
+
+	// InvoiceOrder selects which registered invoice secrets are used first
+	// when creating invoices: "fifo" (the default), "lifo" or "random". Init
+	// fails with any other.
+	InvoiceOrder string
+
+	// FeeAttestationKey is the serialized public key the server signs fee
+	// rate attestations with. Attestations are rejected if it's not set.
+	FeeAttestationKey []byte
+
+	// FeeEstimator, if set, provides the local fee rate estimates attested
+	// fee rates are cross checked against.
+	FeeEstimator FeeEstimator
+
+	// FeeRateTolerance is the factor by which an attested fee rate may be
+	// above or below the local estimate. If not greater than 1,
+	// DefaultFeeRateTolerance is used.
+	FeeRateTolerance float64
+
+	// RebroadcastIntervalSeconds is how often Rebroadcast re-announces each
+	// unconfirmed tx. If zero, DefaultRebroadcastInterval is used.
+	RebroadcastIntervalSeconds int64
+
+	// FeeBumpAfterSeconds is how long a tx can stay unconfirmed before
+	// Rebroadcast suggests bumping its fee. If zero, DefaultFeeBumpAfter is
+	// used.
+	FeeBumpAfterSeconds int64
+
+	// EntropySource, if set, contributes entropy that is mixed with the one
+	// from crypto/rand when generating invoice preimages and payment secrets.
+	EntropySource EntropySource
+
+	// RouteHintNodes is a comma separated list of the pubkeys of the nodes
+	// route hints may point to. Invoices with hints for other nodes are
+	// refused. If empty, and no list was received with
+	// UpdateRouteHintNodes, any node is accepted.
+	RouteHintNodes string
+
+	// RouteHintNodesKey is the serialized public key the server signs
+	// updates of the route hint nodes with. Updates are rejected if it's not
+	// set.
+	RouteHintNodesKey []byte
+
+	// UnusedSecretsPoolSize is the number of unused invoice secrets
+	// GenerateInvoiceSecrets keeps registered. If zero, MaxUnusedSecrets is
+	// used. It's capped at MaxUnusedSecretsPoolSize.
+	UnusedSecretsPoolSize int64
+
+	// MaxClockSkewSeconds is the difference between the device clock and the
+	// time reported with ReportServerTime or ReportChainTipTime tolerated
+	// before invoice timestamps are corrected. If zero,
+	// DefaultMaxClockSkewSeconds is used.
+	MaxClockSkewSeconds int64
+
+	// Clock, if set, provides the device time used for invoice timestamps
+	// and expiries instead of the system clock. It's still corrected with
+	// the times reported with ReportServerTime and ReportChainTipTime.
+	Clock Clock
+
+	// InvoiceListener, if set, is notified whenever an invoice changes state.
+	InvoiceListener InvoiceEventListener
+
+	// SlowQueryThresholdMillis, if set, makes wallet db queries taking longer
+	// than it be recorded in the error journal, to diagnose devices with slow
+	// storage. See GetRecentErrors.
+	SlowQueryThresholdMillis int64
+
+	// InvoiceFeatures, if set, selects the feature bits advertised by the
+	// invoices created. If nil, only the default ones are.
+	InvoiceFeatures *FeatureConfig
+
+	// OverpaymentToleranceSat and OverpaymentTolerancePercent bound how much
+	// a payment can exceed the invoice amount before it's refused with
+	// ErrOverpayment, so the user can be asked about it. The larger of the
+	// two bounds applies, the percentage being of the invoice amount. If
+	// both are zero, any overpayment is accepted. See AcceptOverpayment.
+	OverpaymentToleranceSat     int64
+	OverpaymentTolerancePercent float64
+
+	// StableNodeIdentity makes new invoice secrets share one identity key,
+	// derived once and kept in the wallet db, so payers see the same
+	// destination node across invoices. Otherwise each invoice has its own,
+	// which keeps payments to different invoices from being linked.
+	StableNodeIdentity bool
+}
+
+// MigrationListener is implemented by the apps to follow the progress of
+// RunMigrations.
+type MigrationListener interface {
+	OnMigrationProgress(applied, total int64)
 }
 
 var cfg *Config
 
-// Init configures the libwallet
-func Init(c *Config) {
+// Init configures the libwallet. It fails if the configuration is invalid,
+// leaving the previous one in place.
+func Init(c *Config) error {
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("Init: %w", err)
+	}
 	cfg = c
+	resetClockOffsets()
+	return nil
+}
+
+// validate checks the settings that would otherwise make later calls fail.
+func (c *Config) validate() error {
+	if c.MinCltvSafetyDelta < 0 || c.MinCltvSafetyDelta >= DefaultCltvExpiryBlocks {
+		return fmt.Errorf(
+			"invalid MinCltvSafetyDelta: %v, must be at least 0 and below the default invoice cltv expiry %v",
+			c.MinCltvSafetyDelta, DefaultCltvExpiryBlocks,
+		)
+	}
+	switch walletdb.InvoiceOrder(c.InvoiceOrder) {
+	case "", walletdb.InvoiceOrderFIFO, walletdb.InvoiceOrderLIFO, walletdb.InvoiceOrderRandom:
+	default:
+		return fmt.Errorf("invalid InvoiceOrder: %q, must be fifo, lifo or random", c.InvoiceOrder)
+	}
+	return nil
+}
+
+func minCltvSafetyDelta() int64 {
+	if cfg.MinCltvSafetyDelta != 0 {
+		return cfg.MinCltvSafetyDelta
+	}
+	return DefaultMinCltvSafetyDelta
+}
+
+// RunMigrations applies any pending wallet db migrations, reporting progress
+// to the listener if not nil. It's a no-op if the db is up to date.
+func RunMigrations(listener MigrationListener) (err error) {
+	defer recordErrors("RunMigrations", &err)
+
+	path := dbPath()
+	db, err := attachDBAt(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = runMigrations(db, path, func(applied, total int) {
+		if listener != nil {
+			listener.OnMigrationProgress(int64(applied), int64(total))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("RunMigrations: %w", err)
+	}
+	return nil
+}
+
+func unusedSecretsPoolSize() int {
+	switch {
+	case cfg.UnusedSecretsPoolSize <= 0:
+		return MaxUnusedSecrets
+	case cfg.UnusedSecretsPoolSize > MaxUnusedSecretsPoolSize:
+		return MaxUnusedSecretsPoolSize
+	default:
+		return int(cfg.UnusedSecretsPoolSize)
+	}
+}
+
+func invoiceOrder() walletdb.InvoiceOrder {
+	if cfg.InvoiceOrder != "" {
+		return walletdb.InvoiceOrder(cfg.InvoiceOrder)
+	}
+	return walletdb.InvoiceOrderFIFO
+}
+
+func checkNoPendingMigrations(db *walletdb.DB) error {
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if pending {
+		return errors.New(ErrMigrationsPending, "wallet db has pending migrations, call RunMigrations first")
+	}
+	return nil
 }
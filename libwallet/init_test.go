@@ -1,6 +1,9 @@
 package libwallet
 
-import "io/ioutil"
+import (
+	"io/ioutil"
+	"testing"
+)
 
 func setup() {
 	dir, err := ioutil.TempDir("", "libwallet")
@@ -8,7 +11,95 @@ func setup() {
 		panic(err)
 	}
 
-	Init(&Config{
+	err = Init(&Config{
 		DataDir: dir,
 	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+type recordingMigrationListener struct {
+	progress [][2]int64
+}
+
+func (l *recordingMigrationListener) OnMigrationProgress(applied, total int64) {
+	l.progress = append(l.progress, [2]int64{applied, total})
+}
+
+func TestDeferMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+	err = Init(&Config{
+		DataDir:         dir,
+		DeferMigrations: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	_, err = GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if ErrorCode(err) != ErrMigrationsPending {
+		t.Fatalf("expected ErrMigrationsPending, got %v", err)
+	}
+
+	if report := HealthCheck(); report.Status != HealthStatusError {
+		t.Fatalf("expected health check to report pending migrations, got %v", report.Status)
+	}
+
+	listener := &recordingMigrationListener{}
+	if err := RunMigrations(listener); err != nil {
+		t.Fatal(err)
+	}
+	if len(listener.progress) == 0 {
+		t.Fatal("expected progress to be reported")
+	}
+	last := listener.progress[len(listener.progress)-1]
+	if last[0] != last[1] {
+		t.Fatalf("expected all migrations to be applied, got %v of %v", last[0], last[1])
+	}
+
+	if _, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	// running them again is a no-op
+	if err := RunMigrations(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitRejectsInvalidConfig(t *testing.T) {
+	setup()
+	dataDir := cfg.DataDir
+
+	invalid := []*Config{
+		{DataDir: "other", MinCltvSafetyDelta: -1},
+		{DataDir: "other", MinCltvSafetyDelta: DefaultCltvExpiryBlocks},
+		{DataDir: "other", InvoiceOrder: "newest"},
+	}
+	for _, c := range invalid {
+		if err := Init(c); err == nil {
+			t.Fatalf("expected error with config %+v", c)
+		}
+		if cfg.DataDir != dataDir {
+			t.Fatal("expected the previous config to be kept")
+		}
+	}
+
+	if err := Init(&Config{DataDir: dataDir, MinCltvSafetyDelta: DefaultCltvExpiryBlocks - 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(&Config{DataDir: dataDir, InvoiceOrder: "lifo"}); err != nil {
+		t.Fatal(err)
+	}
 }
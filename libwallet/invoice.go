@@ -17,7 +17,9 @@ type Invoice struct {
 	PaymentHash     []byte
 	Expiry          int64
 	Description     string
+	DescriptionHash []byte // nil for invoices with a description
 	Sats            int64
+	Amount          *Amount // nil for invoices without amount
 }
 
 const lightningScheme = "lightning:"
@@ -61,12 +63,22 @@ func ParseInvoice(rawInput string, network *Network) (*Invoice, error) {
 		description = *parsedInvoice.Description
 	}
 
+	var descriptionHash []byte
+	if parsedInvoice.DescriptionHash != nil {
+		descriptionHash = parsedInvoice.DescriptionHash[:]
+	}
+
 	var milliSats string
 	var sats int64
+	var amount *Amount
 	if parsedInvoice.MilliSat != nil {
 		milliSat := uint64(*parsedInvoice.MilliSat)
 		milliSats = fmt.Sprintf("%v", milliSat)
 		sats = int64(milliSat / 1000)
+		if milliSat > MaxMoneySat*msatsPerSat {
+			return nil, errors.Errorf(ErrInvalidAmount, "invoice amount exceeds the bitcoin supply: %v msats", milliSat)
+		}
+		amount = NewAmountFromMsats(int64(milliSat))
 	}
 
 	return &Invoice{
@@ -78,6 +90,8 @@ func ParseInvoice(rawInput string, network *Network) (*Invoice, error) {
 		PaymentHash:     parsedInvoice.PaymentHash[:],
 		Expiry:          parsedInvoice.Timestamp.Unix() + int64(parsedInvoice.Expiry().Seconds()),
 		Description:     description,
+		DescriptionHash: descriptionHash,
 		Sats:            sats,
+		Amount:          amount,
 	}, nil
 }
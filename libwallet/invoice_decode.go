@@ -0,0 +1,221 @@
+package libwallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/muun/libwallet/errors"
+)
+
+// DecodedInvoice holds every field of a BOLT11 invoice, as decoded by
+// DecodeInvoice.
+type DecodedInvoice struct {
+	Payee              string // hex serialized node pubkey
+	PaymentHash        []byte
+	PaymentSecret      []byte // nil if the invoice has none
+	AmountMsat         int64  // 0 for invoices without amount
+	Description        string
+	DescriptionHash    []byte // nil for invoices with a description
+	Timestamp          int64  // unix seconds
+	ExpiresAt          int64  // unix seconds
+	MinFinalCltvExpiry int64
+	FallbackAddress    string // empty if the invoice has none
+	RouteHints         *InvoiceRouteList
+	Features           *InvoiceFeatureList
+}
+
+// InvoiceHopHint is a hop of a private route to the payee.
+type InvoiceHopHint struct {
+	NodeId                    string // hex serialized node pubkey
+	ShortChannelId            int64
+	FeeBaseMsat               int64
+	FeeProportionalMillionths int64
+	CltvExpiryDelta           int64
+}
+
+// InvoiceRoute is a route hint of an invoice, the hops to follow in order
+// to reach the payee.
+type InvoiceRoute struct {
+	hops []*InvoiceHopHint
+}
+
+// Length returns the number of hops in the route.
+func (r *InvoiceRoute) Length() int {
+	return len(r.hops)
+}
+
+// Get returns the hop at the given index.
+func (r *InvoiceRoute) Get(i int) *InvoiceHopHint {
+	return r.hops[i]
+}
+
+// InvoiceRouteList is a wrapper around an InvoiceRoute slice to be able to
+// pass through the gomobile bridge.
+type InvoiceRouteList struct {
+	routes []*InvoiceRoute
+}
+
+// Length returns the number of routes in the list.
+func (l *InvoiceRouteList) Length() int {
+	return len(l.routes)
+}
+
+// Get returns the route at the given index.
+func (l *InvoiceRouteList) Get(i int) *InvoiceRoute {
+	return l.routes[i]
+}
+
+// InvoiceFeature is a feature bit set in an invoice.
+type InvoiceFeature struct {
+	Bit      int64
+	Name     string // "unknown" for features we don't know
+	Required bool   // even bits must be understood by the payer
+}
+
+// InvoiceFeatureList is a wrapper around an InvoiceFeature slice to be able
+// to pass through the gomobile bridge.
+type InvoiceFeatureList struct {
+	features []*InvoiceFeature
+}
+
+// Length returns the number of features in the list.
+func (l *InvoiceFeatureList) Length() int {
+	return len(l.features)
+}
+
+// Get returns the feature at the given index.
+func (l *InvoiceFeatureList) Get(i int) *InvoiceFeature {
+	return l.features[i]
+}
+
+// DecodeInvoice decodes a bech32 encoded BOLT11 invoice for the given
+// network. Unlike ParseInvoice, it doesn't accept lightning URIs, but returns
+// the route hints and features of the invoice too. Features are sorted by
+// bit.
+func DecodeInvoice(net *Network, bech32 string) (_ *DecodedInvoice, err error) {
+	defer recordErrors("DecodeInvoice", &err)
+
+	parsed, err := zpay32.Decode(bech32, net.network)
+	if err != nil {
+		return nil, errors.Errorf(ErrInvalidInvoice, "DecodeInvoice: couldn't decode invoice: %w", err)
+	}
+
+	invoice := &DecodedInvoice{
+		Payee:              hex.EncodeToString(parsed.Destination.SerializeCompressed()),
+		PaymentHash:        parsed.PaymentHash[:],
+		Timestamp:          parsed.Timestamp.Unix(),
+		ExpiresAt:          parsed.Timestamp.Unix() + int64(parsed.Expiry().Seconds()),
+		MinFinalCltvExpiry: int64(parsed.MinFinalCLTVExpiry()),
+		RouteHints:         &InvoiceRouteList{},
+		Features:           &InvoiceFeatureList{},
+	}
+
+	if parsed.PaymentAddr != nil {
+		invoice.PaymentSecret = parsed.PaymentAddr[:]
+	}
+	if parsed.MilliSat != nil {
+		milliSat := uint64(*parsed.MilliSat)
+		if milliSat > MaxMoneySat*msatsPerSat {
+			return nil, errors.Errorf(ErrInvalidAmount, "DecodeInvoice: invoice amount exceeds the bitcoin supply: %v msats", milliSat)
+		}
+		invoice.AmountMsat = int64(milliSat)
+	}
+	if parsed.Description != nil {
+		invoice.Description = *parsed.Description
+	}
+	if parsed.DescriptionHash != nil {
+		invoice.DescriptionHash = parsed.DescriptionHash[:]
+	}
+	if parsed.FallbackAddr != nil {
+		invoice.FallbackAddress = parsed.FallbackAddr.String()
+	}
+
+	for _, hint := range parsed.RouteHints {
+		route := &InvoiceRoute{}
+		for _, hop := range hint {
+			route.hops = append(route.hops, &InvoiceHopHint{
+				NodeId:                    hex.EncodeToString(hop.NodeID.SerializeCompressed()),
+				ShortChannelId:            int64(hop.ChannelID),
+				FeeBaseMsat:               int64(hop.FeeBaseMSat),
+				FeeProportionalMillionths: int64(hop.FeeProportionalMillionths),
+				CltvExpiryDelta:           int64(hop.CLTVExpiryDelta),
+			})
+		}
+		invoice.RouteHints.routes = append(invoice.RouteHints.routes, route)
+	}
+
+	if parsed.Features != nil {
+		for bit := range parsed.Features.Features() {
+			invoice.Features.features = append(invoice.Features.features, &InvoiceFeature{
+				Bit:      int64(bit),
+				Name:     invoiceFeatureName(parsed.Features, bit),
+				Required: bit.IsRequired(),
+			})
+		}
+		sort.Slice(invoice.Features.features, func(i, j int) bool {
+			return invoice.Features.features[i].Bit < invoice.Features.features[j].Bit
+		})
+	}
+
+	return invoice, nil
+}
+
+// VerifyInvoiceSignature checks the invoice was signed by the identity key
+// the wallet derives for its payment hash, so apps can check invoices before
+// displaying them and support can tell apart invoices the wallet created from
+// tampered ones. The invoice must have been created by this wallet, and the
+// user key must be the one it was created with. Signatures that don't match
+// fail with the ErrInvalidInvoice code.
+func VerifyInvoiceSignature(net *Network, userKey *HDPublicKey, bech32 string) (err error) {
+	defer recordErrors("VerifyInvoiceSignature", &err)
+
+	if err := checkNetwork(net, userKey.Network.Name(), "user key"); err != nil {
+		return err
+	}
+
+	// the signing node key is recovered from the signature when decoding
+	parsed, err := zpay32.Decode(bech32, net.network)
+	if err != nil {
+		return errors.Errorf(ErrInvalidInvoice, "VerifyInvoiceSignature: couldn't decode invoice: %w", err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(parsed.PaymentHash[:])
+	if err != nil {
+		return fmt.Errorf("VerifyInvoiceSignature: could not find invoice data for payment hash: %w", err)
+	}
+
+	identityKeyPath := invoiceIdentityKeyPath(invoice)
+	identityKey, err := userKey.DeriveTo(identityKeyPath.String())
+	if err != nil {
+		return fmt.Errorf("VerifyInvoiceSignature: failed to derive identity key: %w", err)
+	}
+
+	if !bytes.Equal(parsed.Destination.SerializeCompressed(), identityKey.Raw()) {
+		countSecurityEvent(SecurityEventSignatureVerification)
+		return errors.Errorf(
+			ErrInvalidInvoice,
+			"VerifyInvoiceSignature: invoice signed by %x, expected identity key %x",
+			parsed.Destination.SerializeCompressed(), identityKey.Raw(),
+		)
+	}
+	return nil
+}
+
+// invoiceFeatureName returns the name of the feature bit, including the ones
+// the lnd version we use doesn't know.
+func invoiceFeatureName(features *lnwire.FeatureVector, bit lnwire.FeatureBit) string {
+	if bit == ampRequired || bit == ampRequired+1 {
+		return "amp"
+	}
+	return features.Name(bit)
+}
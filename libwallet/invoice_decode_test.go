@@ -0,0 +1,193 @@
+package libwallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+func TestDecodeInvoice(t *testing.T) {
+	const (
+		invoiceWithAmount       = "lnbcrt10u1pwtpd4jpp5lh0p9amq02xel0gduna95ta5ve9q5dwyk8tglvpa258yzzvcgynsdqqcqzysrukfteknjzcqpu8kfnm76dhdtnkmyr3j42xrl89axhqxmpgusyqhn28u2uaave3nr8sk3mg5nug6t8hcnj2aw8t2l5wtksh6w0yyntgqjrrgqk"
+		invoiceWithFallbackAdrr = "lnbcrt1pwtpduxpp57xglq4thtrerzzxt8wzg4wresfclewh8pk8xghahwq8kgek3qslqdqqcqzysfppqhv0a0uhrt2crdehgfge8e8e6texw3q4hpmge888yuu6076utcrhgc97wu7vydmudyagkz25ahuyp4fqrc9e945ff248cpa3krn7vvgcqq6spyuqltd245sjvwh23gz220cegadspkn3lx0"
+	)
+
+	t.Run("amount", func(t *testing.T) {
+		invoice, err := DecodeInvoice(network, invoiceWithAmount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if invoice.AmountMsat != 1000000 {
+			t.Errorf("expected 1000000 msats, got %v", invoice.AmountMsat)
+		}
+		if hex.EncodeToString(invoice.PaymentHash) != "fdde12f7607a8d9fbd0de4fa5a2fb4664a0a35c4b1d68fb03d550e4109984127" {
+			t.Errorf("unexpected payment hash %x", invoice.PaymentHash)
+		}
+		if invoice.Payee != "028cfad4e092191a41f081bedfbe5a6e8f441603c78bf9001b8fb62ac0858f20ed" {
+			t.Errorf("unexpected payee %v", invoice.Payee)
+		}
+		if invoice.ExpiresAt <= invoice.Timestamp {
+			t.Errorf("expected expiry after timestamp, got %v <= %v", invoice.ExpiresAt, invoice.Timestamp)
+		}
+		if invoice.RouteHints.Length() != 0 || invoice.PaymentSecret != nil {
+			t.Errorf("expected no route hints nor payment secret")
+		}
+	})
+
+	t.Run("fallback address", func(t *testing.T) {
+		invoice, err := DecodeInvoice(network, invoiceWithFallbackAdrr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if invoice.FallbackAddress != "bcrt1qhv0a0uhrt2crdehgfge8e8e6texw3q4has8jh7" {
+			t.Errorf("unexpected fallback address %v", invoice.FallbackAddress)
+		}
+		if invoice.AmountMsat != 0 {
+			t.Errorf("expected no amount, got %v", invoice.AmountMsat)
+		}
+	})
+
+	t.Run("route hints and features", func(t *testing.T) {
+		setup()
+
+		userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+		userKey.Path = "m/schema:1'/recovery:1'"
+		muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+		muunKey.Path = "m/schema:1'/recovery:1'"
+
+		secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PersistInvoiceSecrets(secrets); err != nil {
+			t.Fatal(err)
+		}
+
+		const hintPubkey = "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd"
+		raw, err := CreateInvoice(network, userKey, &RouteHints{
+			Pubkey:                    hintPubkey,
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: 100,
+			CltvExpiryDelta:           8,
+		}, &InvoiceOptions{AmountSat: 1234, Description: "coffee", Amp: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		invoice, err := DecodeInvoice(network, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(raw, userKey)
+		if !bytes.Equal(invoice.PaymentHash, paymentHash) || !bytes.Equal(invoice.PaymentSecret, paymentSecret) {
+			t.Errorf("payment hash or secret don't match")
+		}
+		if invoice.Payee != hex.EncodeToString(nodePublicKey.SerializeCompressed()) {
+			t.Errorf("unexpected payee %v", invoice.Payee)
+		}
+		if invoice.AmountMsat != 1234000 || invoice.Description != "coffee" {
+			t.Errorf("unexpected amount %v or description %q", invoice.AmountMsat, invoice.Description)
+		}
+
+		if invoice.RouteHints.Length() != 1 || invoice.RouteHints.Get(0).Length() != 1 {
+			t.Fatalf("expected a single route with a single hop")
+		}
+		hop := invoice.RouteHints.Get(0).Get(0)
+		if hop.NodeId != hintPubkey || hop.FeeBaseMsat != 1000 || hop.FeeProportionalMillionths != 100 ||
+			hop.CltvExpiryDelta != 8 || !IsShortChannelIdAlias(hop.ShortChannelId) {
+			t.Errorf("unexpected hop hint %+v", hop)
+		}
+
+		names := make(map[string]bool)
+		for i := 0; i < invoice.Features.Length(); i++ {
+			feature := invoice.Features.Get(i)
+			if i > 0 && invoice.Features.Get(i-1).Bit >= feature.Bit {
+				t.Errorf("expected features sorted by bit")
+			}
+			names[feature.Name] = true
+		}
+		for _, name := range []string{"tlv-onion", "payment-addr", "amp"} {
+			if !names[name] {
+				t.Errorf("expected feature %v, got %v", name, names)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := DecodeInvoice(network, "lightning:"+invoiceWithAmount)
+		if ErrorCode(err) != ErrInvalidInvoice {
+			t.Errorf("expected invalid invoice error, got %v", err)
+		}
+		_, err = DecodeInvoice(Mainnet(), invoiceWithAmount)
+		if ErrorCode(err) != ErrInvalidInvoice {
+			t.Errorf("expected invalid invoice error for another network, got %v", err)
+		}
+	})
+}
+
+func TestVerifyInvoiceSignature(t *testing.T) {
+	setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyInvoiceSignature(network, userKey.PublicKey(), invoice); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same invoice signed by someone else
+	parsed, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed.Destination = nil
+	otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+	resigned, err := parsed.Encode(zpay32.MessageSigner{
+		SignCompact: func(digest []byte) ([]byte, error) {
+			return btcec.SignCompact(btcec.S256(), otherKey, digest, true)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyInvoiceSignature(network, userKey.PublicKey(), resigned)
+	if ErrorCode(err) != ErrInvalidInvoice {
+		t.Fatalf("expected invoice signed by other key to be invalid, got %v", err)
+	}
+
+	otherUserKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	otherUserKey.Path = "m/schema:1'/recovery:1'"
+	if err := VerifyInvoiceSignature(network, otherUserKey.PublicKey(), invoice); err == nil {
+		t.Fatal("expected error verifying with other user key")
+	}
+
+	// invoices created elsewhere can't be checked
+	const foreignInvoice = "lnbcrt10u1pwtpd4jpp5lh0p9amq02xel0gduna95ta5ve9q5dwyk8tglvpa258yzzvcgynsdqqcqzysrukfteknjzcqpu8kfnm76dhdtnkmyr3j42xrl89axhqxmpgusyqhn28u2uaave3nr8sk3mg5nug6t8hcnj2aw8t2l5wtksh6w0yyntgqjrrgqk"
+	if err := VerifyInvoiceSignature(network, userKey.PublicKey(), foreignInvoice); err == nil {
+		t.Fatal("expected error verifying an invoice of another wallet")
+	}
+}
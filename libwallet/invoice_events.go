@@ -0,0 +1,63 @@
+package libwallet
+
+import (
+	"github.com/muun/libwallet/walletdb"
+)
+
+// Invoice events reported to the InvoiceEventListener.
+const (
+	InvoiceEventCreated    = "created"  // secrets persisted, not handed out yet
+	InvoiceEventPendingUse = "pending"  // issued, waiting for ConfirmInvoiceUse
+	InvoiceEventReleased   = "released" // back in the unused secrets pool, see ReleaseInvoice
+	InvoiceEventUsed       = "used"
+	InvoiceEventAccepted   = "accepted"
+	InvoiceEventSettled    = "settled"
+	InvoiceEventExpired    = "expired"
+	InvoiceEventCancelled  = "cancelled"
+	InvoiceEventRefunded   = "refunded"
+	InvoiceEventAbandoned  = "abandoned"  // see CleanupAbandonedSwaps
+	InvoiceEventSuperseded = "superseded" // see RotateInvoiceSecrets
+)
+
+// InvoiceEventListener is implemented by the apps to be notified when an
+// invoice changes state, so they can update their UI without polling the
+// wallet db. It's called synchronously after the change is stored, so it
+// must not block.
+type InvoiceEventListener interface {
+	OnInvoiceEvent(paymentHash []byte, event string)
+}
+
+// saveInvoiceState moves the invoice to the given state, saving it along
+// with any other change made to it, and notifies the listener if its state
+// changed.
+func saveInvoiceState(db *walletdb.DB, invoice *walletdb.Invoice, state walletdb.InvoiceState) error {
+	previous := invoice.State
+	if err := invoice.TransitionTo(state, walletNow()); err != nil {
+		return err
+	}
+	if err := db.SaveInvoice(invoice); err != nil {
+		return err
+	}
+	switch {
+	case previous == walletdb.InvoiceStatePendingUse && state == walletdb.InvoiceStateRegistered:
+		notifyListener(invoice.PaymentHash, InvoiceEventReleased)
+	case previous != state:
+		notifyInvoiceEvent(invoice.PaymentHash, state)
+	}
+	return nil
+}
+
+func notifyInvoiceEvent(paymentHash []byte, state walletdb.InvoiceState) {
+	event := string(state)
+	if state == walletdb.InvoiceStateRegistered {
+		event = InvoiceEventCreated
+	}
+	notifyListener(paymentHash, event)
+}
+
+func notifyListener(paymentHash []byte, event string) {
+	if cfg == nil || cfg.InvoiceListener == nil || isSimulating() {
+		return
+	}
+	cfg.InvoiceListener.OnInvoiceEvent(paymentHash, event)
+}
@@ -0,0 +1,75 @@
+package libwallet
+
+import (
+	"bytes"
+	"testing"
+)
+
+type recordingInvoiceListener struct {
+	hashes [][]byte
+	events []string
+}
+
+func (l *recordingInvoiceListener) OnInvoiceEvent(paymentHash []byte, event string) {
+	l.hashes = append(l.hashes, paymentHash)
+	l.events = append(l.events, event)
+}
+
+func TestInvoiceEvents(t *testing.T) {
+	setup()
+	defer setup()
+
+	listener := &recordingInvoiceListener{}
+	cfg.InvoiceListener = listener
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+	if len(listener.events) != secrets.Length() {
+		t.Fatalf("expected an event per secret, got %v", listener.events)
+	}
+	for _, event := range listener.events {
+		if event != InvoiceEventCreated {
+			t.Fatalf("expected created events, got %v", listener.events)
+		}
+	}
+
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, _, _ := getInvoiceSecrets(invoice, userKey)
+
+	if err := CancelInvoice(paymentHash); err != nil {
+		t.Fatal(err)
+	}
+	// cancelling twice doesn't change the state
+	if err := CancelInvoice(paymentHash); err != nil {
+		t.Fatal(err)
+	}
+
+	events := listener.events[secrets.Length():]
+	hashes := listener.hashes[secrets.Length():]
+	if len(events) != 2 || events[0] != InvoiceEventUsed || events[1] != InvoiceEventCancelled {
+		t.Fatalf("expected used and cancelled events, got %v", events)
+	}
+	for _, hash := range hashes {
+		if !bytes.Equal(hash, paymentHash) {
+			t.Fatalf("expected events for %x, got %x", paymentHash, hash)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package libwallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+	"golang.org/x/crypto/scrypt"
+)
+
+// InvoiceExportVersion is the version of the document produced by
+// ExportSettledInvoices.
+const InvoiceExportVersion = 1
+
+const (
+	exportScryptN      = 1 << 15
+	exportScryptR      = 8
+	exportScryptP      = 1
+	exportSaltLength   = 16
+	exportKeyLength    = 32
+	exportEnvelopeTag  = "v1"
+	exportEnvelopeSize = 7
+)
+
+// InvoiceExport is the document produced by ExportSettledInvoices, once
+// decrypted. Its JSON encoding is:
+//
+//	{
+//	  "version": 1,
+//	  "exportedAt": 1600000000,
+//	  "invoices": [
+//	    {
+//	      "paymentHash": "<hex>",
+//	      "preimage": "<hex>",
+//	      "amountSat": 1000,              // 0 for invoices without amount
+//	      "timestamp": 1600000000,        // when the invoice was issued, or paid if imported
+//	      "paidAt": 1600000000,           // when the invoice was paid, 0 if unknown
+//	      "imported": false,              // true if imported from another node
+//	      "fiatValue": 0.12,              // value when paid, only if backfilled
+//	      "fiatCurrency": "USD",          // see BackfillFiatValues
+//	      "displayCurrency": "ARS",       // only if given when creating the invoice
+//	      "locale": "es-AR",              // only if given when creating the invoice
+//	      "creationFiatAmount": 150.5,    // value when created, only if given
+//	      "creationFiatCurrency": "ARS",  // see InvoiceOptions.FiatAmount
+//	      "rateTimestamp": 1600000000     // of the exchange rate, only if given
+//	    }
+//	  ]
+//	}
+//
+// The preimage of each invoice is the proof it was paid.
+type InvoiceExport struct {
+	Version    int                    `json:"version"`
+	ExportedAt int64                  `json:"exportedAt"`
+	Invoices   []*InvoiceExportRecord `json:"invoices"`
+}
+
+// InvoiceExportRecord is a paid invoice in an InvoiceExport.
+type InvoiceExportRecord struct {
+	PaymentHash string `json:"paymentHash"`
+	Preimage    string `json:"preimage"`
+	AmountSat   int64  `json:"amountSat"`
+	Timestamp   int64  `json:"timestamp"`
+	PaidAt      int64  `json:"paidAt"`
+	Imported    bool   `json:"imported"`
+
+	FiatValue    float64 `json:"fiatValue,omitempty"`
+	FiatCurrency string  `json:"fiatCurrency,omitempty"`
+
+	DisplayCurrency string `json:"displayCurrency,omitempty"`
+	Locale          string `json:"locale,omitempty"`
+
+	CreationFiatAmount   float64 `json:"creationFiatAmount,omitempty"`
+	CreationFiatCurrency string  `json:"creationFiatCurrency,omitempty"`
+	RateTimestamp        int64   `json:"rateTimestamp,omitempty"`
+}
+
+// ExportSettledInvoices returns the settled and imported invoices with their
+// preimages, so users keep proof of their payment history after leaving the
+// wallet. The InvoiceExport document is encrypted with the passphrase and
+// returned as "v1:N:r:p:salt:nonce:ciphertext", where the key is derived with
+// scrypt (N, r, p, 32 bytes) and the document sealed with AES-256-GCM. Numbers
+// are decimal, the rest is hex. Use DecryptInvoiceExport to get it back.
+func ExportSettledInvoices(passphrase string) (_ string, err error) {
+	defer recordErrors("ExportSettledInvoices", &err)
+
+	if passphrase == "" {
+		return "", fmt.Errorf("ExportSettledInvoices: passphrase can't be empty")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	invoices, err := db.ListInvoices()
+	if err != nil {
+		return "", fmt.Errorf("ExportSettledInvoices: %w", err)
+	}
+
+	export := &InvoiceExport{
+		Version:    InvoiceExportVersion,
+		ExportedAt: walletNow().Unix(),
+		Invoices:   make([]*InvoiceExportRecord, 0),
+	}
+	for _, invoice := range invoices {
+		if invoice.State != walletdb.InvoiceStateSettled && invoice.State != walletdb.InvoiceStateImported {
+			continue
+		}
+		var timestamp int64
+		if invoice.UsedAt != nil {
+			timestamp = invoice.UsedAt.Unix()
+		}
+		var paidAt int64
+		if paid := invoicePaidAt(&invoice); paid != nil {
+			paidAt = paid.Unix()
+		}
+		var rateTimestamp int64
+		if invoice.RateTimestamp != nil {
+			rateTimestamp = invoice.RateTimestamp.Unix()
+		}
+		export.Invoices = append(export.Invoices, &InvoiceExportRecord{
+			PaymentHash: hex.EncodeToString(invoice.PaymentHash),
+			Preimage:    hex.EncodeToString(invoice.Preimage),
+			AmountSat:   invoice.AmountSat,
+			Timestamp:   timestamp,
+			PaidAt:      paidAt,
+			Imported:    invoice.State == walletdb.InvoiceStateImported,
+
+			FiatValue:    invoice.FiatValue,
+			FiatCurrency: invoice.FiatCurrency,
+
+			DisplayCurrency: invoice.DisplayCurrency,
+			Locale:          invoice.Locale,
+
+			CreationFiatAmount:   invoice.CreationFiatAmount,
+			CreationFiatCurrency: invoice.CreationFiatCurrency,
+			RateTimestamp:        rateTimestamp,
+		})
+	}
+
+	plaintext, err := json.Marshal(export)
+	if err != nil {
+		return "", fmt.Errorf("ExportSettledInvoices: %w", err)
+	}
+
+	salt := randomBytes(exportSaltLength)
+	gcm, err := exportCipher(passphrase, salt, exportScryptN, exportScryptR, exportScryptP)
+	if err != nil {
+		return "", fmt.Errorf("ExportSettledInvoices: %w", err)
+	}
+	nonce := randomBytes(gcm.NonceSize())
+	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+
+	elements := []string{
+		exportEnvelopeTag,
+		strconv.Itoa(exportScryptN),
+		strconv.Itoa(exportScryptR),
+		strconv.Itoa(exportScryptP),
+		hex.EncodeToString(salt),
+		hex.EncodeToString(nonce),
+		hex.EncodeToString(ciphertext),
+	}
+	return strings.Join(elements, ":"), nil
+}
+
+// DecryptInvoiceExport decrypts the result of ExportSettledInvoices and
+// returns the InvoiceExport JSON document.
+func DecryptInvoiceExport(export, passphrase string) (_ []byte, err error) {
+	defer recordErrors("DecryptInvoiceExport", &err)
+
+	elements := strings.Split(export, ":")
+	if len(elements) != exportEnvelopeSize || elements[0] != exportEnvelopeTag {
+		return nil, fmt.Errorf("DecryptInvoiceExport: invalid format")
+	}
+
+	var params [3]int
+	for i := range params {
+		value, err := strconv.Atoi(elements[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("DecryptInvoiceExport: invalid scrypt params: %w", err)
+		}
+		params[i] = value
+	}
+	// Only the params exports are made with are accepted, since scrypt needs
+	// 128*N*r bytes and an export with larger ones could exhaust the memory
+	// of the device. A change of params requires a new envelope tag.
+	n, r, p := params[0], params[1], params[2]
+	if n != exportScryptN || r != exportScryptR || p != exportScryptP {
+		return nil, fmt.Errorf("DecryptInvoiceExport: unsupported scrypt params N=%v r=%v p=%v", n, r, p)
+	}
+
+	var decoded [3][]byte
+	for i := range decoded {
+		value, err := hex.DecodeString(elements[i+4])
+		if err != nil {
+			return nil, fmt.Errorf("DecryptInvoiceExport: invalid hex: %w", err)
+		}
+		decoded[i] = value
+	}
+	salt, nonce, ciphertext := decoded[0], decoded[1], decoded[2]
+
+	gcm, err := exportCipher(passphrase, salt, n, r, p)
+	if err != nil {
+		return nil, fmt.Errorf("DecryptInvoiceExport: %w", err)
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("DecryptInvoiceExport: invalid nonce")
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("DecryptInvoiceExport: wrong passphrase or corrupted export")
+	}
+	return plaintext, nil
+}
+
+// invoicePaidAt returns when the invoice was paid, or nil if unknown.
+// Imported invoices have no settled time, and their used time is the one
+// they were paid at on the node they come from.
+func invoicePaidAt(invoice *walletdb.Invoice) *time.Time {
+	if invoice.SettledAt != nil {
+		return invoice.SettledAt
+	}
+	if invoice.State == walletdb.InvoiceStateImported {
+		return invoice.UsedAt
+	}
+	return nil
+}
+
+func exportCipher(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, exportKeyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
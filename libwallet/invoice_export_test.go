@@ -0,0 +1,113 @@
+package libwallet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestExportSettledInvoices(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	// settle one of our invoices
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settled, err := db.FindFirstUnusedInvoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settled.State = walletdb.InvoiceStateSettled
+	settled.AmountSat = 500
+	settledAt := time.Unix(1700000000, 0)
+	settled.SettledAt = &settledAt
+	if err := db.SaveInvoice(settled); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// and import another one
+	preimage := randomBytes(32)
+	hash := sha256.Sum256(preimage)
+	dump := fmt.Sprintf(`{"invoices": [{"r_preimage": %q, "r_hash": %q, "value": "1000", "settled": true, "settle_date": "1600000000"}]}`,
+		hex.EncodeToString(preimage), hex.EncodeToString(hash[:]))
+	if _, err := ImportNodeInvoices(InvoiceImportFormatLnd, []byte(dump)); err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := ExportSettledInvoices("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecryptInvoiceExport(export, "wrong horse"); err == nil {
+		t.Fatal("expected decryption to fail with the wrong passphrase")
+	}
+
+	data, err := DecryptInvoiceExport(export, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc InvoiceExport
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != InvoiceExportVersion {
+		t.Fatalf("unexpected version %v", doc.Version)
+	}
+	if len(doc.Invoices) != 2 {
+		t.Fatalf("expected 2 invoices, got %v", len(doc.Invoices))
+	}
+
+	own, imported := doc.Invoices[0], doc.Invoices[1]
+	if own.Imported || own.AmountSat != 500 || own.Preimage != hex.EncodeToString(settled.Preimage) {
+		t.Fatalf("unexpected settled invoice %+v", own)
+	}
+	if own.PaidAt != 1700000000 {
+		t.Fatalf("expected settled invoice paid at its settled time, got %v", own.PaidAt)
+	}
+	if !imported.Imported || imported.AmountSat != 1000 || imported.Timestamp != 1600000000 || imported.PaidAt != 1600000000 {
+		t.Fatalf("unexpected imported invoice %+v", imported)
+	}
+	if imported.PaymentHash != hex.EncodeToString(hash[:]) {
+		t.Fatal("unexpected imported payment hash")
+	}
+
+	if _, err := ExportSettledInvoices(""); err == nil {
+		t.Fatal("expected error for empty passphrase")
+	}
+	if _, err := DecryptInvoiceExport("v2:1:2:3", "correct horse"); err == nil {
+		t.Fatal("expected error for invalid export")
+	}
+	if !strings.HasPrefix(export, "v1:32768:8:1:") {
+		t.Fatalf("unexpected export params in %v", export)
+	}
+	for _, params := range []string{":1048576:32:16:", ":1073741824:8:1:", ":32768:1024:1:", ":32768:8:64:"} {
+		tampered := strings.Replace(export, ":32768:8:1:", params, 1)
+		if _, err := DecryptInvoiceExport(tampered, "correct horse"); err == nil {
+			t.Fatalf("expected error for export with scrypt params %v", params)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package libwallet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// MaxInvoiceFeatureBit is the highest feature bit invoices can advertise.
+// Every bit makes the encoded invoice longer, and no feature payers
+// understand is that high.
+const MaxInvoiceFeatureBit = 255
+
+// defaultInvoiceFeatures are advertised by every invoice, since payments
+// without a tlv onion and a payment secret can't be verified.
+var defaultInvoiceFeatures = []lnwire.FeatureBit{
+	lnwire.TLVOnionPayloadOptional,
+	lnwire.PaymentAddrOptional,
+}
+
+// FeatureConfig selects the feature bits advertised by the invoices the
+// wallet creates, so the server can have clients advertise newer features,
+// eg basic mpp, without a libwallet release.
+type FeatureConfig struct {
+	// Bits is a comma separated list of the feature bits advertised on top of
+	// var_onion_optin and payment_secret, which every invoice has, eg "17".
+	// Even bits are required features and odd bits optional ones. Giving the
+	// other bit of a default feature replaces it, eg "14" makes the payment
+	// secret required. The amp bits can't be given, see InvoiceOptions.Amp.
+	Bits string
+}
+
+// Validate returns an error if the bits can't be advertised.
+func (c *FeatureConfig) Validate() error {
+	_, err := c.featureVector()
+	return err
+}
+
+// featureVector returns the features advertised by invoices with this
+// config. A nil config advertises the default ones.
+func (c *FeatureConfig) featureVector() (*lnwire.RawFeatureVector, error) {
+	configured := make(map[lnwire.FeatureBit]bool)
+	if c != nil && strings.TrimSpace(c.Bits) != "" {
+		for _, field := range strings.Split(c.Bits, ",") {
+			value, err := strconv.ParseUint(strings.TrimSpace(field), 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid feature bit %q", field)
+			}
+			bit := lnwire.FeatureBit(value)
+			switch {
+			case bit > MaxInvoiceFeatureBit:
+				return nil, fmt.Errorf("feature bit %v is above %v", bit, MaxInvoiceFeatureBit)
+			case bit == ampRequired || bit == ampRequired+1:
+				return nil, fmt.Errorf("amp feature bit %v is set with InvoiceOptions.Amp", bit)
+			case configured[bit^1]:
+				return nil, fmt.Errorf("feature bits %v and %v can't be both set", bit&^1, bit|1)
+			}
+			configured[bit] = true
+		}
+	}
+
+	features := lnwire.NewRawFeatureVector()
+	for _, bit := range defaultInvoiceFeatures {
+		if !configured[bit^1] {
+			features.Set(bit)
+		}
+	}
+	for bit := range configured {
+		features.Set(bit)
+	}
+	return features, nil
+}
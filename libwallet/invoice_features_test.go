@@ -0,0 +1,97 @@
+package libwallet
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+func TestFeatureConfigValidate(t *testing.T) {
+	valid := []string{"", "17", " 17, 49 ", "14", "255"}
+	for _, bits := range valid {
+		if err := (&FeatureConfig{Bits: bits}).Validate(); err != nil {
+			t.Errorf("expected %q to be valid, got %v", bits, err)
+		}
+	}
+
+	invalid := []string{"mpp", "17,", "-1", "256", "16,17", "30", "31"}
+	for _, bits := range invalid {
+		if err := (&FeatureConfig{Bits: bits}).Validate(); err == nil {
+			t.Errorf("expected %q to be invalid", bits)
+		}
+	}
+}
+
+func TestInvoiceFeatures(t *testing.T) {
+	setup()
+	defer setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	createInvoice := func(opts *InvoiceOptions) (*lnwire.FeatureVector, error) {
+		invoice, err := CreateInvoice(network, userKey, &RouteHints{
+			Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: 1000,
+			CltvExpiryDelta:           8,
+		}, opts)
+		if err != nil {
+			return nil, err
+		}
+		payreq, err := zpay32.Decode(invoice, network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payreq.Features, nil
+	}
+
+	expectBits := func(features *lnwire.FeatureVector, expected ...lnwire.FeatureBit) {
+		t.Helper()
+		if len(features.Features()) != len(expected) {
+			t.Fatalf("expected features %v, got %v", expected, features.Features())
+		}
+		for _, bit := range expected {
+			if !features.IsSet(bit) {
+				t.Fatalf("expected feature %v to be set, got %v", bit, features.Features())
+			}
+		}
+	}
+
+	features, err := createInvoice(&InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBits(features, lnwire.TLVOnionPayloadOptional, lnwire.PaymentAddrOptional)
+
+	cfg.InvoiceFeatures = &FeatureConfig{Bits: "17"}
+	features, err = createInvoice(&InvoiceOptions{Amp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBits(features, lnwire.TLVOnionPayloadOptional, lnwire.PaymentAddrOptional, lnwire.MPPOptional, ampRequired)
+
+	// the other bit of a default feature replaces it
+	cfg.InvoiceFeatures = &FeatureConfig{Bits: "14"}
+	features, err = createInvoice(&InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBits(features, lnwire.TLVOnionPayloadOptional, lnwire.PaymentAddrRequired)
+
+	cfg.InvoiceFeatures = &FeatureConfig{Bits: "16,17"}
+	if _, err := createInvoice(&InvoiceOptions{}); err == nil {
+		t.Fatal("expected invalid features to fail")
+	}
+}
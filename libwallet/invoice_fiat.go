@@ -0,0 +1,60 @@
+package libwallet
+
+import (
+	"fmt"
+)
+
+// InvoiceFiatAmount is the value of the amount of an invoice when it was
+// created, as given in InvoiceOptions.
+type InvoiceFiatAmount struct {
+	Amount        float64
+	Currency      string // ISO 4217 code, empty if not given
+	RateTimestamp int64  // unix seconds, 0 if not given
+}
+
+// GetInvoiceFiatAmount returns the value of the invoice with the given payment
+// hash when it was created, so the receive history can show it instead of
+// its value at today's rate. See BackfillFiatValues for its value when paid.
+func GetInvoiceFiatAmount(paymentHash []byte) (_ *InvoiceFiatAmount, err error) {
+	defer recordErrors("GetInvoiceFiatAmount", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return nil, fmt.Errorf("GetInvoiceFiatAmount: could not find invoice for payment hash: %w", err)
+	}
+	fiat := &InvoiceFiatAmount{
+		Amount:   invoice.CreationFiatAmount,
+		Currency: invoice.CreationFiatCurrency,
+	}
+	if invoice.RateTimestamp != nil {
+		fiat.RateTimestamp = invoice.RateTimestamp.Unix()
+	}
+	return fiat, nil
+}
+
+// validateFiatAmount checks the fiat amount of the options has a well formed
+// currency, if given.
+func (o *InvoiceOptions) validateFiatAmount() error {
+	if o.FiatAmount < 0 {
+		return fmt.Errorf("invalid fiat amount: %v", o.FiatAmount)
+	}
+	if o.RateTimestamp < 0 {
+		return fmt.Errorf("invalid rate timestamp: %v", o.RateTimestamp)
+	}
+	if o.FiatCurrency == "" {
+		if o.FiatAmount != 0 || o.RateTimestamp != 0 {
+			return fmt.Errorf("fiat amount must have a currency")
+		}
+		return nil
+	}
+	if !currencyCodePattern.MatchString(o.FiatCurrency) {
+		return fmt.Errorf("invalid fiat currency %q", o.FiatCurrency)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package libwallet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInvoiceFiatAmount(t *testing.T) {
+	setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+	rateTimestamp := time.Now().Add(-time.Minute).Unix()
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		AmountSat:     1000,
+		FiatAmount:    150.5,
+		FiatCurrency:  "ARS",
+		RateTimestamp: rateTimestamp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, _, _ := getInvoiceSecrets(invoice, userKey)
+
+	fiat, err := GetInvoiceFiatAmount(paymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fiat.Amount != 150.5 || fiat.Currency != "ARS" || fiat.RateTimestamp != rateTimestamp {
+		t.Fatalf("unexpected fiat amount %+v", fiat)
+	}
+
+	// backfilling the value when paid keeps the one at creation
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbInvoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbInvoice.FiatValue = 0.12
+	dbInvoice.FiatCurrency = "USD"
+	if err := db.SaveInvoice(dbInvoice); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if fiat, _ := GetInvoiceFiatAmount(paymentHash); fiat.Amount != 150.5 || fiat.Currency != "ARS" {
+		t.Fatalf("expected fiat amount at creation to be kept, got %+v", fiat)
+	}
+
+	for _, opts := range []*InvoiceOptions{
+		{FiatAmount: 10},
+		{FiatAmount: -1, FiatCurrency: "USD"},
+		{FiatAmount: 10, FiatCurrency: "usd"},
+		{FiatAmount: 10, FiatCurrency: "USD", RateTimestamp: -1},
+	} {
+		if _, err := CreateInvoice(network, userKey, routeHints, opts); err == nil {
+			t.Errorf("expected invalid fiat amount %+v to fail", opts)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// Formats accepted by ImportNodeInvoices.
+const (
+	// InvoiceImportFormatLnd is the output of `lncli listinvoices`.
+	InvoiceImportFormatLnd = "lnd"
+	// InvoiceImportFormatCln is the output of `lightning-cli listinvoices`.
+	InvoiceImportFormatCln = "cln"
+)
+
+// InvoiceImportResult summarizes an invoice import.
+type InvoiceImportResult struct {
+	Imported int64
+	Skipped  int64 // unpaid, missing a valid preimage or already in the db
+}
+
+// importedInvoice is the subset of an invoice we can keep from another node.
+type importedInvoice struct {
+	preimage    []byte
+	paymentHash []byte
+	amountSat   int64
+	settledAt   time.Time
+}
+
+// ImportNodeInvoices stores the paid invoices exported from a self-hosted lnd
+// or CLN node as payment history. Invoices are signed by the node key, which
+// this wallet doesn't have, so imported invoices can't receive payments; only
+// their preimage, payment hash, amount and settlement time are kept. Reading
+// lnd's channel.db directly is not supported, export the invoices with lncli
+// instead.
+func ImportNodeInvoices(format string, data []byte) (_ *InvoiceImportResult, err error) {
+	defer recordErrors("ImportNodeInvoices", &err)
+
+	var invoices []*importedInvoice
+	switch format {
+	case InvoiceImportFormatLnd:
+		invoices, err = parseLndInvoices(data)
+	case InvoiceImportFormatCln:
+		invoices, err = parseClnInvoices(data)
+	default:
+		return nil, fmt.Errorf("ImportNodeInvoices: unknown format %v", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ImportNodeInvoices: %w", err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	result := &InvoiceImportResult{}
+	for _, invoice := range invoices {
+		if invoice == nil {
+			result.Skipped++
+			continue
+		}
+
+		settledAt := invoice.settledAt
+		err := db.CreateInvoice(&walletdb.Invoice{
+			Preimage:    invoice.preimage,
+			PaymentHash: invoice.paymentHash,
+			AmountSat:   invoice.amountSat,
+			State:       walletdb.InvoiceStateImported,
+			UsedAt:      &settledAt,
+		})
+		if err == walletdb.ErrDuplicatePaymentHash {
+			result.Skipped++
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ImportNodeInvoices: %w", err)
+		}
+		result.Imported++
+	}
+
+	return result, nil
+}
+
+// newImportedInvoice validates the raw fields of a paid invoice, returning
+// nil if it can't be imported.
+func newImportedInvoice(preimageHex, paymentHashHex string, amountSat, settledAt int64) *importedInvoice {
+	preimage, err := hex.DecodeString(preimageHex)
+	if err != nil || len(preimage) != 32 {
+		return nil
+	}
+	paymentHash := sha256.Sum256(preimage)
+	if paymentHashHex != "" && paymentHashHex != hex.EncodeToString(paymentHash[:]) {
+		return nil
+	}
+	return &importedInvoice{
+		preimage:    preimage,
+		paymentHash: paymentHash[:],
+		amountSat:   amountSat,
+		settledAt:   time.Unix(settledAt, 0),
+	}
+}
+
+// jsonInt accepts integers encoded either as JSON numbers or strings, as lnd
+// encodes 64 bit values as strings. A "msat" suffix, used by CLN, is ignored.
+type jsonInt int64
+
+func (i *jsonInt) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	s := strings.TrimSuffix(strings.Trim(string(data), `"`), "msat")
+	if s == "" {
+		return nil
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = jsonInt(value)
+	return nil
+}
+
+func parseLndInvoices(data []byte) ([]*importedInvoice, error) {
+	var dump struct {
+		Invoices []struct {
+			RPreimage  string  `json:"r_preimage"`
+			RHash      string  `json:"r_hash"`
+			Value      jsonInt `json:"value"`
+			AmtPaidSat jsonInt `json:"amt_paid_sat"`
+			Settled    bool    `json:"settled"`
+			State      string  `json:"state"`
+			SettleDate jsonInt `json:"settle_date"`
+		} `json:"invoices"`
+	}
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("malformed lnd invoices: %w", err)
+	}
+
+	var invoices []*importedInvoice
+	for _, inv := range dump.Invoices {
+		if !inv.Settled && inv.State != "SETTLED" {
+			invoices = append(invoices, nil)
+			continue
+		}
+		amount := int64(inv.AmtPaidSat)
+		if amount == 0 {
+			amount = int64(inv.Value)
+		}
+		invoices = append(invoices, newImportedInvoice(inv.RPreimage, inv.RHash, amount, int64(inv.SettleDate)))
+	}
+	return invoices, nil
+}
+
+func parseClnInvoices(data []byte) ([]*importedInvoice, error) {
+	var dump struct {
+		Invoices []struct {
+			PaymentPreimage    string  `json:"payment_preimage"`
+			PaymentHash        string  `json:"payment_hash"`
+			Status             string  `json:"status"`
+			AmountReceivedMsat jsonInt `json:"amount_received_msat"`
+			MsatoshiReceived   jsonInt `json:"msatoshi_received"`
+			PaidAt             jsonInt `json:"paid_at"`
+		} `json:"invoices"`
+	}
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("malformed cln invoices: %w", err)
+	}
+
+	var invoices []*importedInvoice
+	for _, inv := range dump.Invoices {
+		if inv.Status != "paid" {
+			invoices = append(invoices, nil)
+			continue
+		}
+		msat := int64(inv.AmountReceivedMsat)
+		if msat == 0 {
+			msat = int64(inv.MsatoshiReceived)
+		}
+		invoices = append(invoices, newImportedInvoice(inv.PaymentPreimage, inv.PaymentHash, msat/1000, int64(inv.PaidAt)))
+	}
+	return invoices, nil
+}
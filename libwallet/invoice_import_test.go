@@ -0,0 +1,113 @@
+package libwallet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestImportNodeInvoices(t *testing.T) {
+	setup()
+
+	newPreimage := func() (string, string) {
+		preimage := randomBytes(32)
+		hash := sha256.Sum256(preimage)
+		return hex.EncodeToString(preimage), hex.EncodeToString(hash[:])
+	}
+
+	findImported := func(hash string) *walletdb.Invoice {
+		db, err := openDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		paymentHash, _ := hex.DecodeString(hash)
+		invoice, err := db.FindByPaymentHash(paymentHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return invoice
+	}
+
+	t.Run("lnd", func(t *testing.T) {
+		preimage, hash := newPreimage()
+		openPreimage, openHash := newPreimage()
+		dump := fmt.Sprintf(`{"invoices": [
+			{"r_preimage": %q, "r_hash": %q, "value": "1000", "amt_paid_sat": "1200", "settled": true, "state": "SETTLED", "settle_date": "1600000000"},
+			{"r_preimage": %q, "r_hash": %q, "value": "1000", "settled": false, "state": "OPEN"},
+			{"r_preimage": %q, "r_hash": %q, "value": "1000", "settled": true, "state": "SETTLED"}
+		]}`, preimage, hash, openPreimage, openHash, openPreimage, hash)
+
+		result, err := ImportNodeInvoices(InvoiceImportFormatLnd, []byte(dump))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Imported != 1 || result.Skipped != 2 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+
+		invoice := findImported(hash)
+		if invoice.State != walletdb.InvoiceStateImported {
+			t.Fatalf("unexpected state %v", invoice.State)
+		}
+		if invoice.AmountSat != 1200 {
+			t.Fatalf("expected amount 1200, got %v", invoice.AmountSat)
+		}
+		if invoice.UsedAt.Unix() != 1600000000 {
+			t.Fatalf("unexpected settle date %v", invoice.UsedAt)
+		}
+
+		// importing again skips known invoices
+		result, err = ImportNodeInvoices(InvoiceImportFormatLnd, []byte(dump))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Imported != 0 || result.Skipped != 3 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+
+		// imported invoices can't receive payments
+		paymentHash, _ := hex.DecodeString(hash)
+		swap := &IncomingSwap{PaymentHash: paymentHash, PaymentAmountSat: 1200}
+		if err := swap.VerifyFulfillable(nil, Regtest()); err == nil {
+			t.Fatal("expected imported invoice not to be fulfillable")
+		}
+	})
+
+	t.Run("cln", func(t *testing.T) {
+		preimage, hash := newPreimage()
+		legacyPreimage, legacyHash := newPreimage()
+		dump := fmt.Sprintf(`{"invoices": [
+			{"payment_preimage": %q, "payment_hash": %q, "status": "paid", "amount_received_msat": "2000000msat", "paid_at": 1600000000},
+			{"payment_preimage": %q, "payment_hash": %q, "status": "paid", "msatoshi_received": 3000000, "paid_at": 1600000000},
+			{"payment_hash": "00", "status": "unpaid"}
+		]}`, preimage, hash, legacyPreimage, legacyHash)
+
+		result, err := ImportNodeInvoices(InvoiceImportFormatCln, []byte(dump))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Imported != 2 || result.Skipped != 1 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+		if amount := findImported(hash).AmountSat; amount != 2000 {
+			t.Fatalf("expected amount 2000, got %v", amount)
+		}
+		if amount := findImported(legacyHash).AmountSat; amount != 3000 {
+			t.Fatalf("expected amount 3000, got %v", amount)
+		}
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		if _, err := ImportNodeInvoices("eclair", []byte(`{}`)); err == nil {
+			t.Fatal("expected error for unknown format")
+		}
+		if _, err := ImportNodeInvoices(InvoiceImportFormatLnd, []byte(`not json`)); err == nil {
+			t.Fatal("expected error for malformed dump")
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package libwallet
+
+import (
+	"fmt"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// maxInvoiceListLimit caps the page size of ListInvoices.
+const maxInvoiceListLimit = 1000
+
+// InvoiceDetails describes an invoice of the wallet for the receive history.
+type InvoiceDetails struct {
+	PaymentHash []byte
+	AmountSat   int64 // 0 for invoices without amount
+	State       string
+	KeyPath     string // empty for imported invoices
+	CreatedAt   int64  // unix seconds
+	UsedAt      int64  // unix seconds, 0 if never handed out
+}
+
+// InvoiceDetailsList is a wrapper around an InvoiceDetails slice to be able to
+// pass through the gomobile bridge.
+type InvoiceDetailsList struct {
+	invoices []*InvoiceDetails
+}
+
+// Length returns the number of invoices in the list.
+func (l *InvoiceDetailsList) Length() int {
+	return len(l.invoices)
+}
+
+// Get returns the invoice at the given index.
+func (l *InvoiceDetailsList) Get(i int) *InvoiceDetails {
+	return l.invoices[i]
+}
+
+// ListInvoices returns up to limit invoices in the given state ("registered",
+// "used", "settled", "imported", "refunded", "cancelled" or "expired"), or in
+// any state if empty, skipping the first offset ones. The most recent come
+// first.
+func ListInvoices(state string, offset, limit int64) (_ *InvoiceDetailsList, err error) {
+	defer recordErrors("ListInvoices", &err)
+
+	if offset < 0 || limit <= 0 || limit > maxInvoiceListLimit {
+		return nil, fmt.Errorf("ListInvoices: invalid page offset %v limit %v", offset, limit)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	invoices, err := db.FindInvoices(walletdb.InvoiceState(state), int(offset), int(limit))
+	if err != nil {
+		return nil, fmt.Errorf("ListInvoices: %w", err)
+	}
+
+	list := &InvoiceDetailsList{}
+	for i := range invoices {
+		list.invoices = append(list.invoices, newInvoiceDetails(&invoices[i]))
+	}
+	return list, nil
+}
+
+// GetInvoiceByPaymentHash returns the details of the invoice with the given
+// payment hash, eg to show its status when the server reports an incoming
+// htlc for it.
+func GetInvoiceByPaymentHash(paymentHash []byte) (_ *InvoiceDetails, err error) {
+	defer recordErrors("GetInvoiceByPaymentHash", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return nil, fmt.Errorf("GetInvoiceByPaymentHash: could not find invoice for payment hash: %w", err)
+	}
+	return newInvoiceDetails(invoice), nil
+}
+
+func newInvoiceDetails(invoice *walletdb.Invoice) *InvoiceDetails {
+	details := &InvoiceDetails{
+		PaymentHash: invoice.PaymentHash,
+		AmountSat:   invoice.AmountSat,
+		State:       string(invoice.State),
+		KeyPath:     invoice.KeyPath,
+		CreatedAt:   invoice.CreatedAt.Unix(),
+	}
+	if invoice.UsedAt != nil {
+		details.UsedAt = invoice.UsedAt.Unix()
+	}
+	return details
+}
@@ -0,0 +1,143 @@
+package libwallet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/zpay32"
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestListInvoices(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := db.FindFirstUnusedInvoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	usedAt := time.Date(2020, 9, 13, 9, 0, 0, 0, time.UTC)
+	used.State = walletdb.InvoiceStateUsed
+	used.AmountSat = 1000
+	used.UsedAt = &usedAt
+	if err := db.SaveInvoice(used); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	all, err := ListInvoices("", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Length() != secrets.Length() {
+		t.Fatalf("expected %v invoices, got %v", secrets.Length(), all.Length())
+	}
+	if !bytes.Equal(all.Get(all.Length()-1).PaymentHash, used.PaymentHash) {
+		t.Fatal("expected the oldest invoice last")
+	}
+
+	list, err := ListInvoices(string(walletdb.InvoiceStateUsed), 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Length() != 1 {
+		t.Fatalf("expected 1 used invoice, got %v", list.Length())
+	}
+	details := list.Get(0)
+	if !bytes.Equal(details.PaymentHash, used.PaymentHash) || details.AmountSat != 1000 ||
+		details.State != "used" || details.UsedAt != usedAt.Unix() || details.CreatedAt == 0 {
+		t.Fatalf("unexpected invoice details %+v", details)
+	}
+
+	page, err := ListInvoices("", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Length() != 2 || !bytes.Equal(page.Get(0).PaymentHash, all.Get(1).PaymentHash) {
+		t.Fatal("unexpected page of invoices")
+	}
+	if page.Get(0).UsedAt != 0 {
+		t.Fatalf("expected unused invoice to have no used at, got %v", page.Get(0).UsedAt)
+	}
+
+	if _, err := ListInvoices("", -1, 10); err == nil {
+		t.Fatal("expected error for negative offset")
+	}
+	if _, err := ListInvoices("", 0, 0); err == nil {
+		t.Fatal("expected error for empty page")
+	}
+}
+
+func TestGetInvoiceByPaymentHash(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{AmountSat: 1234})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details, err := GetInvoiceByPaymentHash(payreq.PaymentHash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(details.PaymentHash, payreq.PaymentHash[:]) || details.AmountSat != 1234 ||
+		details.State != "used" || details.CreatedAt == 0 || details.UsedAt == 0 {
+		t.Fatalf("unexpected invoice details %+v", details)
+	}
+
+	var keyPath string
+	for i := 0; i < secrets.Length(); i++ {
+		if bytes.Equal(secrets.Get(i).PaymentHash, payreq.PaymentHash[:]) {
+			keyPath = secrets.Get(i).keyPath
+		}
+	}
+	if details.KeyPath != keyPath {
+		t.Fatalf("expected key path %v, got %v", keyPath, details.KeyPath)
+	}
+
+	if _, err := GetInvoiceByPaymentHash(randomBytes(32)); err == nil {
+		t.Fatal("expected error for unknown payment hash")
+	}
+}
@@ -0,0 +1,52 @@
+package libwallet
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	localePattern       = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+)
+
+// InvoiceLocalization is how the amount of an invoice is displayed to the
+// user, as given in InvoiceOptions when creating it.
+type InvoiceLocalization struct {
+	DisplayCurrency string // ISO 4217 code, empty if not given
+	Locale          string // BCP 47 tag, empty if not given
+}
+
+// GetInvoiceLocalization returns the display currency and locale of the
+// invoice with the given payment hash, so receipts render its amount the way
+// it was shown when it was created, on any device.
+func GetInvoiceLocalization(paymentHash []byte) (_ *InvoiceLocalization, err error) {
+	defer recordErrors("GetInvoiceLocalization", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return nil, fmt.Errorf("GetInvoiceLocalization: could not find invoice for payment hash: %w", err)
+	}
+	return &InvoiceLocalization{
+		DisplayCurrency: invoice.DisplayCurrency,
+		Locale:          invoice.Locale,
+	}, nil
+}
+
+// validateLocalization checks the display currency and locale of the
+// options are well formed, if given.
+func (o *InvoiceOptions) validateLocalization() error {
+	if o.DisplayCurrency != "" && !currencyCodePattern.MatchString(o.DisplayCurrency) {
+		return fmt.Errorf("invalid display currency %q", o.DisplayCurrency)
+	}
+	if o.Locale != "" && !localePattern.MatchString(o.Locale) {
+		return fmt.Errorf("invalid locale %q", o.Locale)
+	}
+	return nil
+}
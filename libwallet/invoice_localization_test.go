@@ -0,0 +1,57 @@
+package libwallet
+
+import (
+	"testing"
+)
+
+func TestInvoiceLocalization(t *testing.T) {
+	setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		AmountSat:       1000,
+		DisplayCurrency: "ARS",
+		Locale:          "es-AR",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, _, _ := getInvoiceSecrets(invoice, userKey)
+
+	localization, err := GetInvoiceLocalization(paymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if localization.DisplayCurrency != "ARS" || localization.Locale != "es-AR" {
+		t.Fatalf("unexpected localization %+v", localization)
+	}
+
+	for _, opts := range []*InvoiceOptions{
+		{DisplayCurrency: "ars"},
+		{DisplayCurrency: "PESO"},
+		{Locale: "es_AR"},
+		{Locale: "-AR"},
+	} {
+		if _, err := CreateInvoice(network, userKey, routeHints, opts); err == nil {
+			t.Errorf("expected invalid localization %+v to fail", opts)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package libwallet
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Error correction levels of QR codes, from the one recovering the least
+// damage, and thus fitting the most data, to the one recovering the most.
+const (
+	QRErrorCorrectionL = "L"
+	QRErrorCorrectionM = "M"
+	QRErrorCorrectionQ = "Q"
+	QRErrorCorrectionH = "H"
+)
+
+// qrDataCodewords is the number of data codewords of each QR version, at each
+// error correction level (L, M, Q, H).
+var qrDataCodewords = [40][4]int{
+	{19, 16, 13, 9}, {34, 28, 22, 16}, {55, 44, 34, 26}, {80, 64, 48, 36},
+	{108, 86, 62, 46}, {136, 108, 76, 60}, {156, 124, 88, 66}, {194, 154, 110, 86},
+	{232, 182, 132, 100}, {274, 216, 154, 122}, {324, 254, 180, 140}, {370, 290, 206, 158},
+	{428, 334, 244, 180}, {461, 365, 261, 197}, {523, 415, 295, 223}, {589, 453, 325, 253},
+	{647, 507, 367, 283}, {721, 563, 397, 313}, {795, 627, 445, 341}, {861, 669, 485, 385},
+	{932, 714, 512, 406}, {1006, 782, 568, 442}, {1094, 860, 614, 464}, {1174, 914, 664, 514},
+	{1276, 1000, 718, 538}, {1370, 1062, 754, 596}, {1468, 1128, 808, 628}, {1531, 1193, 871, 661},
+	{1631, 1267, 911, 701}, {1735, 1373, 985, 745}, {1843, 1455, 1033, 793}, {1955, 1541, 1115, 845},
+	{2071, 1631, 1171, 901}, {2191, 1725, 1231, 961}, {2306, 1812, 1286, 986}, {2434, 1914, 1354, 1054},
+	{2566, 1992, 1426, 1096}, {2702, 2102, 1502, 1142}, {2812, 2216, 1582, 1222}, {2956, 2334, 1666, 1276},
+}
+
+// qrAlphanumeric are the characters QRs encode in alphanumeric mode.
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// InvoiceQR is an invoice encoded for a QR code, with the size of the QR in
+// alphanumeric mode, which the upper case payload allows, and in byte mode,
+// which the lower case invoice needs, for comparison.
+type InvoiceQR struct {
+	Payload          string // "LIGHTNING:" followed by the upper case invoice
+	Version          int64  // smallest QR version fitting the payload, 1 to 40
+	Modules          int64  // per side of the QR, without the quiet zone
+	ByteModeVersion  int64  // 0 if the invoice doesn't fit in byte mode
+	ByteModeModules  int64  // 0 if the invoice doesn't fit in byte mode
+	ErrorCorrection  string // one of the QRErrorCorrection constants
+	PayloadSizeBytes int64
+}
+
+// EncodeInvoiceForQR returns the invoice upper cased for a QR, which lets it
+// be encoded in alphanumeric mode using 5.5 bits per character instead of 8,
+// along with the size of the QR at the given error correction level. The
+// upper case payload is checked to decode to the same invoice.
+func EncodeInvoiceForQR(invoice string, network *Network, errorCorrection string) (_ *InvoiceQR, err error) {
+	defer recordErrors("EncodeInvoiceForQR", &err)
+
+	level := strings.Index("LMQH", errorCorrection)
+	if len(errorCorrection) != 1 || level < 0 {
+		return nil, fmt.Errorf("EncodeInvoiceForQR: invalid error correction level %q", errorCorrection)
+	}
+
+	parsed, err := ParseInvoice(invoice, network)
+	if err != nil {
+		return nil, err
+	}
+	payload := strings.ToUpper(lightningScheme + parsed.RawInvoice)
+
+	decoded, err := ParseInvoice(payload, network)
+	if err != nil {
+		return nil, fmt.Errorf("EncodeInvoiceForQR: upper case invoice failed to decode: %w", err)
+	}
+	if !sameDecodedInvoice(parsed, decoded) {
+		return nil, fmt.Errorf("EncodeInvoiceForQR: upper case invoice decodes differently")
+	}
+
+	version := qrVersion(payload, level, true)
+	if version == 0 {
+		return nil, fmt.Errorf("EncodeInvoiceForQR: invoice too long for a QR")
+	}
+	qr := &InvoiceQR{
+		Payload:          payload,
+		Version:          int64(version),
+		Modules:          qrModules(version),
+		ErrorCorrection:  errorCorrection,
+		PayloadSizeBytes: int64(len(payload)),
+	}
+	if byteVersion := qrVersion(strings.ToLower(payload), level, false); byteVersion != 0 {
+		qr.ByteModeVersion = int64(byteVersion)
+		qr.ByteModeModules = qrModules(byteVersion)
+	}
+	return qr, nil
+}
+
+func sameDecodedInvoice(a, b *Invoice) bool {
+	return bytes.Equal(a.PaymentHash, b.PaymentHash) &&
+		bytes.Equal(a.Destination, b.Destination) &&
+		bytes.Equal(a.DescriptionHash, b.DescriptionHash) &&
+		a.Description == b.Description &&
+		a.MilliSat == b.MilliSat &&
+		a.Expiry == b.Expiry
+}
+
+// qrVersion returns the smallest QR version fitting the payload in a single
+// segment, in alphanumeric or byte mode, or 0 if none does.
+func qrVersion(payload string, level int, alphanumeric bool) int {
+	if alphanumeric {
+		for _, c := range payload {
+			if !strings.ContainsRune(qrAlphanumeric, c) {
+				return 0
+			}
+		}
+	}
+
+	for version := 1; version <= len(qrDataCodewords); version++ {
+		// mode indicator, character count and data
+		bits := 4 + qrCountBits(version, alphanumeric)
+		if alphanumeric {
+			bits += 11*(len(payload)/2) + 6*(len(payload)%2)
+		} else {
+			bits += 8 * len(payload)
+		}
+		if bits <= 8*qrDataCodewords[version-1][level] {
+			return version
+		}
+	}
+	return 0
+}
+
+func qrCountBits(version int, alphanumeric bool) int {
+	switch {
+	case alphanumeric && version <= 9:
+		return 9
+	case alphanumeric && version <= 26:
+		return 11
+	case alphanumeric:
+		return 13
+	case version <= 9:
+		return 8
+	default:
+		return 16
+	}
+}
+
+func qrModules(version int) int64 {
+	return int64(17 + 4*version)
+}
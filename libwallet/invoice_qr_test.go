@@ -0,0 +1,73 @@
+package libwallet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeInvoiceForQR(t *testing.T) {
+	const invoice = "lnbcrt1pwtpd4xpp55meuklpslk5jtxytyh7u2q490c2xhm68dm3a94486zntsg7ad4vsdqqcqzys763w70h39ze44ngzhdt2mag84wlkefqkphuy7ssg4la5gt9vcpmqts00fnapf8frs928mc5ujfutzyu8apkezhrfvydx82l40w0fckqqmerzjc"
+
+	qr, err := EncodeInvoiceForQR(invoice, Regtest(), QRErrorCorrectionM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qr.Payload != "LIGHTNING:"+strings.ToUpper(invoice) {
+		t.Fatalf("unexpected payload %v", qr.Payload)
+	}
+	if qr.Version >= qr.ByteModeVersion || qr.Modules >= qr.ByteModeModules {
+		t.Fatalf("expected alphanumeric mode to be denser, got version %v vs %v", qr.Version, qr.ByteModeVersion)
+	}
+	if qr.Modules != 17+4*qr.Version {
+		t.Fatalf("unexpected modules %v for version %v", qr.Modules, qr.Version)
+	}
+
+	// upper case and uri inputs give the same payload
+	for _, input := range []string{strings.ToUpper(invoice), "lightning:" + invoice} {
+		other, err := EncodeInvoiceForQR(input, Regtest(), QRErrorCorrectionM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.Payload != qr.Payload {
+			t.Errorf("expected the same payload for %v, got %v", input, other.Payload)
+		}
+	}
+
+	// more error correction needs bigger QRs
+	high, err := EncodeInvoiceForQR(invoice, Regtest(), QRErrorCorrectionH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if high.Version <= qr.Version {
+		t.Fatalf("expected a bigger QR with more error correction, got %v vs %v", high.Version, qr.Version)
+	}
+
+	if _, err := EncodeInvoiceForQR(invoice, Regtest(), "X"); err == nil {
+		t.Error("expected invalid error correction level to fail")
+	}
+	if _, err := EncodeInvoiceForQR(invoice, Mainnet(), QRErrorCorrectionM); err == nil {
+		t.Error("expected invoice of another network to fail")
+	}
+}
+
+func TestQRVersion(t *testing.T) {
+	// capacities of the smallest versions at level L
+	tests := []struct {
+		payload      string
+		alphanumeric bool
+		expected     int
+	}{
+		{strings.Repeat("A", 25), true, 1},
+		{strings.Repeat("A", 26), true, 2},
+		{strings.Repeat("a", 17), false, 1},
+		{strings.Repeat("a", 18), false, 2},
+		{strings.Repeat("A", 4296), true, 40},
+		{strings.Repeat("A", 4297), true, 0},
+		{"lowercase", true, 0},
+	}
+	for _, test := range tests {
+		if got := qrVersion(test.payload, 0, test.alphanumeric); got != test.expected {
+			t.Errorf("expected version %v for %v chars, got %v", test.expected, len(test.payload), got)
+		}
+	}
+}
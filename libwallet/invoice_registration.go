@@ -0,0 +1,72 @@
+package libwallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// RegistrationPayloadVersion is the version of the payloads built by
+// BuildRegistrationPayload. The server must reject versions it doesn't know.
+const RegistrationPayloadVersion = 1
+
+// registrationPayloadTag prefixes the signed payload data, so signatures over
+// other messages can't be passed as registrations.
+const registrationPayloadTag = "muun invoice secrets registration"
+
+// BuildRegistrationPayload returns the payload to register the secrets with
+// the remote server, signed with the user key. The payload is the version
+// byte, the number of secrets as a big endian uint16 and, for each secret,
+// its payment hash, its short channel id as a big endian uint64 and its
+// identity, user htlc and muun htlc compressed public keys, followed by the
+// DER signature of the user key over the sha256 hash of the tag and all of
+// the above. The preimages and payment secrets are never included. The
+// secrets must have been generated from the user key.
+func BuildRegistrationPayload(userKey *HDPrivateKey, secrets *InvoiceSecretsList) (_ []byte, err error) {
+	defer recordErrors("BuildRegistrationPayload", &err)
+
+	if secrets == nil || secrets.Length() == 0 {
+		return nil, fmt.Errorf("BuildRegistrationPayload: no secrets to register")
+	}
+	if secrets.Length() > math.MaxUint16 {
+		return nil, fmt.Errorf("BuildRegistrationPayload: too many secrets: %v", secrets.Length())
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(RegistrationPayloadVersion)
+	binary.Write(&buf, binary.BigEndian, uint16(secrets.Length()))
+
+	for _, s := range secrets.secrets {
+		if len(s.PaymentHash) != 32 {
+			return nil, fmt.Errorf("BuildRegistrationPayload: invalid payment hash %x", s.PaymentHash)
+		}
+
+		// a secret from other keys would be registered but never payable
+		identityKey, err := userKey.DeriveTo(s.identityPath().String())
+		if err != nil {
+			return nil, fmt.Errorf("BuildRegistrationPayload: %w", err)
+		}
+		if identityKey.PublicKey().String() != s.IdentityKey.String() {
+			return nil, fmt.Errorf("BuildRegistrationPayload: secret %x is not from the user key", s.PaymentHash)
+		}
+
+		buf.Write(s.PaymentHash)
+		binary.Write(&buf, binary.BigEndian, uint64(s.ShortChanId))
+		for _, key := range []*HDPublicKey{s.IdentityKey, s.UserHtlcKey, s.MuunHtlcKey} {
+			pubKey, err := key.key.ECPubKey()
+			if err != nil {
+				return nil, fmt.Errorf("BuildRegistrationPayload: %w", err)
+			}
+			buf.Write(pubKey.SerializeCompressed())
+		}
+	}
+
+	sig, err := userKey.Sign(append([]byte(registrationPayloadTag), buf.Bytes()...))
+	if err != nil {
+		return nil, fmt.Errorf("BuildRegistrationPayload: failed to sign: %w", err)
+	}
+	buf.Write(sig)
+
+	return buf.Bytes(), nil
+}
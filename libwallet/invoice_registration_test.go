@@ -0,0 +1,83 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+func TestBuildRegistrationPayload(t *testing.T) {
+	setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := BuildRegistrationPayload(userKey, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const secretSize = 32 + 8 + 3*33
+	bodySize := 1 + 2 + secrets.Length()*secretSize
+	if len(payload) <= bodySize {
+		t.Fatalf("expected payload to be signed, got %v bytes", len(payload))
+	}
+	if payload[0] != RegistrationPayloadVersion {
+		t.Fatalf("expected version %v, got %v", RegistrationPayloadVersion, payload[0])
+	}
+	if int(binary.BigEndian.Uint16(payload[1:3])) != secrets.Length() {
+		t.Fatalf("expected %v secrets, got %v", secrets.Length(), binary.BigEndian.Uint16(payload[1:3]))
+	}
+
+	for i := 0; i < secrets.Length(); i++ {
+		s := secrets.Get(i)
+		entry := payload[3+i*secretSize : 3+(i+1)*secretSize]
+		if !bytes.Equal(entry[:32], s.PaymentHash) {
+			t.Fatalf("expected payment hash %x, got %x", s.PaymentHash, entry[:32])
+		}
+		if int64(binary.BigEndian.Uint64(entry[32:40])) != s.ShortChanId {
+			t.Fatalf("expected short chan id %v, got %v", s.ShortChanId, binary.BigEndian.Uint64(entry[32:40]))
+		}
+		pubKey, _ := s.MuunHtlcKey.key.ECPubKey()
+		if !bytes.Equal(entry[106:], pubKey.SerializeCompressed()) {
+			t.Fatalf("expected muun htlc key %x, got %x", pubKey.SerializeCompressed(), entry[106:])
+		}
+	}
+
+	sig, err := btcec.ParseDERSignature(payload[bodySize:], btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(append([]byte(registrationPayloadTag), payload[:bodySize]...))
+	pubKey, _ := userKey.PublicKey().key.ECPubKey()
+	if !sig.Verify(digest[:], pubKey) {
+		t.Fatal("expected signature to verify with the user key")
+	}
+
+	// never leak the secrets themselves
+	for i := 0; i < secrets.Length(); i++ {
+		if bytes.Contains(payload, secrets.Get(i).preimage) || bytes.Contains(payload, secrets.Get(i).paymentSecret) {
+			t.Fatal("expected payload not to include preimages or payment secrets")
+		}
+	}
+
+	otherKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	otherKey.Path = "m/schema:1'/recovery:1'"
+	if _, err := BuildRegistrationPayload(otherKey, secrets); err == nil {
+		t.Fatal("expected error signing secrets of other keys")
+	}
+
+	if _, err := BuildRegistrationPayload(userKey, &InvoiceSecretsList{}); err == nil {
+		t.Fatal("expected error with no secrets")
+	}
+}
@@ -0,0 +1,253 @@
+package libwallet
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/muun/libwallet/cloudsync"
+	"github.com/muun/libwallet/hdpath"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// syncKeyPath is the path of the key the cloud sync secret is derived from,
+// shared by every device with the user key.
+var syncKeyPath = hdpath.SyncBranch.Path().Child(0).String()
+
+// SyncEnvelopeList is a wrapper around sealed cloud sync envelopes to be able
+// to pass through the gomobile bridge.
+type SyncEnvelopeList struct {
+	envelopes [][]byte
+}
+
+// Add appends an envelope to the list.
+func (l *SyncEnvelopeList) Add(envelope []byte) {
+	l.envelopes = append(l.envelopes, envelope)
+}
+
+// Length returns the number of envelopes in the list.
+func (l *SyncEnvelopeList) Length() int {
+	return len(l.envelopes)
+}
+
+// Get returns the envelope at the given index.
+func (l *SyncEnvelopeList) Get(i int) []byte {
+	return l.envelopes[i]
+}
+
+// SealInvoicesForSync returns the invoices changed after sinceMillis, in unix
+// milliseconds, sealed in envelopes for the other devices of the wallet, see
+// package cloudsync. The relay only needs to keep the last revision of each
+// record id. Use 0 to seal every invoice. Invoices that weren't handed out
+// aren't synced, see cloudsync.IsSyncedState.
+func SealInvoicesForSync(userKey *HDPrivateKey, deviceId string, sinceMillis int64) (_ *SyncEnvelopeList, err error) {
+	defer recordErrors("SealInvoicesForSync", &err)
+
+	keys, err := syncKeys(userKey)
+	if err != nil {
+		return nil, fmt.Errorf("SealInvoicesForSync: %w", err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	invoices, err := db.ListInvoices()
+	if err != nil {
+		return nil, fmt.Errorf("SealInvoicesForSync: %w", err)
+	}
+
+	list := &SyncEnvelopeList{}
+	for i := range invoices {
+		record := invoiceRecord(&invoices[i], deviceId)
+		if record.UpdatedAt <= sinceMillis || !cloudsync.IsSyncedState(record.State) {
+			continue
+		}
+		envelope, err := keys.SealInvoice(record)
+		if err != nil {
+			return nil, fmt.Errorf("SealInvoicesForSync: %w", err)
+		}
+		list.Add(envelope)
+	}
+	return list, nil
+}
+
+// ApplySyncedInvoices merges the invoices sealed by other devices of the
+// wallet into the wallet db, following the rules of cloudsync.MergeInvoice,
+// and returns how many invoices were created or changed. Envelopes that fail
+// to open or conflict with the secrets of a local invoice mean the relay
+// tampered with them and abort the sync. Records of invoices that weren't
+// handed out, sealed by older versions, are ignored.
+func ApplySyncedInvoices(userKey *HDPrivateKey, deviceId string, envelopes *SyncEnvelopeList) (_ int64, err error) {
+	defer recordErrors("ApplySyncedInvoices", &err)
+
+	keys, err := syncKeys(userKey)
+	if err != nil {
+		return 0, fmt.Errorf("ApplySyncedInvoices: %w", err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var applied int64
+	for _, envelope := range envelopes.envelopes {
+		remote, err := keys.OpenInvoice(envelope)
+		if err != nil {
+			countSecurityEvent(SecurityEventSignatureVerification)
+			return applied, fmt.Errorf("ApplySyncedInvoices: %w", err)
+		}
+
+		changed, err := applyInvoiceRecord(db, remote, deviceId)
+		if err != nil {
+			return applied, fmt.Errorf("ApplySyncedInvoices: %w", err)
+		}
+		if changed {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// applyInvoiceRecord merges the record into its invoice, creating it if it
+// doesn't exist, and returns whether the wallet db changed.
+func applyInvoiceRecord(db *walletdb.DB, remote *cloudsync.InvoiceRecord, deviceId string) (bool, error) {
+	if !cloudsync.IsSyncedState(remote.State) {
+		return false, nil
+	}
+	exists, err := db.HasInvoice(remote.PaymentHash)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		invoice := &walletdb.Invoice{
+			Preimage:        remote.Preimage,
+			PaymentHash:     remote.PaymentHash,
+			PaymentSecret:   remote.PaymentSecret,
+			KeyPath:         remote.KeyPath,
+			IdentityKeyPath: remote.IdentityKeyPath,
+			ShortChanId:     remote.ShortChanId,
+			Network:         remote.Network,
+			State:           remote.State,
+			SettledAt:       timeFromMillis(remote.SettledAt),
+		}
+		setInvoiceRecordFields(invoice, remote)
+		if err := db.CreateInvoice(invoice); err != nil {
+			return false, err
+		}
+		notifyInvoiceEvent(invoice.PaymentHash, invoice.State)
+		return true, nil
+	}
+
+	invoice, err := db.FindByPaymentHash(remote.PaymentHash)
+	if err != nil {
+		return false, err
+	}
+	local := invoiceRecord(invoice, deviceId)
+	merged, err := cloudsync.MergeInvoice(local, remote)
+	if err != nil {
+		countSecurityEvent(SecurityEventSignatureVerification)
+		return false, err
+	}
+	if sameInvoiceRecord(local, merged) {
+		return false, nil
+	}
+
+	previous := invoice.State
+	// a state this one can't reach was seen by the other device, eg a
+	// payment to an invoice cancelled here, and the merge rules favor it
+	invoice.State = merged.State
+	invoice.SettledAt = timeFromMillis(merged.SettledAt)
+	invoice.Network = merged.Network
+	setInvoiceRecordFields(invoice, merged)
+	if err := db.SaveInvoice(invoice); err != nil {
+		return false, err
+	}
+	if previous != invoice.State {
+		notifyInvoiceEvent(invoice.PaymentHash, invoice.State)
+	}
+	return true, nil
+}
+
+func setInvoiceRecordFields(invoice *walletdb.Invoice, record *cloudsync.InvoiceRecord) {
+	invoice.UsedAt = timeFromMillis(record.UsedAt)
+	invoice.AmountSat = record.AmountSat
+	invoice.FallbackAddress = record.FallbackAddress
+	invoice.CltvExpiry = record.CltvExpiry
+	invoice.ExpiresAt = timeFromMillis(record.ExpiresAt)
+	invoice.Amp = record.Amp
+	invoice.Hold = record.Hold
+	invoice.OverpaymentAcceptedSat = record.OverpaymentAcceptedSat
+	invoice.Metadata = record.Metadata
+	invoice.DisplayCurrency = record.DisplayCurrency
+	invoice.Locale = record.Locale
+}
+
+func invoiceRecord(invoice *walletdb.Invoice, deviceId string) *cloudsync.InvoiceRecord {
+	return &cloudsync.InvoiceRecord{
+		PaymentHash:     invoice.PaymentHash,
+		Preimage:        invoice.Preimage,
+		PaymentSecret:   invoice.PaymentSecret,
+		KeyPath:         invoice.KeyPath,
+		IdentityKeyPath: invoice.IdentityKeyPath,
+		ShortChanId:     invoice.ShortChanId,
+		Network:         invoice.Network,
+		State:           invoice.State,
+		UsedAt:          millisFromTime(invoice.UsedAt),
+		SettledAt:       millisFromTime(invoice.SettledAt),
+		AmountSat:       invoice.AmountSat,
+		FallbackAddress: invoice.FallbackAddress,
+		CltvExpiry:      invoice.CltvExpiry,
+		ExpiresAt:       millisFromTime(invoice.ExpiresAt),
+		Amp:             invoice.Amp,
+		Metadata:        invoice.Metadata,
+		DisplayCurrency: invoice.DisplayCurrency,
+		Locale:          invoice.Locale,
+
+		Hold:                   invoice.Hold,
+		OverpaymentAcceptedSat: invoice.OverpaymentAcceptedSat,
+
+		UpdatedAt: millisFromTime(&invoice.UpdatedAt),
+		Device:    deviceId,
+	}
+}
+
+// sameInvoiceRecord returns whether the records have the same content,
+// regardless of who changed them last.
+func sameInvoiceRecord(a, b *cloudsync.InvoiceRecord) bool {
+	x, y := *a, *b
+	x.UpdatedAt, y.UpdatedAt = 0, 0
+	x.Device, y.Device = "", ""
+	return reflect.DeepEqual(x, y)
+}
+
+func syncKeys(userKey *HDPrivateKey) (*cloudsync.Keys, error) {
+	syncKey, err := userKey.DeriveTo(syncKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive sync key: %w", err)
+	}
+	key, err := syncKey.key.ECPrivKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive sync key: %w", err)
+	}
+	return cloudsync.NewKeys(key.Serialize())
+}
+
+func millisFromTime(t *time.Time) int64 {
+	if t == nil || t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func timeFromMillis(millis int64) *time.Time {
+	if millis == 0 {
+		return nil
+	}
+	t := time.Unix(0, millis*int64(time.Millisecond))
+	return &t
+}
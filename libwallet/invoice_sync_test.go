@@ -0,0 +1,109 @@
+package libwallet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestInvoiceSync(t *testing.T) {
+	setup()
+	defer setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 1000, Metadata: []byte("order 1"), Hold: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, _, _ := getInvoiceSecrets(invoice, userKey)
+
+	sealed, err := SealInvoicesForSync(userKey, "device a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the secrets that weren't handed out stay on this device
+	if sealed.Length() != 1 {
+		t.Fatalf("expected only the used invoice to be sealed, got %v", sealed.Length())
+	}
+
+	// another device with an empty wallet db
+	setup()
+
+	applied, err := ApplySyncedInvoices(userKey, "device b", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 1 {
+		t.Fatalf("expected 1 invoice applied, got %v", applied)
+	}
+	if applied, _ := ApplySyncedInvoices(userKey, "device b", sealed); applied != 0 {
+		t.Fatalf("expected applying twice to change nothing, got %v", applied)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	synced, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused, err := db.CountUnusedInvoices()
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced.State != walletdb.InvoiceStateUsed || synced.AmountSat != 1000 || !bytes.Equal(synced.Metadata, []byte("order 1")) {
+		t.Fatalf("unexpected synced invoice %+v", synced)
+	}
+	if !synced.Hold || synced.CltvExpiry == 0 {
+		t.Fatalf("expected the invoice options to be synced, got %+v", synced)
+	}
+	if unused != 0 {
+		t.Fatalf("expected no unused secrets to be synced, got %v", unused)
+	}
+
+	// only changes made after the last sync are sealed
+	lastSync := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	if err := CancelInvoice(paymentHash); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := SealInvoicesForSync(userKey, "device b", millisFromTime(&lastSync))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Length() != 1 {
+		t.Fatalf("expected only the cancelled invoice to be sealed, got %v", changed.Length())
+	}
+
+	otherKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	otherKey.Path = "m/schema:1'/recovery:1'"
+	if _, err := ApplySyncedInvoices(otherKey, "device b", changed); err == nil {
+		t.Fatal("expected envelopes of another wallet to fail")
+	}
+	tampered := &SyncEnvelopeList{}
+	tampered.Add(append([]byte{}, changed.Get(0)...))
+	tampered.Get(0)[len(tampered.Get(0))-1] ^= 1
+	if _, err := ApplySyncedInvoices(userKey, "device b", tampered); err == nil {
+		t.Fatal("expected tampered envelope to fail")
+	}
+}
@@ -107,6 +107,7 @@ func TestParseInvoice(t *testing.T) {
 				Network:         network,
 				MilliSat:        "1000000",
 				Sats:            1000,
+				Amount:          NewAmountFromMsats(1000000),
 				Destination:     invoiceDestination,
 				PaymentHash:     invoiceWithAmountPaymentHash,
 				Description:     "",
@@ -7,13 +7,13 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"path"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/netann"
 	"github.com/lightningnetwork/lnd/zpay32"
@@ -23,8 +23,30 @@ import (
 	"github.com/muun/libwallet/walletdb"
 )
 
+// MaxUnusedSecrets is the default number of unused invoice secrets kept
+// registered with the remote server.
 const MaxUnusedSecrets = 5
 
+// MaxUnusedSecretsPoolSize bounds the number of unused invoice secrets that
+// can be configured or requested, since each of them is kept in the db and
+// registered with the remote server.
+const MaxUnusedSecretsPoolSize = 100
+
+const defaultInvoiceExpiry = 1 * time.Hour
+
+// invoiceExpiryGrace is how long after their expiry invoices are kept usable,
+// so payments sent right before it can still be fulfilled.
+const invoiceExpiryGrace = 1 * time.Hour
+
+const (
+	// DefaultCltvExpiryBlocks is the final cltv expiry delta of invoices
+	// created without one, ~1/2 day.
+	DefaultCltvExpiryBlocks = 72
+	// MaxCltvExpiryBlocks is the largest final cltv expiry delta accepted,
+	// ~2 weeks. Senders reject routes with longer timelocks anyway.
+	MaxCltvExpiryBlocks = 2016
+)
+
 const (
 	identityKeyChildIndex = 0
 	htlcKeyChildIndex     = 1
@@ -37,11 +59,30 @@ type InvoiceSecrets struct {
 	preimage      []byte
 	paymentSecret []byte
 	keyPath       string
-	PaymentHash   []byte
-	IdentityKey   *HDPublicKey
-	UserHtlcKey   *HDPublicKey
-	MuunHtlcKey   *HDPublicKey
-	ShortChanId   int64
+	// path of the shared identity key, empty if derived from keyPath
+	identityKeyPath string
+	PaymentHash     []byte
+	IdentityKey     *HDPublicKey
+	UserHtlcKey     *HDPublicKey
+	MuunHtlcKey     *HDPublicKey
+	ShortChanId     int64
+}
+
+// identityPath returns the path of the identity key of the secrets.
+func (s *InvoiceSecrets) identityPath() hdpath.Path {
+	if s.identityKeyPath != "" {
+		return hdpath.MustParse(s.identityKeyPath)
+	}
+	return hdpath.MustParse(s.keyPath).Child(identityKeyChildIndex)
+}
+
+// networkName returns the name of the network of the keys the secrets were
+// generated from, or an empty string if unknown.
+func (s *InvoiceSecrets) networkName() string {
+	if s.IdentityKey == nil {
+		return ""
+	}
+	return s.IdentityKey.Network.Name()
 }
 
 // RouteHints is a struct returned by the remote server containing the data
@@ -51,6 +92,55 @@ type RouteHints struct {
 	FeeBaseMsat               int64
 	FeeProportionalMillionths int64
 	CltvExpiryDelta           int32
+	// FinalCltvExpiryDelta is the final cltv expiry delta recommended by the
+	// server for invoices. If zero, DefaultCltvExpiryBlocks is used.
+	FinalCltvExpiryDelta int64
+	// Network is the name of the network the node is on, eg "mainnet". If
+	// set, invoices for other networks are refused.
+	Network string
+}
+
+// CreateFallbackAddress returns the wallet address at the given index of the
+// external branch of the keys, for use as InvoiceOptions.FallbackAddress. The
+// index must not have been handed out before, like for any receiving address.
+func CreateFallbackAddress(userKey, muunKey *HDPublicKey, index int64) (MuunAddress, error) {
+	if index < 0 || index >= hdkeychain.HardenedKeyStart {
+		return nil, fmt.Errorf("CreateFallbackAddress: invalid index %v", index)
+	}
+
+	path := hdpath.MustParse(userKey.Path).Branch(hdpath.ExternalBranch).Child(uint32(index))
+
+	derivedUserKey, err := userKey.DeriveTo(path.String())
+	if err != nil {
+		return nil, fmt.Errorf("CreateFallbackAddress: failed to derive user key: %w", err)
+	}
+	derivedMuunKey, err := muunKey.DeriveTo(path.String())
+	if err != nil {
+		return nil, fmt.Errorf("CreateFallbackAddress: failed to derive muun key: %w", err)
+	}
+
+	return CreateAddressV4(derivedUserKey, derivedMuunKey)
+}
+
+// RouteHintsList is a wrapper around a RouteHints slice to be able to pass
+// through the gomobile bridge.
+type RouteHintsList struct {
+	hints []*RouteHints
+}
+
+// Add appends the route hints to the list.
+func (l *RouteHintsList) Add(h *RouteHints) {
+	l.hints = append(l.hints, h)
+}
+
+// Length returns the number of route hints in the list.
+func (l *RouteHintsList) Length() int {
+	return len(l.hints)
+}
+
+// Get returns the route hints at the given index.
+func (l *RouteHintsList) Get(i int) *RouteHints {
+	return l.hints[i]
 }
 
 // InvoiceOptions defines additional options that can be configured when
@@ -58,6 +148,121 @@ type RouteHints struct {
 type InvoiceOptions struct {
 	Description string
 	AmountSat   int64
+	// Amount is the invoice amount with msat precision. If set, it takes
+	// precedence over AmountSat. It must be at least 1 sat, since payments
+	// are checked with sat precision.
+	Amount *Amount
+	// FallbackAddress is an optional on-chain address of the wallet that
+	// payers can use if they can't pay through lightning.
+	FallbackAddress string
+	// ExpirySeconds is how long the invoice can be paid for. If zero, the
+	// default invoice expiry is used.
+	ExpirySeconds int64
+	// Metadata is opaque app data, eg an order id, stored along with the
+	// invoice to correlate it with incoming payments. It's kept locally and
+	// not included in the invoice. See IncomingSwap.InvoiceMetadata.
+	Metadata []byte
+	// DescriptionHash is the sha256 hash of a description too long to be
+	// included in the invoice, eg an order payload. It's mutually exclusive
+	// with Description.
+	DescriptionHash []byte
+	// CltvExpiryBlocks is the final cltv expiry delta of the invoice, the
+	// blocks left to fulfill payments once they reach the wallet. It must be
+	// greater than the configured MinCltvSafetyDelta, or payments would
+	// arrive too close to their expiry to be fulfilled. Payers lock their
+	// funds for it plus the CltvExpiryDelta of the route hint they use, and
+	// that budget must be at most MaxCltvExpiryBlocks for every route hint.
+	// If zero, the one recommended in the route hints is used.
+	CltvExpiryBlocks int64
+	// Amp makes the invoice an amp invoice, which payers can split in shards
+	// with their own payment hashes.
+	Amp bool
+	// Hold withholds the preimage when a payment arrives, until the user
+	// decides to take it with SettleInvoice or reject it with
+	// CancelHeldInvoice.
+	Hold bool
+	// BlindAmount leaves the amount out of the invoice, so it isn't revealed
+	// to whoever sees it. The amount is still stored and payments below it
+	// are refused.
+	BlindAmount bool
+	// DisplayCurrency is the ISO 4217 code of the currency the amount was
+	// shown in, eg "ARS", and Locale the BCP 47 tag of the locale used, eg
+	// "es-AR". They are stored with the invoice but not included in it, see
+	// GetInvoiceLocalization.
+	DisplayCurrency string
+	Locale          string
+	// FiatAmount is the value of the amount in FiatCurrency, an ISO 4217
+	// code, at the exchange rate of RateTimestamp, in unix seconds, when the
+	// invoice is created. They are stored so the receive history shows what
+	// the payment was worth when it was requested, see GetInvoiceFiatAmount.
+	FiatAmount    float64
+	FiatCurrency  string
+	RateTimestamp int64
+	// MaxRouteHints, if set, limits the route hints of invoices created with
+	// several of them to the best ones of the trusted nodes, ranked by fee
+	// for the invoice amount and then by cltv expiry delta, see
+	// RankRouteHints. The best one becomes the primary hint. If zero, every
+	// hint is included in the given order.
+	MaxRouteHints int64
+	// PendingUse issues the invoice in the pending use state, reserving its
+	// secret until the app confirms the invoice reached the payer with
+	// ConfirmInvoiceUse, or returns the secret to the pool with
+	// ReleaseInvoice if it couldn't be delivered.
+	PendingUse bool
+}
+
+// amount returns the invoice amount, or nil if it has none.
+func (o *InvoiceOptions) amount() (*Amount, error) {
+	if o.Amount != nil && o.Amount.Msats() != 0 {
+		if err := validateInvoiceAmount(o.Amount); err != nil {
+			return nil, err
+		}
+		return o.Amount, nil
+	}
+	if o.AmountSat != 0 {
+		return NewAmountFromSats(o.AmountSat)
+	}
+	return nil, nil
+}
+
+// expiry returns the invoice expiry, or the default one if none was set.
+func (o *InvoiceOptions) expiry() (time.Duration, error) {
+	if o.ExpirySeconds < 0 {
+		return 0, fmt.Errorf("invalid invoice expiry: %v", o.ExpirySeconds)
+	}
+	if o.ExpirySeconds == 0 {
+		return defaultInvoiceExpiry, nil
+	}
+	return time.Duration(o.ExpirySeconds) * time.Second, nil
+}
+
+// cltvExpiry returns the final cltv expiry delta of the invoice, falling back
+// to the recommended one and then to the default if none was set.
+func (o *InvoiceOptions) cltvExpiry(routeHints []*RouteHints) (uint64, error) {
+	cltvExpiry := o.CltvExpiryBlocks
+	if cltvExpiry == 0 {
+		cltvExpiry = routeHints[0].FinalCltvExpiryDelta
+	}
+	if cltvExpiry == 0 {
+		return DefaultCltvExpiryBlocks, nil
+	}
+	if cltvExpiry <= minCltvSafetyDelta() || cltvExpiry > MaxCltvExpiryBlocks {
+		return 0, fmt.Errorf(
+			"invalid invoice cltv expiry: %v, must be greater than %v and at most %v",
+			cltvExpiry, minCltvSafetyDelta(), MaxCltvExpiryBlocks,
+		)
+	}
+	if o.CltvExpiryBlocks != 0 {
+		for _, hint := range routeHints {
+			if budget := cltvExpiry + int64(hint.CltvExpiryDelta); budget > MaxCltvExpiryBlocks {
+				return 0, fmt.Errorf(
+					"invalid invoice cltv expiry: %v plus the route hint cltv expiry delta %v is above %v",
+					cltvExpiry, hint.CltvExpiryDelta, MaxCltvExpiryBlocks,
+				)
+			}
+		}
+	}
+	return uint64(cltvExpiry), nil
 }
 
 // InvoiceSecretsList is a wrapper around an InvoiceSecrets slice to be
@@ -76,33 +281,83 @@ func (l *InvoiceSecretsList) Get(i int) *InvoiceSecrets {
 	return l.secrets[i]
 }
 
+// Add appends the given secret to the list.
+func (l *InvoiceSecretsList) Add(s *InvoiceSecrets) {
+	l.secrets = append(l.secrets, s)
+}
+
 // GenerateInvoiceSecrets returns a slice of new secrets to register with
 // the remote server. Once registered, those invoices should be stored with
 // the PersistInvoiceSecrets method.
-func GenerateInvoiceSecrets(userKey, muunKey *HDPublicKey) (*InvoiceSecretsList, error) {
+func GenerateInvoiceSecrets(userKey, muunKey *HDPublicKey) (_ *InvoiceSecretsList, err error) {
+	defer recordErrors("GenerateInvoiceSecrets", &err)
+
+	return generateInvoiceSecrets(userKey, muunKey, unusedSecretsPoolSize())
+}
 
-	var secrets []*InvoiceSecrets
+// GenerateInvoiceSecretsBatch works like GenerateInvoiceSecrets, but fills
+// the pool up to poolSize unused secrets when the remote server requests a
+// larger batch than the configured one, eg for receivers creating many
+// invoices. The pool never shrinks below the configured size and is capped
+// at MaxUnusedSecretsPoolSize.
+func GenerateInvoiceSecretsBatch(userKey, muunKey *HDPublicKey, poolSize int64) (_ *InvoiceSecretsList, err error) {
+	defer recordErrors("GenerateInvoiceSecretsBatch", &err)
+
+	if poolSize < 0 {
+		return nil, fmt.Errorf("GenerateInvoiceSecretsBatch: invalid pool size %v", poolSize)
+	}
+	size := unusedSecretsPoolSize()
+	if int(poolSize) > size {
+		size = int(poolSize)
+	}
+	if size > MaxUnusedSecretsPoolSize {
+		size = MaxUnusedSecretsPoolSize
+	}
+	return generateInvoiceSecrets(userKey, muunKey, size)
+}
 
+// generateInvoiceSecrets returns the secrets needed to have poolSize unused
+// ones.
+func generateInvoiceSecrets(userKey, muunKey *HDPublicKey, poolSize int) (*InvoiceSecretsList, error) {
 	db, err := openDB()
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
+	if _, err := expireOldInvoices(db, walletNow()); err != nil {
+		return nil, err
+	}
+
 	unused, err := db.CountUnusedInvoices()
 	if err != nil {
 		return nil, err
 	}
 
-	if unused >= MaxUnusedSecrets {
+	if unused >= poolSize {
 		return &InvoiceSecretsList{make([]*InvoiceSecrets, 0)}, nil
 	}
 
-	num := MaxUnusedSecrets - unused
+	return newInvoiceSecrets(db, userKey, muunKey, poolSize-unused)
+}
+
+// newInvoiceSecrets returns count new secrets, each at a random key path.
+// With StableNodeIdentity, all of them share the stable identity key.
+func newInvoiceSecrets(db *walletdb.DB, userKey, muunKey *HDPublicKey, count int) (*InvoiceSecretsList, error) {
+	secrets := make([]*InvoiceSecrets, 0, count)
 
-	for i := 0; i < num; i++ {
-		preimage := randomBytes(32)
-		paymentSecret := randomBytes(32)
+	var stableIdentityKeyPath hdpath.Path
+	if cfg.StableNodeIdentity {
+		var err error
+		stableIdentityKeyPath, err = nodeIdentityKeyPath(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		preimage := secretBytes(32)
+		paymentSecret := secretBytes(32)
 		paymentHashArray := sha256.Sum256(preimage)
 		paymentHash := paymentHashArray[:]
 
@@ -110,9 +365,12 @@ func GenerateInvoiceSecrets(userKey, muunKey *HDPublicKey) (*InvoiceSecretsList,
 		l1 := binary.LittleEndian.Uint32(levels[:4]) & 0x7FFFFFFF
 		l2 := binary.LittleEndian.Uint32(levels[4:]) & 0x7FFFFFFF
 
-		keyPath := hdpath.MustParse("m/schema:1'/recovery:1'/invoices:4").Child(l1).Child(l2)
+		keyPath := hdpath.Schema(1).Recovery(1).Invoices(4).Child(l1).Child(l2)
 
 		identityKeyPath := keyPath.Child(identityKeyChildIndex)
+		if stableIdentityKeyPath != "" {
+			identityKeyPath = stableIdentityKeyPath
+		}
 
 		identityKey, err := userKey.DeriveTo(identityKeyPath.String())
 		if err != nil {
@@ -133,48 +391,150 @@ func GenerateInvoiceSecrets(userKey, muunKey *HDPublicKey) (*InvoiceSecretsList,
 		shortChanId := binary.LittleEndian.Uint64(randomBytes(8)) | (1 << 63)
 
 		secrets = append(secrets, &InvoiceSecrets{
-			preimage:      preimage,
-			paymentSecret: paymentSecret,
-			keyPath:       keyPath.String(),
-			PaymentHash:   paymentHash,
-			IdentityKey:   identityKey,
-			UserHtlcKey:   userHtlcKey,
-			MuunHtlcKey:   muunHtlcKey,
-			ShortChanId:   int64(shortChanId),
+			preimage:        preimage,
+			paymentSecret:   paymentSecret,
+			keyPath:         keyPath.String(),
+			identityKeyPath: stableIdentityKeyPath.String(),
+			PaymentHash:     paymentHash,
+			IdentityKey:     identityKey,
+			UserHtlcKey:     userHtlcKey,
+			MuunHtlcKey:     muunHtlcKey,
+			ShortChanId:     int64(shortChanId),
 		})
 	}
 
-	// TODO: cleanup used secrets
-
 	return &InvoiceSecretsList{secrets}, nil
 }
 
+// nodeIdentityKeyPath returns the path of the stable identity key, picking
+// one at random the first time.
+func nodeIdentityKeyPath(db *walletdb.DB) (hdpath.Path, error) {
+	identity, err := db.FindNodeIdentity()
+	if err != nil {
+		return "", err
+	}
+	if identity != nil {
+		return hdpath.Parse(identity.KeyPath)
+	}
+
+	levels := randomBytes(8)
+	l1 := binary.LittleEndian.Uint32(levels[:4]) & 0x7FFFFFFF
+	l2 := binary.LittleEndian.Uint32(levels[4:]) & 0x7FFFFFFF
+
+	keyPath := hdpath.Schema(1).Recovery(1).Invoices(4).Child(l1).Child(l2).Child(identityKeyChildIndex)
+	if err := db.SaveNodeIdentity(&walletdb.NodeIdentity{KeyPath: keyPath.String()}); err != nil {
+		return "", err
+	}
+	return keyPath, nil
+}
+
+// invoiceIdentityKeyPath returns the path of the identity key the invoice is
+// signed with and its payments are addressed to.
+func invoiceIdentityKeyPath(invoice *walletdb.Invoice) hdpath.Path {
+	if invoice.IdentityKeyPath != "" {
+		return hdpath.MustParse(invoice.IdentityKeyPath)
+	}
+	return hdpath.MustParse(invoice.KeyPath).Child(identityKeyChildIndex)
+}
+
 // PersistInvoiceSecrets stores secrets registered with the remote server
 // in the device local database. These secrets can be used to craft new
 // Lightning invoices.
-func PersistInvoiceSecrets(list *InvoiceSecretsList) error {
+func PersistInvoiceSecrets(list *InvoiceSecretsList) (err error) {
+	defer recordErrors("PersistInvoiceSecrets", &err)
+
 	db, err := openDB()
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
+	// Either the whole batch is stored or none of it, so a failure doesn't
+	// leave part of the registered secrets behind
+	err = db.Transaction(func(tx *walletdb.DB) error {
+		for _, s := range list.secrets {
+			err := tx.CreateInvoice(&walletdb.Invoice{
+				Preimage:        s.preimage,
+				PaymentHash:     s.PaymentHash,
+				PaymentSecret:   s.paymentSecret,
+				KeyPath:         s.keyPath,
+				IdentityKeyPath: s.identityKeyPath,
+				ShortChanId:     uint64(s.ShortChanId),
+				State:           walletdb.InvoiceStateRegistered,
+				Network:         s.networkName(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("PersistInvoiceSecrets: %w", err)
+	}
+
 	for _, s := range list.secrets {
-		db.CreateInvoice(&walletdb.Invoice{
-			Preimage:      s.preimage,
-			PaymentHash:   s.PaymentHash,
-			PaymentSecret: s.paymentSecret,
-			KeyPath:       s.keyPath,
-			ShortChanId:   uint64(s.ShortChanId),
-			State:         walletdb.InvoiceStateRegistered,
-		})
+		notifyInvoiceEvent(s.PaymentHash, walletdb.InvoiceStateRegistered)
 	}
 	return nil
 }
 
+// InvoiceSigner signs invoices with the identity key of the invoice node.
+// Implementations can keep the key outside of libwallet, eg in a hardware
+// backed or remote signer.
+type InvoiceSigner interface {
+	// SignInvoiceDigest returns a compact signature of the digest using the
+	// identity key found at the given derivation path of the user key.
+	SignInvoiceDigest(keyPath string, digest []byte) ([]byte, error)
+}
+
+// hdKeyInvoiceSigner signs invoices using keys derived from the user key.
+type hdKeyInvoiceSigner struct {
+	userKey *HDPrivateKey
+}
+
+func (s *hdKeyInvoiceSigner) SignInvoiceDigest(keyPath string, digest []byte) ([]byte, error) {
+	identityKey, err := deriveIdentityKey(s.userKey, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := netann.NewNodeSigner(identityKey)
+	return signer.SignDigestCompact(digest)
+}
+
 // CreateInvoice returns a new lightning invoice string for the given network.
 // Amount and description can be configured optionally.
 func CreateInvoice(net *Network, userKey *HDPrivateKey, routeHints *RouteHints, opts *InvoiceOptions) (string, error) {
+	if err := checkNetwork(net, userKey.Network.Name(), "user key"); err != nil {
+		return "", err
+	}
+	return CreateInvoiceWithSigner(net, &hdKeyInvoiceSigner{userKey}, routeHints, opts)
+}
+
+// CreateInvoiceWithSigner works like CreateInvoice, but delegates signing the
+// invoice to the given signer.
+func CreateInvoiceWithSigner(net *Network, signer InvoiceSigner, routeHints *RouteHints, opts *InvoiceOptions) (string, error) {
+	return createInvoice(net, signer, []*RouteHints{routeHints}, opts)
+}
+
+// CreateInvoiceWithRouteHints works like CreateInvoice, but includes a route
+// hint for each of the given nodes so payments can still succeed when some of
+// them are offline. The first one is the primary hint, whose recommended
+// final cltv expiry is used.
+func CreateInvoiceWithRouteHints(net *Network, userKey *HDPrivateKey, routeHints *RouteHintsList, opts *InvoiceOptions) (string, error) {
+	if routeHints == nil || routeHints.Length() == 0 {
+		return "", fmt.Errorf("CreateInvoice: at least one route hint is required")
+	}
+	if err := checkNetwork(net, userKey.Network.Name(), "user key"); err != nil {
+		return "", err
+	}
+	return createInvoice(net, &hdKeyInvoiceSigner{userKey}, routeHints.hints, opts)
+}
+
+func createInvoice(net *Network, signer InvoiceSigner, routeHints []*RouteHints, opts *InvoiceOptions) (_ string, err error) {
+	defer recordErrors("CreateInvoice", &err)
+
 	// obtain first unused secret from db
 	db, err := openDB()
 	if err != nil {
@@ -182,95 +542,262 @@ func CreateInvoice(net *Network, userKey *HDPrivateKey, routeHints *RouteHints,
 	}
 	defer db.Close()
 
-	dbInvoice, err := db.FindFirstUnusedInvoice()
+	dbInvoice, err := db.FindUnusedInvoice(invoiceOrder())
 	if err != nil {
 		return "", err
 	}
 	if dbInvoice == nil {
 		return "", nil
 	}
+	return issueInvoice(db, net, signer, dbInvoice, routeHints, opts)
+}
 
-	var paymentHash [32]byte
-	copy(paymentHash[:], dbInvoice.PaymentHash)
+// AmendInvoice re-encodes an invoice that wasn't paid yet with new options,
+// keeping its payment hash and secret, so a wrong amount or description can
+// be fixed without using up another secret. The invoice gets a new timestamp
+// and expiry. The amount of the amended invoice is the one enforced from then
+// on, so the old invoice string can't be used to pay less than it.
+func AmendInvoice(net *Network, userKey *HDPrivateKey, paymentHash []byte, routeHints *RouteHintsList, opts *InvoiceOptions) (_ string, err error) {
+	defer recordErrors("AmendInvoice", &err)
+
+	if routeHints == nil || routeHints.Length() == 0 {
+		return "", fmt.Errorf("AmendInvoice: at least one route hint is required")
+	}
+	if err := checkNetwork(net, userKey.Network.Name(), "user key"); err != nil {
+		return "", err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	dbInvoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return "", fmt.Errorf("AmendInvoice: could not find invoice for payment hash: %w", err)
+	}
+	if dbInvoice.State != walletdb.InvoiceStateUsed {
+		return "", fmt.Errorf("AmendInvoice: can't amend %v invoice", dbInvoice.State)
+	}
+	if dbInvoice.ExpiresAt != nil && walletNow().After(*dbInvoice.ExpiresAt) {
+		return "", fmt.Errorf("AmendInvoice: invoice already expired")
+	}
+
+	// A partial payment may already be in flight for the old amount
+	parts, err := db.ListMppParts(paymentHash)
+	if err != nil {
+		return "", fmt.Errorf("AmendInvoice: %w", err)
+	}
+	if len(parts) > 0 {
+		return "", fmt.Errorf("AmendInvoice: invoice already received %v payment parts", len(parts))
+	}
+
+	return issueInvoice(db, net, &hdKeyInvoiceSigner{userKey}, dbInvoice, routeHints.hints, opts)
+}
 
-	nodeID, err := parsePubKey(routeHints.Pubkey)
+// issueInvoice encodes and signs an invoice with the secrets of dbInvoice,
+// and stores it as used with the given options.
+func issueInvoice(db *walletdb.DB, net *Network, signer InvoiceSigner, dbInvoice *walletdb.Invoice, routeHints []*RouteHints, opts *InvoiceOptions) (string, error) {
+	if err := checkNetwork(net, dbInvoice.Network, "invoice secret"); err != nil {
+		return "", err
+	}
+	routeHints, err := selectRouteHints(db, routeHints, opts)
 	if err != nil {
-		return "", fmt.Errorf("can't parse route hint pubkey: %w", err)
+		return "", err
 	}
+	if err := checkRouteHintNodes(db, routeHints); err != nil {
+		return "", err
+	}
+
+	var paymentHash [32]byte
+	copy(paymentHash[:], dbInvoice.PaymentHash)
 
+	// Each hint is a separate single hop route, so payers can pick any of them
 	var iopts []func(*zpay32.Invoice)
-	iopts = append(iopts, zpay32.RouteHint([]zpay32.HopHint{
-		{
-			NodeID:                    nodeID,
-			ChannelID:                 dbInvoice.ShortChanId,
-			FeeBaseMSat:               uint32(routeHints.FeeBaseMsat),
-			FeeProportionalMillionths: uint32(routeHints.FeeProportionalMillionths),
-			CLTVExpiryDelta:           uint16(routeHints.CltvExpiryDelta),
-		},
-	}))
+	for _, hint := range routeHints {
+		if err := checkNetwork(net, hint.Network, "route hint node"); err != nil {
+			return "", err
+		}
 
-	features := lnwire.EmptyFeatureVector()
-	features.RawFeatureVector.Set(lnwire.TLVOnionPayloadOptional)
-	features.RawFeatureVector.Set(lnwire.PaymentAddrOptional)
+		// The route hint node may be given as a pubkey or a full node URI
+		nodeURI, err := ParseNodeURI(hint.Pubkey)
+		if err != nil {
+			return "", fmt.Errorf("can't parse route hint pubkey: %w", err)
+		}
+		nodeID, err := parseCachedPubKey(nodeURI.PublicKey)
+		if err != nil {
+			return "", fmt.Errorf("can't parse route hint pubkey: %w", err)
+		}
+
+		iopts = append(iopts, zpay32.RouteHint([]zpay32.HopHint{
+			{
+				NodeID:                    nodeID,
+				ChannelID:                 dbInvoice.ShortChanId,
+				FeeBaseMSat:               uint32(hint.FeeBaseMsat),
+				FeeProportionalMillionths: uint32(hint.FeeProportionalMillionths),
+				CLTVExpiryDelta:           uint16(hint.CltvExpiryDelta),
+			},
+		}))
+	}
+
+	rawFeatures, err := cfg.InvoiceFeatures.featureVector()
+	if err != nil {
+		return "", fmt.Errorf("invalid invoice features: %w", err)
+	}
+	if opts.Amp {
+		rawFeatures.Set(ampRequired)
+	}
+	features := lnwire.NewFeatureVector(rawFeatures, lnwire.Features)
 
 	iopts = append(iopts, zpay32.Features(features))
-	iopts = append(iopts, zpay32.CLTVExpiry(72)) // ~1/2 day
-	iopts = append(iopts, zpay32.Expiry(1*time.Hour))
+
+	cltvExpiry, err := opts.cltvExpiry(routeHints)
+	if err != nil {
+		return "", err
+	}
+	iopts = append(iopts, zpay32.CLTVExpiry(cltvExpiry))
+
+	expiry, err := opts.expiry()
+	if err != nil {
+		return "", err
+	}
+	iopts = append(iopts, zpay32.Expiry(expiry))
 
 	var paymentAddr [32]byte
 	copy(paymentAddr[:], dbInvoice.PaymentSecret)
 	iopts = append(iopts, zpay32.PaymentAddr(paymentAddr))
 
-	if opts.Description != "" {
+	if len(opts.DescriptionHash) != 0 {
+		if opts.Description != "" {
+			return "", fmt.Errorf("invoice can't have both a description and a description hash")
+		}
+		if len(opts.DescriptionHash) != sha256.Size {
+			return "", fmt.Errorf("invalid description hash len %v", len(opts.DescriptionHash))
+		}
+		var descriptionHash [32]byte
+		copy(descriptionHash[:], opts.DescriptionHash)
+		iopts = append(iopts, zpay32.DescriptionHash(descriptionHash))
+	} else if opts.Description != "" {
 		iopts = append(iopts, zpay32.Description(opts.Description))
 	} else {
 		// description or description hash must be non-empty, adding a placeholder for now
 		iopts = append(iopts, zpay32.Description(""))
 	}
-	if opts.AmountSat != 0 {
-		msat := lnwire.NewMSatFromSatoshis(btcutil.Amount(opts.AmountSat))
-		iopts = append(iopts, zpay32.Amount(msat))
+	amount, err := opts.amount()
+	if err != nil {
+		return "", err
+	}
+	if err := opts.validateLocalization(); err != nil {
+		return "", err
+	}
+	if err := opts.validateFiatAmount(); err != nil {
+		return "", err
+	}
+	if amount == nil && opts.BlindAmount {
+		return "", fmt.Errorf("invoice with blind amount must have an amount")
+	}
+	if amount != nil && !opts.BlindAmount {
+		iopts = append(iopts, zpay32.Amount(amount.toMilliSatoshi()))
+	}
+	if opts.FallbackAddress != "" {
+		fallbackAddr, err := btcutil.DecodeAddress(opts.FallbackAddress, net.network)
+		if err != nil {
+			return "", fmt.Errorf("invalid fallback address: %w", err)
+		}
+		if !fallbackAddr.IsForNet(net.network) {
+			return "", fmt.Errorf("fallback address %v is not for network %v", opts.FallbackAddress, net.Name())
+		}
+		iopts = append(iopts, zpay32.FallbackAddr(fallbackAddr))
 	}
 
 	// create the invoice
 	invoice, err := zpay32.NewInvoice(
-		net.network, paymentHash, time.Now(), iopts...,
+		net.network, paymentHash, walletNow(), iopts...,
 	)
 	if err != nil {
 		return "", err
 	}
 
-	// recreate the client identity privkey
-	identityKeyPath := hdpath.MustParse(dbInvoice.KeyPath).Child(identityKeyChildIndex)
-	identityHDKey, err := userKey.DeriveTo(identityKeyPath.String())
+	// sign the invoice with the client identity key
+	identityKeyPath := invoiceIdentityKeyPath(dbInvoice)
+	bech32, err := invoice.Encode(zpay32.MessageSigner{
+		SignCompact: func(digest []byte) ([]byte, error) {
+			return signer.SignInvoiceDigest(identityKeyPath.String(), digest)
+		},
+	})
 	if err != nil {
 		return "", err
 	}
-	identityKey, err := identityHDKey.key.ECPrivKey()
-	if err != nil {
-		return "", fmt.Errorf("can't obtain identity privkey: %w", err)
+
+	now := walletNow()
+	dbInvoice.AmountSat = 0
+	if amount != nil {
+		// msat fractions are dropped, incoming swaps are checked with sat precision
+		dbInvoice.AmountSat = amount.Sats()
 	}
+	dbInvoice.FallbackAddress = opts.FallbackAddress
+	dbInvoice.CltvExpiry = int64(cltvExpiry)
+	dbInvoice.Metadata = opts.Metadata
+	dbInvoice.Amp = opts.Amp
+	dbInvoice.Hold = opts.Hold
+	dbInvoice.DisplayCurrency = opts.DisplayCurrency
+	dbInvoice.Locale = opts.Locale
+	dbInvoice.CreationFiatAmount = opts.FiatAmount
+	dbInvoice.CreationFiatCurrency = opts.FiatCurrency
+	dbInvoice.RateTimestamp = nil
+	if opts.RateTimestamp != 0 {
+		rateTimestamp := time.Unix(opts.RateTimestamp, 0)
+		dbInvoice.RateTimestamp = &rateTimestamp
+	}
+	if dbInvoice.UsedAt == nil {
+		dbInvoice.UsedAt = &now
+	}
+	expiresAt := invoice.Timestamp.Add(expiry)
+	dbInvoice.ExpiresAt = &expiresAt
 
-	// sign the invoice with the identity pubkey
-	signer := netann.NewNodeSigner(identityKey)
-	bech32, err := invoice.Encode(zpay32.MessageSigner{
-		SignCompact: signer.SignDigestCompact,
-	})
+	state := walletdb.InvoiceStateUsed
+	if opts.PendingUse {
+		state = walletdb.InvoiceStatePendingUse
+	}
+	err = saveInvoiceState(db, dbInvoice, state)
 	if err != nil {
 		return "", err
 	}
 
-	now := time.Now()
-	dbInvoice.AmountSat = opts.AmountSat
-	dbInvoice.State = walletdb.InvoiceStateUsed
-	dbInvoice.UsedAt = &now
+	return bech32, nil
+}
 
-	err = db.SaveInvoice(dbInvoice)
+// CancelInvoice marks the invoice with the given payment hash as cancelled, so
+// its secrets are never handed out again and payments to it are refused. Only
+// invoices that weren't paid yet can be cancelled, and cancelling twice is a
+// no-op.
+func CancelInvoice(paymentHash []byte) (err error) {
+	defer recordErrors("CancelInvoice", &err)
+
+	db, err := openDB()
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer db.Close()
 
-	return bech32, nil
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return fmt.Errorf("CancelInvoice: could not find invoice for payment hash: %w", err)
+	}
+
+	switch invoice.State {
+	case walletdb.InvoiceStateCancelled:
+		return nil
+	case walletdb.InvoiceStateRegistered, walletdb.InvoiceStatePendingUse, walletdb.InvoiceStateUsed:
+	default:
+		return fmt.Errorf("CancelInvoice: can't cancel %v invoice", invoice.State)
+	}
+
+	if err := saveInvoiceState(db, invoice, walletdb.InvoiceStateCancelled); err != nil {
+		return fmt.Errorf("CancelInvoice: %w", err)
+	}
+	return nil
 }
 
 type IncomingSwap struct {
@@ -279,6 +806,12 @@ type IncomingSwap struct {
 	PaymentHash      []byte
 	PaymentAmountSat int64
 	CollectSat       int64
+	BlockHeight      int64 // current chain tip, 0 skips the cltv safety check
+}
+
+// PaymentAmount returns the amount paid through the swap.
+func (s *IncomingSwap) PaymentAmount() (*Amount, error) {
+	return NewAmountFromSats(s.PaymentAmountSat)
 }
 
 type IncomingSwapHtlc struct {
@@ -295,14 +828,176 @@ type IncomingSwapFulfillmentData struct {
 	MerkleTree         []byte // unused
 	HtlcBlock          []byte // unused
 	BlockHeight        int64  // unused
-	ConfirmationTarget int64  // to validate fee rate, unused for now
+	ConfirmationTarget int64  // must match the fee rate attestation, if any
+	// DustPolicy decides what happens when the fulfillment output is below
+	// the dust threshold: DustPolicyReject (the default) or
+	// DustPolicyRollIntoFees.
+	DustPolicy string
+	// FeeRateAttestation is the server signed fee rate the fulfillment tx was
+	// built with. If set, it's verified before signing.
+	FeeRateAttestation *FeeRateAttestation
 }
 
+// Policies for fulfillments whose output, once the collect is deducted, is
+// below the dust threshold and thus can't be relayed.
+const (
+	// DustPolicyReject fails the fulfillment.
+	DustPolicyReject = "reject"
+	// DustPolicyRollIntoFees gives up the remaining amount as fees, handing
+	// out the preimage without a fulfillment tx like FulfillFullDebt.
+	DustPolicyRollIntoFees = "roll-into-fees"
+)
+
 type IncomingSwapFulfillmentResult struct {
-	FulfillmentTx []byte
+	FulfillmentTx []byte // nil if the output was rolled into fees
 	Preimage      []byte
 }
 
+// ConfirmInvoiceUse marks an invoice issued with InvoiceOptions.PendingUse
+// as used, once the app delivered it to the payer. Confirming twice, or an
+// invoice already paid, is a no-op.
+func ConfirmInvoiceUse(paymentHash []byte) (err error) {
+	defer recordErrors("ConfirmInvoiceUse", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return fmt.Errorf("ConfirmInvoiceUse: could not find invoice for payment hash: %w", err)
+	}
+
+	switch invoice.State {
+	case walletdb.InvoiceStatePendingUse:
+	case walletdb.InvoiceStateUsed, walletdb.InvoiceStateAccepted, walletdb.InvoiceStateSettled:
+		return nil
+	default:
+		return fmt.Errorf("ConfirmInvoiceUse: can't confirm %v invoice", invoice.State)
+	}
+
+	if err := saveInvoiceState(db, invoice, walletdb.InvoiceStateUsed); err != nil {
+		return fmt.Errorf("ConfirmInvoiceUse: %w", err)
+	}
+	return nil
+}
+
+// ReleaseInvoice returns the secret of an invoice issued with
+// InvoiceOptions.PendingUse to the unused secrets pool, when the app failed
+// to deliver it to the payer. The secret will be used by another invoice, so
+// the released one must not have been shown to anyone. Releasing twice is a
+// no-op.
+func ReleaseInvoice(paymentHash []byte) (err error) {
+	defer recordErrors("ReleaseInvoice", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return fmt.Errorf("ReleaseInvoice: could not find invoice for payment hash: %w", err)
+	}
+
+	switch invoice.State {
+	case walletdb.InvoiceStatePendingUse:
+	case walletdb.InvoiceStateRegistered:
+		return nil
+	default:
+		return fmt.Errorf("ReleaseInvoice: can't release %v invoice", invoice.State)
+	}
+
+	// A payment may have started to arrive if the invoice did reach the payer
+	parts, err := db.ListMppParts(paymentHash)
+	if err != nil {
+		return fmt.Errorf("ReleaseInvoice: %w", err)
+	}
+	if len(parts) > 0 {
+		return fmt.Errorf("ReleaseInvoice: invoice already received %v payment parts", len(parts))
+	}
+
+	// forget everything set when it was issued
+	invoice.AmountSat = 0
+	invoice.FallbackAddress = ""
+	invoice.CltvExpiry = 0
+	invoice.Metadata = nil
+	invoice.Amp = false
+	invoice.Hold = false
+	invoice.DisplayCurrency = ""
+	invoice.Locale = ""
+	invoice.CreationFiatAmount = 0
+	invoice.CreationFiatCurrency = ""
+	invoice.RateTimestamp = nil
+	invoice.UsedAt = nil
+	invoice.ExpiresAt = nil
+	if err := saveInvoiceState(db, invoice, walletdb.InvoiceStateRegistered); err != nil {
+		return fmt.Errorf("ReleaseInvoice: %w", err)
+	}
+	return nil
+}
+
+// ExpireOldInvoices marks the invoices that were handed out but not paid as
+// expired once their expiry is past, so payments to them are refused. It's
+// also run when generating new secrets. It returns the number of invoices
+// expired.
+func ExpireOldInvoices() (_ int64, err error) {
+	defer recordErrors("ExpireOldInvoices", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	return expireOldInvoices(db, walletNow())
+}
+
+func expireOldInvoices(db *walletdb.DB, now time.Time) (int64, error) {
+	invoices, err := db.ListInvoices()
+	if err != nil {
+		return 0, fmt.Errorf("ExpireOldInvoices: %w", err)
+	}
+
+	var expired int64
+	for i := range invoices {
+		invoice := &invoices[i]
+		pending := invoice.State == walletdb.InvoiceStateUsed || invoice.State == walletdb.InvoiceStatePendingUse
+		if !pending || !invoiceExpired(invoice, now) {
+			continue
+		}
+
+		if err := saveInvoiceState(db, invoice, walletdb.InvoiceStateExpired); err != nil {
+			return expired, fmt.Errorf("ExpireOldInvoices: %w", err)
+		}
+		// the parts of a payment that didn't complete won't be fulfilled
+		if _, err := db.DeleteMppParts(invoice.PaymentHash); err != nil {
+			return expired, fmt.Errorf("ExpireOldInvoices: %w", err)
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// invoiceExpired returns whether the invoice expiry plus the grace period is
+// past. Invoices issued before expiries were stored are assumed to have the
+// default one.
+func invoiceExpired(invoice *walletdb.Invoice, now time.Time) bool {
+	var expiresAt time.Time
+	switch {
+	case invoice.ExpiresAt != nil:
+		expiresAt = *invoice.ExpiresAt
+	case invoice.UsedAt != nil:
+		expiresAt = invoice.UsedAt.Add(defaultInvoiceExpiry)
+	default:
+		return false
+	}
+	return now.After(expiresAt.Add(invoiceExpiryGrace))
+}
+
 func (s *IncomingSwap) getInvoice() (*walletdb.Invoice, error) {
 	db, err := openDB()
 	if err != nil {
@@ -310,23 +1005,95 @@ func (s *IncomingSwap) getInvoice() (*walletdb.Invoice, error) {
 	}
 	defer db.Close()
 
-	return db.FindByPaymentHash(s.PaymentHash)
+	return findFulfillableInvoice(db, s.PaymentHash)
 }
 
-func (s *IncomingSwap) VerifyFulfillable(userKey *HDPrivateKey, net *Network) error {
+// findFulfillableInvoice returns the invoice with the payment hash, unless
+// its state keeps payments to it from being fulfilled.
+func findFulfillableInvoice(db *walletdb.DB, paymentHash []byte) (*walletdb.Invoice, error) {
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.State == walletdb.InvoiceStateImported {
+		return nil, fmt.Errorf("invoice was imported from another node and can't receive payments")
+	}
+	if invoice.State == walletdb.InvoiceStateRefunded {
+		return nil, fmt.Errorf("invoice htlc was refunded to the swap server")
+	}
+	if invoice.State == walletdb.InvoiceStateCancelled {
+		return nil, fmt.Errorf("invoice was cancelled")
+	}
+	if invoice.State == walletdb.InvoiceStateExpired {
+		return nil, fmt.Errorf("invoice expired")
+	}
+	if invoice.State == walletdb.InvoiceStateAbandoned {
+		return nil, fmt.Errorf("invoice swap was abandoned")
+	}
+	if invoice.State == walletdb.InvoiceStateSuperseded {
+		return nil, fmt.Errorf("invoice secrets were superseded")
+	}
+	return invoice, nil
+}
+
+// cltvSafetyDelta returns the minimum number of blocks between the chain tip
+// and the expiry of a payment to the invoice. It's capped to the invoice cltv
+// expiry, so raising MinCltvSafetyDelta doesn't make invoices issued before
+// unfulfillable.
+func cltvSafetyDelta(invoice *walletdb.Invoice) int64 {
+	delta := minCltvSafetyDelta()
+	if invoice.CltvExpiry != 0 && invoice.CltvExpiry < delta {
+		return invoice.CltvExpiry
+	}
+	return delta
+}
+
+func (s *IncomingSwap) VerifyFulfillable(userKey *HDPrivateKey, net *Network) (err error) {
+	defer recordErrors("VerifyFulfillable", &err)
+	defer countRejectedFulfillment(&err)
+
+	return s.verifyFulfillable(userKey, net)
+}
+
+func (s *IncomingSwap) verifyFulfillable(userKey *HDPrivateKey, net *Network) error {
 	paymentHash := s.PaymentHash
 
 	if len(paymentHash) != 32 {
 		return fmt.Errorf("VerifyFulfillable: received invalid hash len %v", len(paymentHash))
 	}
+	// Malformed packets relayed by the server are rejected before decoding
+	if len(s.SphinxPacket) > 0 {
+		if err := checkSphinxPacket("VerifyFulfillable", s.SphinxPacket); err != nil {
+			return err
+		}
+	}
+
+	paymentAmount, err := s.PaymentAmount()
+	if err != nil {
+		return err
+	}
+
+	if err := s.recordKeysendInvoice(userKey, net); err != nil {
+		return fmt.Errorf("VerifyFulfillable: invalid keysend payment: %w", err)
+	}
+	// Errors are returned as they are to keep the incomplete set code
+	if err := s.recordAmpShard(userKey, net); err != nil {
+		return err
+	}
 
 	// Lookup invoice data matching this HTLC using the payment hash
 	invoice, err := s.getInvoice()
 	if err != nil {
 		return fmt.Errorf("VerifyFulfillable: could not find invoice data for payment hash: %w", err)
 	}
+	if err := checkNetwork(net, invoice.Network, "invoice"); err != nil {
+		return err
+	}
+	if err := checkNetwork(net, userKey.Network.Name(), "user key"); err != nil {
+		return err
+	}
 
-	identityKeyPath := hdpath.MustParse(invoice.KeyPath).Child(identityKeyChildIndex)
+	identityKeyPath := invoiceIdentityKeyPath(invoice)
 
 	nodeHDKey, err := userKey.DeriveTo(identityKeyPath.String())
 	if err != nil {
@@ -337,46 +1104,88 @@ func (s *IncomingSwap) VerifyFulfillable(userKey *HDPrivateKey, net *Network) er
 		return fmt.Errorf("VerifyFulfillable: failed to get priv key: %w", err)
 	}
 
-	// implementation is allowed to send a few extra sats
-	if invoice.AmountSat != 0 && invoice.AmountSat > s.PaymentAmountSat {
-		return fmt.Errorf("VerifyFulfillable: payment amount (%v) does not match invoice amount (%v)",
-			s.PaymentAmountSat, invoice.AmountSat)
+	if len(s.SphinxPacket) == 0 {
+		return verifyInvoiceAmount(invoice, s.PaymentAmountSat)
 	}
 
-	if len(s.SphinxPacket) == 0 {
-		return nil
+	// Reject payments that expire too close to the chain tip, since we might
+	// not be able to claim them on-chain in time
+	var minCltvExpiry uint32
+	if s.BlockHeight != 0 {
+		minCltvExpiry = uint32(s.BlockHeight + cltvSafetyDelta(invoice))
 	}
 
-	err = sphinx.Validate(
+	part, err := sphinx.ValidatePart(
 		s.SphinxPacket,
 		paymentHash,
 		invoice.PaymentSecret,
 		nodeKey,
 		0, // This is used internally by the sphinx decoder but it's not needed
-		lnwire.MilliSatoshi(uint64(s.PaymentAmountSat)*1000),
+		minCltvExpiry,
+		paymentAmount.toMilliSatoshi(),
 		net.network,
 	)
 	if err != nil {
+		countSecurityEvent(SecurityEventSphinxValidation)
 		return fmt.Errorf("VerifyFulfillable: invalid sphinx: %w", err)
 	}
 
-	return nil
+	if part == nil {
+		return verifyInvoiceAmount(invoice, s.PaymentAmountSat)
+	}
+
+	// Parts of a multi-part payment are checked against the invoice amount
+	// with the total of the payment
+	if err := verifyInvoiceAmount(invoice, int64(part.TotalMsat.ToSatoshis())); err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Errors are returned as they are to keep the incomplete set code
+	return recordMppPart(db, paymentHash, s.SphinxPacket, part, s.BlockHeight)
+}
+
+// verifyInvoiceAmount checks the amount paid covers the invoice amount, if it
+// has one, without exceeding it by more than the tolerated overpayment.
+func verifyInvoiceAmount(invoice *walletdb.Invoice, paidSat int64) error {
+	// implementation is allowed to send a few extra sats
+	if invoice.AmountSat != 0 && invoice.AmountSat > paidSat {
+		return fmt.Errorf("VerifyFulfillable: payment amount (%v) does not match invoice amount (%v)",
+			paidSat, invoice.AmountSat)
+	}
+	return checkOverpayment(invoice, paidSat)
 }
 
 func (s *IncomingSwap) Fulfill(
 	data *IncomingSwapFulfillmentData,
 	userKey *HDPrivateKey, muunKey *HDPublicKey,
-	net *Network) (*IncomingSwapFulfillmentResult, error) {
+	net *Network) (_ *IncomingSwapFulfillmentResult, err error) {
+
+	defer recordErrors("Fulfill", &err)
+	defer countRejectedFulfillment(&err)
 
 	if s.Htlc == nil {
 		return nil, fmt.Errorf("Fulfill: missing swap htlc data")
 	}
 
-	err := s.VerifyFulfillable(userKey, net)
+	err = s.verifyFulfillable(userKey, net)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := validateAmountSat(s.CollectSat); err != nil {
+		return nil, err
+	}
+
+	if err := data.verifyFeeRate(); err != nil {
+		return nil, err
+	}
+
 	// Validate the fullfillment tx proposed by Muun.
 	tx := wire.MsgTx{}
 	err = tx.DeserializeNoWitness(bytes.NewReader(data.FulfillmentTx))
@@ -395,6 +1204,22 @@ func (s *IncomingSwap) Fulfill(
 	if err != nil {
 		return nil, fmt.Errorf("Fulfill: could not find invoice data for payment hash: %w", err)
 	}
+	if err := s.checkHeld("Fulfill"); err != nil {
+		return nil, err
+	}
+
+	if tx.TxOut[0].Value < dustThreshold {
+		if err := s.checkDustPolicy(data.DustPolicy); err != nil {
+			return nil, err
+		}
+		if err := s.markSettled(); err != nil {
+			return nil, fmt.Errorf("Fulfill: could not mark invoice as settled: %w", err)
+		}
+		return &IncomingSwapFulfillmentResult{
+			FulfillmentTx: nil,
+			Preimage:      invoice.Preimage,
+		}, nil
+	}
 
 	// Sign the htlc input (there is only one, at index 0)
 	coin := coinIncomingSwap{
@@ -413,20 +1238,57 @@ func (s *IncomingSwap) Fulfill(
 		return nil, err
 	}
 
+	prevOut, err := htlcPrevOutput(s.Htlc.HtlcTx, int(tx.TxIn[0].PreviousOutPoint.Index))
+	if err != nil {
+		return nil, fmt.Errorf("Fulfill: %w", err)
+	}
+	if err := verifySignedInputs(&tx, []*wire.TxOut{prevOut}); err != nil {
+		return nil, fmt.Errorf("Fulfill: signed fulfillment tx is invalid: %w", err)
+	}
+	if err := data.verifyFulfillmentFee(&tx, prevOut, s.CollectSat); err != nil {
+		return nil, err
+	}
+
 	// Serialize and return the signed fulfillment tx
 	var buf bytes.Buffer
 	err = tx.Serialize(&buf)
 	if err != nil {
 		return nil, fmt.Errorf("Fulfill: could not serialize fulfillment tx: %w", err)
 	}
+
+	err = s.markSettled()
+	if err != nil {
+		return nil, fmt.Errorf("Fulfill: could not mark invoice as settled: %w", err)
+	}
+
 	return &IncomingSwapFulfillmentResult{
 		FulfillmentTx: buf.Bytes(),
 		Preimage:      invoice.Preimage,
 	}, nil
 }
 
+// checkDustPolicy returns nil if the remaining amount of a fulfillment with a
+// sub-dust output can be rolled into fees under the given policy. Only the
+// payment amount verified against the sphinx is trusted to be dust, not the
+// fulfillment tx output.
+func (s *IncomingSwap) checkDustPolicy(policy string) error {
+	switch policy {
+	case "", DustPolicyReject:
+		return fmt.Errorf("Fulfill: fulfillment output is below the dust threshold (%v sats)", dustThreshold)
+	case DustPolicyRollIntoFees:
+		if remaining := s.PaymentAmountSat - s.CollectSat; remaining < 0 || remaining >= dustThreshold {
+			return fmt.Errorf("Fulfill: can't roll %v sats into fees, payment of %v sats with %v sats collect",
+				remaining, s.PaymentAmountSat, s.CollectSat)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Fulfill: unknown dust policy %v", policy)
+	}
+}
+
 // FulfillFullDebt gives the preimage matching a payment hash if we have it
-func (s *IncomingSwap) FulfillFullDebt() (*IncomingSwapFulfillmentResult, error) {
+func (s *IncomingSwap) FulfillFullDebt() (_ *IncomingSwapFulfillmentResult, err error) {
+	defer recordErrors("FulfillFullDebt", &err)
 
 	// Lookup invoice data matching this HTLC using the payment hash
 	db, err := openDB()
@@ -439,6 +1301,14 @@ func (s *IncomingSwap) FulfillFullDebt() (*IncomingSwapFulfillmentResult, error)
 	if err != nil {
 		return nil, fmt.Errorf("FulfillFullDebt: could not find invoice data for payment hash: %w", err)
 	}
+	if err := s.checkHeld("FulfillFullDebt"); err != nil {
+		return nil, err
+	}
+
+	err = saveInvoiceState(db, secrets, walletdb.InvoiceStateSettled)
+	if err != nil {
+		return nil, fmt.Errorf("FulfillFullDebt: could not mark invoice as settled: %w", err)
+	}
 
 	return &IncomingSwapFulfillmentResult{
 		FulfillmentTx: nil,
@@ -446,8 +1316,145 @@ func (s *IncomingSwap) FulfillFullDebt() (*IncomingSwapFulfillmentResult, error)
 	}, nil
 }
 
+// markSettled records that the preimage for this swap's invoice has been
+// handed out to fulfill the payment.
+func (s *IncomingSwap) markSettled() error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(s.PaymentHash)
+	if err != nil {
+		return err
+	}
+
+	return saveInvoiceState(db, invoice, walletdb.InvoiceStateSettled)
+}
+
+// EncryptInvoicePreimage returns the preimage of a settled invoice encrypted
+// to the given receiver key, to be used as proof of payment by the payer.
+// The payload is signed with the invoice identity key, so the receiver can
+// check its authenticity against the node pubkey found in the invoice.
+func EncryptInvoicePreimage(paymentHash []byte, receiverKey *HDPublicKey, userKey *HDPrivateKey) (_ string, err error) {
+	defer recordErrors("EncryptInvoicePreimage", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return "", fmt.Errorf("EncryptInvoicePreimage: could not find invoice data for payment hash: %w", err)
+	}
+	if invoice.State != walletdb.InvoiceStateSettled {
+		return "", fmt.Errorf("EncryptInvoicePreimage: invoice is not settled (state %v)", invoice.State)
+	}
+
+	identityKeyPath := invoiceIdentityKeyPath(invoice)
+	identityKey, err := userKey.DeriveTo(identityKeyPath.String())
+	if err != nil {
+		return "", fmt.Errorf("EncryptInvoicePreimage: failed to derive identity key: %w", err)
+	}
+
+	ciphertext, err := identityKey.EncrypterTo(receiverKey).Encrypt(invoice.Preimage)
+	if err != nil {
+		return "", fmt.Errorf("EncryptInvoicePreimage: failed to encrypt preimage: %w", err)
+	}
+
+	return ciphertext, nil
+}
+
+// GetSettlementPreimage returns the preimage of a settled or imported
+// invoice, the proof it was paid, for the user to hand to a merchant or
+// support. Unlike EncryptInvoicePreimage, it's returned in the clear.
+func GetSettlementPreimage(paymentHash []byte) (_ []byte, err error) {
+	defer recordErrors("GetSettlementPreimage", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return nil, fmt.Errorf("GetSettlementPreimage: could not find invoice data for payment hash: %w", err)
+	}
+	if invoice.State != walletdb.InvoiceStateSettled && invoice.State != walletdb.InvoiceStateImported {
+		return nil, fmt.Errorf("GetSettlementPreimage: invoice is not settled (state %v)", invoice.State)
+	}
+
+	return invoice.Preimage, nil
+}
+
 func openDB() (*walletdb.DB, error) {
-	return walletdb.Open(path.Join(cfg.DataDir, "wallet.db"))
+	path := dbPath()
+
+	db, err := attachDBAt(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DeferMigrations {
+		err = checkNoPendingMigrations(db)
+	} else {
+		err = runMigrations(db, path, nil)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// runMigrations applies the pending migrations to the db at path, along with
+// the decoys of hidden wallet dbs, see createHiddenWalletDB.
+func runMigrations(db *walletdb.DB, path string, progress func(applied, total int)) error {
+	hidden := isHiddenWalletDB(path)
+
+	var pending bool
+	if hidden {
+		var err error
+		if pending, err = db.PendingMigrations(); err != nil {
+			return err
+		}
+	}
+	if err := db.RunMigrations(progress); err != nil {
+		return err
+	}
+	if pending {
+		return migrateDecoyWalletDBs(path)
+	}
+	return nil
+}
+
+// attachDB opens the db of the active wallet without running migrations.
+func attachDB() (*walletdb.DB, error) {
+	return attachDBAt(dbPath())
+}
+
+// attachDBAt opens the db at path without running migrations. Callers that
+// need the path themselves resolve it once and use this, so a concurrent
+// context switch can't make them open another wallet's db.
+func attachDBAt(path string) (*walletdb.DB, error) {
+	db, err := walletdb.Attach(path, cfg.DeviceSecret)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SlowQueryThresholdMillis > 0 {
+		threshold := time.Duration(cfg.SlowQueryThresholdMillis) * time.Millisecond
+		db.RecordSlowQueries(threshold, MaxJournalEntries)
+	}
+	return db, nil
+}
+
+// dbPath returns the path of the db of the active wallet.
+func dbPath() string {
+	return walletDBPath(ActiveWalletContext())
 }
 
 func parsePubKey(s string) (*btcec.PublicKey, error) {
@@ -468,6 +1475,7 @@ func verifyTxWitnessSignature(tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, o
 		return err
 	}
 	if !signature.Verify(sigHash, signKey) {
+		countSecurityEvent(SecurityEventSignatureVerification)
 		return errors.New("signature does not verify")
 	}
 	return nil
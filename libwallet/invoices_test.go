@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/lightningnetwork/lnd/record"
@@ -18,6 +20,8 @@ import (
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/zpay32"
 	"github.com/muun/libwallet/hdpath"
+	muunsphinx "github.com/muun/libwallet/sphinx"
+	"github.com/muun/libwallet/walletdb"
 )
 
 func TestInvoiceSecrets(t *testing.T) {
@@ -128,6 +132,1019 @@ func TestInvoiceSecrets(t *testing.T) {
 
 }
 
+func TestPersistInvoiceSecretsIsAtomic(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last secret of the batch repeats the payment hash of the first one
+	secrets.secrets = append(secrets.secrets, secrets.secrets[0])
+	if err := PersistInvoiceSecrets(secrets); err == nil {
+		t.Fatal("expected error persisting a duplicate payment hash")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	invoices, err := db.ListInvoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 0 {
+		t.Fatalf("expected no secrets stored, got %v", len(invoices))
+	}
+}
+
+func TestInvoiceSecretsPoolSize(t *testing.T) {
+	setup()
+	defer setup()
+	cfg.UnusedSecretsPoolSize = 8
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets.Length() != 8 {
+		t.Fatalf("expected 8 new secrets, got %d", secrets.Length())
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	// a smaller batch doesn't shrink the configured pool
+	secrets, err = GenerateInvoiceSecretsBatch(userKey.PublicKey(), muunKey.PublicKey(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets.Length() != 0 {
+		t.Fatalf("expected no new secrets, got %d", secrets.Length())
+	}
+
+	secrets, err = GenerateInvoiceSecretsBatch(userKey.PublicKey(), muunKey.PublicKey(), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets.Length() != 12 {
+		t.Fatalf("expected 12 new secrets, got %d", secrets.Length())
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err = GenerateInvoiceSecretsBatch(userKey.PublicKey(), muunKey.PublicKey(), MaxUnusedSecretsPoolSize+10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets.Length() != MaxUnusedSecretsPoolSize-20 {
+		t.Fatalf("expected %d new secrets, got %d", MaxUnusedSecretsPoolSize-20, secrets.Length())
+	}
+
+	if _, err := GenerateInvoiceSecretsBatch(userKey.PublicKey(), muunKey.PublicKey(), -1); err == nil {
+		t.Fatal("expected negative pool size to fail")
+	}
+}
+
+func TestCreateInvoiceWithFallbackAddress(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	fallbackAddress := newAddressAt(userKey, muunKey, "m/schema:1'/recovery:1'/external:1/0", network)
+
+	walletAddress, err := CreateFallbackAddress(userKey.PublicKey(), muunKey.PublicKey(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walletAddress.Address() != fallbackAddress.String() {
+		t.Fatalf("expected fallback address %v, got %v", fallbackAddress, walletAddress.Address())
+	}
+	if _, err := CreateFallbackAddress(userKey.PublicKey(), muunKey.PublicKey(), -1); err == nil {
+		t.Fatal("expected error with negative fallback address index")
+	}
+
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		FallbackAddress: walletAddress.Address(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payreq.FallbackAddr == nil || payreq.FallbackAddr.String() != fallbackAddress.String() {
+		t.Fatalf("expected invoice fallback address to match, got %v", payreq.FallbackAddr)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dbInvoice, err := db.FindByPaymentHash(payreq.PaymentHash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbInvoice.FallbackAddress != fallbackAddress.String() {
+		t.Fatalf("expected fallback address to be stored, got %v", dbInvoice.FallbackAddress)
+	}
+
+	_, err = CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		FallbackAddress: "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq",
+	})
+	if err == nil {
+		t.Fatal("expected error with fallback address for another network")
+	}
+}
+
+func TestCreateInvoiceWithExpiry(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	tests := []struct {
+		expirySeconds int64
+		want          time.Duration
+	}{
+		{0, time.Hour},
+		{60, time.Minute},
+		{7 * 24 * 3600, 7 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+			ExpirySeconds: tt.expirySeconds,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payreq, err := zpay32.Decode(invoice, network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payreq.Expiry() != tt.want {
+			t.Fatalf("expected expiry %v, got %v", tt.want, payreq.Expiry())
+		}
+	}
+
+	_, err = CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		ExpirySeconds: -1,
+	})
+	if err == nil {
+		t.Fatal("expected error with negative expiry")
+	}
+}
+
+func TestCreateInvoiceWithCltvExpiry(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	tests := []struct {
+		cltvExpiryBlocks int64
+		want             uint64
+	}{
+		{0, DefaultCltvExpiryBlocks},
+		{DefaultMinCltvSafetyDelta + 1, DefaultMinCltvSafetyDelta + 1},
+		{MaxCltvExpiryBlocks - 8, MaxCltvExpiryBlocks - 8},
+	}
+	for _, tt := range tests {
+		invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+			CltvExpiryBlocks: tt.cltvExpiryBlocks,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payreq, err := zpay32.Decode(invoice, network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payreq.MinFinalCLTVExpiry() != tt.want {
+			t.Fatalf("expected cltv expiry %v, got %v", tt.want, payreq.MinFinalCLTVExpiry())
+		}
+	}
+
+	// The server recommended cltv expiry is used unless one is given, and is
+	// persisted along with the invoice
+	routeHints.FinalCltvExpiryDelta = 144
+	for _, opts := range []*InvoiceOptions{{}, {CltvExpiryBlocks: 40}} {
+		invoice, err := CreateInvoice(network, userKey, routeHints, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payreq, err := zpay32.Decode(invoice, network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := uint64(144)
+		if opts.CltvExpiryBlocks != 0 {
+			want = uint64(opts.CltvExpiryBlocks)
+		}
+		if payreq.MinFinalCLTVExpiry() != want {
+			t.Fatalf("expected cltv expiry %v, got %v", want, payreq.MinFinalCLTVExpiry())
+		}
+
+		db, err := openDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbInvoice, err := db.FindByPaymentHash(payreq.PaymentHash[:])
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dbInvoice.CltvExpiry != int64(want) {
+			t.Fatalf("expected cltv expiry %v to be stored, got %v", want, dbInvoice.CltvExpiry)
+		}
+	}
+
+	secrets, err = GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints.FinalCltvExpiryDelta = MaxCltvExpiryBlocks + 1
+	_, err = CreateInvoice(network, userKey, routeHints, &InvoiceOptions{})
+	if err == nil {
+		t.Fatal("expected error with invalid recommended cltv expiry")
+	}
+	routeHints.FinalCltvExpiryDelta = 0
+
+	// the last one is above the budget of the route hint
+	invalid := []int64{-1, DefaultMinCltvSafetyDelta, MaxCltvExpiryBlocks + 1, MaxCltvExpiryBlocks - 7}
+	for _, cltvExpiryBlocks := range invalid {
+		_, err = CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+			CltvExpiryBlocks: cltvExpiryBlocks,
+		})
+		if err == nil {
+			t.Fatalf("expected error with cltv expiry %v", cltvExpiryBlocks)
+		}
+	}
+}
+
+func TestCreateInvoiceWithCltvExpiryBudget(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hint := func(cltvExpiryDelta int32) *RouteHints {
+		return &RouteHints{
+			Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: 1000,
+			CltvExpiryDelta:           cltvExpiryDelta,
+			FinalCltvExpiryDelta:      144,
+		}
+	}
+	routeHints := &RouteHintsList{}
+	routeHints.Add(hint(8))
+	routeHints.Add(hint(40))
+
+	// it takes precedence over the recommended one
+	invoice, err := CreateInvoiceWithRouteHints(network, userKey, routeHints, &InvoiceOptions{
+		CltvExpiryBlocks: MaxCltvExpiryBlocks - 40,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payreq.MinFinalCLTVExpiry() != MaxCltvExpiryBlocks-40 {
+		t.Fatalf("expected cltv expiry %v, got %v", MaxCltvExpiryBlocks-40, payreq.MinFinalCLTVExpiry())
+	}
+
+	invalid := []*InvoiceOptions{
+		// above the budget of the second hint
+		{CltvExpiryBlocks: MaxCltvExpiryBlocks - 39},
+		{CltvExpiryBlocks: DefaultMinCltvSafetyDelta},
+	}
+	for _, opts := range invalid {
+		_, err = CreateInvoiceWithRouteHints(network, userKey, routeHints, opts)
+		if err == nil {
+			t.Fatalf("expected error with cltv expiry %v", opts.CltvExpiryBlocks)
+		}
+	}
+}
+
+func TestCreateInvoiceWithDescriptionHash(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	descriptionHash := sha256.Sum256([]byte(`{"order": 1234, "items": ["coffee", "croissant"]}`))
+
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		DescriptionHash: descriptionHash[:],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseInvoice(invoice, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.DescriptionHash, descriptionHash[:]) {
+		t.Fatalf("expected description hash %x, got %x", descriptionHash, parsed.DescriptionHash)
+	}
+	if parsed.Description != "" {
+		t.Fatalf("expected no description, got %v", parsed.Description)
+	}
+
+	_, err = CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		Description:     "coffee",
+		DescriptionHash: descriptionHash[:],
+	})
+	if err == nil {
+		t.Fatal("expected error with both description and description hash")
+	}
+
+	_, err = CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		DescriptionHash: descriptionHash[:16],
+	})
+	if err == nil {
+		t.Fatal("expected error with short description hash")
+	}
+}
+
+func TestCreateInvoiceWithRouteHints(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupNode, _ := NewHDPrivateKey(randomBytes(32), network)
+
+	routeHints := &RouteHintsList{}
+	routeHints.Add(&RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	})
+	routeHints.Add(&RouteHints{
+		Pubkey:                    hex.EncodeToString(backupNode.PublicKey().Raw()),
+		FeeBaseMsat:               2000,
+		FeeProportionalMillionths: 500,
+		CltvExpiryDelta:           40,
+	})
+
+	invoice, err := CreateInvoiceWithRouteHints(network, userKey, routeHints, &InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payreq.RouteHints) != routeHints.Length() {
+		t.Fatalf("expected %v route hints, got %v", routeHints.Length(), len(payreq.RouteHints))
+	}
+	for i, route := range payreq.RouteHints {
+		expected := routeHints.Get(i)
+		if len(route) != 1 {
+			t.Fatalf("expected single hop route hints, got %v hops", len(route))
+		}
+		hop := route[0]
+		if hex.EncodeToString(hop.NodeID.SerializeCompressed()) != expected.Pubkey {
+			t.Fatalf("expected route hint node %v, got %x", expected.Pubkey, hop.NodeID.SerializeCompressed())
+		}
+		if int64(hop.FeeBaseMSat) != expected.FeeBaseMsat || int32(hop.CLTVExpiryDelta) != expected.CltvExpiryDelta {
+			t.Fatalf("unexpected route hint policy %+v", hop)
+		}
+		if hop.ChannelID != payreq.RouteHints[0][0].ChannelID {
+			t.Fatal("expected every route hint to use the invoice short channel id")
+		}
+	}
+
+	_, err = CreateInvoiceWithRouteHints(network, userKey, &RouteHintsList{}, &InvoiceOptions{})
+	if err == nil {
+		t.Fatal("expected error without route hints")
+	}
+}
+
+func TestCancelInvoice(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shown := payreq.PaymentHash[:]
+
+	if err := CancelInvoice(shown); err != nil {
+		t.Fatal(err)
+	}
+	if err := CancelInvoice(shown); err != nil {
+		t.Fatalf("expected cancelling twice to succeed, got %v", err)
+	}
+	swap := &IncomingSwap{PaymentHash: shown}
+	if _, err := swap.getInvoice(); err == nil {
+		t.Fatal("expected cancelled invoice to refuse payments")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused, err := db.FindFirstUnusedInvoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if err := CancelInvoice(unused.PaymentHash); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := db.FindFirstUnusedInvoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(next.PaymentHash, unused.PaymentHash) {
+		t.Fatal("expected cancelled invoice to not be handed out")
+	}
+	count, err := db.CountUnusedInvoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != secrets.Length()-2 {
+		t.Fatalf("expected %v unused invoices, got %v", secrets.Length()-2, count)
+	}
+
+	next.State = walletdb.InvoiceStateSettled
+	if err := db.SaveInvoice(next); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if err := CancelInvoice(next.PaymentHash); err == nil {
+		t.Fatal("expected error cancelling a settled invoice")
+	}
+	if err := CancelInvoice(randomBytes(32)); err == nil {
+		t.Fatal("expected error cancelling an unknown invoice")
+	}
+}
+
+func TestAmendInvoice(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHintsList{}
+	routeHints.Add(&RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	})
+	invoice, err := CreateInvoiceWithRouteHints(network, userKey, routeHints, &InvoiceOptions{AmountSat: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+
+	amended, err := AmendInvoice(network, userKey, paymentHash, routeHints, &InvoiceOptions{
+		AmountSat:   10000,
+		Description: "fixed amount",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(amended, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payreq.PaymentHash[:], paymentHash) || !bytes.Equal(payreq.PaymentAddr[:], paymentSecret) {
+		t.Fatal("expected amended invoice to keep the payment hash and secret")
+	}
+	if payreq.MilliSat.ToSatoshis() != 10000 || *payreq.Description != "fixed amount" {
+		t.Fatalf("unexpected amount %v or description %v", payreq.MilliSat, *payreq.Description)
+	}
+
+	pay := func(amt int64) error {
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, amt, 1000),
+			PaymentAmountSat: amt,
+		}
+		return swap.VerifyFulfillable(userKey, network)
+	}
+	if err := pay(1000); err == nil {
+		t.Fatal("expected payment of the old amount to fail")
+	}
+	if err := pay(10000); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused, err := db.FindFirstUnusedInvoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := AmendInvoice(network, userKey, unused.PaymentHash, routeHints, &InvoiceOptions{}); err == nil {
+		t.Fatal("expected amending an unused secret to fail")
+	}
+
+	if err := CancelInvoice(paymentHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AmendInvoice(network, userKey, paymentHash, routeHints, &InvoiceOptions{}); err == nil {
+		t.Fatal("expected amending a cancelled invoice to fail")
+	}
+}
+
+func TestExpireOldInvoices(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{ExpirySeconds: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// an invoice handed out before expiries were stored
+	legacy, err := db.FindFirstUnusedInvoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	usedAt := time.Now().Add(-90 * time.Minute)
+	legacy.State = walletdb.InvoiceStateUsed
+	legacy.UsedAt = &usedAt
+	if err := db.SaveInvoice(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	expired, err := expireOldInvoices(db, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 0 {
+		t.Fatalf("expected no invoices expired within the grace period, got %v", expired)
+	}
+
+	expired, err = expireOldInvoices(db, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 2 {
+		t.Fatalf("expected 2 invoices expired, got %v", expired)
+	}
+
+	created, err := db.FindByPaymentHash(payreq.PaymentHash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.State != walletdb.InvoiceStateExpired {
+		t.Fatalf("expected invoice to be expired, got %v", created.State)
+	}
+	count, err := db.CountUnusedInvoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != secrets.Length()-2 {
+		t.Fatalf("expected unused invoices to be kept, got %v", count)
+	}
+
+	swap := &IncomingSwap{PaymentHash: payreq.PaymentHash[:]}
+	if _, err := swap.getInvoice(); err == nil {
+		t.Fatal("expected expired invoice to refuse payments")
+	}
+}
+
+type recordingInvoiceSigner struct {
+	userKey *HDPrivateKey
+	keyPath string
+	digest  []byte
+}
+
+func (s *recordingInvoiceSigner) SignInvoiceDigest(keyPath string, digest []byte) ([]byte, error) {
+	s.keyPath = keyPath
+	s.digest = digest
+	return (&hdKeyInvoiceSigner{s.userKey}).SignInvoiceDigest(keyPath, digest)
+}
+
+func TestCreateInvoiceWithSigner(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := &recordingInvoiceSigner{userKey: userKey}
+	invoice, err := CreateInvoiceWithSigner(network, signer, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedKeyPath := hdpath.MustParse(secrets.Get(0).keyPath).Child(identityKeyChildIndex)
+	if signer.keyPath != expectedKeyPath.String() {
+		t.Fatalf("expected signer to be asked for key %v, got %v", expectedKeyPath, signer.keyPath)
+	}
+	if len(signer.digest) != 32 {
+		t.Fatalf("expected signer to receive a 32 byte digest, got %v bytes", len(signer.digest))
+	}
+
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payreq.Destination.SerializeCompressed(), secrets.Get(0).IdentityKey.Raw()) {
+		t.Fatal("expected invoice to be signed by the identity key")
+	}
+}
+
+func TestStableNodeIdentity(t *testing.T) {
+	setup()
+	defer setup()
+
+	cfg.StableNodeIdentity = true
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	// the identity is kept across batches of secrets
+	for i := 0; i < 2; i++ {
+		secrets, err := GenerateInvoiceSecretsBatch(userKey.PublicKey(), muunKey.PublicKey(), int64(2*(i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PersistInvoiceSecrets(secrets); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var destination []byte
+	for i := 0; i < 3; i++ {
+		invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{AmountSat: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		payReq, err := zpay32.Decode(invoice, network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if destination == nil {
+			destination = payReq.Destination.SerializeCompressed()
+		} else if !bytes.Equal(destination, payReq.Destination.SerializeCompressed()) {
+			t.Fatal("expected invoices to share the destination node")
+		}
+
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 1000, 1000),
+			PaymentAmountSat: 1000,
+		}
+		if err := swap.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// invoices created without the option have their own identity
+	cfg.StableNodeIdentity = false
+	secrets, err := GenerateInvoiceSecretsBatch(userKey.PublicKey(), muunKey.PublicKey(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < secrets.Length(); i++ {
+		if bytes.Equal(secrets.Get(i).IdentityKey.Raw(), destination) {
+			t.Fatal("expected secrets without the option to have their own identity")
+		}
+	}
+}
+
+func TestInvoiceNetworkMismatch(t *testing.T) {
+	network := Regtest()
+
+	newKeys := func(net *Network) (*HDPrivateKey, *HDPrivateKey) {
+		userKey, _ := NewHDPrivateKey(randomBytes(32), net)
+		userKey.Path = "m/schema:1'/recovery:1'"
+		muunKey, _ := NewHDPrivateKey(randomBytes(32), net)
+		muunKey.Path = "m/schema:1'/recovery:1'"
+		return userKey, muunKey
+	}
+	persistSecrets := func(userKey, muunKey *HDPrivateKey) {
+		secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PersistInvoiceSecrets(secrets); err != nil {
+			t.Fatal(err)
+		}
+	}
+	routeHints := func(net string) *RouteHints {
+		return &RouteHints{
+			Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: 1000,
+			CltvExpiryDelta:           8,
+			Network:                   net,
+		}
+	}
+
+	t.Run("user key", func(t *testing.T) {
+		setup()
+		userKey, muunKey := newKeys(network)
+		persistSecrets(userKey, muunKey)
+
+		_, err := CreateInvoice(Mainnet(), userKey, routeHints(""), &InvoiceOptions{})
+		if ErrorCode(err) != ErrNetworkMismatch {
+			t.Fatalf("expected network mismatch, got %v", err)
+		}
+	})
+
+	t.Run("route hint node", func(t *testing.T) {
+		setup()
+		userKey, muunKey := newKeys(network)
+		persistSecrets(userKey, muunKey)
+
+		_, err := CreateInvoice(network, userKey, routeHints(Mainnet().Name()), &InvoiceOptions{})
+		if ErrorCode(err) != ErrNetworkMismatch {
+			t.Fatalf("expected network mismatch, got %v", err)
+		}
+		if _, err := CreateInvoice(network, userKey, routeHints(network.Name()), &InvoiceOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("invoice secrets", func(t *testing.T) {
+		setup()
+		persistSecrets(newKeys(Testnet()))
+		userKey, muunKey := newKeys(network)
+		persistSecrets(userKey, muunKey)
+
+		_, err := CreateInvoice(network, userKey, routeHints(""), &InvoiceOptions{})
+		if ErrorCode(err) != ErrNetworkMismatch {
+			t.Fatalf("expected network mismatch, got %v", err)
+		}
+	})
+
+	t.Run("payment", func(t *testing.T) {
+		setup()
+		userKey, muunKey := newKeys(network)
+		persistSecrets(userKey, muunKey)
+
+		invoice, err := CreateInvoice(network, userKey, routeHints(""), &InvoiceOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 1000, 1000),
+			PaymentAmountSat: 1000,
+		}
+
+		if err := swap.VerifyFulfillable(userKey, Mainnet()); ErrorCode(err) != ErrNetworkMismatch {
+			t.Fatalf("expected network mismatch, got %v", err)
+		}
+		if err := swap.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
 func TestFulfillHtlc(t *testing.T) {
 	setup()
 
@@ -414,17 +1431,195 @@ func TestFulfillHtlcWithCollect(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	swap.CollectSat = 0
-	_, err = swap.Fulfill(data, userKey, muunKey.PublicKey(), network)
+
+	swap.CollectSat = 0
+	_, err = swap.Fulfill(data, userKey, muunKey.PublicKey(), network)
+	if err == nil {
+		t.Fatal("expected 0 collect to fail")
+	}
+
+	signedTx := wire.NewMsgTx(2)
+	signedTx.Deserialize(bytes.NewReader(result.FulfillmentTx))
+
+	verifyInput(t, signedTx, hex.EncodeToString(swap.Htlc.HtlcTx), 0, 0)
+}
+
+func TestFulfillHtlcDustPolicy(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	// fulfill pays outputAmount to the wallet out of a payment of amt sats
+	fulfill := func(invoiceSecrets *InvoiceSecrets, amt, collected, outputAmount int64, policy string) (*IncomingSwapFulfillmentResult, error) {
+		swapServerPublicKey := randomBytes(32)
+		paymentHash := invoiceSecrets.PaymentHash
+		lockTime := int64(1000)
+
+		htlcKeyPath := hdpath.MustParse(invoiceSecrets.keyPath).Child(htlcKeyChildIndex)
+		userHtlcKey, err := userKey.DeriveTo(htlcKeyPath.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		muunHtlcKey, err := muunKey.DeriveTo(htlcKeyPath.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		htlcScript, err := createHtlcScript(
+			userHtlcKey.PublicKey().Raw(),
+			muunHtlcKey.PublicKey().Raw(),
+			swapServerPublicKey,
+			lockTime,
+			paymentHash,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		witnessHash := sha256.Sum256(htlcScript)
+		address, err := btcutil.NewAddressWitnessScriptHash(witnessHash[:], network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkScript, err := txscript.PayToAddrScript(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prevOutHash, err := chainhash.NewHash(randomBytes(32))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		htlcTx := wire.NewMsgTx(1)
+		htlcTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Hash: *prevOutHash},
+		})
+		htlcTx.AddTxOut(&wire.TxOut{
+			PkScript: pkScript,
+			Value:    amt,
+		})
+
+		nodePublicKey, err := invoiceSecrets.IdentityKey.key.ECPubKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fulfillmentTx := wire.NewMsgTx(1)
+		fulfillmentTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Hash: htlcTx.TxHash()},
+		})
+		addr := newAddressAt(userKey, muunKey, "m/schema:1'/recovery:1'/34/56", network)
+		fulfillmentTx.AddTxOut(&wire.TxOut{
+			PkScript: addr.ScriptAddress(),
+			Value:    outputAmount,
+		})
+
+		muunSignKey, err := muunHtlcKey.key.ECPrivKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		muunSignature, err := txscript.RawTxInWitnessSignature(
+			fulfillmentTx,
+			txscript.NewTxSigHashes(fulfillmentTx),
+			0,
+			amt,
+			htlcScript,
+			txscript.SigHashAll,
+			muunSignKey,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		swap := &IncomingSwap{
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, invoiceSecrets.paymentSecret, amt, lockTime),
+			PaymentHash:      paymentHash,
+			PaymentAmountSat: amt,
+			CollectSat:       collected,
+			Htlc: &IncomingSwapHtlc{
+				HtlcTx:              serializeTx(htlcTx),
+				ExpirationHeight:    lockTime,
+				SwapServerPublicKey: swapServerPublicKey,
+			},
+		}
+
+		data := &IncomingSwapFulfillmentData{
+			FulfillmentTx:      serializeTx(fulfillmentTx),
+			MuunSignature:      muunSignature,
+			ConfirmationTarget: 1,
+			DustPolicy:         policy,
+		}
+		return swap.Fulfill(data, userKey, muunKey.PublicKey(), network)
+	}
+
+	tests := []struct {
+		desc         string
+		collected    int64
+		outputAmount int64
+		policy       string
+		rolled       bool
+		fails        bool
+	}{
+		{"dust threshold is not dust", 1000, dustThreshold, DustPolicyReject, false, false},
+		{"dust threshold with roll into fees", 1000, dustThreshold, DustPolicyRollIntoFees, false, false},
+		{"below dust threshold is rejected by default", 1000, dustThreshold - 1, "", false, true},
+		{"below dust threshold is rejected", 1000, dustThreshold - 1, DustPolicyReject, false, true},
+		{"below dust threshold is rolled into fees", 1000, dustThreshold - 1, DustPolicyRollIntoFees, true, false},
+		{"zero output is rolled into fees", 1000, 0, DustPolicyRollIntoFees, true, false},
+		{"unknown policy", 1000, dustThreshold - 1, "keep", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			setup()
+
+			secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := PersistInvoiceSecrets(secrets); err != nil {
+				t.Fatal(err)
+			}
+
+			amt := tt.collected + tt.outputAmount
+			result, err := fulfill(secrets.Get(0), amt, tt.collected, tt.outputAmount, tt.policy)
+			if tt.fails {
+				if err == nil {
+					t.Fatal("expected fulfillment to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.rolled != (result.FulfillmentTx == nil) {
+				t.Fatalf("expected rolled into fees to be %v", tt.rolled)
+			}
+			if !bytes.Equal(result.Preimage, secrets.Get(0).preimage) {
+				t.Fatal("expected preimage to be handed out")
+			}
+		})
+	}
+
+	// A dust output can't be used to roll more than dust into fees, even if
+	// the collect says so
+	setup()
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+	_, err = fulfill(secrets.Get(0), 10000, 1000, dustThreshold-1, DustPolicyRollIntoFees)
 	if err == nil {
-		t.Fatal("expected 0 collect to fail")
+		t.Fatal("expected error rolling more than dust into fees")
 	}
-
-	signedTx := wire.NewMsgTx(2)
-	signedTx.Deserialize(bytes.NewReader(result.FulfillmentTx))
-
-	verifyInput(t, signedTx, hex.EncodeToString(swap.Htlc.HtlcTx), 0, 0)
 }
 
 func TestVerifyFulfillable(t *testing.T) {
@@ -500,8 +1695,127 @@ func TestVerifyFulfillable(t *testing.T) {
 			// ignore the rest of the parameters
 		}
 
-		if err := swap.VerifyFulfillable(userKey, network); err == nil {
-			t.Fatal("expected failure to fulfill mpp payment")
+		err := swap.VerifyFulfillable(userKey, network)
+		if ErrorCode(err) != ErrIncompleteMppSet {
+			t.Fatalf("expected failure to fulfill incomplete mpp payment, got %v", err)
+		}
+	})
+
+	t.Run("multi part payment", func(t *testing.T) {
+		invoice := createInvoice(&InvoiceOptions{AmountSat: 10000})
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		amt := int64(10000)
+		lockTime := int64(1000)
+
+		first := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createMppSphinxPacket(nodePublicKey, paymentHash, paymentSecret, amt, lockTime),
+			PaymentAmountSat: amt / 2,
+			// ignore the rest of the parameters
+		}
+		second := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createMppSphinxPacket(nodePublicKey, paymentHash, paymentSecret, amt, lockTime),
+			PaymentAmountSat: amt / 2,
+			// ignore the rest of the parameters
+		}
+
+		err := first.VerifyFulfillable(userKey, network)
+		if ErrorCode(err) != ErrIncompleteMppSet {
+			t.Fatalf("expected first part to be incomplete, got %v", err)
+		}
+		// Verifying a part again doesn't count it twice
+		err = first.VerifyFulfillable(userKey, network)
+		if ErrorCode(err) != ErrIncompleteMppSet {
+			t.Fatalf("expected first part to still be incomplete, got %v", err)
+		}
+
+		if err := second.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
+		}
+		if err := first.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("multi part payment with expired part", func(t *testing.T) {
+		invoice := createInvoice(&InvoiceOptions{AmountSat: 10000})
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		amt := int64(10000)
+
+		first := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createMppSphinxPacket(nodePublicKey, paymentHash, paymentSecret, amt, 1000),
+			PaymentAmountSat: amt / 2,
+		}
+		second := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createMppSphinxPacket(nodePublicKey, paymentHash, paymentSecret, amt, 2000),
+			PaymentAmountSat: amt / 2,
+			BlockHeight:      1000,
+		}
+
+		err := first.VerifyFulfillable(userKey, network)
+		if ErrorCode(err) != ErrIncompleteMppSet {
+			t.Fatalf("expected first part to be incomplete, got %v", err)
+		}
+		// the htlc of the first part expired by the time the second arrives
+		err = second.VerifyFulfillable(userKey, network)
+		if ErrorCode(err) != ErrIncompleteMppSet {
+			t.Fatalf("expected expired part not to complete the payment, got %v", err)
+		}
+	})
+
+	t.Run("multi part payment below invoice amount", func(t *testing.T) {
+		invoice := createInvoice(&InvoiceOptions{AmountSat: 20000})
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		amt := int64(10000)
+		lockTime := int64(1000)
+
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createMppSphinxPacket(nodePublicKey, paymentHash, paymentSecret, amt, lockTime),
+			PaymentAmountSat: amt / 2,
+			// ignore the rest of the parameters
+		}
+
+		err := swap.VerifyFulfillable(userKey, network)
+		if err == nil || ErrorCode(err) == ErrIncompleteMppSet {
+			t.Fatalf("expected failure to fulfill mpp payment below invoice amount, got %v", err)
+		}
+	})
+
+	t.Run("blind amount", func(t *testing.T) {
+		invoice := createInvoice(&InvoiceOptions{AmountSat: 10000, BlindAmount: true})
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		lockTime := int64(1000)
+
+		decoded, err := DecodeInvoice(network, invoice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.AmountMsat != 0 {
+			t.Fatalf("expected invoice without amount, got %v msats", decoded.AmountMsat)
+		}
+
+		underpaid := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 9999, lockTime),
+			PaymentAmountSat: 9999,
+			// ignore the rest of the parameters
+		}
+		if err := underpaid.VerifyFulfillable(userKey, network); err == nil {
+			t.Fatal("expected failure to fulfill payment below the blind amount")
+		}
+
+		paid := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 10000, lockTime),
+			PaymentAmountSat: 10000,
+			// ignore the rest of the parameters
+		}
+		if err := paid.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
 		}
 	})
 
@@ -628,6 +1942,46 @@ func TestVerifyFulfillable(t *testing.T) {
 		}
 	})
 
+	t.Run("cltv too close to chain tip", func(t *testing.T) {
+		invoice := createInvoice(&InvoiceOptions{})
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		amt := int64(10000)
+		lockTime := int64(1000)
+		onion := createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, amt, lockTime)
+
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     onion,
+			PaymentAmountSat: amt,
+			BlockHeight:      lockTime - DefaultMinCltvSafetyDelta + 1,
+			// ignore the rest of the parameters
+		}
+
+		if err := swap.VerifyFulfillable(userKey, network); err == nil {
+			t.Fatal("expected error with cltv too close to chain tip")
+		}
+	})
+
+	t.Run("cltv far from chain tip", func(t *testing.T) {
+		invoice := createInvoice(&InvoiceOptions{})
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		amt := int64(10000)
+		lockTime := int64(1000)
+		onion := createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, amt, lockTime)
+
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     onion,
+			PaymentAmountSat: amt,
+			BlockHeight:      lockTime - DefaultMinCltvSafetyDelta,
+			// ignore the rest of the parameters
+		}
+
+		if err := swap.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("invoice with amount", func(t *testing.T) {
 		invoice := createInvoice(&InvoiceOptions{
 			AmountSat: 20000,
@@ -670,6 +2024,48 @@ func newAddressAt(userKey, muunKey *HDPrivateKey, keyPath string, network *Netwo
 	return addr
 }
 
+func TestVerifyFulfillableMalformedSphinx(t *testing.T) {
+	setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+
+	nodeKey, _ := btcec.NewPrivateKey(btcec.S256())
+	valid := createSphinxPacket(nodeKey.PubKey(), randomBytes(32), randomBytes(32), 1000, 1000)
+
+	wrongVersion := append([]byte{}, valid...)
+	wrongVersion[0] = 1
+	offCurve := append([]byte{}, valid...)
+	offCurve[1] = 0x05
+
+	testCases := []struct {
+		desc   string
+		packet []byte
+		err    error
+	}{
+		{desc: "too short", packet: valid[:100], err: muunsphinx.ErrInvalidPacketLength},
+		{desc: "too long", packet: append(valid, 0), err: muunsphinx.ErrInvalidPacketLength},
+		{desc: "unknown version", packet: wrongVersion, err: muunsphinx.ErrUnknownPacketVersion},
+		{desc: "invalid ephemeral key", packet: offCurve, err: muunsphinx.ErrInvalidEphemeralKey},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			swap := &IncomingSwap{
+				PaymentHash:      randomBytes(32),
+				SphinxPacket:     tC.packet,
+				PaymentAmountSat: 1000,
+			}
+			err := swap.VerifyFulfillable(userKey, network)
+			if ErrorCode(err) != ErrInvalidSphinx {
+				t.Fatalf("expected invalid sphinx error, got %v", err)
+			}
+			if !errors.Is(err, tC.err) {
+				t.Fatalf("expected %v, got %v", tC.err, err)
+			}
+		})
+	}
+}
+
 func createSphinxPacket(nodePublicKey *btcec.PublicKey, paymentHash, paymentSecret []byte, amt, lockTime int64) []byte {
 	var paymentPath sphinx.PaymentPath
 	paymentPath[0].NodePub = *nodePublicKey
@@ -866,6 +2262,102 @@ func TestFulfillFullDebt(t *testing.T) {
 	}
 }
 
+func TestEncryptInvoicePreimage(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+	payerKey, _ := NewHDPrivateKey(randomBytes(32), network)
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		panic(err)
+	}
+	err = PersistInvoiceSecrets(secrets)
+	if err != nil {
+		panic(err)
+	}
+
+	invoice := secrets.Get(0)
+
+	_, err = EncryptInvoicePreimage(invoice.PaymentHash, payerKey.PublicKey(), userKey)
+	if err == nil {
+		t.Fatal("expected error revealing preimage of unsettled invoice")
+	}
+
+	swap := &IncomingSwap{
+		PaymentHash: invoice.PaymentHash,
+	}
+	_, err = swap.FulfillFullDebt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := EncryptInvoicePreimage(invoice.PaymentHash, payerKey.PublicKey(), userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identityKey, err := NewPublicKeyFromBytes(invoice.IdentityKey.Raw())
+	if err != nil {
+		t.Fatal(err)
+	}
+	preimage, err := payerKey.DecrypterFrom(identityKey).Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(preimage, invoice.preimage) {
+		t.Fatal("expected decrypted preimage to match")
+	}
+}
+
+func TestGetSettlementPreimage(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	invoice := secrets.Get(0)
+
+	if _, err := GetSettlementPreimage(invoice.PaymentHash); err == nil {
+		t.Fatal("expected error revealing preimage of unsettled invoice")
+	}
+	if _, err := GetSettlementPreimage(randomBytes(32)); err == nil {
+		t.Fatal("expected error for unknown payment hash")
+	}
+
+	swap := &IncomingSwap{
+		PaymentHash: invoice.PaymentHash,
+	}
+	if _, err := swap.FulfillFullDebt(); err != nil {
+		t.Fatal(err)
+	}
+
+	preimage, err := GetSettlementPreimage(invoice.PaymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := sha256.Sum256(preimage); !bytes.Equal(hash[:], invoice.PaymentHash) {
+		t.Fatal("expected preimage to match the payment hash")
+	}
+}
+
 func getInvoiceSecrets(invoice string, userKey *HDPrivateKey) (paymentHash []byte, paymentSecret []byte, identityKey *btcec.PublicKey) {
 	db, err := openDB()
 	if err != nil {
@@ -885,8 +2377,7 @@ func getInvoiceSecrets(invoice string, userKey *HDPrivateKey) (paymentHash []byt
 	paymentHash = payReq.PaymentHash[:]
 	paymentSecret = dbInvoice.PaymentSecret
 
-	keyPath := hdpath.MustParse(dbInvoice.KeyPath).Child(identityKeyChildIndex)
-	key, err := userKey.DeriveTo(keyPath.String())
+	key, err := userKey.DeriveTo(invoiceIdentityKeyPath(dbInvoice).String())
 	if err != nil {
 		panic(err)
 	}
@@ -896,3 +2387,104 @@ func getInvoiceSecrets(invoice string, userKey *HDPrivateKey) (paymentHash []byt
 	}
 	return
 }
+
+func TestInvoicePendingUse(t *testing.T) {
+	setup()
+	defer setup()
+
+	listener := &recordingInvoiceListener{}
+	cfg.InvoiceListener = listener
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+	stored := func(paymentHash []byte) (*walletdb.Invoice, int) {
+		db, err := openDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		invoice, err := db.FindByPaymentHash(paymentHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unused, err := db.CountUnusedInvoices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return invoice, unused
+	}
+
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{
+		AmountSat:  1000,
+		Metadata:   []byte("order 1"),
+		PendingUse: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, _, _ := getInvoiceSecrets(invoice, userKey)
+
+	pending, unused := stored(paymentHash)
+	if pending.State != walletdb.InvoiceStatePendingUse || unused != secrets.Length()-1 {
+		t.Fatalf("expected a pending invoice reserving its secret, got %v with %v unused", pending.State, unused)
+	}
+
+	// delivery failed, the secret goes back to the pool
+	listener.events = nil
+	if err := ReleaseInvoice(paymentHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseInvoice(paymentHash); err != nil {
+		t.Fatalf("expected releasing twice to be a no-op, got %v", err)
+	}
+	released, unused := stored(paymentHash)
+	if released.State != walletdb.InvoiceStateRegistered || unused != secrets.Length() {
+		t.Fatalf("expected a released invoice back in the pool, got %v with %v unused", released.State, unused)
+	}
+	if released.AmountSat != 0 || released.Metadata != nil || released.UsedAt != nil || released.ExpiresAt != nil {
+		t.Fatalf("expected a released invoice to forget how it was issued, got %+v", released)
+	}
+	if len(listener.events) != 1 || listener.events[0] != InvoiceEventReleased {
+		t.Fatalf("expected a released event, got %v", listener.events)
+	}
+	if err := ConfirmInvoiceUse(paymentHash); err == nil {
+		t.Fatal("expected confirming a released invoice to fail")
+	}
+
+	// delivery succeeded
+	invoice, err = CreateInvoice(network, userKey, routeHints, &InvoiceOptions{PendingUse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, _, _ = getInvoiceSecrets(invoice, userKey)
+	if err := ConfirmInvoiceUse(paymentHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfirmInvoiceUse(paymentHash); err != nil {
+		t.Fatalf("expected confirming twice to be a no-op, got %v", err)
+	}
+	if confirmed, _ := stored(paymentHash); confirmed.State != walletdb.InvoiceStateUsed {
+		t.Fatalf("expected a confirmed invoice to be used, got %v", confirmed.State)
+	}
+	if err := ReleaseInvoice(paymentHash); err == nil {
+		t.Fatal("expected releasing a used invoice to fail")
+	}
+}
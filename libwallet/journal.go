@@ -0,0 +1,133 @@
+package libwallet
+
+import (
+	"log"
+	"regexp"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// MaxJournalEntries is the number of errors kept in the error journal.
+const MaxJournalEntries = 100
+
+// redactedPatterns match data that must not be persisted in the journal:
+// extended keys and long hex strings such as preimages, hashes or keys.
+var redactedPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[xt](pub|prv)[1-9A-HJ-NP-Za-km-z]{100,}`),
+	regexp.MustCompile(`[0-9a-fA-F]{32,}`),
+}
+
+// Journal levels. Expected outcomes returned as errors, like an incomplete
+// mpp set or a held invoice, are recorded at the info level so they aren't
+// mistaken for failures.
+const (
+	JournalLevelError = string(walletdb.JournalLevelError)
+	JournalLevelInfo  = string(walletdb.JournalLevelInfo)
+)
+
+// JournalEntry is an error recorded in the error journal.
+type JournalEntry struct {
+	Level     string // JournalLevelError or JournalLevelInfo
+	Code      int64
+	Operation string
+	Message   string
+	Timestamp int64 // unix seconds
+}
+
+// JournalEntryList is a wrapper around a JournalEntry slice to be able to
+// pass through the gomobile bridge.
+type JournalEntryList struct {
+	entries []*JournalEntry
+}
+
+// Length returns the number of entries in the list.
+func (l *JournalEntryList) Length() int {
+	return len(l.entries)
+}
+
+// Get returns the entry at the given index.
+func (l *JournalEntryList) Get(i int) *JournalEntry {
+	return l.entries[i]
+}
+
+// GetRecentErrors returns the errors recorded in the error journal, the most
+// recent first, including the expected outcomes recorded at the info level.
+func GetRecentErrors() (*JournalEntryList, error) {
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	dbEntries, err := db.RecentJournalEntries(MaxJournalEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &JournalEntryList{}
+	for _, e := range dbEntries {
+		list.entries = append(list.entries, &JournalEntry{
+			Level:     string(e.Level),
+			Code:      e.Code,
+			Operation: e.Operation,
+			Message:   e.Message,
+			Timestamp: e.CreatedAt.Unix(),
+		})
+	}
+	return list, nil
+}
+
+// recordErrors stores *err in the error journal if it's not nil. It's meant
+// to be deferred by entry points using a named error result.
+func recordErrors(operation string, err *error) {
+	if *err != nil {
+		recordError(operation, *err)
+	}
+}
+
+// recordError stores the error in the error journal. Failing to do so is
+// logged but never reported to the caller, since the original error is more
+// relevant.
+func recordError(operation string, err error) {
+	if cfg == nil {
+		return
+	}
+
+	db, dbErr := openDB()
+	if dbErr != nil {
+		log.Printf("error opening the db to record error: %v", dbErr)
+		return
+	}
+	defer db.Close()
+
+	level := walletdb.JournalLevelError
+	if isExpectedOutcome(err) {
+		level = walletdb.JournalLevelInfo
+	}
+	dbErr = db.AppendJournalEntry(&walletdb.JournalEntry{
+		Level:     level,
+		Code:      ErrorCode(err),
+		Operation: operation,
+		Message:   redact(err.Error()),
+	}, MaxJournalEntries)
+	if dbErr != nil {
+		log.Printf("error recording error in the journal: %v", dbErr)
+	}
+}
+
+// isExpectedOutcome tells whether the error reports a normal state the
+// caller has to wait on or act upon, rather than a failure.
+func isExpectedOutcome(err error) bool {
+	switch ErrorCode(err) {
+	case ErrIncompleteAmpSet, ErrIncompleteMppSet, ErrInvoiceHeld:
+		return true
+	}
+	return false
+}
+
+func redact(message string) string {
+	for _, re := range redactedPatterns {
+		message = re.ReplaceAllString(message, "<redacted>")
+	}
+	return message
+}
@@ -0,0 +1,83 @@
+package libwallet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/muun/libwallet/errors"
+)
+
+func TestGetRecentErrors(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+
+	swap := &IncomingSwap{
+		PaymentHash: randomBytes(32),
+	}
+	if err := swap.VerifyFulfillable(userKey, network); err == nil {
+		t.Fatal("expected failure to fulfill non existant invoice")
+	}
+
+	entries, err := GetRecentErrors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries.Length() != 1 {
+		t.Fatalf("expected 1 journal entry, got %v", entries.Length())
+	}
+
+	entry := entries.Get(0)
+	if entry.Operation != "VerifyFulfillable" {
+		t.Fatalf("expected entry for VerifyFulfillable, got %v", entry.Operation)
+	}
+	if entry.Code != ErrUnknown {
+		t.Fatalf("expected unknown error code, got %v", entry.Code)
+	}
+	if entry.Level != JournalLevelError {
+		t.Fatalf("expected error level, got %v", entry.Level)
+	}
+	if entry.Timestamp == 0 {
+		t.Fatal("expected entry to have a timestamp")
+	}
+}
+
+func TestJournalLevels(t *testing.T) {
+	setup()
+
+	recordError("VerifyFulfillable", errors.Errorf(ErrIncompleteMppSet, "waiting for more parts"))
+	recordError("VerifyFulfillable", errors.Errorf(ErrInvoiceHeld, "invoice is held"))
+
+	entries, err := GetRecentErrors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries.Length() != 2 {
+		t.Fatalf("expected 2 journal entries, got %v", entries.Length())
+	}
+	for i := 0; i < entries.Length(); i++ {
+		if level := entries.Get(i).Level; level != JournalLevelInfo {
+			t.Fatalf("expected info level, got %v", level)
+		}
+	}
+
+	// expected outcomes don't make the health check warn about errors
+	for _, module := range HealthCheck().modules {
+		if module.Module == "errors" && module.Status != HealthStatusOk {
+			t.Fatalf("expected no recent errors, got %v", module.Detail)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	message := redact("failed for hash 31b35302d3e842a363f8992e423910bfb655b9cd6325b67f5c469fa8f2c4e55b " +
+		"and key tpubDBYMnFoxYLdMBZThTk4uARTe4kGPeEYWdKcaEzaUxt1cesetnxtTqmAxVkzDRou51emWytommyLWcF91SdF5KecA6Ja8oHK1FF7d5U2hMxX at index 12")
+
+	if strings.Contains(message, "31b35302") || strings.Contains(message, "tpubDBYM") {
+		t.Fatalf("expected sensitive data to be redacted, got %v", message)
+	}
+	if !strings.Contains(message, "at index 12") {
+		t.Fatalf("expected the rest of the message to be kept, got %v", message)
+	}
+}
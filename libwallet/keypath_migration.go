@@ -0,0 +1,153 @@
+package libwallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/muun/libwallet/hdpath"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// KeyPathMigration describes a change in the derivation scheme of invoice
+// secrets: every stored key path starting with FromPrefix is rewritten to
+// start with ToPrefix instead. The new paths must be under a branch known to
+// hdpath, with any schema number.
+type KeyPathMigration struct {
+	FromPrefix string
+	ToPrefix   string
+}
+
+// MigrateInvoiceKeyPaths applies the migration to every invoice secret in the
+// db and returns the number of rows updated. The keys must be labeled with the
+// paths of the new derivation scheme, eg m/schema:2'/recovery:1' when moving
+// invoices to schema 2, so every new path can be derived from them.
+//
+// Invoice secrets are registered with the remote server, so a migration must
+// not change the keys they use. registered holds the secrets as registered,
+// with at least the payment hash and keys of every migrated row. For each row
+// the identity and htlc keys are re-derived at the new paths and checked
+// against the registered ones, and a signature of the payment hash made with
+// the new identity private key is verified against the registered identity
+// key. If any row fails these checks, no row is updated.
+func MigrateInvoiceKeyPaths(m *KeyPathMigration, userKey *HDPrivateKey, muunKey *HDPublicKey, registered *InvoiceSecretsList) (_ int, err error) {
+	defer recordErrors("MigrateInvoiceKeyPaths", &err)
+
+	registeredByHash := make(map[string]*InvoiceSecrets)
+	if registered != nil {
+		for _, secrets := range registered.secrets {
+			registeredByHash[hex.EncodeToString(secrets.PaymentHash)] = secrets
+		}
+	}
+
+	if _, err := hdpath.Parse(m.FromPrefix); err != nil {
+		return 0, fmt.Errorf("MigrateInvoiceKeyPaths: invalid from prefix: %w", err)
+	}
+	if _, err := hdpath.Parse(m.ToPrefix); err != nil {
+		return 0, fmt.Errorf("MigrateInvoiceKeyPaths: invalid to prefix: %w", err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	migrated := 0
+	err = db.Transaction(func(tx *walletdb.DB) error {
+		invoices, err := tx.ListInvoices()
+		if err != nil {
+			return err
+		}
+
+		for i := range invoices {
+			invoice := &invoices[i]
+			if !strings.HasPrefix(invoice.KeyPath, m.FromPrefix) {
+				continue
+			}
+
+			oldKeyPath := invoice.KeyPath
+			invoice.KeyPath = m.migrate(invoice.KeyPath)
+			if err := hdpath.Validate(invoice.KeyPath); err != nil {
+				return fmt.Errorf("invalid key path %v: %w", invoice.KeyPath, err)
+			}
+			if strings.HasPrefix(invoice.IdentityKeyPath, m.FromPrefix) {
+				invoice.IdentityKeyPath = m.migrate(invoice.IdentityKeyPath)
+				if err := hdpath.Validate(invoice.IdentityKeyPath); err != nil {
+					return fmt.Errorf("invalid identity key path %v: %w", invoice.IdentityKeyPath, err)
+				}
+			}
+
+			secrets, ok := registeredByHash[hex.EncodeToString(invoice.PaymentHash)]
+			if !ok {
+				return fmt.Errorf("can't migrate key path %v: no registered keys for it", oldKeyPath)
+			}
+			err = verifyKeyPathMigration(invoice, secrets, userKey, muunKey)
+			if err != nil {
+				return fmt.Errorf("can't migrate key path %v: %w", oldKeyPath, err)
+			}
+
+			if err := tx.SaveInvoice(invoice); err != nil {
+				return err
+			}
+			migrated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("MigrateInvoiceKeyPaths: %w", err)
+	}
+
+	return migrated, nil
+}
+
+func (m *KeyPathMigration) migrate(keyPath string) string {
+	return m.ToPrefix + strings.TrimPrefix(keyPath, m.FromPrefix)
+}
+
+// verifyKeyPathMigration checks the keys of the invoice, derived at its new
+// paths, are the ones registered and sign for it.
+func verifyKeyPathMigration(invoice *walletdb.Invoice, registered *InvoiceSecrets, userKey *HDPrivateKey, muunKey *HDPublicKey) error {
+	identityKey, err := userKey.DeriveTo(invoiceIdentityKeyPath(invoice).String())
+	if err != nil {
+		return err
+	}
+	identityPrivKey, err := identityKey.key.ECPrivKey()
+	if err != nil {
+		return err
+	}
+	registeredIdentityKey, err := registered.IdentityKey.key.ECPubKey()
+	if err != nil {
+		return err
+	}
+	sig, err := identityPrivKey.Sign(invoice.PaymentHash)
+	if err != nil {
+		return err
+	}
+	if !sig.Verify(invoice.PaymentHash, registeredIdentityKey) {
+		return fmt.Errorf("identity key changed")
+	}
+
+	htlcKeyPath := hdpath.MustParse(invoice.KeyPath).Child(htlcKeyChildIndex).String()
+	if err := compareDerivedKey(userKey.PublicKey(), htlcKeyPath, registered.UserHtlcKey); err != nil {
+		return fmt.Errorf("user htlc key: %w", err)
+	}
+	if err := compareDerivedKey(muunKey, htlcKeyPath, registered.MuunHtlcKey); err != nil {
+		return fmt.Errorf("muun htlc key: %w", err)
+	}
+
+	return nil
+}
+
+// compareDerivedKey checks the key derived at path is the expected one.
+func compareDerivedKey(key *HDPublicKey, path string, expected *HDPublicKey) error {
+	derived, err := key.DeriveTo(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(derived.Raw(), expected.Raw()) {
+		return fmt.Errorf("key changed")
+	}
+	return nil
+}
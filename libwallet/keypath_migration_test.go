@@ -0,0 +1,227 @@
+package libwallet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateInvoiceKeyPaths(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPaths := func() []string {
+		db, err := openDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		invoices, err := db.ListInvoices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, invoice := range invoices {
+			paths = append(paths, invoice.KeyPath)
+		}
+		return paths
+	}
+
+	t.Run("moving to an unknown branch index is rejected", func(t *testing.T) {
+		before := keyPaths()
+
+		_, err := MigrateInvoiceKeyPaths(&KeyPathMigration{
+			FromPrefix: "m/schema:1'/recovery:1'/invoices:4",
+			ToPrefix:   "m/schema:1'/recovery:1'/invoices:5",
+		}, userKey, muunKey.PublicKey(), secrets)
+		if err == nil {
+			t.Fatal("expected migration to fail")
+		}
+
+		after := keyPaths()
+		for i := range before {
+			if before[i] != after[i] {
+				t.Fatalf("expected key paths not to change, got %v", after[i])
+			}
+		}
+	})
+
+	t.Run("relabeling to an unknown branch is rejected", func(t *testing.T) {
+		before := keyPaths()
+
+		_, err := MigrateInvoiceKeyPaths(&KeyPathMigration{
+			FromPrefix: "m/schema:1'/recovery:1'/invoices:4",
+			ToPrefix:   "m/schema:1'/recovery:1'/lightning:4",
+		}, userKey, muunKey.PublicKey(), secrets)
+		if err == nil {
+			t.Fatal("expected migration to fail")
+		}
+
+		after := keyPaths()
+		for i := range before {
+			if before[i] != after[i] {
+				t.Fatalf("expected key paths not to change, got %v", after[i])
+			}
+		}
+	})
+
+	t.Run("relabeling keeps keys", func(t *testing.T) {
+		// Every known branch has a single name, so the only relabeling left
+		// is the one that leaves paths as they are
+		migrated, err := MigrateInvoiceKeyPaths(&KeyPathMigration{
+			FromPrefix: "m/schema:1'/recovery:1'/invoices:4",
+			ToPrefix:   "m/schema:1'/recovery:1'/invoices:4",
+		}, userKey, muunKey.PublicKey(), secrets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migrated != secrets.Length() {
+			t.Fatalf("expected %v rows migrated, got %v", secrets.Length(), migrated)
+		}
+
+		for _, path := range keyPaths() {
+			if !strings.HasPrefix(path, "m/schema:1'/recovery:1'/invoices:4/") {
+				t.Fatalf("unexpected key path %v", path)
+			}
+		}
+
+		// invoices created after the migration must still be payable
+		invoice, err := CreateInvoice(network, userKey, &RouteHints{
+			Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: 1000,
+			CltvExpiryDelta:           8,
+		}, &InvoiceOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+		swap := &IncomingSwap{
+			PaymentHash:      paymentHash,
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 1000, 1000),
+			PaymentAmountSat: 1000,
+		}
+		if err := swap.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("invalid prefix", func(t *testing.T) {
+		_, err := MigrateInvoiceKeyPaths(&KeyPathMigration{
+			FromPrefix: "m/schema:1'",
+			ToPrefix:   "not a path",
+		}, userKey, muunKey.PublicKey(), secrets)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestMigrateInvoiceKeyPathsSchemaChange(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	const (
+		oldBasePath = "m/schema:1'/recovery:1'"
+		newBasePath = "m/schema:2'/recovery:1'"
+	)
+
+	userRootKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunRootKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey, _ := userRootKey.DeriveTo(oldBasePath)
+	muunKey, _ := muunRootKey.DeriveTo(oldBasePath)
+
+	// the new scheme only renames the paths, so the keys are the same ones
+	// labeled with the new base path
+	newUserKey, _ := NewHDPrivateKeyFromString(userKey.String(), newBasePath, network)
+	newMuunKey, _ := NewHDPrivateKeyFromString(muunKey.String(), newBasePath, network)
+	wrongUserKey, _ := userRootKey.DeriveTo(newBasePath)
+	wrongMuunKey, _ := muunRootKey.DeriveTo(newBasePath)
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	migration := &KeyPathMigration{FromPrefix: oldBasePath, ToPrefix: newBasePath}
+
+	// the keys labeled with the old scheme can't derive the new paths
+	if _, err := MigrateInvoiceKeyPaths(migration, userKey, muunKey.PublicKey(), secrets); err == nil {
+		t.Fatal("expected migration with the old keys to fail")
+	}
+
+	// the keys actually derived at the new paths aren't the registered ones
+	if _, err := MigrateInvoiceKeyPaths(migration, wrongUserKey, wrongMuunKey.PublicKey(), secrets); err == nil {
+		t.Fatal("expected migration changing the keys to fail")
+	}
+
+	// every migrated row must have been registered
+	partial := &InvoiceSecretsList{}
+	partial.Add(secrets.Get(0))
+	if _, err := MigrateInvoiceKeyPaths(migration, newUserKey, newMuunKey.PublicKey(), partial); err == nil {
+		t.Fatal("expected migration of unregistered rows to fail")
+	}
+	if _, err := MigrateInvoiceKeyPaths(migration, newUserKey, newMuunKey.PublicKey(), nil); err == nil {
+		t.Fatal("expected migration without registered keys to fail")
+	}
+
+	migrated, err := MigrateInvoiceKeyPaths(migration, newUserKey, newMuunKey.PublicKey(), secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != secrets.Length() {
+		t.Fatalf("expected %v rows migrated, got %v", secrets.Length(), migrated)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoices, err := db.ListInvoices()
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, invoice := range invoices {
+		if !strings.HasPrefix(invoice.KeyPath, newBasePath+"/invoices:4/") {
+			t.Fatalf("unexpected key path %v", invoice.KeyPath)
+		}
+	}
+
+	// invoices of the new scheme are payable with its keys
+	invoice, err := CreateInvoice(network, newUserKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, newUserKey)
+	swap := &IncomingSwap{
+		PaymentHash:      paymentHash,
+		SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 1000, 1000),
+		PaymentAmountSat: 1000,
+	}
+	if err := swap.VerifyFulfillable(newUserKey, network); err != nil {
+		t.Fatal(err)
+	}
+}
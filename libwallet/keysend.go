@@ -0,0 +1,90 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/muun/libwallet/hdpath"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// keysendKeyPath is the key path of keysend payments. Payers have no invoice
+// to learn a per payment key from, so all of them are sent to the same
+// identity key and locked with the same htlc keys.
+var keysendKeyPath = hdpath.KeysendBranch.Path().Child(0).String()
+
+func keysendIdentityKeyPath() hdpath.Path {
+	return hdpath.MustParse(keysendKeyPath).Child(identityKeyChildIndex)
+}
+
+// KeysendNodePublicKey returns the hex encoded node public key payers must
+// send keysend payments to.
+func KeysendNodePublicKey(userKey *HDPublicKey) (_ string, err error) {
+	defer recordErrors("KeysendNodePublicKey", &err)
+
+	identityKey, err := userKey.DeriveTo(keysendIdentityKeyPath().String())
+	if err != nil {
+		return "", fmt.Errorf("KeysendNodePublicKey: failed to derive key: %w", err)
+	}
+	return hex.EncodeToString(identityKey.Raw()), nil
+}
+
+// recordKeysendInvoice stores an invoice for a keysend payment, so it's
+// verified and fulfilled like payments to regular invoices. Keysend payers
+// pick the preimage and send it in the onion, so the invoice is only created
+// when the payment arrives. Swaps for payment hashes already stored, or whose
+// sphinx has no keysend record, are left alone.
+func (s *IncomingSwap) recordKeysendInvoice(userKey *HDPrivateKey, net *Network) error {
+	if len(s.SphinxPacket) == 0 {
+		return nil
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	found, err := db.HasInvoice(s.PaymentHash)
+	if err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+
+	// Payments not addressed to the keysend key fail to decode, and are
+	// reported as payments to unknown invoices by the caller
+	payload, err := decodeSwapSphinx(s, keysendIdentityKeyPath(), userKey, net)
+	if err != nil {
+		return nil
+	}
+	preimage, ok := payload.CustomRecords()[record.KeySendType]
+	if !ok {
+		return nil
+	}
+
+	hash := sha256.Sum256(preimage)
+	if !bytes.Equal(hash[:], s.PaymentHash) {
+		return fmt.Errorf("keysend preimage does not match payment hash")
+	}
+
+	now := walletNow()
+	invoice := &walletdb.Invoice{
+		Preimage:    preimage,
+		PaymentHash: s.PaymentHash,
+		KeyPath:     keysendKeyPath,
+		AmountSat:   s.PaymentAmountSat,
+		Network:     net.Name(),
+		State:       walletdb.InvoiceStateUsed,
+		UsedAt:      &now,
+	}
+	if payload.MPP != nil {
+		paymentAddr := payload.MPP.PaymentAddr()
+		invoice.PaymentSecret = paymentAddr[:]
+	}
+	return db.CreateInvoice(invoice)
+}
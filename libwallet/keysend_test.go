@@ -0,0 +1,183 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/muun/libwallet/hdpath"
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestKeysend(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	nodeKeyHex, err := KeysendNodePublicKey(userKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeKeyBytes, _ := hex.DecodeString(nodeKeyHex)
+	nodePublicKey, err := btcec.ParsePubKey(nodeKeyBytes, btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amt := int64(10000)
+	lockTime := int64(1000)
+
+	keysendSwap := func(preimage, paymentHash []byte) *IncomingSwap {
+		records := record.CustomSet{record.KeySendType: preimage}
+		return &IncomingSwap{
+			SphinxPacket:     createSphinxPacketWithRecords(nodePublicKey, paymentHash, randomBytes(32), amt, lockTime, records),
+			PaymentHash:      paymentHash,
+			PaymentAmountSat: amt,
+		}
+	}
+
+	t.Run("preimage not matching the hash", func(t *testing.T) {
+		swap := keysendSwap(randomBytes(32), randomBytes(32))
+		if err := swap.VerifyFulfillable(userKey, network); err == nil {
+			t.Fatal("expected keysend with wrong preimage to fail")
+		}
+		if _, err := GetInvoiceByPaymentHash(swap.PaymentHash); err == nil {
+			t.Fatal("expected no invoice to be recorded")
+		}
+	})
+
+	t.Run("payment to unknown invoice", func(t *testing.T) {
+		paymentHash := randomBytes(32)
+		swap := &IncomingSwap{
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, randomBytes(32), amt, lockTime),
+			PaymentHash:      paymentHash,
+			PaymentAmountSat: amt,
+		}
+		if err := swap.VerifyFulfillable(userKey, network); err == nil {
+			t.Fatal("expected payment without keysend record to fail")
+		}
+	})
+
+	t.Run("fulfill", func(t *testing.T) {
+		preimage := randomBytes(32)
+		paymentHash := sha256.Sum256(preimage)
+		swap := keysendSwap(preimage, paymentHash[:])
+
+		if err := swap.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
+		}
+		details, err := GetInvoiceByPaymentHash(paymentHash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if details.State != string(walletdb.InvoiceStateUsed) || details.AmountSat != amt {
+			t.Fatalf("unexpected keysend invoice %+v", details)
+		}
+
+		// verifying again finds the recorded invoice
+		if err := swap.VerifyFulfillable(userKey, network); err != nil {
+			t.Fatal(err)
+		}
+
+		swapServerPublicKey := randomBytes(32)
+		htlcKeyPath := hdpath.MustParse(keysendKeyPath).Child(htlcKeyChildIndex)
+		userHtlcKey, err := userKey.DeriveTo(htlcKeyPath.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		muunHtlcKey, err := muunKey.DeriveTo(htlcKeyPath.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		htlcScript, err := createHtlcScript(
+			userHtlcKey.PublicKey().Raw(),
+			muunHtlcKey.PublicKey().Raw(),
+			swapServerPublicKey,
+			lockTime,
+			paymentHash[:],
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		witnessHash := sha256.Sum256(htlcScript)
+		address, err := btcutil.NewAddressWitnessScriptHash(witnessHash[:], network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkScript, err := txscript.PayToAddrScript(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prevOutHash, err := chainhash.NewHash(randomBytes(32))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		htlcTx := wire.NewMsgTx(1)
+		htlcTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: *prevOutHash}})
+		htlcTx.AddTxOut(&wire.TxOut{PkScript: pkScript, Value: amt})
+
+		fulfillmentTx := wire.NewMsgTx(1)
+		fulfillmentTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: htlcTx.TxHash()}})
+		addr := newAddressAt(userKey, muunKey, "m/schema:1'/recovery:1'/34/56", network)
+		fulfillmentTx.AddTxOut(&wire.TxOut{PkScript: addr.ScriptAddress(), Value: amt})
+
+		muunSignKey, err := muunHtlcKey.key.ECPrivKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		muunSignature, err := txscript.RawTxInWitnessSignature(
+			fulfillmentTx,
+			txscript.NewTxSigHashes(fulfillmentTx),
+			0,
+			amt,
+			htlcScript,
+			txscript.SigHashAll,
+			muunSignKey,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		swap.Htlc = &IncomingSwapHtlc{
+			HtlcTx:              serializeTx(htlcTx),
+			ExpirationHeight:    lockTime,
+			SwapServerPublicKey: swapServerPublicKey,
+		}
+		result, err := swap.Fulfill(&IncomingSwapFulfillmentData{
+			FulfillmentTx:      serializeTx(fulfillmentTx),
+			MuunSignature:      muunSignature,
+			ConfirmationTarget: 1,
+		}, userKey, muunKey.PublicKey(), network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(result.Preimage, preimage) {
+			t.Fatal("expected the keysend preimage to be handed out")
+		}
+
+		signedTx := wire.NewMsgTx(2)
+		signedTx.Deserialize(bytes.NewReader(result.FulfillmentTx))
+		verifyInput(t, signedTx, hex.EncodeToString(swap.Htlc.HtlcTx), 0, 0)
+
+		details, err = GetInvoiceByPaymentHash(paymentHash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if details.State != string(walletdb.InvoiceStateSettled) {
+			t.Fatalf("expected keysend invoice to be settled, got %v", details.State)
+		}
+	})
+}
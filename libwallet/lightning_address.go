@@ -0,0 +1,50 @@
+package libwallet
+
+import (
+	"fmt"
+
+	"github.com/muun/libwallet/lnurl"
+)
+
+// LightningAddressURL returns the url payers fetch the payRequest of the
+// given Lightning Address (LUD-16) from, eg
+// "https://example.com/.well-known/lnurlp/alice" for "alice@example.com".
+// The server of the domain must answer it with LnurlPayResponse.
+func LightningAddressURL(address string) (_ string, err error) {
+	defer recordErrors("LightningAddressURL", &err)
+
+	parsed, err := lnurl.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("LightningAddressURL: %w", err)
+	}
+	return parsed.URL(), nil
+}
+
+// NewLightningAddressPayOptions returns the options of the LNURL-pay
+// endpoint serving the given Lightning Address, whose metadata identifies
+// the address so payers' wallets can show who they pay. They are meant for
+// LnurlPayMetadata, LnurlPayResponse and LnurlPayCallback, which mints the
+// invoices with the description hash of the metadata.
+func NewLightningAddressPayOptions(address, callback string, minSendableMsat, maxSendableMsat int64) (_ *LnurlPayOptions, err error) {
+	defer recordErrors("NewLightningAddressPayOptions", &err)
+
+	parsed, err := lnurl.ParseAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("NewLightningAddressPayOptions: %w", err)
+	}
+	if minSendableMsat < 0 || maxSendableMsat < 0 {
+		return nil, fmt.Errorf("NewLightningAddressPayOptions: invalid sendable range %v-%v msat", minSendableMsat, maxSendableMsat)
+	}
+	pay := parsed.PayFor(callback, uint64(minSendableMsat), uint64(maxSendableMsat))
+	opts := &LnurlPayOptions{
+		Callback:        pay.Callback,
+		MinSendableMsat: minSendableMsat,
+		MaxSendableMsat: maxSendableMsat,
+		Description:     pay.Description,
+		Identifier:      pay.Identifier,
+	}
+	if _, err := opts.pay(); err != nil {
+		return nil, fmt.Errorf("NewLightningAddressPayOptions: %w", err)
+	}
+	return opts, nil
+}
@@ -0,0 +1,106 @@
+package libwallet
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+func TestLightningAddress(t *testing.T) {
+	setup()
+
+	url, err := LightningAddressURL("Alice@Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://example.com/.well-known/lnurlp/alice" {
+		t.Fatalf("unexpected url %v", url)
+	}
+
+	opts, err := NewLightningAddressPayOptions("alice@example.com", "https://example.com/lnurlp/alice/callback", 1000, 1000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := LnurlPayResponse(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payRequest struct {
+		Metadata string `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(response), &payRequest); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(payRequest.Metadata, `["text/identifier","alice@example.com"]`) {
+		t.Fatalf("expected metadata to identify the address, got %v", payRequest.Metadata)
+	}
+
+	// without secrets in the pool no invoice can be minted
+	if err := CheckLnurlPayAmount(opts, 5000); ErrorCode(err) != ErrNoUnusedSecrets {
+		t.Fatalf("expected ErrNoUnusedSecrets, got %v", err)
+	}
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, amountMsat := range []int64{-1, 999, 1000001} {
+		if err := CheckLnurlPayAmount(opts, amountMsat); ErrorCode(err) != ErrInvalidAmount {
+			t.Errorf("expected amount %v to fail with ErrInvalidAmount, got %v", amountMsat, err)
+		}
+	}
+	if err := CheckLnurlPayAmount(opts, 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+	for i := 0; i < secrets.Length(); i++ {
+		raw, err := LnurlPayCallback(network, userKey, routeHints, opts, 5000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var callback struct {
+			Pr string `json:"pr"`
+		}
+		if err := json.Unmarshal([]byte(raw), &callback); err != nil {
+			t.Fatal(err)
+		}
+		payreq, err := zpay32.Decode(callback.Pr, network.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := sha256.Sum256([]byte(payRequest.Metadata)); *payreq.DescriptionHash != expected {
+			t.Fatal("expected invoice to commit to the metadata")
+		}
+	}
+
+	if _, err := LnurlPayCallback(network, userKey, routeHints, opts, 5000); ErrorCode(err) != ErrNoUnusedSecrets {
+		t.Fatalf("expected ErrNoUnusedSecrets once the pool is used up, got %v", err)
+	}
+
+	for _, invalid := range []string{"alice", "alice@example.com/x"} {
+		if _, err := NewLightningAddressPayOptions(invalid, "https://example.com/callback", 1000, 2000); err == nil {
+			t.Errorf("expected address %q to fail", invalid)
+		}
+	}
+	if _, err := NewLightningAddressPayOptions("alice@example.com", "https://example.com/callback", 1000, MaxMoneySat*1000+1); err == nil {
+		t.Error("expected max sendable above the bitcoin supply to fail")
+	}
+}
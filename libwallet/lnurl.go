@@ -0,0 +1,155 @@
+package libwallet
+
+import (
+	"fmt"
+
+	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/lnurl"
+)
+
+// LnurlPayOptions are the parameters of a static LNURL-pay endpoint backed
+// by the wallet. The endpoint is served by a server-side component of the
+// user, which forwards the requests to the callback to the wallet.
+type LnurlPayOptions struct {
+	Callback        string // https url payers request invoices from
+	MinSendableMsat int64
+	MaxSendableMsat int64
+	Description     string // shown to the payer, required
+	Identifier      string // internet identifier, eg "alice@example.com", optional
+}
+
+func (o *LnurlPayOptions) pay() (*lnurl.Pay, error) {
+	if o.MinSendableMsat < 0 || o.MaxSendableMsat < 0 {
+		return nil, fmt.Errorf("invalid sendable range %v-%v msat", o.MinSendableMsat, o.MaxSendableMsat)
+	}
+	if err := validateAmount(NewAmountFromMsats(o.MaxSendableMsat)); err != nil {
+		return nil, err
+	}
+	pay := &lnurl.Pay{
+		Callback:        o.Callback,
+		MinSendableMsat: uint64(o.MinSendableMsat),
+		MaxSendableMsat: uint64(o.MaxSendableMsat),
+		Description:     o.Description,
+		Identifier:      o.Identifier,
+	}
+	if err := pay.Validate(); err != nil {
+		return nil, err
+	}
+	return pay, nil
+}
+
+// EncodeLnurl returns the LNURL of the given https url, to be shown as a
+// static QR.
+func EncodeLnurl(url string) (_ string, err error) {
+	defer recordErrors("EncodeLnurl", &err)
+
+	encoded, err := lnurl.Encode(url)
+	if err != nil {
+		return "", fmt.Errorf("EncodeLnurl: %w", err)
+	}
+	return encoded, nil
+}
+
+// LnurlPayMetadata returns the metadata of the endpoint, which the invoices
+// minted by LnurlPayCallback commit to with their description hash.
+func LnurlPayMetadata(opts *LnurlPayOptions) (_ string, err error) {
+	defer recordErrors("LnurlPayMetadata", &err)
+
+	pay, err := opts.pay()
+	if err != nil {
+		return "", fmt.Errorf("LnurlPayMetadata: %w", err)
+	}
+	return pay.Metadata(), nil
+}
+
+// LnurlPayResponse returns the json payRequest to be served by the LNURL of
+// the endpoint. It doesn't change unless the options do, so it can be cached
+// by the server-side component.
+func LnurlPayResponse(opts *LnurlPayOptions) (_ string, err error) {
+	defer recordErrors("LnurlPayResponse", &err)
+
+	pay, err := opts.pay()
+	if err != nil {
+		return "", fmt.Errorf("LnurlPayResponse: %w", err)
+	}
+	response, err := pay.Response()
+	if err != nil {
+		return "", fmt.Errorf("LnurlPayResponse: %w", err)
+	}
+	return string(response), nil
+}
+
+// LnurlPayCallback mints an invoice for the amount requested by a payer to
+// the callback of the endpoint, using the next secret of the unused secrets
+// pool, and returns the json response with it. The amount is checked with
+// CheckLnurlPayAmount first. On error, the server-side
+// component should answer with LnurlPayErrorResponse instead.
+func LnurlPayCallback(net *Network, userKey *HDPrivateKey, routeHints *RouteHints, opts *LnurlPayOptions, amountMsat int64) (_ string, err error) {
+	defer recordErrors("LnurlPayCallback", &err)
+
+	pay, err := opts.pay()
+	if err != nil {
+		return "", fmt.Errorf("LnurlPayCallback: %w", err)
+	}
+	if err := checkLnurlPayAmount(pay, amountMsat); err != nil {
+		return "", err
+	}
+
+	invoice, err := CreateInvoice(net, userKey, routeHints, &InvoiceOptions{
+		Amount:          NewAmountFromMsats(amountMsat),
+		DescriptionHash: pay.DescriptionHash(),
+	})
+	if err != nil {
+		return "", err
+	}
+	// the pool can run out between the check and minting the invoice
+	if invoice == "" {
+		return "", errors.New(ErrNoUnusedSecrets, "no unused invoice secrets left")
+	}
+	return string(lnurl.CallbackResponse(invoice)), nil
+}
+
+// LnurlPayErrorResponse returns the json response of a failed request to the
+// endpoint, with the reason shown to the payer.
+func LnurlPayErrorResponse(reason string) string {
+	return string(lnurl.ErrorResponse(reason))
+}
+
+// CheckLnurlPayAmount checks the amount requested by a payer to the callback
+// of the endpoint can be received: it must be in the sendable range, and
+// there must be unused secrets left in the pool to mint the invoice, or an
+// error with the ErrNoUnusedSecrets code is returned. Servers can use it to
+// answer payers before forwarding the request to the wallet.
+func CheckLnurlPayAmount(opts *LnurlPayOptions, amountMsat int64) (err error) {
+	defer recordErrors("CheckLnurlPayAmount", &err)
+
+	pay, err := opts.pay()
+	if err != nil {
+		return fmt.Errorf("CheckLnurlPayAmount: %w", err)
+	}
+	return checkLnurlPayAmount(pay, amountMsat)
+}
+
+func checkLnurlPayAmount(pay *lnurl.Pay, amountMsat int64) error {
+	if err := validateAmount(NewAmountFromMsats(amountMsat)); err != nil {
+		return err
+	}
+	if err := pay.CheckAmount(uint64(amountMsat)); err != nil {
+		return errors.New(ErrInvalidAmount, err.Error())
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	unused, err := db.CountUnusedInvoices()
+	if err != nil {
+		return err
+	}
+	if unused == 0 {
+		return errors.New(ErrNoUnusedSecrets, "no unused invoice secrets left")
+	}
+	return nil
+}
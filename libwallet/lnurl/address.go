@@ -0,0 +1,60 @@
+package lnurl
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// usernamePattern matches the usernames allowed by LUD-16.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9\-_.+]+$`)
+
+// Address is a Lightning Address (LUD-16), an internet identifier like
+// "alice@example.com" payers resolve to the LNURL-pay endpoint of the user.
+type Address struct {
+	Username string
+	Domain   string
+}
+
+// ParseAddress parses a Lightning Address, ignoring case.
+func ParseAddress(s string) (*Address, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(s)), "@")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid lightning address %q", s)
+	}
+	address := &Address{Username: parts[0], Domain: parts[1]}
+	if !usernamePattern.MatchString(address.Username) {
+		return nil, fmt.Errorf("invalid lightning address username %q", address.Username)
+	}
+	if err := validateURL(address.URL()); err != nil || strings.ContainsAny(address.Domain, "/?#") {
+		return nil, fmt.Errorf("invalid lightning address domain %q", address.Domain)
+	}
+	return address, nil
+}
+
+// String returns the address as "username@domain".
+func (a *Address) String() string {
+	return a.Username + "@" + a.Domain
+}
+
+// URL returns the url payers fetch the payRequest of the address from, http
+// only for onion services.
+func (a *Address) URL() string {
+	scheme := "https"
+	if strings.HasSuffix(a.Domain, ".onion") {
+		scheme = "http"
+	}
+	return scheme + "://" + a.Domain + "/.well-known/lnurlp/" + a.Username
+}
+
+// PayFor returns the parameters of the endpoint of the address, whose
+// metadata identifies the address so wallets can show who they pay.
+func (a *Address) PayFor(callback string, minSendableMsat, maxSendableMsat uint64) *Pay {
+	return &Pay{
+		Callback:        callback,
+		MinSendableMsat: minSendableMsat,
+		MaxSendableMsat: maxSendableMsat,
+		Description:     "Payment to " + a.String(),
+		Identifier:      a.String(),
+	}
+}
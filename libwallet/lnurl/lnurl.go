@@ -0,0 +1,153 @@
+// Package lnurl builds LNURL-pay (LUD-06) responses, so a static LNURL can be
+// served by a thin server-side component while the invoices are minted by
+// the wallet.
+//
+// The payer fetches the LNURL, gets a payRequest with the metadata and the
+// amounts accepted, and then calls the callback with the amount it chose,
+// getting an invoice committing to the sha256 hash of the metadata.
+package lnurl
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/btcsuite/btcutil/bech32"
+)
+
+const hrp = "lnurl"
+
+// Pay are the parameters of an LNURL-pay endpoint.
+type Pay struct {
+	Callback        string // url the payer requests invoices from
+	MinSendableMsat uint64
+	MaxSendableMsat uint64
+	Description     string // shown to the payer, required
+	Identifier      string // internet identifier (LUD-16), optional
+}
+
+// Encode returns the LNURL of the given url: its bech32 encoding, upper case
+// so it fits QRs in alphanumeric mode.
+func Encode(rawURL string) (string, error) {
+	if err := validateURL(rawURL); err != nil {
+		return "", err
+	}
+	data, err := bech32.ConvertBits([]byte(rawURL), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := bech32.Encode(hrp, data)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(encoded), nil
+}
+
+// Validate checks the parameters are well formed.
+func (p *Pay) Validate() error {
+	if err := validateURL(p.Callback); err != nil {
+		return fmt.Errorf("invalid callback: %w", err)
+	}
+	if p.MinSendableMsat == 0 || p.MinSendableMsat > p.MaxSendableMsat {
+		return fmt.Errorf("invalid sendable range %v-%v msat", p.MinSendableMsat, p.MaxSendableMsat)
+	}
+	if p.Description == "" {
+		return errors.New("description can't be empty")
+	}
+	return nil
+}
+
+// Metadata returns the metadata the invoices of the endpoint commit to, a
+// json array of [mime type, content] entries.
+func (p *Pay) Metadata() string {
+	entries := [][]string{{"text/plain", p.Description}}
+	if p.Identifier != "" {
+		entries = append(entries, []string{"text/identifier", p.Identifier})
+	}
+	// marshaling strings can't fail
+	metadata, _ := json.Marshal(entries)
+	return string(metadata)
+}
+
+// DescriptionHash returns the description hash of the invoices of the
+// endpoint.
+func (p *Pay) DescriptionHash() []byte {
+	hash := sha256.Sum256([]byte(p.Metadata()))
+	return hash[:]
+}
+
+// Response returns the payRequest served by the LNURL.
+func (p *Pay) Response() ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Tag         string `json:"tag"`
+		Callback    string `json:"callback"`
+		MinSendable uint64 `json:"minSendable"`
+		MaxSendable uint64 `json:"maxSendable"`
+		Metadata    string `json:"metadata"`
+	}{
+		Tag:         "payRequest",
+		Callback:    p.Callback,
+		MinSendable: p.MinSendableMsat,
+		MaxSendable: p.MaxSendableMsat,
+		Metadata:    p.Metadata(),
+	})
+}
+
+// CheckAmount returns an error if the amount requested to the callback is
+// outside the sendable range.
+func (p *Pay) CheckAmount(amountMsat uint64) error {
+	if amountMsat < p.MinSendableMsat || amountMsat > p.MaxSendableMsat {
+		return fmt.Errorf("amount %v msat is outside the sendable range %v-%v msat",
+			amountMsat, p.MinSendableMsat, p.MaxSendableMsat)
+	}
+	return nil
+}
+
+// CallbackResponse returns the response of the callback with the invoice
+// minted for the payer.
+func CallbackResponse(invoice string) []byte {
+	response, _ := json.Marshal(struct {
+		Pr     string        `json:"pr"`
+		Routes []interface{} `json:"routes"`
+	}{
+		Pr:     invoice,
+		Routes: []interface{}{},
+	})
+	return response
+}
+
+// ErrorResponse returns the response of a failed request, with the reason
+// shown to the payer.
+func ErrorResponse(reason string) []byte {
+	response, _ := json.Marshal(struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}{
+		Status: "ERROR",
+		Reason: reason,
+	})
+	return response
+}
+
+// validateURL checks the url is https, or http for onion services, as
+// payers refuse anything else.
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("url %q has no host", rawURL)
+	}
+	onion := strings.HasSuffix(u.Hostname(), ".onion")
+	if u.Scheme != "https" && !(u.Scheme == "http" && onion) {
+		return fmt.Errorf("url %q must be https", rawURL)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package lnurl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	// LUD-01 example
+	encoded, err := Encode("https://service.com/api?q=3fc3645b439ce8e7f2553a69e5267081d96dcd340693afabe04be7b0ccd178df")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = "LNURL1DP68GURN8GHJ7UM9WFMXJCM99E3K7MF0V9CXJ0M385EKVCENXC6R2C35XVUKXEFCV5MKVV34X5EKZD3EV56NYD3HXQURZEPEXEJXXEPNXSCRVWFNV9NXZCN9XQ6XYEFHVGCXXCMYXYMNSERXFQ5FNS"
+	if encoded != expected {
+		t.Fatalf("unexpected lnurl %v", encoded)
+	}
+
+	for _, rawURL := range []string{
+		"http://service.com/api",
+		"service.com/api",
+		"https:///api",
+	} {
+		if _, err := Encode(rawURL); err == nil {
+			t.Errorf("expected %v to fail", rawURL)
+		}
+	}
+	if _, err := Encode("http://abcdef.onion/api"); err != nil {
+		t.Errorf("expected http onion url to be valid, got %v", err)
+	}
+}
+
+func TestPayResponse(t *testing.T) {
+	pay := &Pay{
+		Callback:        "https://service.com/api/pay",
+		MinSendableMsat: 1000,
+		MaxSendableMsat: 1000000,
+		Description:     "Pay to \"alice\"",
+		Identifier:      "alice@service.com",
+	}
+
+	const expectedMetadata = `[["text/plain","Pay to \"alice\""],["text/identifier","alice@service.com"]]`
+	if pay.Metadata() != expectedMetadata {
+		t.Fatalf("unexpected metadata %v", pay.Metadata())
+	}
+
+	raw, err := pay.Response()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response["tag"] != "payRequest" || response["metadata"] != expectedMetadata ||
+		response["minSendable"] != 1000.0 || response["maxSendable"] != 1000000.0 {
+		t.Fatalf("unexpected response %s", raw)
+	}
+
+	if err := pay.CheckAmount(999); err == nil {
+		t.Error("expected amount below the range to fail")
+	}
+	if err := pay.CheckAmount(1000001); err == nil {
+		t.Error("expected amount above the range to fail")
+	}
+	if err := pay.CheckAmount(5000); err != nil {
+		t.Errorf("expected amount in range to succeed, got %v", err)
+	}
+
+	for _, invalid := range []*Pay{
+		{Callback: "http://service.com", MinSendableMsat: 1, MaxSendableMsat: 1, Description: "a"},
+		{Callback: "https://service.com", MinSendableMsat: 0, MaxSendableMsat: 1, Description: "a"},
+		{Callback: "https://service.com", MinSendableMsat: 2, MaxSendableMsat: 1, Description: "a"},
+		{Callback: "https://service.com", MinSendableMsat: 1, MaxSendableMsat: 1},
+	} {
+		if _, err := invalid.Response(); err == nil {
+			t.Errorf("expected %+v to fail", invalid)
+		}
+	}
+}
+
+func TestResponses(t *testing.T) {
+	if string(CallbackResponse("lnbc1")) != `{"pr":"lnbc1","routes":[]}` {
+		t.Fatalf("unexpected callback response %s", CallbackResponse("lnbc1"))
+	}
+	if !strings.Contains(string(ErrorResponse("no")), `"status":"ERROR"`) {
+		t.Fatalf("unexpected error response %s", ErrorResponse("no"))
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	address, err := ParseAddress(" Alice.B+tips@Example.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address.String() != "alice.b+tips@example.com" {
+		t.Fatalf("unexpected address %v", address)
+	}
+	if address.URL() != "https://example.com/.well-known/lnurlp/alice.b+tips" {
+		t.Fatalf("unexpected url %v", address.URL())
+	}
+	onion, err := ParseAddress("alice@abcdef.onion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onion.URL() != "http://abcdef.onion/.well-known/lnurlp/alice" {
+		t.Fatalf("unexpected onion url %v", onion.URL())
+	}
+
+	pay := address.PayFor("https://example.com/callback", 1000, 2000)
+	const expectedMetadata = `[["text/plain","Payment to alice.b+tips@example.com"],["text/identifier","alice.b+tips@example.com"]]`
+	if pay.Metadata() != expectedMetadata {
+		t.Fatalf("unexpected metadata %v", pay.Metadata())
+	}
+
+	for _, invalid := range []string{
+		"alice",
+		"alice@",
+		"@example.com",
+		"alice@bob@example.com",
+		"ali ce@example.com",
+		"alice@example.com/path",
+		"alice@example.com?q",
+	} {
+		if _, err := ParseAddress(invalid); err == nil {
+			t.Errorf("expected %q to fail", invalid)
+		}
+	}
+}
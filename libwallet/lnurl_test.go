@@ -0,0 +1,84 @@
+package libwallet
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+func TestLnurlPayCallback(t *testing.T) {
+	setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+	opts := &LnurlPayOptions{
+		Callback:        "https://example.com/lnurlp/alice/callback",
+		MinSendableMsat: 1000,
+		MaxSendableMsat: 100000000,
+		Description:     "Pay to alice",
+		Identifier:      "alice@example.com",
+	}
+
+	metadata, err := LnurlPayMetadata(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LnurlPayResponse(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := LnurlPayCallback(network, userKey, routeHints, opts, 21500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response struct {
+		Pr string `json:"pr"`
+	}
+	if err := json.Unmarshal([]byte(raw), &response); err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(response.Pr, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payreq.MilliSat == nil || *payreq.MilliSat != 21500 {
+		t.Fatalf("unexpected invoice amount %v", payreq.MilliSat)
+	}
+	if expected := sha256.Sum256([]byte(metadata)); payreq.DescriptionHash == nil || *payreq.DescriptionHash != expected {
+		t.Fatal("expected invoice to commit to the metadata")
+	}
+
+	// every call mints an invoice with a new secret
+	other, err := LnurlPayCallback(network, userKey, routeHints, opts, 21500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == raw {
+		t.Fatal("expected a new invoice for each callback")
+	}
+
+	for _, amountMsat := range []int64{-1, 999, 100000001} {
+		if _, err := LnurlPayCallback(network, userKey, routeHints, opts, amountMsat); err == nil {
+			t.Errorf("expected amount %v to fail", amountMsat)
+		}
+	}
+}
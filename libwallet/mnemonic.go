@@ -0,0 +1,91 @@
+package libwallet
+
+import (
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/muun/libwallet/errors"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// keyMnemonicWords is the number of words of a mnemonic produced by
+// ExportKeyMnemonic: two 24 word BIP39 mnemonics.
+const keyMnemonicWords = 48
+
+// NewHDPrivateKeyFromMnemonic builds the root HD priv key of a standard BIP39
+// mnemonic and optional passphrase, matching the master key other BIP39
+// wallets derive from it.
+func NewHDPrivateKeyFromMnemonic(mnemonic, passphrase string, network *Network) (*HDPrivateKey, error) {
+	mnemonic = normalizeMnemonic(mnemonic)
+	if _, err := bip39.EntropyFromMnemonic(mnemonic); err != nil {
+		return nil, errors.Errorf(ErrInvalidPrivateKey, "invalid mnemonic: %w", err)
+	}
+	return NewHDPrivateKey(bip39.NewSeed(mnemonic, passphrase), network)
+}
+
+// ExportKeyMnemonic encodes a root HD priv key as words of the BIP39 english
+// wordlist. A BIP39 mnemonic can't be recovered from a key, since it's hashed
+// into the seed, so the key is wrapped instead: the 32 byte private key and
+// the 32 byte chain code are each encoded as a 24 word BIP39 mnemonic, and
+// both are joined into 48 words. Use NewHDPrivateKeyFromKeyMnemonic to get
+// the key back. Other wallets can decode the words with any BIP39 library,
+// but must not use them as a regular BIP39 mnemonic.
+func ExportKeyMnemonic(key *HDPrivateKey) (string, error) {
+	const (
+		chainCodeStart  = 13
+		chainCodeLength = 32
+		privKeyStart    = 46
+		privKeyLength   = 32
+	)
+
+	if key.Path != "m" {
+		return "", errors.Errorf(ErrInvalidPrivateKey, "only root keys can be exported, got path %v", key.Path)
+	}
+
+	rawHDKey := base58.Decode(key.String())
+	privKey := rawHDKey[privKeyStart : privKeyStart+privKeyLength]
+	chainCode := rawHDKey[chainCodeStart : chainCodeStart+chainCodeLength]
+
+	privKeyWords, err := bip39.NewMnemonic(privKey)
+	if err != nil {
+		return "", err
+	}
+	chainCodeWords, err := bip39.NewMnemonic(chainCode)
+	if err != nil {
+		return "", err
+	}
+
+	return privKeyWords + " " + chainCodeWords, nil
+}
+
+// NewHDPrivateKeyFromKeyMnemonic decodes a key exported with
+// ExportKeyMnemonic.
+func NewHDPrivateKeyFromKeyMnemonic(mnemonic string, network *Network) (*HDPrivateKey, error) {
+	words := strings.Fields(normalizeMnemonic(mnemonic))
+	if len(words) != keyMnemonicWords {
+		return nil, errors.Errorf(ErrInvalidPrivateKey, "expected %v words, got %v", keyMnemonicWords, len(words))
+	}
+
+	half := keyMnemonicWords / 2
+	privKey, err := bip39.EntropyFromMnemonic(strings.Join(words[:half], " "))
+	if err != nil {
+		return nil, errors.Errorf(ErrInvalidPrivateKey, "invalid private key words: %w", err)
+	}
+	chainCode, err := bip39.EntropyFromMnemonic(strings.Join(words[half:], " "))
+	if err != nil {
+		return nil, errors.Errorf(ErrInvalidPrivateKey, "invalid chain code words: %w", err)
+	}
+
+	return NewHDPrivateKeyFromBytes(privKey, chainCode, network)
+}
+
+// IsValidMnemonic reports whether the given words are a valid BIP39 mnemonic,
+// including its checksum.
+func IsValidMnemonic(mnemonic string) bool {
+	_, err := bip39.EntropyFromMnemonic(normalizeMnemonic(mnemonic))
+	return err == nil
+}
+
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
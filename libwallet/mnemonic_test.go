@@ -0,0 +1,74 @@
+package libwallet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewHDPrivateKeyFromMnemonic(t *testing.T) {
+	// BIP39 test vector
+	const (
+		mnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+		xprv     = "xprv9s21ZrQH143K3h3fDYiay8mocZ3afhfULfb5GX8kCBdno77K4HiA15Tg23wpbeF1pLfs1c5SPmYHrEpTuuRhxMwvKDwqdKiGJS9XFKzUsAF"
+	)
+
+	key, err := NewHDPrivateKeyFromMnemonic(mnemonic, "TREZOR", Mainnet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.String() != xprv {
+		t.Fatalf("unexpected key %v", key.String())
+	}
+
+	// case and spacing are normalized
+	key, err = NewHDPrivateKeyFromMnemonic("  "+strings.ToUpper(mnemonic)+"\n", "TREZOR", Mainnet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.String() != xprv {
+		t.Fatalf("unexpected key %v", key.String())
+	}
+
+	invalid := strings.Replace(mnemonic, "about", "abandon", 1)
+	if IsValidMnemonic(invalid) {
+		t.Fatal("expected mnemonic with bad checksum to be invalid")
+	}
+	if _, err := NewHDPrivateKeyFromMnemonic(invalid, "", Mainnet()); ErrorCode(err) != ErrInvalidPrivateKey {
+		t.Fatalf("expected ErrInvalidPrivateKey, got %v", err)
+	}
+}
+
+func TestExportKeyMnemonic(t *testing.T) {
+	network := Regtest()
+	key, _ := NewHDPrivateKey(randomBytes(32), network)
+
+	words, err := ExportKeyMnemonic(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(strings.Fields(words)); n != 48 {
+		t.Fatalf("expected 48 words, got %v", n)
+	}
+
+	imported, err := NewHDPrivateKeyFromKeyMnemonic(words, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.String() != key.String() {
+		t.Fatal("expected imported key to match the exported one")
+	}
+
+	derived, _ := key.DeriveTo("m/1'")
+	if _, err := ExportKeyMnemonic(derived); err == nil {
+		t.Fatal("expected error exporting a derived key")
+	}
+
+	fields := strings.Fields(words)
+	if _, err := NewHDPrivateKeyFromKeyMnemonic(strings.Join(fields[:24], " "), network); err == nil {
+		t.Fatal("expected error for truncated mnemonic")
+	}
+	fields[0] = "notaword"
+	if _, err := NewHDPrivateKeyFromKeyMnemonic(strings.Join(fields, " "), network); err == nil {
+		t.Fatal("expected error for mnemonic with unknown words")
+	}
+}
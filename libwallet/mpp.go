@@ -0,0 +1,71 @@
+package libwallet
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/sphinx"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// recordMppPart stores the htlc with the given sphinx packet as a part of the
+// multi-part payment for the payment hash. Until the parts received add up to
+// the total of the payment, an error with the ErrIncompleteMppSet code is
+// returned, and the swaps should be verified again once more parts arrive.
+// Recording a part twice is a no-op, so every swap of the set passes once the
+// last one arrives. Parts whose htlcs expired by blockHeight aren't counted,
+// since the swap server reclaims them. A blockHeight of 0 counts every part.
+func recordMppPart(db *walletdb.DB, paymentHash, sphinxPacket []byte, part *sphinx.Part, blockHeight int64) error {
+	partId := sha256.Sum256(sphinxPacket)
+
+	err := db.SaveMppPart(&walletdb.MppPart{
+		PaymentHash:  paymentHash,
+		PartId:       partId[:],
+		AmountMsat:   uint64(part.AmountMsat),
+		TotalMsat:    uint64(part.TotalMsat),
+		ExpiryHeight: part.ExpiryHeight,
+	})
+	if err != nil {
+		return err
+	}
+
+	parts, err := db.ListMppParts(paymentHash)
+	if err != nil {
+		return err
+	}
+
+	received, err := mppReceived(parts, part.TotalMsat, blockHeight)
+	if err != nil {
+		return err
+	}
+	if received < part.TotalMsat {
+		return errors.Errorf(ErrIncompleteMppSet,
+			"multi-part payment incomplete, received %v of %v", received, part.TotalMsat)
+	}
+	return nil
+}
+
+// mppReceived returns the amount paid by the parts whose htlcs are still
+// unexpired at blockHeight, which must all agree on the total of the payment.
+// Parts without a known expiry are always counted.
+func mppReceived(parts []walletdb.MppPart, total lnwire.MilliSatoshi, blockHeight int64) (lnwire.MilliSatoshi, error) {
+	var received lnwire.MilliSatoshi
+	for _, part := range parts {
+		if lnwire.MilliSatoshi(part.TotalMsat) != total {
+			return 0, fmt.Errorf("multi-part payment parts don't match, total %v != %v", part.TotalMsat, total)
+		}
+		if mppPartExpired(&part, blockHeight) {
+			continue
+		}
+		received += lnwire.MilliSatoshi(part.AmountMsat)
+	}
+	return received, nil
+}
+
+// mppPartExpired returns whether the htlc paying the part expired by
+// blockHeight.
+func mppPartExpired(part *walletdb.MppPart, blockHeight int64) bool {
+	return blockHeight != 0 && part.ExpiryHeight != 0 && int64(part.ExpiryHeight) <= blockHeight
+}
@@ -2,6 +2,7 @@ package libwallet
 
 import (
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/muun/libwallet/errors"
 )
 
 // Network has the parameters for operating in a given Bitcoin network
@@ -28,3 +29,13 @@ func Regtest() *Network {
 func (n *Network) Name() string {
 	return n.network.Name
 }
+
+// checkNetwork returns an error with the ErrNetworkMismatch code if name, the
+// network of what is being checked, isn't the given network. Empty names are
+// unknown and accepted.
+func checkNetwork(net *Network, name string, what string) error {
+	if name != "" && name != net.Name() {
+		return errors.Errorf(ErrNetworkMismatch, "%v is for network %v, not %v", what, name, net.Name())
+	}
+	return nil
+}
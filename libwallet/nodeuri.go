@@ -0,0 +1,131 @@
+package libwallet
+
+import (
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/muun/libwallet/errors"
+)
+
+// defaultLightningPort is used for node URIs that don't specify a port.
+const defaultLightningPort = 9735
+
+// NodeURI identifies a lightning node and, optionally, the address where it
+// can be reached.
+type NodeURI struct {
+	PublicKey string // hex-encoded compressed public key
+	Host      string // empty if the URI had no address
+	Port      int
+}
+
+// ParseNodeURI parses and normalizes node URIs of the form pubkey,
+// pubkey@host or pubkey@host:port. The public key may be given compressed
+// or uncompressed, and IPv6 hosts followed by a port must be enclosed in
+// brackets. Addresses without a port use the default lightning port.
+func ParseNodeURI(uri string) (*NodeURI, error) {
+	uri = strings.TrimSpace(uri)
+
+	rawKey := uri
+	var address string
+	if i := strings.Index(uri, "@"); i >= 0 {
+		rawKey = uri[:i]
+		address = uri[i+1:]
+	}
+
+	key, err := parseNodePubKey(rawKey)
+	if err != nil {
+		return nil, errors.Errorf(ErrInvalidURI, "invalid node public key %v: %w", rawKey, err)
+	}
+
+	nodeURI := &NodeURI{
+		PublicKey: hex.EncodeToString(key.SerializeCompressed()),
+	}
+	if address == "" {
+		if strings.Contains(uri, "@") {
+			return nil, errors.Errorf(ErrInvalidURI, "missing node address in %v", uri)
+		}
+		return nodeURI, nil
+	}
+
+	host, port, err := splitNodeAddress(address)
+	if err != nil {
+		return nil, errors.Errorf(ErrInvalidURI, "invalid node address %v: %w", address, err)
+	}
+	nodeURI.Host = host
+	nodeURI.Port = port
+
+	return nodeURI, nil
+}
+
+// HasAddress returns true if the URI specifies where to reach the node.
+func (n *NodeURI) HasAddress() bool {
+	return n.Host != ""
+}
+
+// Address returns the host:port the node can be reached at, or an empty
+// string if the URI had no address.
+func (n *NodeURI) Address() string {
+	if !n.HasAddress() {
+		return ""
+	}
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// String returns the normalized form of the URI.
+func (n *NodeURI) String() string {
+	if !n.HasAddress() {
+		return n.PublicKey
+	}
+	return n.PublicKey + "@" + n.Address()
+}
+
+// SameNode returns true if both strings identify the same node. Each of them
+// can be a node URI or a public key in any of the supported formats.
+func SameNode(a, b string) bool {
+	nodeA, err := ParseNodeURI(a)
+	if err != nil {
+		return false
+	}
+	nodeB, err := ParseNodeURI(b)
+	if err != nil {
+		return false
+	}
+	return nodeA.PublicKey == nodeB.PublicKey
+}
+
+func parseNodePubKey(s string) (*btcec.PublicKey, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return btcec.ParsePubKey(raw, btcec.S256())
+}
+
+func splitNodeAddress(address string) (string, int, error) {
+	host := address
+	port := defaultLightningPort
+
+	// Only split the port if there is one, taking care of bare IPv6 hosts
+	if strings.HasSuffix(address, "]") || (strings.Count(address, ":") != 1 && !strings.HasPrefix(address, "[")) {
+		host = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+	} else {
+		rawHost, rawPort, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", 0, err
+		}
+		port, err = strconv.Atoi(rawPort)
+		if err != nil || port <= 0 || port > 65535 {
+			return "", 0, errors.Errorf(ErrInvalidURI, "invalid port %v", rawPort)
+		}
+		host = rawHost
+	}
+
+	if host == "" || strings.ContainsAny(host, " /@") {
+		return "", 0, errors.Errorf(ErrInvalidURI, "invalid host %v", host)
+	}
+
+	return strings.ToLower(host), port, nil
+}
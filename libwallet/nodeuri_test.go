@@ -0,0 +1,69 @@
+package libwallet
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+const testNodePubKey = "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd"
+
+func TestParseNodeURI(t *testing.T) {
+	tests := []struct {
+		name    string
+		uri     string
+		want    string
+		wantErr bool
+	}{
+		{name: "pubkey only", uri: testNodePubKey, want: testNodePubKey},
+		{name: "host and port", uri: testNodePubKey + "@node.example.com:9736", want: testNodePubKey + "@node.example.com:9736"},
+		{name: "default port", uri: testNodePubKey + "@node.example.com", want: testNodePubKey + "@node.example.com:9735"},
+		{name: "normalizes case", uri: " 03C48D1FF96FA32E2776F71BBA02102FFC2A1B91E2136586418607D32E762869FD@Node.Example.com:9735 ", want: testNodePubKey + "@node.example.com:9735"},
+		{name: "ipv4", uri: testNodePubKey + "@127.0.0.1:9735", want: testNodePubKey + "@127.0.0.1:9735"},
+		{name: "ipv6 with port", uri: testNodePubKey + "@[::1]:9736", want: testNodePubKey + "@[::1]:9736"},
+		{name: "ipv6 without port", uri: testNodePubKey + "@[::1]", want: testNodePubKey + "@[::1]:9735"},
+		{name: "invalid pubkey", uri: "03c48d1ff96fa32e@node.example.com", wantErr: true},
+		{name: "not hex", uri: "zz@node.example.com", wantErr: true},
+		{name: "missing address", uri: testNodePubKey + "@", wantErr: true},
+		{name: "invalid port", uri: testNodePubKey + "@node.example.com:99999", wantErr: true},
+		{name: "non numeric port", uri: testNodePubKey + "@node.example.com:abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNodeURI(tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNodeURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if ErrorCode(err) != ErrInvalidURI {
+					t.Fatalf("expected invalid uri error code, got %v", ErrorCode(err))
+				}
+				return
+			}
+			if got.String() != tt.want {
+				t.Fatalf("ParseNodeURI() = %v, want %v", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSameNode(t *testing.T) {
+	key, err := parseNodePubKey(testNodePubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed := key.SerializeUncompressed()
+
+	if !SameNode(testNodePubKey, testNodePubKey+"@node.example.com:9735") {
+		t.Fatal("expected pubkey and uri to be the same node")
+	}
+	if !SameNode(testNodePubKey, hex.EncodeToString(uncompressed)) {
+		t.Fatal("expected compressed and uncompressed keys to be the same node")
+	}
+	otherKey := NewChallengePrivateKey(randomBytes(32), randomBytes(8)).PubKeyHex()
+	if SameNode(testNodePubKey, otherKey) {
+		t.Fatal("expected different keys to be different nodes")
+	}
+	if SameNode(testNodePubKey, "invalid") {
+		t.Fatal("expected invalid key to not match")
+	}
+}
@@ -0,0 +1,90 @@
+package libwallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// Outcomes of CompareOperationLogHead.
+const (
+	// OperationLogEqual means both devices made the same invoice mutations.
+	OperationLogEqual = "equal"
+	// OperationLogBehind means the other device made a prefix of the
+	// mutations of this one, so it only needs the ones after it.
+	OperationLogBehind = "behind"
+	// OperationLogDiverged means the other device made mutations this one
+	// doesn't know, or is too far behind to tell, so the dbs must be merged.
+	OperationLogDiverged = "diverged"
+)
+
+// OperationLogHead is the head of the hash chain over the invoice mutations
+// of the wallet db, which devices compare before syncing.
+type OperationLogHead struct {
+	Head   string // hex encoded
+	Length int64  // number of mutations chained
+}
+
+// GetOperationLogHead returns the head of the operation log of the wallet
+// db. Devices that made the same invoice mutations in the same order have
+// the same head, so comparing them detects divergence without sending the
+// invoices, see CompareOperationLogHead.
+func GetOperationLogHead() (_ *OperationLogHead, err error) {
+	defer recordErrors("GetOperationLogHead", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	entry, err := db.OperationLogHead()
+	if err != nil {
+		return nil, fmt.Errorf("GetOperationLogHead: %w", err)
+	}
+	if entry == nil {
+		return &OperationLogHead{Head: hex.EncodeToString(walletdb.GenesisHead)}, nil
+	}
+	return &OperationLogHead{Head: hex.EncodeToString(entry.Head), Length: entry.Sequence}, nil
+}
+
+// CompareOperationLogHead compares the hex encoded head of another device
+// with the one of this device, returning OperationLogEqual,
+// OperationLogBehind or OperationLogDiverged.
+func CompareOperationLogHead(head string) (_ string, err error) {
+	defer recordErrors("CompareOperationLogHead", &err)
+
+	remote, err := hex.DecodeString(head)
+	if err != nil || len(remote) != len(walletdb.GenesisHead) {
+		return "", fmt.Errorf("CompareOperationLogHead: invalid head %v", head)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	local := walletdb.GenesisHead
+	entry, err := db.OperationLogHead()
+	if err != nil {
+		return "", fmt.Errorf("CompareOperationLogHead: %w", err)
+	}
+	if entry != nil {
+		local = entry.Head
+	}
+	if bytes.Equal(local, remote) {
+		return OperationLogEqual, nil
+	}
+
+	known, err := db.HasOperationLogHead(remote)
+	if err != nil {
+		return "", fmt.Errorf("CompareOperationLogHead: %w", err)
+	}
+	if known {
+		return OperationLogBehind, nil
+	}
+	return OperationLogDiverged, nil
+}
@@ -0,0 +1,78 @@
+package libwallet
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestOperationLogHead(t *testing.T) {
+	setup()
+
+	genesis, err := GetOperationLogHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genesis.Length != 0 {
+		t.Fatalf("expected an empty operation log, got %+v", genesis)
+	}
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+	registered, err := GetOperationLogHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.Length != int64(secrets.Length()) {
+		t.Fatalf("expected a mutation for each secret, got %+v", registered)
+	}
+
+	_, err = CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := GetOperationLogHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc     string
+		head     string
+		expected string
+	}{
+		{desc: "same head", head: head.Head, expected: OperationLogEqual},
+		{desc: "earlier head", head: registered.Head, expected: OperationLogBehind},
+		{desc: "genesis", head: genesis.Head, expected: OperationLogBehind},
+		{desc: "unknown head", head: hex.EncodeToString(randomBytes(32)), expected: OperationLogDiverged},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			outcome, err := CompareOperationLogHead(tC.head)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outcome != tC.expected {
+				t.Fatalf("expected %v, got %v", tC.expected, outcome)
+			}
+		})
+	}
+
+	if _, err := CompareOperationLogHead("beef"); err == nil {
+		t.Fatal("expected error with an invalid head")
+	}
+}
@@ -0,0 +1,88 @@
+package libwallet
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// AcceptOverpayment lets payments to the invoice of up to amountSat be
+// fulfilled even if they exceed the configured overpayment tolerance, once
+// the user confirmed it after VerifyFulfillable or Fulfill failed with
+// ErrOverpayment. Payments larger than any accepted amount are still
+// refused. Apps must call Fulfill again for the swap afterwards.
+func AcceptOverpayment(paymentHash []byte, amountSat int64) (err error) {
+	defer recordErrors("AcceptOverpayment", &err)
+
+	if err := validateAmountSat(amountSat); err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return fmt.Errorf("AcceptOverpayment: could not find invoice for payment hash: %w", err)
+	}
+
+	switch invoice.State {
+	case walletdb.InvoiceStateUsed, walletdb.InvoiceStateAccepted:
+	default:
+		return fmt.Errorf("AcceptOverpayment: can't accept payments to %v invoice", invoice.State)
+	}
+	if invoice.AmountSat == 0 || amountSat <= invoice.AmountSat {
+		return fmt.Errorf("AcceptOverpayment: %v is not above the invoice amount (%v)", amountSat, invoice.AmountSat)
+	}
+	if amountSat <= invoice.OverpaymentAcceptedSat {
+		return nil
+	}
+
+	invoice.OverpaymentAcceptedSat = amountSat
+	if err := db.SaveInvoice(invoice); err != nil {
+		return fmt.Errorf("AcceptOverpayment: %w", err)
+	}
+	return nil
+}
+
+// overpaymentTolerance returns how many sats a payment can exceed the amount
+// by, or -1 if there's no bound.
+func overpaymentTolerance(amountSat int64) int64 {
+	toleranceSat := cfg.OverpaymentToleranceSat
+	percent := cfg.OverpaymentTolerancePercent
+	if toleranceSat <= 0 && (percent <= 0 || math.IsNaN(percent)) {
+		return -1
+	}
+	if toleranceSat < 0 {
+		toleranceSat = 0
+	}
+	if percent > 0 {
+		if fromPercent := int64(float64(amountSat) * percent / 100); fromPercent > toleranceSat {
+			toleranceSat = fromPercent
+		}
+	}
+	return toleranceSat
+}
+
+// checkOverpayment returns an error with the ErrOverpayment code if the amount
+// paid exceeds the invoice amount by more than the configured tolerance and
+// the user didn't accept it.
+func checkOverpayment(invoice *walletdb.Invoice, paidSat int64) error {
+	if invoice.AmountSat == 0 || paidSat <= invoice.AmountSat || paidSat <= invoice.OverpaymentAcceptedSat {
+		return nil
+	}
+	tolerance := overpaymentTolerance(invoice.AmountSat)
+	if tolerance < 0 || paidSat-invoice.AmountSat <= tolerance {
+		return nil
+	}
+	return errors.Errorf(
+		ErrOverpayment,
+		"VerifyFulfillable: payment amount (%v) exceeds invoice amount (%v) by more than %v",
+		paidSat, invoice.AmountSat, tolerance,
+	)
+}
@@ -0,0 +1,86 @@
+package libwallet
+
+import (
+	"testing"
+)
+
+func TestOverpaymentTolerance(t *testing.T) {
+	setup()
+	defer setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{AmountSat: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+	swap := func(amountSat int64) *IncomingSwap {
+		return &IncomingSwap{
+			SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, amountSat, 1000),
+			PaymentHash:      paymentHash,
+			PaymentAmountSat: amountSat,
+		}
+	}
+
+	// any overpayment is accepted unless configured
+	if err := swap(5000).VerifyFulfillable(userKey, network); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.OverpaymentToleranceSat = 100
+	cfg.OverpaymentTolerancePercent = 20
+
+	// the larger bound applies
+	if err := swap(1200).VerifyFulfillable(userKey, network); err != nil {
+		t.Fatal(err)
+	}
+	err = swap(1201).VerifyFulfillable(userKey, network)
+	if ErrorCode(err) != ErrOverpayment {
+		t.Fatalf("expected overpayment to be flagged, got %v", err)
+	}
+
+	cfg.OverpaymentTolerancePercent = 0
+	err = swap(1101).VerifyFulfillable(userKey, network)
+	if ErrorCode(err) != ErrOverpayment {
+		t.Fatalf("expected overpayment to be flagged, got %v", err)
+	}
+
+	if err := AcceptOverpayment(paymentHash, 1500); err != nil {
+		t.Fatal(err)
+	}
+	if err := swap(1500).VerifyFulfillable(userKey, network); err != nil {
+		t.Fatal(err)
+	}
+	err = swap(1501).VerifyFulfillable(userKey, network)
+	if ErrorCode(err) != ErrOverpayment {
+		t.Fatalf("expected payment above the accepted amount to be flagged, got %v", err)
+	}
+
+	if err := AcceptOverpayment(paymentHash, 1000); err == nil {
+		t.Fatal("expected error accepting the invoice amount")
+	}
+	if err := AcceptOverpayment(secrets.Get(1).PaymentHash, 1500); err == nil {
+		t.Fatal("expected error accepting payments to an unused invoice")
+	}
+}
@@ -2,12 +2,9 @@ package libwallet
 
 import (
 	"bytes"
-	"encoding/hex"
 	"errors"
 	"fmt"
 
-	"github.com/muun/libwallet/addresses"
-
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 
 	"github.com/btcsuite/btcd/txscript"
@@ -113,51 +110,16 @@ func NewPartiallySignedTransaction(inputs *InputList, rawTx []byte) (*PartiallyS
 	return &PartiallySignedTransaction{tx: tx, inputs: inputs.Inputs()}, nil
 }
 
-func (p *PartiallySignedTransaction) coins(net *Network) ([]coin, error) {
-	var coins []coin
-	for _, input := range p.inputs {
-		coin, err := createCoin(input, net)
-		if err != nil {
-			return nil, err
-		}
-		coins = append(coins, coin)
-	}
-	return coins, nil
-}
-
-func (p *PartiallySignedTransaction) Sign(userKey *HDPrivateKey, muunKey *HDPublicKey) (*Transaction, error) {
-
-	coins, err := p.coins(userKey.Network)
-	if err != nil {
-		return nil, fmt.Errorf("could not convert input data to coin: %w", err)
-	}
-
-	for i, coin := range coins {
-		err = coin.SignInput(i, p.tx, userKey, muunKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to sign input: %w", err)
-		}
-	}
-
-	return newTransaction(p.tx)
+func (p *PartiallySignedTransaction) Sign(userKey *HDPrivateKey, muunKey *HDPublicKey) (_ *Transaction, err error) {
+	defer recordErrors("Sign", &err)
 
+	return newBatchSigner(p.tx, p.inputs).sign(userKey, muunKey)
 }
 
-func (p *PartiallySignedTransaction) FullySign(userKey, muunKey *HDPrivateKey) (*Transaction, error) {
+func (p *PartiallySignedTransaction) FullySign(userKey, muunKey *HDPrivateKey) (_ *Transaction, err error) {
+	defer recordErrors("FullySign", &err)
 
-	coins, err := p.coins(userKey.Network)
-	if err != nil {
-		return nil, fmt.Errorf("could not convert input data to coin: %w", err)
-	}
-
-	for i, coin := range coins {
-		err = coin.FullySignInput(i, p.tx, userKey, muunKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to sign input: %w", err)
-		}
-	}
-
-	return newTransaction(p.tx)
+	return newBatchSigner(p.tx, p.inputs).fullySign(userKey, muunKey)
 }
 
 func (p *PartiallySignedTransaction) Verify(expectations *SigningExpectations, userPublicKey *HDPublicKey, muunPublickKey *HDPublicKey) error {
@@ -260,12 +222,11 @@ func (p *PartiallySignedTransaction) Verify(expectations *SigningExpectations, u
 				expectedChange.DerivationPath(), err)
 		}
 
-		expectedChangeAddress, err := addresses.Create(
+		expectedChangeAddress, err := createWalletAddress(
 			expectedChange.Version(),
-			&derivedUserKey.key,
-			&derivedMuunKey.key,
+			derivedUserKey,
+			derivedMuunKey,
 			expectedChange.DerivationPath(),
-			network.network,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to build the change address with version %v: %w",
@@ -331,7 +292,9 @@ type coin interface {
 	FullySignInput(index int, tx *wire.MsgTx, userKey, muunKey *HDPrivateKey) error
 }
 
-func createCoin(input Input, network *Network) (coin, error) {
+// createCoin returns the coin to sign the input with. The sighash midstate of
+// the tx can be shared by all its inputs, and may be nil.
+func createCoin(input Input, network *Network, sigHashes *txscript.TxSigHashes) (coin, error) {
 	txID, err := chainhash.NewHash(input.OutPoint().TxId())
 	if err != nil {
 		return nil, err
@@ -340,93 +303,16 @@ func createCoin(input Input, network *Network) (coin, error) {
 		Hash:  *txID,
 		Index: uint32(input.OutPoint().Index()),
 	}
-	keyPath := input.Address().DerivationPath()
-	amount := btcutil.Amount(input.OutPoint().Amount())
-
-	version := input.Address().Version()
-
-	switch version {
-	case addresses.V1:
-		return &coinV1{
-			Network:  network.network,
-			OutPoint: outPoint,
-			KeyPath:  keyPath,
-		}, nil
-	case addresses.V2:
-		return &coinV2{
-			Network:       network.network,
-			OutPoint:      outPoint,
-			KeyPath:       keyPath,
-			MuunSignature: input.MuunSignature(),
-		}, nil
-	case addresses.V3:
-		return &coinV3{
-			Network:       network.network,
-			OutPoint:      outPoint,
-			KeyPath:       keyPath,
-			Amount:        amount,
-			MuunSignature: input.MuunSignature(),
-		}, nil
-	case addresses.V4:
-		return &coinV4{
-			Network:       network.network,
-			OutPoint:      outPoint,
-			KeyPath:       keyPath,
-			Amount:        amount,
-			MuunSignature: input.MuunSignature(),
-		}, nil
-	case addresses.SubmarineSwapV1:
-		swap := input.SubmarineSwapV1()
-		if swap == nil {
-			return nil, errors.New("submarine swap data is nil for swap input")
-		}
-		return &coinSubmarineSwapV1{
-			Network:         network.network,
-			OutPoint:        outPoint,
-			KeyPath:         keyPath,
-			Amount:          amount,
-			RefundAddress:   swap.RefundAddress(),
-			PaymentHash256:  swap.PaymentHash256(),
-			ServerPublicKey: swap.ServerPublicKey(),
-			LockTime:        swap.LockTime(),
-		}, nil
-	case addresses.SubmarineSwapV2:
-		swap := input.SubmarineSwapV2()
-		if swap == nil {
-			return nil, errors.New("submarine swap data is nil for swap input")
-		}
-		return &coinSubmarineSwapV2{
-			Network:             network.network,
-			OutPoint:            outPoint,
-			KeyPath:             keyPath,
-			Amount:              amount,
-			PaymentHash256:      swap.PaymentHash256(),
-			UserPublicKey:       swap.UserPublicKey(),
-			MuunPublicKey:       swap.MuunPublicKey(),
-			ServerPublicKey:     swap.ServerPublicKey(),
-			BlocksForExpiration: swap.BlocksForExpiration(),
-			ServerSignature:     swap.ServerSignature(),
-		}, nil
-	case addresses.IncomingSwap:
-		swap := input.IncomingSwap()
-		if swap == nil {
-			return nil, errors.New("incoming swap data is nil for incoming swap input")
-		}
-		swapServerPublicKey, err := hex.DecodeString(swap.SwapServerPublicKey())
-		if err != nil {
-			return nil, err
-		}
-		return &coinIncomingSwap{
-			Network:             network.network,
-			MuunSignature:       input.MuunSignature(),
-			Sphinx:              swap.Sphinx(),
-			HtlcTx:              swap.HtlcTx(),
-			PaymentHash256:      swap.PaymentHash256(),
-			SwapServerPublicKey: swapServerPublicKey,
-			ExpirationHeight:    swap.ExpirationHeight(),
-			Collect:             btcutil.Amount(swap.CollectInSats()),
-		}, nil
-	default:
-		return nil, fmt.Errorf("can't create coin from input version %v", version)
+	template, err := scriptTemplateFor(input.Address().Version())
+	if err != nil {
+		return nil, fmt.Errorf("can't create coin from input: %w", err)
 	}
+
+	return template.coin(input, &spentOutput{
+		network:   network.network,
+		outPoint:  outPoint,
+		keyPath:   input.Address().DerivationPath(),
+		amount:    btcutil.Amount(input.OutPoint().Amount()),
+		sigHashes: sigHashes,
+	})
 }
@@ -0,0 +1,173 @@
+package libwallet
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/muun/libwallet/walletdb"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	passphraseSaltPrefix = "muun passphrase"
+	passphraseIterations = 2048
+	passphraseSeedLength = 64
+)
+
+// walletContextIDLength is the length in bytes of the ids of hidden wallets.
+const walletContextIDLength = 8
+
+// decoyWalletDBs is the number of decoy dbs created along the db of each
+// hidden wallet, see createHiddenWalletDB.
+const decoyWalletDBs = 2
+
+// hiddenWalletDBPattern matches the names of the dbs of hidden wallets and
+// their decoys.
+var hiddenWalletDBPattern = "wallet-" + strings.Repeat("[0-9a-f]", 2*walletContextIDLength) + ".db"
+
+// NewHDPrivateKeyWithPassphrase builds an HD priv key from a seed and a
+// passphrase, in the spirit of BIP39 passphrases: each passphrase yields an
+// unrelated wallet from the same recovery material. The seed is stretched
+// with PBKDF2-HMAC-SHA512 (2048 iterations, salt "muun passphrase" followed
+// by the NFKD normalized passphrase) into the master seed. An empty
+// passphrase returns the same key as NewHDPrivateKey, so existing wallets are
+// unaffected.
+func NewHDPrivateKeyWithPassphrase(seed []byte, passphrase string, network *Network) (*HDPrivateKey, error) {
+	passphrase = norm.NFKD.String(passphrase)
+	if passphrase == "" {
+		return NewHDPrivateKey(seed, network)
+	}
+
+	salt := []byte(passphraseSaltPrefix + passphrase)
+	stretched := pbkdf2.Key(seed, salt, passphraseIterations, passphraseSeedLength, sha512.New)
+
+	return NewHDPrivateKey(stretched, network)
+}
+
+// walletContext identifies the wallet whose data is being accessed. Each
+// passphrase-scoped wallet keeps its own db, so hidden wallets don't leak
+// their invoices into the default one. The dbs of hidden wallets are named
+// after their id, which doesn't reveal the passphrase, and are created along
+// decoys named the same way, see createHiddenWalletDB.
+var walletContext struct {
+	sync.Mutex
+	id string // empty for the default wallet
+}
+
+// SwitchWalletContext makes the wallet of the seed and passphrase the active
+// one, and returns its root key, see NewHDPrivateKeyWithPassphrase. Data
+// stored from then on, like invoice secrets, is kept apart from other
+// wallets. An empty passphrase makes the default wallet the active one.
+func SwitchWalletContext(seed []byte, passphrase string, network *Network) (*HDPrivateKey, error) {
+	rootKey, err := NewHDPrivateKeyWithPassphrase(seed, passphrase, network)
+	if err != nil {
+		return nil, fmt.Errorf("SwitchWalletContext: %w", err)
+	}
+
+	id := ""
+	if norm.NFKD.String(passphrase) != "" {
+		hash := btcutil.Hash160(rootKey.PublicKey().Raw())
+		id = hex.EncodeToString(hash[:walletContextIDLength])
+	}
+
+	walletContext.Lock()
+	defer walletContext.Unlock()
+
+	if id != "" {
+		if err := createHiddenWalletDB(walletDBPath(id)); err != nil {
+			return nil, fmt.Errorf("SwitchWalletContext: %w", err)
+		}
+	}
+	walletContext.id = id
+	clearIdentityKeyCache()
+
+	return rootKey, nil
+}
+
+// ResetWalletContext makes the default wallet, the one without passphrase,
+// the active one.
+func ResetWalletContext() {
+	walletContext.Lock()
+	defer walletContext.Unlock()
+
+	walletContext.id = ""
+	clearIdentityKeyCache()
+}
+
+// ActiveWalletContext returns an identifier of the active wallet, empty for
+// the default one. It doesn't reveal the passphrase.
+func ActiveWalletContext() string {
+	walletContext.Lock()
+	defer walletContext.Unlock()
+
+	return walletContext.id
+}
+
+// walletDBPath returns the path of the db of the wallet context id.
+func walletDBPath(id string) string {
+	if id != "" {
+		return path.Join(cfg.DataDir, "wallet-"+id+".db")
+	}
+	return path.Join(cfg.DataDir, "wallet.db")
+}
+
+// createHiddenWalletDB creates the db of a hidden wallet along with
+// decoyWalletDBs decoys named the same way, unless it exists already. They're
+// all created empty, without running migrations, and the decoys are migrated
+// whenever the hidden wallet db is, see runMigrations. This way which of the
+// dbs belongs to the wallet can't be told by their names, creation times or
+// schemas. Rows written by the wallet aren't mirrored into the decoys.
+func createHiddenWalletDB(dbPath string) error {
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		return err
+	}
+
+	paths := []string{dbPath}
+	for i := 0; i < decoyWalletDBs; i++ {
+		id := hex.EncodeToString(randomBytes(walletContextIDLength))
+		paths = append(paths, walletDBPath(id))
+	}
+	for _, p := range paths {
+		file, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return fmt.Errorf("can't create hidden wallet db: %w", err)
+		}
+		file.Close()
+	}
+	return nil
+}
+
+// isHiddenWalletDB tells whether the db belongs to a hidden wallet, or is one
+// of their decoys.
+func isHiddenWalletDB(dbPath string) bool {
+	matched, _ := filepath.Match(hiddenWalletDBPattern, filepath.Base(dbPath))
+	return matched
+}
+
+// migrateDecoyWalletDBs applies the migrations to the hidden wallet dbs other
+// than the given one, so the decoys keep the same schema as the real ones.
+func migrateDecoyWalletDBs(dbPath string) error {
+	others, err := filepath.Glob(path.Join(cfg.DataDir, hiddenWalletDBPattern))
+	if err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other == dbPath {
+			continue
+		}
+		db, err := walletdb.OpenWithMacKey(other, cfg.DeviceSecret)
+		if err != nil {
+			return fmt.Errorf("can't migrate decoy wallet db: %w", err)
+		}
+		db.Close()
+	}
+	return nil
+}
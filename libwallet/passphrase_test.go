@@ -0,0 +1,176 @@
+package libwallet
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestNewHDPrivateKeyWithPassphrase(t *testing.T) {
+	network := Regtest()
+	seed := randomBytes(32)
+
+	plain, err := NewHDPrivateKey(seed, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := NewHDPrivateKeyWithPassphrase(seed, "", network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.String() != empty.String() {
+		t.Fatal("expected empty passphrase to keep the default key")
+	}
+
+	hidden, err := NewHDPrivateKeyWithPassphrase(seed, "hidden", network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewHDPrivateKeyWithPassphrase(seed, "hidden", network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewHDPrivateKeyWithPassphrase(seed, "other", network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hidden.String() != again.String() {
+		t.Fatal("expected derivation to be deterministic")
+	}
+	if hidden.String() == plain.String() || hidden.String() == other.String() {
+		t.Fatal("expected each passphrase to yield a different key")
+	}
+
+	// the passphrase is NFKD normalized, so "é" typed precomposed or
+	// decomposed yields the same key
+	composed, err := NewHDPrivateKeyWithPassphrase(seed, "caf\u00e9", network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decomposed, err := NewHDPrivateKeyWithPassphrase(seed, "cafe\u0301", network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if composed.String() != decomposed.String() {
+		t.Fatal("expected equivalent passphrases to yield the same key")
+	}
+}
+
+func TestWalletContext(t *testing.T) {
+	setup()
+	defer ResetWalletContext()
+
+	network := Regtest()
+	seed := randomBytes(32)
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	generateAndPersist := func(rootKey *HDPrivateKey) int {
+		userKey, err := rootKey.DeriveTo("m/schema:1'/recovery:1'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PersistInvoiceSecrets(secrets); err != nil {
+			t.Fatal(err)
+		}
+		return secrets.Length()
+	}
+
+	defaultKey, _ := NewHDPrivateKeyWithPassphrase(seed, "", network)
+
+	if ActiveWalletContext() != "" {
+		t.Fatal("expected the default context to be active")
+	}
+	if n := generateAndPersist(defaultKey); n != MaxUnusedSecrets {
+		t.Fatalf("expected %v secrets, got %v", MaxUnusedSecrets, n)
+	}
+
+	walletDBs := func() []string {
+		files, err := ioutil.ReadDir(cfg.DataDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), "wallet-") {
+				names = append(names, file.Name())
+			}
+		}
+		return names
+	}
+	// the default wallet doesn't create any hidden wallet db
+	if dbs := walletDBs(); len(dbs) != 0 {
+		t.Fatalf("expected no hidden wallet dbs, got %v", dbs)
+	}
+
+	hiddenKey, err := SwitchWalletContext(seed, "hidden", network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ActiveWalletContext() == "" {
+		t.Fatal("expected a hidden context to be active")
+	}
+
+	// its db is created along decoys that look alike, without migrations
+	dbs := walletDBs()
+	if len(dbs) != decoyWalletDBs+1 {
+		t.Fatalf("expected %v wallet dbs, got %v", decoyWalletDBs+1, dbs)
+	}
+	for _, name := range dbs {
+		info, err := os.Stat(path.Join(cfg.DataDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(name) != len(dbs[0]) || info.Size() != 0 {
+			t.Fatalf("expected hidden and decoy dbs to look alike, got %v", dbs)
+		}
+	}
+
+	// the hidden wallet doesn't see the default wallet's secrets
+	if n := generateAndPersist(hiddenKey); n != MaxUnusedSecrets {
+		t.Fatalf("expected %v secrets, got %v", MaxUnusedSecrets, n)
+	}
+
+	// the decoys are migrated along the hidden wallet db
+	for _, name := range dbs {
+		db, err := walletdb.Attach(path.Join(cfg.DataDir, name), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, err := db.PendingMigrations()
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending {
+			t.Fatalf("expected %v to be migrated", name)
+		}
+	}
+
+	// switching to it again doesn't create more decoys
+	if _, err := SwitchWalletContext(seed, "hidden", network); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(walletDBs()); n != decoyWalletDBs+1 {
+		t.Fatalf("expected %v wallet dbs, got %v", decoyWalletDBs+1, n)
+	}
+
+	// an empty passphrase goes back to the default wallet
+	if _, err := SwitchWalletContext(seed, "", network); err != nil {
+		t.Fatal(err)
+	}
+	if ActiveWalletContext() != "" {
+		t.Fatal("expected the default context to be active")
+	}
+	if n := generateAndPersist(defaultKey); n != 0 {
+		t.Fatalf("expected no new secrets, got %v", n)
+	}
+}
@@ -0,0 +1,238 @@
+package libwallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/muun/libwallet/addresses"
+	"github.com/muun/libwallet/hdpath"
+)
+
+// simulationContextPrefix starts the wallet context ids of simulations, so
+// their invoices are kept out of the wallet db and the listener.
+const simulationContextPrefix = "simulation-"
+
+// simulationExpirationHeight is the expiry of the simulated htlc. Its block
+// height is left unset, so the expiry isn't checked against the chain tip.
+const simulationExpirationHeight = 1000
+
+// IncomingPaymentSimulation is the outcome of SimulateIncomingPayment.
+type IncomingPaymentSimulation struct {
+	PaymentHash   []byte
+	FulfillmentTx []byte // signed fulfillment tx, spending a fake htlc
+	ElapsedMillis int64  // time the whole pipeline took
+}
+
+// SimulateIncomingPayment runs a payment of amountSat through the same steps
+// as a real one: an invoice is created, an htlc paying it and its sphinx
+// packet are fabricated with throwaway regtest keys, and the swap is checked
+// with VerifyFulfillable and signed with Fulfill. It lets apps check the
+// device can receive, eg during onboarding, before real funds move. The
+// simulation uses its own db in DataDir, removed afterwards, and no invoice
+// events are notified. It must not run concurrently with other wallet
+// operations, which would use its db.
+func SimulateIncomingPayment(amountSat int64) (_ *IncomingPaymentSimulation, err error) {
+	defer recordErrors("SimulateIncomingPayment", &err)
+
+	if err := validateAmountSat(amountSat); err != nil {
+		return nil, err
+	}
+	if amountSat < dustThreshold {
+		return nil, fmt.Errorf("SimulateIncomingPayment: amount is below the dust threshold (%v sats)", dustThreshold)
+	}
+
+	start := time.Now()
+	defer enterSimulationContext()()
+
+	simulation, err := simulateIncomingPayment(amountSat)
+	if err != nil {
+		return nil, fmt.Errorf("SimulateIncomingPayment: %w", err)
+	}
+	simulation.ElapsedMillis = time.Since(start).Milliseconds()
+	return simulation, nil
+}
+
+// enterSimulationContext makes a new simulation wallet context the active
+// one, and returns a func that removes its db and restores the previous one.
+func enterSimulationContext() func() {
+	walletContext.Lock()
+	defer walletContext.Unlock()
+
+	previous := walletContext.id
+	walletContext.id = simulationContextPrefix + hex.EncodeToString(randomBytes(8))
+
+	return func() {
+		os.Remove(dbPath())
+
+		walletContext.Lock()
+		defer walletContext.Unlock()
+		walletContext.id = previous
+	}
+}
+
+func isSimulating() bool {
+	return strings.HasPrefix(ActiveWalletContext(), simulationContextPrefix)
+}
+
+func simulateIncomingPayment(amountSat int64) (*IncomingPaymentSimulation, error) {
+	net := Regtest()
+	basePath := "m/schema:1'/recovery:1'"
+
+	userKey, err := NewHDPrivateKey(randomBytes(32), net)
+	if err != nil {
+		return nil, err
+	}
+	userKey.Path = basePath
+	muunKey, err := NewHDPrivateKey(randomBytes(32), net)
+	if err != nil {
+		return nil, err
+	}
+	muunKey.Path = basePath
+
+	secrets, err := generateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey(), 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate invoice secrets: %w", err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		return nil, fmt.Errorf("failed to persist invoice secrets: %w", err)
+	}
+
+	hintNode, err := simulationRouteHintNode()
+	if err != nil {
+		return nil, err
+	}
+	bech32, err := CreateInvoice(net, userKey, &RouteHints{
+		Pubkey:                    hintNode,
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: amountSat})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoice: %w", err)
+	}
+	invoice := secrets.Get(0)
+
+	// the faucet plays the swap server and the payer
+	faucet, err := NewRegtestFaucet(randomBytes(32))
+	if err != nil {
+		return nil, err
+	}
+	htlc, err := faucet.FundHtlc(
+		invoice.UserHtlcKey, invoice.MuunHtlcKey, invoice.PaymentHash, amountSat, simulationExpirationHeight,
+	)
+	if err != nil {
+		return nil, err
+	}
+	sphinxPacket, err := faucet.CreateSphinxPacket(bech32, amountSat, simulationExpirationHeight)
+	if err != nil {
+		return nil, err
+	}
+	htlcTx := wire.NewMsgTx(0)
+	if err := htlcTx.Deserialize(bytes.NewReader(htlc.HtlcTx)); err != nil {
+		return nil, err
+	}
+
+	// the fulfillment pays the whole htlc to a wallet address
+	outputPath := basePath + "/external:1/0"
+	userOutputKey, err := userKey.PublicKey().DeriveTo(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	muunOutputKey, err := muunKey.PublicKey().DeriveTo(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	outputAddress, err := createWalletAddress(addresses.V4, userOutputKey, muunOutputKey, outputPath)
+	if err != nil {
+		return nil, err
+	}
+	outputScript, err := addressToScript(outputAddress.Address(), net)
+	if err != nil {
+		return nil, err
+	}
+
+	htlcHash := htlcTx.TxHash()
+	fulfillmentTx := wire.NewMsgTx(1)
+	fulfillmentTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&htlcHash, 0), nil, nil))
+	fulfillmentTx.AddTxOut(wire.NewTxOut(amountSat, outputScript))
+
+	htlcKeyPath := hdpath.MustParse(invoice.keyPath).Child(htlcKeyChildIndex)
+	muunHtlcKey, err := muunKey.DeriveTo(htlcKeyPath.String())
+	if err != nil {
+		return nil, err
+	}
+	muunSigningKey, err := muunHtlcKey.key.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+	muunSignature, err := txscript.RawTxInWitnessSignature(
+		fulfillmentTx,
+		txscript.NewTxSigHashes(fulfillmentTx),
+		0,
+		amountSat,
+		htlc.WitnessScript,
+		txscript.SigHashAll,
+		muunSigningKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawFulfillmentTx bytes.Buffer
+	if err := fulfillmentTx.Serialize(&rawFulfillmentTx); err != nil {
+		return nil, err
+	}
+
+	swap := &IncomingSwap{
+		SphinxPacket:     sphinxPacket,
+		PaymentHash:      invoice.PaymentHash,
+		PaymentAmountSat: amountSat,
+		Htlc: &IncomingSwapHtlc{
+			HtlcTx:              htlc.HtlcTx,
+			ExpirationHeight:    htlc.ExpirationHeight,
+			SwapServerPublicKey: htlc.SwapServerPublicKey,
+		},
+	}
+	if err := swap.VerifyFulfillable(userKey, net); err != nil {
+		return nil, err
+	}
+	result, err := swap.Fulfill(&IncomingSwapFulfillmentData{
+		FulfillmentTx:      rawFulfillmentTx.Bytes(),
+		MuunSignature:      muunSignature,
+		ConfirmationTarget: 1,
+	}, userKey, muunKey.PublicKey(), net)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IncomingPaymentSimulation{
+		PaymentHash:   invoice.PaymentHash,
+		FulfillmentTx: result.FulfillmentTx,
+	}, nil
+}
+
+// simulationRouteHintNode returns a node the simulated invoice can have a
+// route hint for: one of the trusted nodes if there's a list, or else a
+// random one.
+func simulationRouteHintNode() (string, error) {
+	nodes, err := parseRouteHintNodes(cfg.RouteHintNodes)
+	if err != nil {
+		return "", err
+	}
+	for node := range nodes {
+		return node, nil
+	}
+
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key.PubKey().SerializeCompressed()), nil
+}
@@ -0,0 +1,55 @@
+package libwallet
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestSimulateIncomingPayment(t *testing.T) {
+	setup()
+	defer setup()
+
+	listener := &recordingInvoiceListener{}
+	cfg.InvoiceListener = listener
+
+	simulation, err := SimulateIncomingPayment(10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := wire.NewMsgTx(0)
+	if err := tx.Deserialize(bytes.NewReader(simulation.FulfillmentTx)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.TxIn) != 1 || len(tx.TxIn[0].Witness) == 0 {
+		t.Fatal("expected a signed fulfillment tx")
+	}
+	if tx.TxOut[0].Value != 10000 {
+		t.Fatalf("expected fulfillment of 10000 sats, got %v", tx.TxOut[0].Value)
+	}
+
+	// nothing is left behind
+	if len(listener.events) != 0 {
+		t.Fatalf("expected no invoice events, got %v", listener.events)
+	}
+	if ActiveWalletContext() != "" {
+		t.Fatalf("expected the wallet context to be restored, got %v", ActiveWalletContext())
+	}
+	files, err := ioutil.ReadDir(cfg.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), "wallet-"+simulationContextPrefix) {
+			t.Fatalf("expected the simulation db to be removed, found %v", file.Name())
+		}
+	}
+
+	if _, err := SimulateIncomingPayment(dustThreshold - 1); err == nil {
+		t.Fatal("expected error simulating a dust payment")
+	}
+}
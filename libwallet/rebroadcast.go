@@ -0,0 +1,208 @@
+package libwallet
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// DefaultRebroadcastInterval is how often unconfirmed txs are re-announced
+// when no interval is configured.
+const DefaultRebroadcastInterval = 30 * time.Minute
+
+// DefaultFeeBumpAfter is how long a tx can stay unconfirmed before a fee bump
+// is suggested when no time is configured.
+const DefaultFeeBumpAfter = 24 * time.Hour
+
+// Tx statuses reported by TxStatusProvider.
+const (
+	TxStatusUnconfirmed = "unconfirmed"
+	TxStatusConfirmed   = "confirmed"
+	// TxStatusConflicted is reported for txs that will never confirm because
+	// a tx spending the same outputs did.
+	TxStatusConflicted = "conflicted"
+)
+
+// TxStatusProvider is implemented by the apps to report the status of txs in
+// the chain, since libwallet doesn't follow it.
+type TxStatusProvider interface {
+	// TxStatus returns one of the TxStatus constants for the tx.
+	TxStatus(txId string) (string, error)
+}
+
+// RebroadcastStatus is the rebroadcast state of a tracked tx.
+type RebroadcastStatus struct {
+	TxId             string
+	State            string // one of the TxStatus constants
+	Broadcasts       int64
+	LastBroadcastAt  int64 // unix seconds, 0 if never rebroadcast
+	FeeBumpSuggested bool  // unconfirmed for longer than FeeBumpAfterSeconds
+}
+
+// RebroadcastStatusList is a wrapper around a RebroadcastStatus slice to be
+// able to pass through the gomobile bridge.
+type RebroadcastStatusList struct {
+	statuses []*RebroadcastStatus
+}
+
+// Length returns the number of statuses in the list.
+func (l *RebroadcastStatusList) Length() int {
+	return len(l.statuses)
+}
+
+// Get returns the status at the given index.
+func (l *RebroadcastStatusList) Get(i int) *RebroadcastStatus {
+	return l.statuses[i]
+}
+
+// TrackTransaction starts re-announcing the broadcast tx on every Rebroadcast
+// call until it or a conflicting tx confirms. Tracking a tx twice is a no-op.
+// It returns the tx hash.
+func TrackTransaction(rawTx []byte) (_ string, err error) {
+	defer recordErrors("TrackTransaction", &err)
+
+	tx := wire.NewMsgTx(0)
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return "", fmt.Errorf("TrackTransaction: failed to decode tx: %w", err)
+	}
+	txId := tx.TxHash().String()
+
+	db, err := openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	tracked, err := db.FindTrackedTransaction(txId)
+	if err != nil {
+		return "", fmt.Errorf("TrackTransaction: %w", err)
+	}
+	if tracked != nil {
+		return txId, nil
+	}
+
+	err = db.SaveTrackedTransaction(&walletdb.TrackedTransaction{
+		TxId:  txId,
+		RawTx: rawTx,
+		State: walletdb.TrackedTxStateUnconfirmed,
+	})
+	if err != nil {
+		return "", fmt.Errorf("TrackTransaction: %w", err)
+	}
+	return txId, nil
+}
+
+// Rebroadcast updates the status of the tracked txs and re-announces the
+// unconfirmed ones not broadcast in the last RebroadcastIntervalSeconds. Txs
+// stop being announced once they or a conflicting tx confirm. Apps should call
+// it periodically, eg every few minutes while in the foreground. Broadcast
+// failures are retried on the next call. It returns the status of every
+// tracked tx.
+func Rebroadcast(broadcaster TxBroadcaster, chain TxStatusProvider) (_ *RebroadcastStatusList, err error) {
+	defer recordErrors("Rebroadcast", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return rebroadcast(db, broadcaster, chain, walletNow())
+}
+
+func rebroadcast(db *walletdb.DB, broadcaster TxBroadcaster, chain TxStatusProvider, now time.Time) (*RebroadcastStatusList, error) {
+	txs, err := db.ListTrackedTransactions()
+	if err != nil {
+		return nil, fmt.Errorf("Rebroadcast: %w", err)
+	}
+
+	for i := range txs {
+		tx := &txs[i]
+		if tx.State != walletdb.TrackedTxStateUnconfirmed {
+			continue
+		}
+
+		// If the status is unknown the tx is announced anyway, it's harmless
+		status, err := chain.TxStatus(tx.TxId)
+		if err == nil {
+			switch status {
+			case TxStatusUnconfirmed:
+			case TxStatusConfirmed, TxStatusConflicted:
+				tx.State = walletdb.TrackedTxState(status)
+				if err := db.SaveTrackedTransaction(tx); err != nil {
+					return nil, fmt.Errorf("Rebroadcast: %w", err)
+				}
+				continue
+			default:
+				return nil, fmt.Errorf("Rebroadcast: unknown status %v for tx %v", status, tx.TxId)
+			}
+		}
+
+		if tx.LastBroadcastAt != nil && now.Sub(*tx.LastBroadcastAt) < rebroadcastInterval() {
+			continue
+		}
+		if err := broadcaster.BroadcastTx(tx.RawTx); err != nil {
+			continue
+		}
+		tx.Broadcasts++
+		tx.LastBroadcastAt = &now
+		if err := db.SaveTrackedTransaction(tx); err != nil {
+			return nil, fmt.Errorf("Rebroadcast: %w", err)
+		}
+	}
+
+	return rebroadcastStatuses(txs, now), nil
+}
+
+// RebroadcastStatuses returns the status of every tracked tx as of the last
+// Rebroadcast call.
+func RebroadcastStatuses() (_ *RebroadcastStatusList, err error) {
+	defer recordErrors("RebroadcastStatuses", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	txs, err := db.ListTrackedTransactions()
+	if err != nil {
+		return nil, fmt.Errorf("RebroadcastStatuses: %w", err)
+	}
+	return rebroadcastStatuses(txs, walletNow()), nil
+}
+
+func rebroadcastStatuses(txs []walletdb.TrackedTransaction, now time.Time) *RebroadcastStatusList {
+	list := &RebroadcastStatusList{}
+	for _, tx := range txs {
+		status := &RebroadcastStatus{
+			TxId:       tx.TxId,
+			State:      string(tx.State),
+			Broadcasts: tx.Broadcasts,
+			FeeBumpSuggested: tx.State == walletdb.TrackedTxStateUnconfirmed &&
+				now.Sub(tx.CreatedAt) > feeBumpAfter(),
+		}
+		if tx.LastBroadcastAt != nil {
+			status.LastBroadcastAt = tx.LastBroadcastAt.Unix()
+		}
+		list.statuses = append(list.statuses, status)
+	}
+	return list
+}
+
+func rebroadcastInterval() time.Duration {
+	if cfg.RebroadcastIntervalSeconds > 0 {
+		return time.Duration(cfg.RebroadcastIntervalSeconds) * time.Second
+	}
+	return DefaultRebroadcastInterval
+}
+
+func feeBumpAfter() time.Duration {
+	if cfg.FeeBumpAfterSeconds > 0 {
+		return time.Duration(cfg.FeeBumpAfterSeconds) * time.Second
+	}
+	return DefaultFeeBumpAfter
+}
@@ -0,0 +1,131 @@
+package libwallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+type mapTxStatusProvider struct {
+	statuses map[string]string
+	err      error
+}
+
+func (p *mapTxStatusProvider) TxStatus(txId string) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	if status, ok := p.statuses[txId]; ok {
+		return status, nil
+	}
+	return TxStatusUnconfirmed, nil
+}
+
+func TestRebroadcast(t *testing.T) {
+	setup()
+
+	var txIds []string
+	var rawTxs [][]byte
+	for i := 0; i < 3; i++ {
+		rawTx := newQueuedTx(int64(1000 * (i + 1)))
+		txId, err := TrackTransaction(rawTx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txIds = append(txIds, txId)
+		rawTxs = append(rawTxs, rawTx)
+	}
+	if _, err := TrackTransaction(rawTxs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TrackTransaction([]byte{1, 2, 3}); err == nil {
+		t.Fatal("expected error for invalid tx")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	chain := &mapTxStatusProvider{statuses: map[string]string{}}
+	broadcaster := &recordingBroadcaster{}
+	now := time.Now()
+
+	list, err := rebroadcast(db, broadcaster, chain, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Length() != 3 || len(broadcaster.broadcast) != 3 {
+		t.Fatalf("expected 3 txs broadcast, got %v", len(broadcaster.broadcast))
+	}
+
+	// Txs aren't announced again before the interval
+	if _, err := rebroadcast(db, broadcaster, chain, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(broadcaster.broadcast) != 3 {
+		t.Fatalf("expected no txs broadcast within the interval, got %v", len(broadcaster.broadcast)-3)
+	}
+
+	chain.statuses[txIds[1]] = TxStatusConfirmed
+	chain.statuses[txIds[2]] = TxStatusConflicted
+	later := now.Add(DefaultRebroadcastInterval + time.Minute)
+	list, err = rebroadcast(db, broadcaster, chain, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broadcaster.broadcast) != 4 || !bytes.Equal(broadcaster.broadcast[3], rawTxs[0]) {
+		t.Fatal("expected only the unconfirmed tx to be broadcast again")
+	}
+	expected := []string{TxStatusUnconfirmed, TxStatusConfirmed, TxStatusConflicted}
+	for i, state := range expected {
+		status := list.Get(i)
+		if status.TxId != txIds[i] || status.State != state {
+			t.Fatalf("unexpected status %+v, expected %v", status, state)
+		}
+		if status.FeeBumpSuggested {
+			t.Fatalf("expected no fee bump suggested yet for %v", status.TxId)
+		}
+	}
+	if list.Get(0).Broadcasts != 2 || list.Get(0).LastBroadcastAt != later.Unix() {
+		t.Fatalf("unexpected broadcasts %+v", list.Get(0))
+	}
+
+	// Txs are announced if their status is unknown, and failures are retried
+	chain.err = errors.New("offline")
+	if _, err := rebroadcast(db, &recordingBroadcaster{err: errors.New("offline")}, chain, later.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	list, err = rebroadcast(db, broadcaster, chain, later.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Get(0).Broadcasts != 3 {
+		t.Fatalf("expected tx to be broadcast with unknown status, got %+v", list.Get(0))
+	}
+
+	txs, err := db.ListTrackedTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := rebroadcastStatuses(txs, now.Add(DefaultFeeBumpAfter+time.Hour))
+	if !statuses.Get(0).FeeBumpSuggested || statuses.Get(1).FeeBumpSuggested || statuses.Get(2).FeeBumpSuggested {
+		t.Fatal("expected a fee bump suggested only for the unconfirmed tx")
+	}
+
+	chain.err = nil
+	chain.statuses[txIds[0]] = "bogus"
+	if _, err := rebroadcast(db, broadcaster, chain, later.Add(2*time.Hour)); err == nil {
+		t.Fatal("expected error for unknown status")
+	}
+
+	statuses, err = RebroadcastStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses.Length() != 3 {
+		t.Fatalf("expected 3 statuses, got %v", statuses.Length())
+	}
+}
@@ -0,0 +1,155 @@
+package libwallet
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/muun/libwallet/addresses"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// Keys of the receive preferences in the wallet db settings.
+const (
+	settingDefaultDescription = "receive.defaultDescription"
+	settingDefaultExpiry      = "receive.defaultExpirySeconds"
+	settingAddressVersion     = "receive.addressVersion"
+	settingUnifiedQR          = "receive.unifiedQR"
+)
+
+// maxDescriptionLength is the longest description that fits an invoice
+// tagged field.
+const maxDescriptionLength = 639
+
+// defaultReceiveAddressVersion is the address version used if the user has
+// no preference.
+const defaultReceiveAddressVersion = addresses.V4
+
+// ReceivePreferences are the user defaults for receiving payments. They're
+// kept in the wallet db, so every app of the wallet honors the same ones.
+type ReceivePreferences struct {
+	// DefaultDescription is the description of invoices the user doesn't
+	// describe. Empty if none.
+	DefaultDescription string
+	// DefaultExpirySeconds is how long invoices can be paid for, see
+	// InvoiceOptions.ExpirySeconds.
+	DefaultExpirySeconds int64
+	// AddressVersion is the version of the on-chain addresses shown to
+	// receive, see package addresses.
+	AddressVersion int64
+	// UnifiedQR shows a single QR with the address and the invoice, see
+	// BuildUnifiedPaymentURI, instead of one for each.
+	UnifiedQR bool
+}
+
+// GetReceivePreferences returns the receive preferences, with the defaults
+// for the ones the user didn't set.
+func GetReceivePreferences() (_ *ReceivePreferences, err error) {
+	defer recordErrors("GetReceivePreferences", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	prefs := &ReceivePreferences{
+		DefaultExpirySeconds: int64(defaultInvoiceExpiry.Seconds()),
+		AddressVersion:       defaultReceiveAddressVersion,
+	}
+
+	prefs.DefaultDescription, _, err = db.FindSetting(settingDefaultDescription)
+	if err != nil {
+		return nil, fmt.Errorf("GetReceivePreferences: %w", err)
+	}
+	if err := findIntSetting(db, settingDefaultExpiry, &prefs.DefaultExpirySeconds); err != nil {
+		return nil, fmt.Errorf("GetReceivePreferences: %w", err)
+	}
+	if err := findIntSetting(db, settingAddressVersion, &prefs.AddressVersion); err != nil {
+		return nil, fmt.Errorf("GetReceivePreferences: %w", err)
+	}
+	unifiedQR, found, err := db.FindSetting(settingUnifiedQR)
+	if err != nil {
+		return nil, fmt.Errorf("GetReceivePreferences: %w", err)
+	}
+	if found {
+		prefs.UnifiedQR, err = strconv.ParseBool(unifiedQR)
+		if err != nil {
+			return nil, fmt.Errorf("GetReceivePreferences: invalid %v setting: %w", settingUnifiedQR, err)
+		}
+	}
+
+	return prefs, nil
+}
+
+// findIntSetting parses the setting into value, which is left as is if the
+// setting isn't set.
+func findIntSetting(db *walletdb.DB, key string, value *int64) error {
+	s, found, err := db.FindSetting(key)
+	if err != nil || !found {
+		return err
+	}
+	*value, err = strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %v setting: %w", key, err)
+	}
+	return nil
+}
+
+// SetDefaultDescription sets the description of invoices the user doesn't
+// describe. Use an empty one to clear it.
+func SetDefaultDescription(description string) (err error) {
+	defer recordErrors("SetDefaultDescription", &err)
+
+	if len(description) > maxDescriptionLength {
+		return fmt.Errorf("SetDefaultDescription: description is longer than %v bytes", maxDescriptionLength)
+	}
+	return saveSetting("SetDefaultDescription", settingDefaultDescription, description)
+}
+
+// SetDefaultExpirySeconds sets how long invoices can be paid for by
+// default. Use 0 to go back to the default invoice expiry.
+func SetDefaultExpirySeconds(seconds int64) (err error) {
+	defer recordErrors("SetDefaultExpirySeconds", &err)
+
+	if seconds < 0 {
+		return fmt.Errorf("SetDefaultExpirySeconds: invalid expiry %v", seconds)
+	}
+	if seconds == 0 {
+		seconds = int64(defaultInvoiceExpiry.Seconds())
+	}
+	return saveSetting("SetDefaultExpirySeconds", settingDefaultExpiry, strconv.FormatInt(seconds, 10))
+}
+
+// SetPreferredAddressVersion sets the version of the on-chain addresses
+// shown to receive. It must be a multisig version wallet addresses can be
+// created for, V2 or later.
+func SetPreferredAddressVersion(version int64) (err error) {
+	defer recordErrors("SetPreferredAddressVersion", &err)
+
+	template, err := scriptTemplateFor(int(version))
+	if err != nil || template.address == nil || version == addresses.V1 {
+		return fmt.Errorf("SetPreferredAddressVersion: can't receive on addresses of version %v", version)
+	}
+	return saveSetting("SetPreferredAddressVersion", settingAddressVersion, strconv.FormatInt(version, 10))
+}
+
+// SetUnifiedQR sets whether a single QR with the address and the invoice is
+// shown to receive.
+func SetUnifiedQR(enabled bool) (err error) {
+	defer recordErrors("SetUnifiedQR", &err)
+
+	return saveSetting("SetUnifiedQR", settingUnifiedQR, strconv.FormatBool(enabled))
+}
+
+func saveSetting(op, key, value string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.SaveSetting(key, value); err != nil {
+		return fmt.Errorf("%v: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package libwallet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/muun/libwallet/addresses"
+)
+
+func TestReceivePreferences(t *testing.T) {
+	setup()
+
+	prefs, err := GetReceivePreferences()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs.DefaultDescription != "" ||
+		prefs.DefaultExpirySeconds != 3600 ||
+		prefs.AddressVersion != addresses.V4 ||
+		prefs.UnifiedQR {
+		t.Fatalf("unexpected default preferences %+v", prefs)
+	}
+
+	if err := SetDefaultDescription("coffee"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDefaultExpirySeconds(600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPreferredAddressVersion(addresses.V3); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetUnifiedQR(true); err != nil {
+		t.Fatal(err)
+	}
+	// overwriting keeps a single value
+	if err := SetDefaultDescription("tea"); err != nil {
+		t.Fatal(err)
+	}
+
+	prefs, err = GetReceivePreferences()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ReceivePreferences{
+		DefaultDescription:   "tea",
+		DefaultExpirySeconds: 600,
+		AddressVersion:       addresses.V3,
+		UnifiedQR:            true,
+	}
+	if *prefs != expected {
+		t.Fatalf("expected %+v, got %+v", expected, prefs)
+	}
+
+	if err := SetDefaultExpirySeconds(0); err != nil {
+		t.Fatal(err)
+	}
+	if prefs, _ := GetReceivePreferences(); prefs.DefaultExpirySeconds != 3600 {
+		t.Fatalf("expected the default expiry back, got %v", prefs.DefaultExpirySeconds)
+	}
+
+	if err := SetDefaultDescription(strings.Repeat("a", 640)); err == nil {
+		t.Fatal("expected error with a description too long")
+	}
+	if err := SetDefaultExpirySeconds(-1); err == nil {
+		t.Fatal("expected error with a negative expiry")
+	}
+	for _, version := range []int64{addresses.V1, addresses.SubmarineSwapV2, addresses.IncomingSwap, 7} {
+		if err := SetPreferredAddressVersion(version); err == nil {
+			t.Fatalf("expected error with address version %v", version)
+		}
+	}
+}
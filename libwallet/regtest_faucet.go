@@ -0,0 +1,167 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	sphinx "github.com/lightningnetwork/lightning-onion"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+// RegtestFaucet plays the swap server and the payer of incoming payments on
+// regtest, so QA automation can drive the receive flow without a lightning
+// cluster. Everything it creates is derived from its seed and the inputs,
+// so runs with the same seed are reproducible. It refuses keys and invoices
+// of other networks.
+type RegtestFaucet struct {
+	seed []byte
+}
+
+// RegtestHtlc is an htlc paying an invoice, as the swap server would fund it.
+type RegtestHtlc struct {
+	HtlcTx              []byte // the funding tx, with the htlc at output 0
+	WitnessScript       []byte
+	SwapServerPublicKey []byte
+	ExpirationHeight    int64
+}
+
+// NewRegtestFaucet returns a faucet deriving its keys and txs from the seed.
+func NewRegtestFaucet(seed []byte) (*RegtestFaucet, error) {
+	if len(seed) == 0 {
+		return nil, fmt.Errorf("NewRegtestFaucet: empty seed")
+	}
+	return &RegtestFaucet{seed: seed}, nil
+}
+
+// derive returns a 32 byte value unique to the seed, the purpose and the data.
+func (f *RegtestFaucet) derive(purpose string, data ...[]byte) []byte {
+	h := sha256.New()
+	h.Write(f.seed)
+	h.Write([]byte(purpose))
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// SwapServerPublicKey returns the key the faucet funds htlcs with.
+func (f *RegtestFaucet) SwapServerPublicKey() []byte {
+	key, _ := btcec.PrivKeyFromBytes(btcec.S256(), f.derive("swap server key"))
+	return key.PubKey().SerializeCompressed()
+}
+
+// FundHtlc returns a tx funding an htlc of amountSat to the htlc keys of an
+// invoice, see InvoiceSecrets, spendable with its preimage until the
+// expiration height. The tx spends a made up output, so it can't be
+// broadcast.
+func (f *RegtestFaucet) FundHtlc(userHtlcKey, muunHtlcKey *HDPublicKey, paymentHash []byte, amountSat, expirationHeight int64) (*RegtestHtlc, error) {
+	for _, key := range []*HDPublicKey{userHtlcKey, muunHtlcKey} {
+		if err := checkNetwork(Regtest(), key.Network.Name(), "htlc key"); err != nil {
+			return nil, err
+		}
+	}
+	if len(paymentHash) != 32 {
+		return nil, fmt.Errorf("FundHtlc: invalid payment hash %x", paymentHash)
+	}
+	if err := validateAmountSat(amountSat); err != nil || amountSat == 0 {
+		return nil, fmt.Errorf("FundHtlc: invalid amount %v", amountSat)
+	}
+
+	swapServerPublicKey := f.SwapServerPublicKey()
+	script, err := createHtlcScript(
+		userHtlcKey.Raw(),
+		muunHtlcKey.Raw(),
+		swapServerPublicKey,
+		expirationHeight,
+		paymentHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("FundHtlc: %w", err)
+	}
+	witnessHash := sha256.Sum256(script)
+	address, err := btcutil.NewAddressWitnessScriptHash(witnessHash[:], Regtest().network)
+	if err != nil {
+		return nil, fmt.Errorf("FundHtlc: %w", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return nil, fmt.Errorf("FundHtlc: %w", err)
+	}
+
+	fundingHash, err := chainhash.NewHash(f.derive("funding outpoint", paymentHash))
+	if err != nil {
+		return nil, fmt.Errorf("FundHtlc: %w", err)
+	}
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(fundingHash, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(amountSat, pkScript))
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		return nil, fmt.Errorf("FundHtlc: %w", err)
+	}
+
+	return &RegtestHtlc{
+		HtlcTx:              buf.Bytes(),
+		WitnessScript:       script,
+		SwapServerPublicKey: swapServerPublicKey,
+		ExpirationHeight:    expirationHeight,
+	}, nil
+}
+
+// CreateSphinxPacket returns the onion a payer would send to pay amountSat
+// of the invoice in a single part, with the given htlc expiry.
+func (f *RegtestFaucet) CreateSphinxPacket(bech32 string, amountSat, lockTime int64) ([]byte, error) {
+	invoice, err := zpay32.Decode(bech32, Regtest().network)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSphinxPacket: couldn't decode invoice: %w", err)
+	}
+	if invoice.PaymentAddr == nil {
+		return nil, fmt.Errorf("CreateSphinxPacket: invoice has no payment secret")
+	}
+	if err := validateAmountSat(amountSat); err != nil || amountSat == 0 {
+		return nil, fmt.Errorf("CreateSphinxPacket: invalid amount %v", amountSat)
+	}
+
+	var paymentPath sphinx.PaymentPath
+	paymentPath[0].NodePub = *invoice.Destination
+
+	amount := uint64(lnwire.NewMSatFromSatoshis(btcutil.Amount(amountSat)))
+	expiry := uint32(lockTime)
+
+	var payload bytes.Buffer
+	err = tlv.MustNewStream(
+		record.NewAmtToFwdRecord(&amount),
+		record.NewLockTimeRecord(&expiry),
+		record.NewMPP(lnwire.MilliSatoshi(amount), *invoice.PaymentAddr).Record(),
+	).Encode(&payload)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSphinxPacket: %w", err)
+	}
+	hopPayload, err := sphinx.NewHopPayload(nil, payload.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("CreateSphinxPacket: %w", err)
+	}
+	paymentPath[0].HopPayload = hopPayload
+
+	sessionKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), f.derive("session key", invoice.PaymentHash[:]))
+	packet, err := sphinx.NewOnionPacket(&paymentPath, sessionKey, invoice.PaymentHash[:], sphinx.BlankPacketFiller)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSphinxPacket: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := packet.Encode(&buf); err != nil {
+		return nil, fmt.Errorf("CreateSphinxPacket: %w", err)
+	}
+	return buf.Bytes(), nil
+}
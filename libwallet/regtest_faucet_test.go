@@ -0,0 +1,91 @@
+package libwallet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegtestFaucet(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, _, _ := getInvoiceSecrets(invoice, userKey)
+	var secret *InvoiceSecrets
+	for i := 0; i < secrets.Length(); i++ {
+		if bytes.Equal(secrets.Get(i).PaymentHash, paymentHash) {
+			secret = secrets.Get(i)
+		}
+	}
+
+	faucet, err := NewRegtestFaucet([]byte("qa seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	htlc, err := faucet.FundHtlc(secret.UserHtlcKey, secret.MuunHtlcKey, paymentHash, 5000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := faucet.CreateSphinxPacket(invoice, 5000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same seed creates the same txs and packets
+	again, _ := NewRegtestFaucet([]byte("qa seed"))
+	htlcAgain, err := again.FundHtlc(secret.UserHtlcKey, secret.MuunHtlcKey, paymentHash, 5000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetAgain, err := again.CreateSphinxPacket(invoice, 5000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(htlc.HtlcTx, htlcAgain.HtlcTx) || !bytes.Equal(packet, packetAgain) {
+		t.Fatal("expected the faucet to be deterministic")
+	}
+
+	swap := &IncomingSwap{
+		SphinxPacket:     packet,
+		PaymentHash:      paymentHash,
+		PaymentAmountSat: 5000,
+		Htlc: &IncomingSwapHtlc{
+			HtlcTx:              htlc.HtlcTx,
+			ExpirationHeight:    htlc.ExpirationHeight,
+			SwapServerPublicKey: htlc.SwapServerPublicKey,
+		},
+	}
+	if err := swap.VerifyFulfillable(userKey, network); err != nil {
+		t.Fatal(err)
+	}
+
+	mainnetKey, _ := NewHDPrivateKey(randomBytes(32), Mainnet())
+	if _, err := faucet.FundHtlc(mainnetKey.PublicKey(), secret.MuunHtlcKey, paymentHash, 5000, 1000); err == nil {
+		t.Fatal("expected error funding htlcs of other networks")
+	}
+	if _, err := NewRegtestFaucet(nil); err == nil {
+		t.Fatal("expected error with an empty seed")
+	}
+}
@@ -0,0 +1,190 @@
+// Package retry implements the backoff policy shared by every libwallet module
+// that talks to the network, so transient failures behave the same way across
+// features.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Policy describes how an operation is retried.
+type Policy struct {
+	// InitialDelay is the wait before the first retry.
+	InitialDelay time.Duration
+	// MaxDelay caps the wait between two attempts.
+	MaxDelay time.Duration
+	// Multiplier grows the delay after every failed attempt.
+	Multiplier float64
+	// Jitter is the fraction (0 to 1) of each delay that is randomized.
+	Jitter float64
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// Budget, if set, is consulted before every retry and shared with other
+	// callers using the same budget.
+	Budget *Budget
+	// Classify decides whether an error is worth retrying. Defaults to
+	// IsRetryable.
+	Classify func(error) bool
+}
+
+// DefaultPolicy is a sensible policy for short interactive requests.
+var DefaultPolicy = Policy{
+	InitialDelay: 200 * time.Millisecond,
+	MaxDelay:     5 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+	MaxAttempts:  4,
+}
+
+// Delay returns the wait before the given retry (1 for the first retry),
+// without jitter.
+func (p *Policy) Delay(retry int) time.Duration {
+	if retry < 1 {
+		return 0
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+func (p *Policy) jittered(retry int) time.Duration {
+	delay := p.Delay(retry)
+	if p.Jitter <= 0 || delay == 0 {
+		return delay
+	}
+	jitter := math.Min(p.Jitter, 1)
+	spread := float64(delay) * jitter
+	return time.Duration(float64(delay) - spread + rand.Float64()*2*spread)
+}
+
+// Do calls fn until it succeeds, returns a non retryable error, the attempts
+// or budget are exhausted, or ctx is done. The last error is returned.
+func Do(ctx context.Context, p Policy, fn func() error) error {
+	classify := p.Classify
+	if classify == nil {
+		classify = IsRetryable
+	}
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts || !classify(err) {
+			return unwrapPermanent(err)
+		}
+		if p.Budget != nil && !p.Budget.Allow() {
+			return err
+		}
+
+		timer := time.NewTimer(p.jittered(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying. Do returns the wrapped error.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+func unwrapPermanent(err error) error {
+	if permanent, ok := err.(*permanentError); ok {
+		return permanent.err
+	}
+	return err
+}
+
+// IsRetryable is the default error classification: errors marked Permanent
+// and context cancellation are final, network timeouts and every other error
+// are retried.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	return true
+}
+
+// Budget limits how many retries may happen within a time window across all
+// operations sharing it, so an outage doesn't multiply the load on a server.
+type Budget struct {
+	mu      sync.Mutex
+	max     int
+	window  time.Duration
+	retries []time.Time
+	now     func() time.Time
+}
+
+// NewBudget returns a budget allowing up to max retries per window.
+func NewBudget(max int, window time.Duration) *Budget {
+	return &Budget{max: max, window: window, now: time.Now}
+}
+
+// Allow reports whether a retry may happen now and, if so, records it.
+func (b *Budget) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	cutoff := now.Add(-b.window)
+	kept := b.retries[:0]
+	for _, t := range b.retries {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	b.retries = kept
+
+	if len(b.retries) >= b.max {
+		return false
+	}
+	b.retries = append(b.retries, now)
+	return true
+}
@@ -0,0 +1,146 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func fastPolicy() Policy {
+	return Policy{
+		InitialDelay: time.Millisecond,
+		MaxDelay:     2 * time.Millisecond,
+		Multiplier:   2,
+		Jitter:       0.5,
+		MaxAttempts:  3,
+	}
+}
+
+func TestDo(t *testing.T) {
+
+	t.Run("succeeds after transient errors", func(t *testing.T) {
+		calls := 0
+		err := Do(context.Background(), fastPolicy(), func() error {
+			calls++
+			if calls < 3 {
+				return errTransient
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if calls != 3 {
+			t.Fatalf("expected 3 calls, got %v", calls)
+		}
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		calls := 0
+		err := Do(context.Background(), fastPolicy(), func() error {
+			calls++
+			return errTransient
+		})
+		if err != errTransient {
+			t.Fatalf("expected transient error, got %v", err)
+		}
+		if calls != 3 {
+			t.Fatalf("expected 3 calls, got %v", calls)
+		}
+	})
+
+	t.Run("permanent errors are not retried", func(t *testing.T) {
+		calls := 0
+		err := Do(context.Background(), fastPolicy(), func() error {
+			calls++
+			return Permanent(errTransient)
+		})
+		if err != errTransient {
+			t.Fatalf("expected unwrapped error, got %v", err)
+		}
+		if calls != 1 {
+			t.Fatalf("expected 1 call, got %v", calls)
+		}
+	})
+
+	t.Run("stops when context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		policy := fastPolicy()
+		policy.InitialDelay = time.Hour
+		policy.MaxDelay = time.Hour
+		calls := 0
+		err := Do(ctx, policy, func() error {
+			calls++
+			return errTransient
+		})
+		if err != errTransient || calls != 1 {
+			t.Fatalf("expected a single call, got %v calls and %v", calls, err)
+		}
+	})
+
+	t.Run("shared budget", func(t *testing.T) {
+		policy := fastPolicy()
+		policy.Budget = NewBudget(1, time.Hour)
+		calls := 0
+		fn := func() error {
+			calls++
+			return errTransient
+		}
+		_ = Do(context.Background(), policy, fn)
+		_ = Do(context.Background(), policy, fn)
+		// 2 attempts on the first call (1 retry), then 1 on the second
+		if calls != 3 {
+			t.Fatalf("expected 3 calls, got %v", calls)
+		}
+	})
+}
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.Delay(tt.retry); got != tt.want {
+			t.Errorf("Delay(%v) = %v, want %v", tt.retry, got, tt.want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := policy.jittered(2)
+		if d < time.Second || d > 3*time.Second {
+			t.Fatalf("jittered delay out of range: %v", d)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	now := time.Now()
+	budget := NewBudget(2, time.Minute)
+	budget.now = func() time.Time { return now }
+
+	if !budget.Allow() || !budget.Allow() {
+		t.Fatal("expected budget to allow 2 retries")
+	}
+	if budget.Allow() {
+		t.Fatal("expected budget to be exhausted")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if !budget.Allow() {
+		t.Fatal("expected budget to refill after the window")
+	}
+}
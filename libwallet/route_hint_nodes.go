@@ -0,0 +1,164 @@
+package libwallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// routeHintNodesTag prefixes the signed node list data, so signatures over
+// other messages can't be passed as node lists.
+const routeHintNodesTag = "muun route hint nodes"
+
+// RouteHintNodesUpdate is a list of the nodes route hints may point to,
+// signed by the server with its RouteHintNodesKey.
+type RouteHintNodesUpdate struct {
+	Nodes     string // comma separated node pubkeys
+	Version   int64  // must be greater than the one of the last update
+	Signature []byte // DER ecdsa signature over the digest
+}
+
+// digest returns the sha256 hash of the signed data.
+func (u *RouteHintNodesUpdate) digest() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(routeHintNodesTag)
+	binary.Write(&buf, binary.BigEndian, u.Version)
+	buf.WriteString(u.Nodes)
+
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+// UpdateRouteHintNodes replaces the nodes route hints may point to with the
+// ones in the update, which must be signed by the configured
+// RouteHintNodesKey. Updates with a version not greater than the last one
+// are rejected, so an old list can't be replayed. The list is kept in the
+// wallet db and takes precedence over the configured RouteHintNodes.
+func UpdateRouteHintNodes(u *RouteHintNodesUpdate) (err error) {
+	defer recordErrors("UpdateRouteHintNodes", &err)
+
+	if len(cfg.RouteHintNodesKey) == 0 {
+		return fmt.Errorf("UpdateRouteHintNodes: no route hint nodes key configured")
+	}
+	serverKey, err := btcec.ParsePubKey(cfg.RouteHintNodesKey, btcec.S256())
+	if err != nil {
+		return fmt.Errorf("UpdateRouteHintNodes: invalid route hint nodes key: %w", err)
+	}
+
+	sig, err := btcec.ParseDERSignature(u.Signature, btcec.S256())
+	if err != nil {
+		return fmt.Errorf("UpdateRouteHintNodes: invalid signature: %w", err)
+	}
+	if !sig.Verify(u.digest(), serverKey) {
+		countSecurityEvent(SecurityEventSignatureVerification)
+		return fmt.Errorf("UpdateRouteHintNodes: signature does not verify")
+	}
+
+	nodes, err := parseRouteHintNodes(u.Nodes)
+	if err != nil {
+		return fmt.Errorf("UpdateRouteHintNodes: %w", err)
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("UpdateRouteHintNodes: empty node list")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	latest, err := db.FindLatestRouteHintNodeList()
+	if err != nil {
+		return fmt.Errorf("UpdateRouteHintNodes: %w", err)
+	}
+	if latest != nil && u.Version <= latest.Version {
+		return fmt.Errorf("UpdateRouteHintNodes: version %v is not newer than %v", u.Version, latest.Version)
+	}
+
+	err = db.SaveRouteHintNodeList(&walletdb.RouteHintNodeList{
+		Version:   u.Version,
+		Nodes:     u.Nodes,
+		Signature: u.Signature,
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateRouteHintNodes: %w", err)
+	}
+	return nil
+}
+
+// ValidateRouteHints returns an error with the ErrUntrustedRouteHint code if
+// any of the route hints points to a node that isn't a known provider node,
+// so apps can check hints received from the server before using them.
+func ValidateRouteHints(routeHints *RouteHintsList) (err error) {
+	defer recordErrors("ValidateRouteHints", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return checkRouteHintNodes(db, routeHints.hints)
+}
+
+// checkRouteHintNodes returns an error with the ErrUntrustedRouteHint code if
+// any of the route hints points to a node that isn't in the list in use.
+func checkRouteHintNodes(db *walletdb.DB, routeHints []*RouteHints) error {
+	nodes, err := trustedRouteHintNodes(db)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	for _, hint := range routeHints {
+		nodeURI, err := ParseNodeURI(hint.Pubkey)
+		if err != nil {
+			return fmt.Errorf("can't parse route hint pubkey: %w", err)
+		}
+		if !nodes[nodeURI.PublicKey] {
+			return errors.Errorf(ErrUntrustedRouteHint, "route hint node %v is not a known provider node", nodeURI.PublicKey)
+		}
+	}
+	return nil
+}
+
+// trustedRouteHintNodes returns the nodes of the last update received, or the
+// configured ones if none was. It's empty if any node is accepted.
+func trustedRouteHintNodes(db *walletdb.DB) (map[string]bool, error) {
+	latest, err := db.FindLatestRouteHintNodeList()
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil {
+		return parseRouteHintNodes(latest.Nodes)
+	}
+	return parseRouteHintNodes(cfg.RouteHintNodes)
+}
+
+// parseRouteHintNodes returns the set of hex serialized compressed pubkeys in
+// the comma separated list.
+func parseRouteHintNodes(list string) (map[string]bool, error) {
+	nodes := make(map[string]bool)
+	for _, node := range strings.Split(list, ",") {
+		node = strings.TrimSpace(node)
+		if node == "" {
+			continue
+		}
+		key, err := parseNodePubKey(node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node pubkey %v: %w", node, err)
+		}
+		nodes[hex.EncodeToString(key.SerializeCompressed())] = true
+	}
+	return nodes, nil
+}
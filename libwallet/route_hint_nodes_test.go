@@ -0,0 +1,123 @@
+package libwallet
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+func signRouteHintNodes(key *btcec.PrivateKey, nodes string, version int64) *RouteHintNodesUpdate {
+	u := &RouteHintNodesUpdate{Nodes: nodes, Version: version}
+	sig, err := key.Sign(u.digest())
+	if err != nil {
+		panic(err)
+	}
+	u.Signature = sig.Serialize()
+	return u
+}
+
+func TestRouteHintNodes(t *testing.T) {
+	const (
+		pinnedNode = "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd"
+		otherNode  = "028cfad4e092191a41f081bedfbe5a6e8f441603c78bf9001b8fb62ac0858f20ed"
+	)
+
+	hints := func(pubkeys ...string) *RouteHintsList {
+		list := &RouteHintsList{}
+		for _, pubkey := range pubkeys {
+			list.Add(&RouteHints{Pubkey: pubkey})
+		}
+		return list
+	}
+
+	serverKey, _ := btcec.NewPrivateKey(btcec.S256())
+	otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+	t.Run("no pinned nodes", func(t *testing.T) {
+		setup()
+		defer setup()
+
+		if err := ValidateRouteHints(hints(pinnedNode, otherNode)); err != nil {
+			t.Fatalf("expected any node to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("configured nodes", func(t *testing.T) {
+		setup()
+		defer setup()
+		cfg.RouteHintNodes = " " + pinnedNode + ", "
+
+		if err := ValidateRouteHints(hints(pinnedNode)); err != nil {
+			t.Fatal(err)
+		}
+		err := ValidateRouteHints(hints(pinnedNode, otherNode))
+		if ErrorCode(err) != ErrUntrustedRouteHint {
+			t.Fatalf("expected untrusted route hint error, got %v", err)
+		}
+	})
+
+	t.Run("signed update", func(t *testing.T) {
+		setup()
+		defer setup()
+		cfg.RouteHintNodes = pinnedNode
+
+		if err := UpdateRouteHintNodes(signRouteHintNodes(serverKey, otherNode, 1)); err == nil {
+			t.Fatal("expected error without route hint nodes key")
+		}
+
+		cfg.RouteHintNodesKey = serverKey.PubKey().SerializeCompressed()
+
+		tampered := signRouteHintNodes(serverKey, pinnedNode, 1)
+		tampered.Nodes = otherNode
+		if err := UpdateRouteHintNodes(tampered); err == nil {
+			t.Fatal("expected tampered update to fail")
+		}
+		if err := UpdateRouteHintNodes(signRouteHintNodes(otherKey, otherNode, 1)); err == nil {
+			t.Fatal("expected update signed by another key to fail")
+		}
+
+		if err := UpdateRouteHintNodes(signRouteHintNodes(serverKey, otherNode, 2)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidateRouteHints(hints(otherNode)); err != nil {
+			t.Fatalf("expected updated node to be accepted, got %v", err)
+		}
+		if err := ValidateRouteHints(hints(pinnedNode)); ErrorCode(err) != ErrUntrustedRouteHint {
+			t.Fatalf("expected configured node to be replaced, got %v", err)
+		}
+
+		if err := UpdateRouteHintNodes(signRouteHintNodes(serverKey, pinnedNode, 2)); err == nil {
+			t.Fatal("expected update with the same version to fail")
+		}
+		if err := UpdateRouteHintNodes(signRouteHintNodes(serverKey, pinnedNode, 1)); err == nil {
+			t.Fatal("expected update with an older version to fail")
+		}
+	})
+
+	t.Run("create invoice", func(t *testing.T) {
+		setup()
+		defer setup()
+		cfg.RouteHintNodes = pinnedNode
+
+		userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+		userKey.Path = "m/schema:1'/recovery:1'"
+		muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+		muunKey.Path = "m/schema:1'/recovery:1'"
+
+		secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PersistInvoiceSecrets(secrets); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = CreateInvoice(network, userKey, &RouteHints{Pubkey: otherNode}, &InvoiceOptions{})
+		if ErrorCode(err) != ErrUntrustedRouteHint {
+			t.Fatalf("expected untrusted route hint error, got %v", err)
+		}
+		if _, err := CreateInvoice(network, userKey, &RouteHints{Pubkey: pinnedNode}, &InvoiceOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
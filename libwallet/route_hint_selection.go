@@ -0,0 +1,103 @@
+package libwallet
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/muun/libwallet/errors"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// RankRouteHints returns the route hints sorted from the best to the worst
+// for payers of an invoice of amountSat: the cheapest first, ties broken by
+// the lowest cltv expiry delta. For amountless invoices, use 0, and hints are
+// compared by their proportional fee before their base fee. Hints that rank
+// the same keep their order.
+func RankRouteHints(routeHints *RouteHintsList, amountSat int64) (_ *RouteHintsList, err error) {
+	defer recordErrors("RankRouteHints", &err)
+
+	if routeHints == nil {
+		return &RouteHintsList{}, nil
+	}
+	amount, err := NewAmountFromSats(amountSat)
+	if err != nil {
+		return nil, fmt.Errorf("RankRouteHints: %w", err)
+	}
+	return &RouteHintsList{rankRouteHints(routeHints.hints, amount.Msats())}, nil
+}
+
+// rankRouteHints returns a sorted copy of the hints, see RankRouteHints.
+func rankRouteHints(hints []*RouteHints, amountMsat int64) []*RouteHints {
+	ranked := make([]*RouteHints, len(hints))
+	copy(ranked, hints)
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if amountMsat != 0 {
+			if feeA, feeB := routeHintFeeMsat(a, amountMsat), routeHintFeeMsat(b, amountMsat); feeA != feeB {
+				return feeA < feeB
+			}
+		}
+		if a.FeeProportionalMillionths != b.FeeProportionalMillionths {
+			return a.FeeProportionalMillionths < b.FeeProportionalMillionths
+		}
+		if a.FeeBaseMsat != b.FeeBaseMsat {
+			return a.FeeBaseMsat < b.FeeBaseMsat
+		}
+		return a.CltvExpiryDelta < b.CltvExpiryDelta
+	})
+	return ranked
+}
+
+// routeHintFeeMsat returns the fee payers pay the hint node to forward
+// amountMsat. The amount is split to keep big ones from overflowing.
+func routeHintFeeMsat(hint *RouteHints, amountMsat int64) int64 {
+	proportional := amountMsat/1000000*hint.FeeProportionalMillionths +
+		amountMsat%1000000*hint.FeeProportionalMillionths/1000000
+	return hint.FeeBaseMsat + proportional
+}
+
+// selectRouteHints returns the hints to include in the invoice. If the
+// options limit them, the best ones of the trusted nodes are picked, see
+// RankRouteHints. Otherwise they're all included in the given order.
+func selectRouteHints(db *walletdb.DB, hints []*RouteHints, opts *InvoiceOptions) ([]*RouteHints, error) {
+	if opts.MaxRouteHints < 0 {
+		return nil, fmt.Errorf("invalid max route hints: %v", opts.MaxRouteHints)
+	}
+	if opts.MaxRouteHints == 0 {
+		return hints, nil
+	}
+
+	nodes, err := trustedRouteHintNodes(db)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*RouteHints
+	for _, hint := range hints {
+		nodeURI, err := ParseNodeURI(hint.Pubkey)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse route hint pubkey: %w", err)
+		}
+		if len(nodes) == 0 || nodes[nodeURI.PublicKey] {
+			candidates = append(candidates, hint)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.Errorf(ErrUntrustedRouteHint, "none of the route hint nodes is a known provider node")
+	}
+
+	amount, err := opts.amount()
+	if err != nil {
+		return nil, err
+	}
+	var amountMsat int64
+	if amount != nil {
+		amountMsat = amount.Msats()
+	}
+
+	ranked := rankRouteHints(candidates, amountMsat)
+	if int64(len(ranked)) > opts.MaxRouteHints {
+		ranked = ranked[:opts.MaxRouteHints]
+	}
+	return ranked, nil
+}
@@ -0,0 +1,129 @@
+package libwallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+func TestRankRouteHints(t *testing.T) {
+	cheapBase := &RouteHints{Pubkey: "a", FeeBaseMsat: 0, FeeProportionalMillionths: 5000, CltvExpiryDelta: 40}
+	cheapRate := &RouteHints{Pubkey: "b", FeeBaseMsat: 10000, FeeProportionalMillionths: 100, CltvExpiryDelta: 40}
+	slowRate := &RouteHints{Pubkey: "c", FeeBaseMsat: 10000, FeeProportionalMillionths: 100, CltvExpiryDelta: 144}
+
+	hints := &RouteHintsList{}
+	hints.Add(slowRate)
+	hints.Add(cheapBase)
+	hints.Add(cheapRate)
+
+	testCases := []struct {
+		desc      string
+		amountSat int64
+		expected  string
+	}{
+		// 1000 msat for cheapBase, 10100 msat for the others
+		{desc: "small amount", amountSat: 200, expected: "abc"},
+		// 5000 sats for cheapBase, 110 sats for the others
+		{desc: "big amount", amountSat: 1000000, expected: "bca"},
+		{desc: "no amount", amountSat: 0, expected: "bca"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ranked, err := RankRouteHints(hints, tC.amountSat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var order string
+			for i := 0; i < ranked.Length(); i++ {
+				order += ranked.Get(i).Pubkey
+			}
+			if order != tC.expected {
+				t.Fatalf("expected order %v, got %v", tC.expected, order)
+			}
+		})
+	}
+
+	if hints.Get(0) != slowRate {
+		t.Fatal("expected the given list to be left as is")
+	}
+	if _, err := RankRouteHints(hints, -1); err == nil {
+		t.Fatal("expected error with a negative amount")
+	}
+}
+
+func TestCreateInvoiceWithMaxRouteHints(t *testing.T) {
+	setup()
+	defer setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := make([]string, 3)
+	for i := range nodes {
+		node, _ := NewHDPrivateKey(randomBytes(32), network)
+		nodes[i] = hex.EncodeToString(node.PublicKey().Raw())
+	}
+
+	routeHints := &RouteHintsList{}
+	for i, node := range nodes {
+		routeHints.Add(&RouteHints{
+			Pubkey:                    node,
+			FeeBaseMsat:               1000,
+			FeeProportionalMillionths: int64(3000 - 1000*i),
+			CltvExpiryDelta:           8,
+		})
+	}
+
+	invoice, err := CreateInvoiceWithRouteHints(network, userKey, routeHints, &InvoiceOptions{
+		AmountSat:     10000,
+		MaxRouteHints: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, err := zpay32.Decode(invoice, network.network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payreq.RouteHints) != 2 {
+		t.Fatalf("expected 2 route hints, got %v", len(payreq.RouteHints))
+	}
+	for i, expected := range []string{nodes[2], nodes[1]} {
+		if node := hex.EncodeToString(payreq.RouteHints[i][0].NodeID.SerializeCompressed()); node != expected {
+			t.Fatalf("expected route hint %v to be %v, got %v", i, expected, node)
+		}
+	}
+
+	// only trusted nodes are picked
+	cfg.RouteHintNodes = nodes[0]
+	invoice, err = CreateInvoiceWithRouteHints(network, userKey, routeHints, &InvoiceOptions{
+		AmountSat:     10000,
+		MaxRouteHints: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payreq, _ = zpay32.Decode(invoice, network.network)
+	if len(payreq.RouteHints) != 1 || hex.EncodeToString(payreq.RouteHints[0][0].NodeID.SerializeCompressed()) != nodes[0] {
+		t.Fatalf("expected only the trusted route hint, got %v", payreq.RouteHints)
+	}
+
+	cfg.RouteHintNodes = "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd"
+	_, err = CreateInvoiceWithRouteHints(network, userKey, routeHints, &InvoiceOptions{MaxRouteHints: 2})
+	if ErrorCode(err) != ErrUntrustedRouteHint {
+		t.Fatalf("expected untrusted route hint error, got %v", err)
+	}
+}
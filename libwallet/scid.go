@@ -0,0 +1,88 @@
+package libwallet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// Short channel ids pack the block height in 24 bits, the tx index in 24 bits
+// and the output index in 16 bits.
+const (
+	maxScidBlockHeight = 1<<24 - 1
+	maxScidTxIndex     = 1<<24 - 1
+	maxScidOutputIndex = 1<<16 - 1
+)
+
+// scidAliasStart is the first block height of the alias range. The ids of
+// the route hints of invoices are random and have the high bit set, so they
+// fall in this range and can't collide with real channels for centuries.
+const scidAliasStart = 1 << 23
+
+// FormatShortChannelId returns the human readable BxTxO form of the packed
+// short channel id, eg 654321x1234x1.
+func FormatShortChannelId(scid int64) string {
+	id := lnwire.NewShortChanIDFromInt(uint64(scid))
+	return fmt.Sprintf("%dx%dx%d", id.BlockHeight, id.TxIndex, id.TxPosition)
+}
+
+// ParseShortChannelId returns the packed short channel id of its BxTxO form.
+// The colon separated form used by lnd is accepted too.
+func ParseShortChannelId(s string) (_ int64, err error) {
+	defer recordErrors("ParseShortChannelId", &err)
+
+	sep := "x"
+	if strings.Contains(s, ":") {
+		sep = ":"
+	}
+	parts := strings.Split(s, sep)
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("ParseShortChannelId: invalid short channel id %q", s)
+	}
+
+	var values [3]uint64
+	limits := [3]uint64{maxScidBlockHeight, maxScidTxIndex, maxScidOutputIndex}
+	for i, part := range parts {
+		values[i], err = strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("ParseShortChannelId: invalid short channel id %q: %w", s, err)
+		}
+		if values[i] > limits[i] {
+			return 0, fmt.Errorf("ParseShortChannelId: short channel id %q out of range", s)
+		}
+	}
+
+	id := lnwire.ShortChannelID{
+		BlockHeight: uint32(values[0]),
+		TxIndex:     uint32(values[1]),
+		TxPosition:  uint16(values[2]),
+	}
+	return int64(id.ToUint64()), nil
+}
+
+// IsShortChannelIdAlias returns whether the short channel id is in the alias
+// range, ie it doesn't point to a funding output but is only used to route
+// payments to the wallet.
+func IsShortChannelIdAlias(scid int64) bool {
+	return lnwire.NewShortChanIDFromInt(uint64(scid)).BlockHeight >= scidAliasStart
+}
+
+// NewAliasShortChannelId returns the packed short channel id in the alias
+// range for the given block, tx and output indexes, whose block height is
+// offset by the start of the range.
+func NewAliasShortChannelId(block, tx, output int64) (int64, error) {
+	if block < 0 || block > maxScidBlockHeight-scidAliasStart ||
+		tx < 0 || tx > maxScidTxIndex ||
+		output < 0 || output > maxScidOutputIndex {
+
+		return 0, fmt.Errorf("NewAliasShortChannelId: %vx%vx%v out of range", block, tx, output)
+	}
+	id := lnwire.ShortChannelID{
+		BlockHeight: uint32(block + scidAliasStart),
+		TxIndex:     uint32(tx),
+		TxPosition:  uint16(output),
+	}
+	return int64(id.ToUint64()), nil
+}
@@ -0,0 +1,74 @@
+package libwallet
+
+import (
+	"testing"
+)
+
+func TestShortChannelId(t *testing.T) {
+	scid, err := ParseShortChannelId("654321x1234x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scid != 654321<<40|1234<<16|1 {
+		t.Fatalf("unexpected packed short channel id %v", scid)
+	}
+	if s := FormatShortChannelId(scid); s != "654321x1234x1" {
+		t.Fatalf("unexpected short channel id %v", s)
+	}
+	if IsShortChannelIdAlias(scid) {
+		t.Fatal("expected short channel id not to be an alias")
+	}
+
+	colon, err := ParseShortChannelId("654321:1234:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if colon != scid {
+		t.Fatalf("expected lnd format to parse the same, got %v", colon)
+	}
+
+	for _, invalid := range []string{"", "1x2", "1x2x3x4", "ax2x3", "-1x2x3", "16777216x0x0", "0x16777216x0", "0x0x65536", "1x2:3"} {
+		if _, err := ParseShortChannelId(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+
+	alias, err := NewAliasShortChannelId(0, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsShortChannelIdAlias(alias) || FormatShortChannelId(alias) != "8388608x1x2" {
+		t.Fatalf("unexpected alias %v", FormatShortChannelId(alias))
+	}
+	if _, err := NewAliasShortChannelId(1<<23, 0, 0); err == nil {
+		t.Fatal("expected alias out of range to fail")
+	}
+
+	max, err := ParseShortChannelId("16777215x16777215x65535")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max != -1 {
+		t.Fatalf("unexpected max short channel id %v", max)
+	}
+}
+
+func TestInvoiceShortChannelIdsAreAliases(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < secrets.Length(); i++ {
+		if scid := secrets.Get(i).ShortChanId; !IsShortChannelIdAlias(scid) {
+			t.Fatalf("expected %v to be an alias", FormatShortChannelId(scid))
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package libwallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/muun/libwallet/addresses"
+)
+
+// scriptTemplate is how the wallet handles the outputs of an address version:
+// how to build their addresses, how to sign inputs spending them and how big
+// those inputs are. Adding an output type only takes registering its template
+// in scriptTemplates.
+type scriptTemplate struct {
+	// address builds the address of the keys at the path. It's nil for
+	// versions the wallet doesn't derive addresses for, eg swaps.
+	address func(userKey, muunKey *hdkeychain.ExtendedKey, path string, network *chaincfg.Params) (*addresses.WalletAddress, error)
+	// coin returns the coin to sign an input spending an output of this
+	// version with.
+	coin func(input Input, spent *spentOutput) (coin, error)
+	// inputWeight is the weight of a fully signed input spending an output
+	// of this version, with signatures of the max length. It's 0 if it
+	// depends on the output, eg for swaps.
+	inputWeight int64
+}
+
+// spentOutput is what every coin knows about the output its input spends.
+type spentOutput struct {
+	network   *chaincfg.Params
+	outPoint  wire.OutPoint
+	keyPath   string
+	amount    btcutil.Amount
+	sigHashes *txscript.TxSigHashes // shared by the inputs of a tx, may be nil
+}
+
+// Sizes, in bytes, of the parts of the inputs spending the wallet outputs.
+// Signatures are at most 72 bytes with their sighash flag, since they have a
+// low s value.
+const (
+	inputBaseSize       = 32 + 4 + 1 + 4 // outpoint, script sig length and sequence
+	signaturePushSize   = 1 + 72
+	pubKeyPushSize      = 1 + 33
+	multisigScriptSize  = 1 + 2*pubKeyPushSize + 1 + 1 // 2 <user> <muun> 2 CHECKMULTISIG
+	multisigPushSize    = 1 + multisigScriptSize
+	multisigWitnessSize = 1 + 1 + 2*signaturePushSize + multisigPushSize // item count, dummy, sigs and script
+)
+
+var scriptTemplates = map[int]*scriptTemplate{
+	addresses.V1: {
+		address: func(userKey, _ *hdkeychain.ExtendedKey, path string, network *chaincfg.Params) (*addresses.WalletAddress, error) {
+			return addresses.CreateAddressV1(userKey, path, network)
+		},
+		coin: func(_ Input, spent *spentOutput) (coin, error) {
+			return &coinV1{
+				Network:  spent.network,
+				OutPoint: spent.outPoint,
+				KeyPath:  spent.keyPath,
+			}, nil
+		},
+		// sig and pubkey in the script sig
+		inputWeight: (inputBaseSize + signaturePushSize + pubKeyPushSize) * blockchain.WitnessScaleFactor,
+	},
+	addresses.V2: {
+		address: addresses.CreateAddressV2,
+		coin: func(input Input, spent *spentOutput) (coin, error) {
+			return &coinV2{
+				Network:       spent.network,
+				OutPoint:      spent.outPoint,
+				KeyPath:       spent.keyPath,
+				MuunSignature: input.MuunSignature(),
+			}, nil
+		},
+		// dummy, sigs and redeem script in the script sig
+		inputWeight: (inputBaseSize + 1 + 2*signaturePushSize + multisigPushSize) * blockchain.WitnessScaleFactor,
+	},
+	addresses.V3: {
+		address: addresses.CreateAddressV3,
+		coin: func(input Input, spent *spentOutput) (coin, error) {
+			return &coinV3{
+				Network:       spent.network,
+				OutPoint:      spent.outPoint,
+				KeyPath:       spent.keyPath,
+				Amount:        spent.amount,
+				MuunSignature: input.MuunSignature(),
+				SigHashes:     spent.sigHashes,
+			}, nil
+		},
+		// the p2wsh script in the script sig
+		inputWeight: (inputBaseSize+1+34)*blockchain.WitnessScaleFactor + multisigWitnessSize,
+	},
+	addresses.V4: {
+		address: addresses.CreateAddressV4,
+		coin: func(input Input, spent *spentOutput) (coin, error) {
+			return &coinV4{
+				Network:       spent.network,
+				OutPoint:      spent.outPoint,
+				KeyPath:       spent.keyPath,
+				Amount:        spent.amount,
+				MuunSignature: input.MuunSignature(),
+				SigHashes:     spent.sigHashes,
+			}, nil
+		},
+		inputWeight: inputBaseSize*blockchain.WitnessScaleFactor + multisigWitnessSize,
+	},
+	addresses.SubmarineSwapV1: {
+		coin: func(input Input, spent *spentOutput) (coin, error) {
+			swap := input.SubmarineSwapV1()
+			if swap == nil {
+				return nil, errors.New("submarine swap data is nil for swap input")
+			}
+			return &coinSubmarineSwapV1{
+				Network:         spent.network,
+				OutPoint:        spent.outPoint,
+				KeyPath:         spent.keyPath,
+				Amount:          spent.amount,
+				RefundAddress:   swap.RefundAddress(),
+				PaymentHash256:  swap.PaymentHash256(),
+				ServerPublicKey: swap.ServerPublicKey(),
+				LockTime:        swap.LockTime(),
+				SigHashes:       spent.sigHashes,
+			}, nil
+		},
+	},
+	addresses.SubmarineSwapV2: {
+		coin: func(input Input, spent *spentOutput) (coin, error) {
+			swap := input.SubmarineSwapV2()
+			if swap == nil {
+				return nil, errors.New("submarine swap data is nil for swap input")
+			}
+			return &coinSubmarineSwapV2{
+				Network:             spent.network,
+				OutPoint:            spent.outPoint,
+				KeyPath:             spent.keyPath,
+				Amount:              spent.amount,
+				PaymentHash256:      swap.PaymentHash256(),
+				UserPublicKey:       swap.UserPublicKey(),
+				MuunPublicKey:       swap.MuunPublicKey(),
+				ServerPublicKey:     swap.ServerPublicKey(),
+				BlocksForExpiration: swap.BlocksForExpiration(),
+				ServerSignature:     swap.ServerSignature(),
+				SigHashes:           spent.sigHashes,
+			}, nil
+		},
+	},
+	addresses.IncomingSwap: {
+		coin: func(input Input, spent *spentOutput) (coin, error) {
+			swap := input.IncomingSwap()
+			if swap == nil {
+				return nil, errors.New("incoming swap data is nil for incoming swap input")
+			}
+			swapServerPublicKey, err := hex.DecodeString(swap.SwapServerPublicKey())
+			if err != nil {
+				return nil, err
+			}
+			return &coinIncomingSwap{
+				Network:             spent.network,
+				MuunSignature:       input.MuunSignature(),
+				Sphinx:              swap.Sphinx(),
+				HtlcTx:              swap.HtlcTx(),
+				PaymentHash256:      swap.PaymentHash256(),
+				SwapServerPublicKey: swapServerPublicKey,
+				ExpirationHeight:    swap.ExpirationHeight(),
+				Collect:             btcutil.Amount(swap.CollectInSats()),
+				SigHashes:           spent.sigHashes,
+			}, nil
+		},
+	},
+}
+
+func scriptTemplateFor(version int) (*scriptTemplate, error) {
+	template, ok := scriptTemplates[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown or unsupported version %v", version)
+	}
+	return template, nil
+}
+
+// createWalletAddress returns the address of the given version for the keys,
+// which must be derived to the path.
+func createWalletAddress(version int, userKey, muunKey *HDPublicKey, path string) (*addresses.WalletAddress, error) {
+	template, err := scriptTemplateFor(version)
+	if err != nil {
+		return nil, err
+	}
+	if template.address == nil {
+		return nil, fmt.Errorf("can't create addresses of version %v", version)
+	}
+	return template.address(&userKey.key, &muunKey.key, path, userKey.Network.network)
+}
+
+// EstimateInputWeight returns the weight of a fully signed input spending an
+// output of the given address version, for the apps to estimate the size of
+// the txs they build. It's an upper bound, since signatures can be a byte
+// shorter. Swap outputs aren't supported, their inputs depend on the swap.
+func EstimateInputWeight(version int64) (int64, error) {
+	template, err := scriptTemplateFor(int(version))
+	if err != nil {
+		return 0, err
+	}
+	if template.inputWeight == 0 {
+		return 0, fmt.Errorf("can't estimate the weight of inputs of version %v", version)
+	}
+	return template.inputWeight, nil
+}
@@ -0,0 +1,76 @@
+package libwallet
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/muun/libwallet/addresses"
+)
+
+func TestEstimateInputWeight(t *testing.T) {
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = basePath
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = basePath
+
+	path := basePath + "/external:1/0"
+	userPublicKey, err := userKey.PublicKey().DeriveTo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	muunPublicKey, err := muunKey.PublicKey().DeriveTo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []int{addresses.V1, addresses.V2, addresses.V3, addresses.V4} {
+		estimate, err := EstimateInputWeight(int64(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		address, err := createWalletAddress(version, userPublicKey, muunPublicKey, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prevOutHash, _ := chainhash.NewHash(randomBytes(32))
+		in := &input{
+			outpoint: outpoint{txId: prevOutHash[:], index: 0, amount: 10000},
+			address:  address,
+		}
+
+		tx := wire.NewMsgTx(2)
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevOutHash, 0), nil, nil))
+		tx.AddTxOut(wire.NewTxOut(9000, []byte{}))
+
+		coin, err := createCoin(in, network, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := coin.FullySignInput(0, tx, userKey, muunKey); err != nil {
+			t.Fatal(err)
+		}
+
+		txIn := tx.TxIn[0]
+		weight := int64(32+4+wire.VarIntSerializeSize(uint64(len(txIn.SignatureScript)))+
+			len(txIn.SignatureScript)+4) * blockchain.WitnessScaleFactor
+		if len(txIn.Witness) > 0 {
+			weight += int64(txIn.Witness.SerializeSize())
+		}
+
+		// signatures can be a few bytes shorter than the max, depending on
+		// the size of their r and s values
+		if weight > estimate || weight < estimate-6*blockchain.WitnessScaleFactor {
+			t.Errorf("expected weight of v%v input to be close to %v, got %v", version, estimate, weight)
+		}
+	}
+
+	for _, version := range []int64{addresses.SubmarineSwapV2, addresses.IncomingSwap, 42} {
+		if _, err := EstimateInputWeight(version); err == nil {
+			t.Errorf("expected error estimating weight of version %v", version)
+		}
+	}
+}
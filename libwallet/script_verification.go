@@ -0,0 +1,104 @@
+package libwallet
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/muun/libwallet/addresses"
+)
+
+// scriptVerifyFlags are the flags used to run the script VM over signed
+// inputs, the same standardness rules nodes apply before relaying.
+const scriptVerifyFlags = txscript.StandardVerifyFlags
+
+// verifySignedInputs runs the script VM over every input of the signed tx
+// against the outputs it spends, so a signer bug results in an error instead
+// of a tx that nodes reject once broadcast.
+func verifySignedInputs(tx *wire.MsgTx, prevOuts []*wire.TxOut) error {
+	if len(prevOuts) != len(tx.TxIn) {
+		return fmt.Errorf("expected %v previous outputs, got %v", len(tx.TxIn), len(prevOuts))
+	}
+
+	sigHashes := txscript.NewTxSigHashes(tx)
+	for i, prevOut := range prevOuts {
+		vm, err := txscript.NewEngine(
+			prevOut.PkScript, tx, i, scriptVerifyFlags, nil, sigHashes, prevOut.Value,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create script engine for input %v: %w", i, err)
+		}
+		if err := vm.Execute(); err != nil {
+			return fmt.Errorf("input %v failed script verification: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// prevOutputs returns the outputs spent by the inputs. The scripts of wallet
+// addresses are derived from their version and path with the signing keys
+// rather than taken from the address the caller gave, which may be missing,
+// eg when recovering funds.
+func prevOutputs(inputs []Input, userKey, muunKey *HDPublicKey) ([]*wire.TxOut, error) {
+	net := userKey.Network
+
+	var prevOuts []*wire.TxOut
+	for _, input := range inputs {
+		var script []byte
+		var err error
+		switch input.Address().Version() {
+		case addresses.IncomingSwap:
+			prevOut, err := htlcPrevOutput(input.IncomingSwap().HtlcTx(), input.OutPoint().Index())
+			if err != nil {
+				return nil, err
+			}
+			prevOuts = append(prevOuts, prevOut)
+			continue
+		case addresses.V1, addresses.V2, addresses.V3, addresses.V4:
+			script, err = walletAddressScript(input.Address(), userKey, muunKey)
+		default:
+			script, err = addressToScript(input.Address().Address(), net)
+		}
+		if err != nil {
+			return nil, err
+		}
+		prevOuts = append(prevOuts, wire.NewTxOut(input.OutPoint().Amount(), script))
+	}
+	return prevOuts, nil
+}
+
+// walletAddressScript returns the output script of the wallet address with
+// the keys derived to its path.
+func walletAddressScript(address MuunAddress, userKey, muunKey *HDPublicKey) ([]byte, error) {
+	path := address.DerivationPath()
+	derivedUserKey, err := userKey.DeriveTo(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive user key: %w", err)
+	}
+	derivedMuunKey, err := muunKey.DeriveTo(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive muun key: %w", err)
+	}
+
+	derived, err := addresses.Create(
+		address.Version(), &derivedUserKey.key, &derivedMuunKey.key, path, userKey.Network.network,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return addressToScript(derived.Address(), userKey.Network)
+}
+
+// htlcPrevOutput returns the output at the given index of the serialized htlc
+// tx.
+func htlcPrevOutput(rawHtlcTx []byte, index int) (*wire.TxOut, error) {
+	htlcTx := wire.MsgTx{}
+	if err := htlcTx.Deserialize(bytes.NewReader(rawHtlcTx)); err != nil {
+		return nil, fmt.Errorf("could not deserialize htlc tx: %w", err)
+	}
+	if index < 0 || index >= len(htlcTx.TxOut) {
+		return nil, fmt.Errorf("htlc tx has no output %v", index)
+	}
+	return htlcTx.TxOut[index], nil
+}
@@ -0,0 +1,55 @@
+package libwallet
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestVerifySignedInputs(t *testing.T) {
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	address, err := btcutil.NewAddressWitnessPubKeyHash(
+		btcutil.Hash160(key.PubKey().SerializeCompressed()), &chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOut := wire.NewTxOut(10000, pkScript)
+
+	prevOutHash, _ := chainhash.NewHash(randomBytes(32))
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevOutHash, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(9000, pkScript))
+
+	tx.TxIn[0].Witness, err = txscript.WitnessSignature(
+		tx, txscript.NewTxSigHashes(tx), 0, prevOut.Value, pkScript, txscript.SigHashAll, key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifySignedInputs(tx, []*wire.TxOut{prevOut}); err != nil {
+		t.Fatalf("expected valid tx, got %v", err)
+	}
+
+	if err := verifySignedInputs(tx, nil); err == nil {
+		t.Fatal("expected error with missing previous outputs")
+	}
+
+	// A signature for another amount doesn't verify
+	if err := verifySignedInputs(tx, []*wire.TxOut{wire.NewTxOut(20000, pkScript)}); err == nil {
+		t.Fatal("expected error with wrong previous output amount")
+	}
+
+	tx.TxOut[0].Value = 5000
+	if err := verifySignedInputs(tx, []*wire.TxOut{prevOut}); err == nil {
+		t.Fatal("expected error after tampering with the tx")
+	}
+}
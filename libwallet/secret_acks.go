@@ -0,0 +1,138 @@
+package libwallet
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// Reasons of the discrepancies found by VerifyRegisteredSecrets.
+const (
+	// SecretDiscrepancyUnknown is an acknowledged payment hash the wallet
+	// doesn't have.
+	SecretDiscrepancyUnknown = "unknown"
+	// SecretDiscrepancyKeyPath is a secret acknowledged with another key path.
+	SecretDiscrepancyKeyPath = "key path mismatch"
+	// SecretDiscrepancyMissing is a registered secret the server didn't
+	// acknowledge.
+	SecretDiscrepancyMissing = "not acknowledged"
+)
+
+// SecretAck is a secret the server acknowledged as registered.
+type SecretAck struct {
+	PaymentHash []byte
+	KeyPath     string
+}
+
+// SecretAckList is a wrapper around a SecretAck slice to be able to pass
+// through the gomobile bridge.
+type SecretAckList struct {
+	acks []*SecretAck
+}
+
+// Add appends the ack to the list.
+func (l *SecretAckList) Add(ack *SecretAck) {
+	l.acks = append(l.acks, ack)
+}
+
+// Length returns the number of acks in the list.
+func (l *SecretAckList) Length() int {
+	return len(l.acks)
+}
+
+// Get returns the ack at the given index.
+func (l *SecretAckList) Get(i int) *SecretAck {
+	return l.acks[i]
+}
+
+// SecretDiscrepancy is a difference between the secrets acknowledged by the
+// server and the ones in the wallet db.
+type SecretDiscrepancy struct {
+	PaymentHash []byte
+	Reason      string // one of the SecretDiscrepancy constants
+}
+
+// SecretDiscrepancyList is a wrapper around a SecretDiscrepancy slice to be
+// able to pass through the gomobile bridge.
+type SecretDiscrepancyList struct {
+	discrepancies []*SecretDiscrepancy
+}
+
+// Length returns the number of discrepancies in the list.
+func (l *SecretDiscrepancyList) Length() int {
+	return len(l.discrepancies)
+}
+
+// Get returns the discrepancy at the given index.
+func (l *SecretDiscrepancyList) Get(i int) *SecretDiscrepancy {
+	return l.discrepancies[i]
+}
+
+func (l *SecretDiscrepancyList) add(paymentHash []byte, reason string) {
+	l.discrepancies = append(l.discrepancies, &SecretDiscrepancy{
+		PaymentHash: paymentHash,
+		Reason:      reason,
+	})
+}
+
+// VerifyRegisteredSecrets cross checks the secrets the server acknowledged
+// as registered against the ones persisted with PersistInvoiceSecrets, and
+// returns the discrepancies found. Secrets not handed out yet that the server
+// didn't acknowledge, or acknowledged with another key path, are cancelled so
+// they are never used to create invoices.
+func VerifyRegisteredSecrets(acks *SecretAckList) (_ *SecretDiscrepancyList, err error) {
+	defer recordErrors("VerifyRegisteredSecrets", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	invoices, err := db.ListInvoices()
+	if err != nil {
+		return nil, fmt.Errorf("VerifyRegisteredSecrets: %w", err)
+	}
+	byHash := make(map[string]*walletdb.Invoice, len(invoices))
+	for i := range invoices {
+		byHash[hex.EncodeToString(invoices[i].PaymentHash)] = &invoices[i]
+	}
+
+	discrepancies := &SecretDiscrepancyList{}
+	var rejected []*walletdb.Invoice
+	acked := make(map[string]bool, acks.Length())
+	for _, ack := range acks.acks {
+		key := hex.EncodeToString(ack.PaymentHash)
+		acked[key] = true
+
+		invoice, ok := byHash[key]
+		if !ok {
+			discrepancies.add(ack.PaymentHash, SecretDiscrepancyUnknown)
+			continue
+		}
+		if invoice.KeyPath != ack.KeyPath {
+			discrepancies.add(ack.PaymentHash, SecretDiscrepancyKeyPath)
+			rejected = append(rejected, invoice)
+		}
+	}
+
+	for i := range invoices {
+		invoice := &invoices[i]
+		if invoice.State == walletdb.InvoiceStateRegistered && !acked[hex.EncodeToString(invoice.PaymentHash)] {
+			discrepancies.add(invoice.PaymentHash, SecretDiscrepancyMissing)
+			rejected = append(rejected, invoice)
+		}
+	}
+
+	for _, invoice := range rejected {
+		if invoice.State != walletdb.InvoiceStateRegistered {
+			continue
+		}
+		if err := saveInvoiceState(db, invoice, walletdb.InvoiceStateCancelled); err != nil {
+			return nil, fmt.Errorf("VerifyRegisteredSecrets: %w", err)
+		}
+	}
+
+	return discrepancies, nil
+}
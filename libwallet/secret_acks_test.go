@@ -0,0 +1,91 @@
+package libwallet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestVerifyRegisteredSecrets(t *testing.T) {
+	setup()
+	defer setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	acks := &SecretAckList{}
+	for i := 0; i < secrets.Length(); i++ {
+		acks.Add(&SecretAck{PaymentHash: secrets.Get(i).PaymentHash, KeyPath: secrets.Get(i).keyPath})
+	}
+
+	discrepancies, err := VerifyRegisteredSecrets(acks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if discrepancies.Length() != 0 {
+		t.Fatalf("expected no discrepancies, got %v", discrepancies.Length())
+	}
+
+	missing := secrets.Get(0).PaymentHash
+	wrongPath := secrets.Get(1).PaymentHash
+	unknown := randomBytes(32)
+
+	acks = &SecretAckList{}
+	for i := 1; i < secrets.Length(); i++ {
+		acks.Add(&SecretAck{PaymentHash: secrets.Get(i).PaymentHash, KeyPath: secrets.Get(i).keyPath})
+	}
+	acks.Get(0).KeyPath = "m/schema:1'/recovery:1'/invoices:4/1/2"
+	acks.Add(&SecretAck{PaymentHash: unknown, KeyPath: secrets.Get(0).keyPath})
+
+	discrepancies, err = VerifyRegisteredSecrets(acks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]byte{
+		SecretDiscrepancyMissing: missing,
+		SecretDiscrepancyKeyPath: wrongPath,
+		SecretDiscrepancyUnknown: unknown,
+	}
+	if discrepancies.Length() != len(expected) {
+		t.Fatalf("expected %v discrepancies, got %v", len(expected), discrepancies.Length())
+	}
+	for i := 0; i < discrepancies.Length(); i++ {
+		d := discrepancies.Get(i)
+		if !bytes.Equal(expected[d.Reason], d.PaymentHash) {
+			t.Errorf("unexpected discrepancy %v for %x", d.Reason, d.PaymentHash)
+		}
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, hash := range [][]byte{missing, wrongPath} {
+		invoice, err := db.FindByPaymentHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if invoice.State != walletdb.InvoiceStateCancelled {
+			t.Errorf("expected secret %x to be cancelled, got %v", hash, invoice.State)
+		}
+	}
+	unused, err := db.CountUnusedInvoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unused != secrets.Length()-2 {
+		t.Fatalf("expected %v unused secrets, got %v", secrets.Length()-2, unused)
+	}
+}
@@ -0,0 +1,91 @@
+package libwallet
+
+import (
+	"fmt"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// PaymentHashList is a wrapper around a slice of payment hashes to be able to
+// pass through the gomobile bridge.
+type PaymentHashList struct {
+	hashes [][]byte
+}
+
+// Add appends the payment hash to the list.
+func (l *PaymentHashList) Add(paymentHash []byte) {
+	l.hashes = append(l.hashes, paymentHash)
+}
+
+// Length returns the number of payment hashes in the list.
+func (l *PaymentHashList) Length() int {
+	return len(l.hashes)
+}
+
+// Get returns the payment hash at the given index.
+func (l *PaymentHashList) Get(i int) []byte {
+	return l.hashes[i]
+}
+
+// RotateInvoiceSecrets supersedes the registered secrets with the given
+// payment hashes, or every registered secret if the list is nil or empty, and
+// returns new secrets at new key paths to replace them. It's meant for
+// secrets suspected compromised, eg after restoring the device from a backup
+// other devices may have used too. Superseded secrets are never used to
+// create invoices and payments to them are refused. The new secrets must be
+// registered with the remote server and stored with PersistInvoiceSecrets,
+// like the ones returned by GenerateInvoiceSecrets. Secrets already handed
+// out can't be rotated, and nothing is superseded if any of them is.
+func RotateInvoiceSecrets(userKey, muunKey *HDPublicKey, paymentHashes *PaymentHashList) (_ *InvoiceSecretsList, err error) {
+	defer recordErrors("RotateInvoiceSecrets", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var suspected []*walletdb.Invoice
+	if paymentHashes == nil || paymentHashes.Length() == 0 {
+		invoices, err := db.ListInvoices()
+		if err != nil {
+			return nil, fmt.Errorf("RotateInvoiceSecrets: %w", err)
+		}
+		for i := range invoices {
+			if invoices[i].State == walletdb.InvoiceStateRegistered {
+				suspected = append(suspected, &invoices[i])
+			}
+		}
+	} else {
+		for _, paymentHash := range paymentHashes.hashes {
+			invoice, err := db.FindByPaymentHash(paymentHash)
+			if err != nil {
+				return nil, fmt.Errorf("RotateInvoiceSecrets: could not find invoice data for payment hash: %w", err)
+			}
+			if invoice.State != walletdb.InvoiceStateRegistered {
+				return nil, fmt.Errorf("RotateInvoiceSecrets: can't rotate %v invoice secrets", invoice.State)
+			}
+			suspected = append(suspected, invoice)
+		}
+	}
+
+	// derive the replacements first, so nothing is superseded with the wrong keys
+	secrets, err := newInvoiceSecrets(db, userKey, muunKey, len(suspected))
+	if err != nil {
+		return nil, fmt.Errorf("RotateInvoiceSecrets: %w", err)
+	}
+
+	err = db.Transaction(func(tx *walletdb.DB) error {
+		for _, invoice := range suspected {
+			if err := saveInvoiceState(tx, invoice, walletdb.InvoiceStateSuperseded); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("RotateInvoiceSecrets: %w", err)
+	}
+
+	return secrets, nil
+}
@@ -0,0 +1,137 @@
+package libwallet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestRotateInvoiceSecrets(t *testing.T) {
+	setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	states := func() map[string]walletdb.InvoiceState {
+		db, err := openDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		invoices, err := db.ListInvoices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[string]walletdb.InvoiceState)
+		for _, invoice := range invoices {
+			result[string(invoice.PaymentHash)] = invoice.State
+		}
+		return result
+	}
+
+	// hand out one of the secrets
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, _, _ := getInvoiceSecrets(invoice, userKey)
+
+	t.Run("secrets handed out", func(t *testing.T) {
+		hashes := &PaymentHashList{}
+		hashes.Add(secrets.Get(1).PaymentHash)
+		hashes.Add(used)
+		if _, err := RotateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey(), hashes); err == nil {
+			t.Fatal("expected error rotating used secrets")
+		}
+		if states()[string(secrets.Get(1).PaymentHash)] != walletdb.InvoiceStateRegistered {
+			t.Fatal("expected nothing to be superseded")
+		}
+	})
+
+	t.Run("wrong keys", func(t *testing.T) {
+		otherKey, _ := NewHDPrivateKey(randomBytes(32), network)
+		otherKey.Path = "m/schema:1'/recovery:1'/change:0"
+		if _, err := RotateInvoiceSecrets(otherKey.PublicKey(), muunKey.PublicKey(), nil); err == nil {
+			t.Fatal("expected error deriving from the wrong keys")
+		}
+		if states()[string(secrets.Get(1).PaymentHash)] != walletdb.InvoiceStateRegistered {
+			t.Fatal("expected nothing to be superseded")
+		}
+	})
+
+	t.Run("some secrets", func(t *testing.T) {
+		hashes := &PaymentHashList{}
+		hashes.Add(secrets.Get(1).PaymentHash)
+		hashes.Add(secrets.Get(2).PaymentHash)
+		rotated, err := RotateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey(), hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotated.Length() != 2 {
+			t.Fatalf("expected 2 new secrets, got %v", rotated.Length())
+		}
+		for i := 0; i < rotated.Length(); i++ {
+			for j := 0; j < secrets.Length(); j++ {
+				if bytes.Equal(rotated.Get(i).PaymentHash, secrets.Get(j).PaymentHash) ||
+					rotated.Get(i).keyPath == secrets.Get(j).keyPath {
+					t.Fatal("expected new secrets at new key paths")
+				}
+			}
+		}
+
+		current := states()
+		if current[string(secrets.Get(1).PaymentHash)] != walletdb.InvoiceStateSuperseded ||
+			current[string(secrets.Get(2).PaymentHash)] != walletdb.InvoiceStateSuperseded {
+			t.Fatal("expected rotated secrets to be superseded")
+		}
+		if current[string(secrets.Get(3).PaymentHash)] != walletdb.InvoiceStateRegistered {
+			t.Fatal("expected other secrets to be kept")
+		}
+
+		if err := PersistInvoiceSecrets(rotated); err != nil {
+			t.Fatal(err)
+		}
+
+		// superseded secrets can't be paid
+		swap := &IncomingSwap{PaymentHash: secrets.Get(1).PaymentHash}
+		if _, err := swap.getInvoice(); err == nil {
+			t.Fatal("expected superseded invoice to be refused")
+		}
+	})
+
+	t.Run("every secret", func(t *testing.T) {
+		rotated, err := RotateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 2 left of the original pool, plus the 2 that replaced the others
+		if rotated.Length() != secrets.Length()-1 {
+			t.Fatalf("expected %v new secrets, got %v", secrets.Length()-1, rotated.Length())
+		}
+		for hash, state := range states() {
+			if state == walletdb.InvoiceStateRegistered {
+				t.Fatalf("expected every registered secret to be superseded, got %x", hash)
+			}
+		}
+		if states()[string(used)] != walletdb.InvoiceStateUsed {
+			t.Fatal("expected used secret to be kept")
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package libwallet
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// Security relevant events counted in the wallet db. A spike in any of them
+// may be a sign of an attack, so they are reported by HealthCheck.
+const (
+	// SecurityEventSphinxValidation is a payment whose sphinx packet didn't
+	// match the invoice or the htlc.
+	SecurityEventSphinxValidation = "sphinx validation failure"
+	// SecurityEventRejectedFulfillment is a payment VerifyFulfillable or
+	// Fulfill refused for any reason other than waiting for more parts or
+	// a held invoice.
+	SecurityEventRejectedFulfillment = "rejected fulfillment"
+	// SecurityEventSignatureVerification is a signature from the server that
+	// didn't verify.
+	SecurityEventSignatureVerification = "signature verification failure"
+)
+
+// countSecurityEvent increments the counter of the event. Like recordError,
+// failing to do so is only logged, since the event itself is more relevant
+// to the caller.
+func countSecurityEvent(event string) {
+	if cfg == nil {
+		return
+	}
+
+	db, err := openDB()
+	if err != nil {
+		log.Printf("error opening the db to count security event: %v", err)
+		return
+	}
+	defer db.Close()
+
+	if err := db.IncrementSecurityCounter(event); err != nil {
+		log.Printf("error counting security event: %v", err)
+	}
+}
+
+// countRejectedFulfillment counts the error, if any, as a rejected
+// fulfillment. It's meant to be deferred like recordErrors.
+func countRejectedFulfillment(err *error) {
+	if *err == nil || isExpectedOutcome(*err) {
+		return
+	}
+	countSecurityEvent(SecurityEventRejectedFulfillment)
+}
+
+// addSecurityEvents reports the security event counters, with a warning if
+// any of them increased recently.
+func (r *HealthReport) addSecurityEvents(counters []walletdb.SecurityCounter) {
+	if len(counters) == 0 {
+		r.add("security events", HealthStatusOk, "no security events")
+		return
+	}
+
+	var counts []string
+	var lastAt time.Time
+	for _, counter := range counters {
+		counts = append(counts, fmt.Sprintf("%v: %v", counter.Event, counter.Count))
+		if counter.UpdatedAt.After(lastAt) {
+			lastAt = counter.UpdatedAt
+		}
+	}
+	detail := strings.Join(counts, ", ")
+
+	if time.Since(lastAt) < recentErrorsWindow {
+		r.add("security events", HealthStatusWarning, fmt.Sprintf("%v, the last one less than %v ago", detail, recentErrorsWindow))
+	} else {
+		r.add("security events", HealthStatusOk, detail)
+	}
+}
@@ -0,0 +1,109 @@
+package libwallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/muun/libwallet/errors"
+)
+
+func TestSecurityEvents(t *testing.T) {
+	setup()
+	defer setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	counters := func() map[string]int64 {
+		db, err := openDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		list, err := db.ListSecurityCounters()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[string]int64)
+		for _, counter := range list {
+			counts[counter.Event] = counter.Count
+		}
+		return counts
+	}
+
+	securityHealth := func() *ModuleHealth {
+		report := HealthCheck()
+		for i := 0; i < report.Length(); i++ {
+			if report.Get(i).Module == "security events" {
+				return report.Get(i)
+			}
+		}
+		t.Fatal("expected security events in the health report")
+		return nil
+	}
+
+	if health := securityHealth(); health.Status != HealthStatusOk {
+		t.Fatalf("expected no security events, got %v", health.Detail)
+	}
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a sphinx for another amount is rejected
+	paymentHash, paymentSecret, nodePublicKey := getInvoiceSecrets(invoice, userKey)
+	swap := &IncomingSwap{
+		PaymentHash:      paymentHash,
+		SphinxPacket:     createSphinxPacket(nodePublicKey, paymentHash, paymentSecret, 10000, 1000),
+		PaymentAmountSat: 5000,
+	}
+	if err := swap.VerifyFulfillable(userKey, network); err == nil {
+		t.Fatal("expected sphinx for another amount to fail")
+	}
+
+	serverKey, _ := btcec.NewPrivateKey(btcec.S256())
+	otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+	cfg.FeeAttestationKey = serverKey.PubKey().SerializeCompressed()
+	if err := verifyFeeRateAttestation(signFeeRateAttestation(otherKey, 10, 6, time.Now()), time.Now()); err == nil {
+		t.Fatal("expected attestation signed by another key to fail")
+	}
+
+	counts := counters()
+	if counts[SecurityEventSphinxValidation] != 1 {
+		t.Errorf("expected 1 sphinx validation failure, got %v", counts[SecurityEventSphinxValidation])
+	}
+	if counts[SecurityEventRejectedFulfillment] != 1 {
+		t.Errorf("expected 1 rejected fulfillment, got %v", counts[SecurityEventRejectedFulfillment])
+	}
+	if counts[SecurityEventSignatureVerification] != 1 {
+		t.Errorf("expected 1 signature verification failure, got %v", counts[SecurityEventSignatureVerification])
+	}
+
+	// waiting for more parts is not a rejection
+	incomplete := errors.New(ErrIncompleteMppSet, "waiting for more parts")
+	countRejectedFulfillment(&incomplete)
+	if counts := counters(); counts[SecurityEventRejectedFulfillment] != 1 {
+		t.Errorf("expected incomplete sets not to be counted, got %v", counts[SecurityEventRejectedFulfillment])
+	}
+
+	if health := securityHealth(); health.Status != HealthStatusWarning {
+		t.Fatalf("expected a warning for recent security events, got %v: %v", health.Status, health.Detail)
+	}
+}
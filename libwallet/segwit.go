@@ -9,15 +9,23 @@ import (
 	"github.com/btcsuite/btcutil"
 )
 
-func signNativeSegwitInput(index int, tx *wire.MsgTx, privateKey *HDPrivateKey, witnessScript []byte, amount btcutil.Amount) ([]byte, error) {
+// txSigHashes returns the sighash midstate shared by the inputs of a batch, or
+// computes it for tx if the input is signed on its own.
+func txSigHashes(tx *wire.MsgTx, shared *txscript.TxSigHashes) *txscript.TxSigHashes {
+	if shared != nil {
+		return shared
+	}
+	return txscript.NewTxSigHashes(tx)
+}
+
+func signNativeSegwitInput(index int, tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, privateKey *HDPrivateKey, witnessScript []byte, amount btcutil.Amount) ([]byte, error) {
 
 	privKey, err := privateKey.key.ECPrivKey()
 	if err != nil {
 		return nil, fmt.Errorf("failed to produce EC priv key for signing: %w", err)
 	}
 
-	sigHashes := txscript.NewTxSigHashes(tx)
-	sig, err := txscript.RawTxInWitnessSignature(tx, sigHashes, index, int64(amount), witnessScript, txscript.SigHashAll, privKey)
+	sig, err := txscript.RawTxInWitnessSignature(tx, txSigHashes(tx, sigHashes), index, int64(amount), witnessScript, txscript.SigHashAll, privKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign V4 input: %w", err)
 	}
@@ -35,7 +43,7 @@ func createNonNativeSegwitRedeemScript(witnessScript []byte) ([]byte, error) {
 	return builder.Script()
 }
 
-func signNonNativeSegwitInput(index int, tx *wire.MsgTx, privateKey *HDPrivateKey,
+func signNonNativeSegwitInput(index int, tx *wire.MsgTx, sigHashes *txscript.TxSigHashes, privateKey *HDPrivateKey,
 	redeemScript, witnessScript []byte, amount btcutil.Amount) ([]byte, error) {
 
 	txInput := tx.TxIn[index]
@@ -53,9 +61,8 @@ func signNonNativeSegwitInput(index int, tx *wire.MsgTx, privateKey *HDPrivateKe
 		return nil, fmt.Errorf("failed to produce EC priv key for signing: %w", err)
 	}
 
-	sigHashes := txscript.NewTxSigHashes(tx)
 	sig, err := txscript.RawTxInWitnessSignature(
-		tx, sigHashes, index, int64(amount), witnessScript, txscript.SigHashAll, privKey)
+		tx, txSigHashes(tx, sigHashes), index, int64(amount), witnessScript, txscript.SigHashAll, privKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign V3 input: %w", err)
 	}
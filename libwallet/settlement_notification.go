@@ -0,0 +1,89 @@
+package libwallet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// SettlementNotificationVersion is the version of the payloads built by
+// SignSettlementNotification.
+const SettlementNotificationVersion = 1
+
+// minNotificationKeySize is the shortest key notifications can be signed with,
+// so signatures can't be forged by guessing it.
+const minNotificationKeySize = 16
+
+// SettlementNotification tells a merchant backend an invoice was paid. The
+// backend must check the signature over the exact payload bytes before
+// parsing it, and can use the payment hash to ignore repeated notifications.
+// The payload has the version, the hex encoded paymentHash, the amountSat of
+// the invoice and the settledAt unix time. Invoices without amount have an
+// amountSat of 0, so the backend must get the amount paid for them from
+// elsewhere.
+type SettlementNotification struct {
+	Payload   string // json encoded settlementNotificationPayload
+	Signature string // hex encoded hmac-sha256 of the payload with the key
+}
+
+type settlementNotificationPayload struct {
+	Version     int    `json:"version"`
+	PaymentHash string `json:"paymentHash"`
+	AmountSat   int64  `json:"amountSat"` // 0 for invoices without amount
+	SettledAt   int64  `json:"settledAt"` // unix seconds
+}
+
+// SignSettlementNotification returns a notification of the payment of a
+// settled invoice, signed with a key shared with the merchant backend, for
+// merchants running their own backend to be told about payments without
+// trusting the channel the notification is sent through. The key must be at
+// least 16 random bytes, kept by the app and never sent along.
+func SignSettlementNotification(paymentHash []byte, key []byte) (_ *SettlementNotification, err error) {
+	defer recordErrors("SignSettlementNotification", &err)
+
+	if len(key) < minNotificationKeySize {
+		return nil, fmt.Errorf("SignSettlementNotification: key must be at least %v bytes", minNotificationKeySize)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return nil, fmt.Errorf("SignSettlementNotification: could not find invoice data for payment hash: %w", err)
+	}
+	if !invoice.State.IsPaid() {
+		return nil, fmt.Errorf("SignSettlementNotification: invoice is not settled (state %v)", invoice.State)
+	}
+
+	// invoices settled before the time was stored fall back to when they were used
+	var settledAt int64
+	if invoice.SettledAt != nil {
+		settledAt = invoice.SettledAt.Unix()
+	} else if invoice.UsedAt != nil {
+		settledAt = invoice.UsedAt.Unix()
+	}
+
+	payload, err := json.Marshal(&settlementNotificationPayload{
+		Version:     SettlementNotificationVersion,
+		PaymentHash: hex.EncodeToString(invoice.PaymentHash),
+		AmountSat:   invoice.AmountSat,
+		SettledAt:   settledAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("SignSettlementNotification: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+
+	return &SettlementNotification{
+		Payload:   string(payload),
+		Signature: hex.EncodeToString(mac.Sum(nil)),
+	}, nil
+}
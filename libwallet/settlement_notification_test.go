@@ -0,0 +1,86 @@
+package libwallet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSignSettlementNotification(t *testing.T) {
+	setup()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, _, _ := getInvoiceSecrets(invoice, userKey)
+	key := randomBytes(32)
+
+	if _, err := SignSettlementNotification(paymentHash, key); err == nil {
+		t.Fatal("expected error notifying an unsettled invoice")
+	}
+
+	swap := &IncomingSwap{PaymentHash: paymentHash}
+	if _, err := swap.FulfillFullDebt(); err != nil {
+		t.Fatal(err)
+	}
+
+	notification, err := SignSettlementNotification(paymentHash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(notification.Payload))
+	if hex.EncodeToString(mac.Sum(nil)) != notification.Signature {
+		t.Fatal("expected signature to verify with the key")
+	}
+
+	var payload settlementNotificationPayload
+	if err := json.Unmarshal([]byte(notification.Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Version != SettlementNotificationVersion ||
+		payload.PaymentHash != hex.EncodeToString(paymentHash) ||
+		payload.AmountSat != 1500 {
+		t.Fatalf("unexpected payload %v", notification.Payload)
+	}
+	if time.Since(time.Unix(payload.SettledAt, 0)) > time.Minute {
+		t.Fatalf("expected settlement time to be now, got %v", payload.SettledAt)
+	}
+
+	other, err := SignSettlementNotification(paymentHash, randomBytes(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Signature == notification.Signature {
+		t.Fatal("expected signatures with other keys to differ")
+	}
+
+	if _, err := SignSettlementNotification(paymentHash, randomBytes(8)); err == nil {
+		t.Fatal("expected error with a short key")
+	}
+}
@@ -0,0 +1,125 @@
+package libwallet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// walletSnapshotTTL is how long GetWalletSnapshot reuses a snapshot before
+// taking a new one.
+const walletSnapshotTTL = 30 * time.Second
+
+// WalletSnapshot is a compact summary of the wallet for OS widgets and
+// notifications.
+type WalletSnapshot struct {
+	BalanceSat             int64 // only meaningful if HasBalance
+	HasBalance             bool  // whether the app reported a balance, see SetSnapshotBalance
+	PendingReceives        int64 // invoices handed out and not yet settled
+	LastOperation          string
+	LastOperationAmountSat int64 // 0 for invoices without amount
+	LastOperationAt        int64 // unix seconds, 0 if there are no operations
+	TakenAt                int64 // unix seconds
+}
+
+// walletSnapshots keeps the last snapshot and reported balance of each wallet
+// db, keyed by its path.
+var walletSnapshots = struct {
+	sync.Mutex
+	snapshots map[string]*WalletSnapshot
+	balances  map[string]int64
+}{
+	snapshots: make(map[string]*WalletSnapshot),
+	balances:  make(map[string]int64),
+}
+
+// SetSnapshotBalance records the balance of the active wallet to be included
+// in its snapshots. Chain data is not tracked by libwallet, so apps that
+// track the balance must report it whenever it changes.
+func SetSnapshotBalance(balanceSat int64) {
+	path := dbPath()
+
+	walletSnapshots.Lock()
+	defer walletSnapshots.Unlock()
+
+	walletSnapshots.balances[path] = balanceSat
+	if snapshot, ok := walletSnapshots.snapshots[path]; ok {
+		snapshot.BalanceSat = balanceSat
+		snapshot.HasBalance = true
+	}
+}
+
+// GetWalletSnapshot returns a summary of the active wallet: the balance last
+// reported by the app, the number of pending receives and the last invoice
+// operation. It's meant for widget refresh paths, so snapshots are reused for
+// walletSnapshotTTL, the db is never migrated and errors aren't recorded in
+// the journal. LastOperation is the state of the last invoice handed out or
+// updated, empty if there are none.
+func GetWalletSnapshot() (*WalletSnapshot, error) {
+	return getWalletSnapshot(walletNow())
+}
+
+func getWalletSnapshot(now time.Time) (*WalletSnapshot, error) {
+	path := dbPath()
+
+	walletSnapshots.Lock()
+	cached, ok := walletSnapshots.snapshots[path]
+	walletSnapshots.Unlock()
+	if ok && now.Sub(time.Unix(cached.TakenAt, 0)) < walletSnapshotTTL {
+		copied := *cached
+		return &copied, nil
+	}
+
+	snapshot, err := takeWalletSnapshot(path, now)
+	if err != nil {
+		return nil, err
+	}
+
+	walletSnapshots.Lock()
+	defer walletSnapshots.Unlock()
+
+	if balance, ok := walletSnapshots.balances[path]; ok {
+		snapshot.BalanceSat = balance
+		snapshot.HasBalance = true
+	}
+	walletSnapshots.snapshots[path] = snapshot
+
+	copied := *snapshot
+	return &copied, nil
+}
+
+func takeWalletSnapshot(path string, now time.Time) (*WalletSnapshot, error) {
+	db, err := attachDBAt(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if err := checkNoPendingMigrations(db); err != nil {
+		return nil, err
+	}
+
+	pending, err := db.CountInvoices(walletdb.InvoiceStateUsed, walletdb.InvoiceStateAccepted)
+	if err != nil {
+		return nil, fmt.Errorf("GetWalletSnapshot: %w", err)
+	}
+
+	snapshot := &WalletSnapshot{
+		PendingReceives: int64(pending),
+		TakenAt:         now.Unix(),
+	}
+
+	last, err := db.FindLastUsedInvoice()
+	if err != nil {
+		return nil, fmt.Errorf("GetWalletSnapshot: %w", err)
+	}
+	if last != nil {
+		snapshot.LastOperation = string(last.State)
+		snapshot.LastOperationAmountSat = last.AmountSat
+		snapshot.LastOperationAt = last.UpdatedAt.Unix()
+	}
+
+	return snapshot, nil
+}
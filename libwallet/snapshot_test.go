@@ -0,0 +1,82 @@
+package libwallet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetWalletSnapshot(t *testing.T) {
+	setup()
+
+	walletSnapshots.Lock()
+	walletSnapshots.snapshots = make(map[string]*WalletSnapshot)
+	walletSnapshots.balances = make(map[string]int64)
+	walletSnapshots.Unlock()
+
+	network := Regtest()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	snapshot, err := getWalletSnapshot(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.PendingReceives != 0 || snapshot.LastOperation != "" || snapshot.HasBalance {
+		t.Fatalf("expected empty snapshot, got %+v", snapshot)
+	}
+
+	_, err = CreateInvoice(network, userKey, &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}, &InvoiceOptions{AmountSat: 1234})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err = getWalletSnapshot(now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.PendingReceives != 0 {
+		t.Fatalf("expected cached snapshot to be reused, got %+v", snapshot)
+	}
+
+	SetSnapshotBalance(5000)
+
+	snapshot, err = getWalletSnapshot(now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snapshot.HasBalance || snapshot.BalanceSat != 5000 {
+		t.Fatalf("expected reported balance in cached snapshot, got %+v", snapshot)
+	}
+
+	snapshot, err = getWalletSnapshot(now.Add(walletSnapshotTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.PendingReceives != 1 {
+		t.Fatalf("expected 1 pending receive, got %v", snapshot.PendingReceives)
+	}
+	if snapshot.LastOperation != "used" || snapshot.LastOperationAmountSat != 1234 || snapshot.LastOperationAt == 0 {
+		t.Fatalf("expected last operation to be the invoice handed out, got %+v", snapshot)
+	}
+	if !snapshot.HasBalance || snapshot.BalanceSat != 5000 {
+		t.Fatalf("expected reported balance in new snapshot, got %+v", snapshot)
+	}
+}
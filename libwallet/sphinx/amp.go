@@ -0,0 +1,136 @@
+package sphinx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	lndsphinx "github.com/lightningnetwork/lightning-onion"
+	"github.com/lightningnetwork/lnd/htlcswitch/hop"
+	"github.com/lightningnetwork/lnd/tlv"
+)
+
+// ampType is the tlv type of the amp record in the onion payload. The hop
+// payloads of the lnd version we use don't know it, and reject it since it's
+// even.
+const ampType = 14
+
+// AMP is the record sent with each shard of an atomic multi-path payment. The
+// preimages of the shards are derived from the xor of the root shares of
+// every shard in the set.
+type AMP struct {
+	RootShare  [32]byte
+	SetID      [32]byte
+	ChildIndex uint32
+}
+
+// DecodeAMP works like Decode, but also returns the amp record of the payload,
+// or nil if it has none.
+func DecodeAMP(
+	onionBlob []byte,
+	paymentHash []byte,
+	nodeKey *btcec.PrivateKey,
+	expiry uint32,
+	net *chaincfg.Params,
+) (*hop.Payload, *AMP, error) {
+	if err := CheckPacket(onionBlob); err != nil {
+		return nil, nil, err
+	}
+
+	router := lndsphinx.NewRouter(nodeKey, net, lndsphinx.NewMemoryReplayLog())
+	if err := router.Start(); err != nil {
+		panic(err)
+	}
+	defer router.Stop()
+
+	var onion lndsphinx.OnionPacket
+	if err := onion.Decode(bytes.NewReader(onionBlob)); err != nil {
+		return nil, nil, fmt.Errorf("failed decode sphinx due to %v", err)
+	}
+	packet, err := router.ProcessOnionPacket(&onion, paymentHash, expiry)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed decode sphinx due to %v", err)
+	}
+
+	switch packet.Payload.Type {
+	case lndsphinx.PayloadLegacy:
+		return hop.NewLegacyPayload(packet.ForwardingInstructions), nil, nil
+	case lndsphinx.PayloadTLV:
+		records, amp, err := extractAMP(packet.Payload.Payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		payload, err := hop.NewPayloadFromReader(bytes.NewReader(records))
+		if err != nil {
+			return nil, nil, err
+		}
+		return payload, amp, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown sphinx payload type: %v", packet.Payload.Type)
+	}
+}
+
+// extractAMP returns the tlv stream without the amp record, and the record.
+func extractAMP(stream []byte) ([]byte, *AMP, error) {
+	r := bytes.NewReader(stream)
+	var rest bytes.Buffer
+	var amp *AMP
+	var scratch [8]byte
+	for r.Len() > 0 {
+		start := len(stream) - r.Len()
+		typ, err := tlv.ReadVarInt(r, &scratch)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid sphinx payload: %w", err)
+		}
+		length, err := tlv.ReadVarInt(r, &scratch)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid sphinx payload: %w", err)
+		}
+		if length > uint64(r.Len()) {
+			return nil, nil, errors.New("invalid sphinx payload length")
+		}
+		value := make([]byte, length)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid sphinx payload: %w", err)
+		}
+
+		if typ != ampType {
+			rest.Write(stream[start : len(stream)-r.Len()])
+			continue
+		}
+		if amp, err = decodeAMP(value); err != nil {
+			return nil, nil, err
+		}
+	}
+	return rest.Bytes(), amp, nil
+}
+
+func decodeAMP(value []byte) (*AMP, error) {
+	// The child index is a truncated uint32
+	if len(value) < 64 || len(value) > 68 || (len(value) > 64 && value[64] == 0) {
+		return nil, fmt.Errorf("invalid amp record length %v", len(value))
+	}
+	amp := &AMP{}
+	copy(amp.RootShare[:], value[:32])
+	copy(amp.SetID[:], value[32:64])
+
+	var index [4]byte
+	copy(index[4-(len(value)-64):], value[64:])
+	amp.ChildIndex = binary.BigEndian.Uint32(index[:])
+	return amp, nil
+}
+
+// Record returns a tlv record to encode the amp record in an onion payload.
+func (a *AMP) Record() tlv.Record {
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], a.ChildIndex)
+	value := make([]byte, 0, 68)
+	value = append(value, a.RootShare[:]...)
+	value = append(value, a.SetID[:]...)
+	value = append(value, bytes.TrimLeft(index[:], "\x00")...)
+	return tlv.MakePrimitiveRecord(ampType, &value)
+}
@@ -0,0 +1,36 @@
+package sphinx
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// packetVersion is the only onion packet version payers send.
+const packetVersion = 0
+
+// Errors returned by CheckPacket for malformed onion blobs.
+var (
+	ErrInvalidPacketLength  = errors.New("invalid sphinx packet length")
+	ErrUnknownPacketVersion = errors.New("unknown sphinx packet version")
+	ErrInvalidEphemeralKey  = errors.New("invalid sphinx ephemeral key")
+)
+
+// CheckPacket checks the onion blob is a well formed packet: it has the size
+// of an onion packet, a known version and an ephemeral key on the curve. It
+// doesn't peel the onion, so it needs no keys, and it lets malformed blobs be
+// rejected with a clear error before they reach the onion library.
+func CheckPacket(onionBlob []byte) error {
+	if len(onionBlob) != lnwire.OnionPacketSize {
+		return fmt.Errorf("%w: %v bytes, expected %v", ErrInvalidPacketLength, len(onionBlob), lnwire.OnionPacketSize)
+	}
+	if onionBlob[0] != packetVersion {
+		return fmt.Errorf("%w: %v", ErrUnknownPacketVersion, onionBlob[0])
+	}
+	if _, err := btcec.ParsePubKey(onionBlob[1:34], btcec.S256()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEphemeralKey, err)
+	}
+	return nil
+}
@@ -7,60 +7,101 @@ import (
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
-	lndsphinx "github.com/lightningnetwork/lightning-onion"
 	"github.com/lightningnetwork/lnd/htlcswitch/hop"
 	"github.com/lightningnetwork/lnd/lnwire"
 )
 
 // Validate checks that the onion blob is valid and matches the invoice parameters.
 // Pass 0 as amount to skip amount validation.
+// Pass 0 as minCltvExpiry to skip cltv validation.
 func Validate(
 	onionBlob []byte,
 	paymentHash []byte,
 	paymentSecret []byte,
 	nodeKey *btcec.PrivateKey,
 	expiry uint32,
+	minCltvExpiry uint32,
 	amount lnwire.MilliSatoshi,
 	net *chaincfg.Params,
 ) error {
-	router := lndsphinx.NewRouter(nodeKey, net, lndsphinx.NewMemoryReplayLog())
-	if err := router.Start(); err != nil {
-		panic(err)
+	part, err := ValidatePart(onionBlob, paymentHash, paymentSecret, nodeKey, expiry, minCltvExpiry, amount, net)
+	if err != nil {
+		return err
 	}
-	onionProcessor := hop.NewOnionProcessor(router)
-	onionProcessor.Start()
-	iterator, code := onionProcessor.DecodeHopIterator(
-		bytes.NewReader(onionBlob),
-		paymentHash,
-		expiry,
-	)
-	if code != lnwire.CodeNone {
-		return fmt.Errorf("failed decode sphinx due to %v", code.String())
+	if part != nil {
+		return fmt.Errorf("payment is multipart. forwarded amt = %v, total amt = %v", part.AmountMsat, part.TotalMsat)
 	}
-	payload, err := iterator.HopPayload()
+	return nil
+}
+
+// Part is the share of a multi-part payment paid by a single htlc.
+type Part struct {
+	AmountMsat   lnwire.MilliSatoshi
+	TotalMsat    lnwire.MilliSatoshi
+	ExpiryHeight uint32 // the cltv expiry of the htlc paying the part
+}
+
+// ValidatePart is like Validate, but accepts onion blobs paying only part of
+// a multi-part payment. It returns the part paid in that case, and the caller
+// must check the whole payment arrived before handing out the preimage. Shards
+// of amp payments have their own payment hashes and aren't parts.
+func ValidatePart(
+	onionBlob []byte,
+	paymentHash []byte,
+	paymentSecret []byte,
+	nodeKey *btcec.PrivateKey,
+	expiry uint32,
+	minCltvExpiry uint32,
+	amount lnwire.MilliSatoshi,
+	net *chaincfg.Params,
+) (*Part, error) {
+	payload, amp, err := DecodeAMP(onionBlob, paymentHash, nodeKey, expiry, net)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	amountToForward := payload.ForwardingInfo().AmountToForward
 	if amount != 0 && amountToForward > amount {
-		return fmt.Errorf(
+		return nil, fmt.Errorf(
 			"sphinx payment amount does not match (%v != %v)", amount, amountToForward,
 		)
 	}
 
+	outgoingCltv := payload.ForwardingInfo().OutgoingCTLV
+	if minCltvExpiry != 0 && outgoingCltv < minCltvExpiry {
+		return nil, fmt.Errorf(
+			"sphinx cltv expiry is too close to the chain tip (%v < %v)", outgoingCltv, minCltvExpiry,
+		)
+	}
+
 	// Validate payment secret if it exists
 	if payload.MPP != nil {
 		paymentAddr := payload.MPP.PaymentAddr()
 		total := payload.MultiPath().TotalMsat()
 
 		if !bytes.Equal(paymentAddr[:], paymentSecret) {
-			return errors.New("sphinx payment secret does not match")
+			return nil, errors.New("sphinx payment secret does not match")
 		}
 
-		if amountToForward < total {
-			return fmt.Errorf("payment is multipart. forwarded amt = %v, total amt = %v", amountToForward, total)
+		// Shards of amp payments are checked on their own, the caller
+		// must check the whole set arrived
+		if amp == nil && amountToForward < total {
+			return &Part{AmountMsat: amountToForward, TotalMsat: total, ExpiryHeight: outgoingCltv}, nil
 		}
 	}
-	return nil
+	return nil, nil
+}
+
+// Decode peels the onion blob addressed to nodeKey and returns the payload for
+// this hop, without validating it against any invoice. It fails if the onion
+// hmac doesn't match.
+func Decode(
+	onionBlob []byte,
+	paymentHash []byte,
+	nodeKey *btcec.PrivateKey,
+	expiry uint32,
+	net *chaincfg.Params,
+) (*hop.Payload, error) {
+	payload, _, err := DecodeAMP(onionBlob, paymentHash, nodeKey, expiry, net)
+	return payload, err
 }
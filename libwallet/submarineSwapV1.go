@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/muun/libwallet/swaps"
@@ -19,6 +20,7 @@ type coinSubmarineSwapV1 struct {
 	PaymentHash256  []byte
 	ServerPublicKey []byte
 	LockTime        int64
+	SigHashes       *txscript.TxSigHashes // shared by the inputs of a batch, may be nil
 }
 
 func (c *coinSubmarineSwapV1) SignInput(index int, tx *wire.MsgTx, userKey *HDPrivateKey,
@@ -46,7 +48,7 @@ func (c *coinSubmarineSwapV1) SignInput(index int, tx *wire.MsgTx, userKey *HDPr
 	}
 
 	sig, err := signNonNativeSegwitInput(
-		index, tx, userKey, redeemScript, witnessScript, c.Amount)
+		index, tx, c.SigHashes, userKey, redeemScript, witnessScript, c.Amount)
 	if err != nil {
 		return err
 	}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/muun/libwallet/swaps"
@@ -21,6 +22,7 @@ type coinSubmarineSwapV2 struct {
 	ServerPublicKey     []byte
 	BlocksForExpiration int64
 	ServerSignature     []byte
+	SigHashes           *txscript.TxSigHashes // shared by the inputs of a batch, may be nil
 }
 
 func (c *coinSubmarineSwapV2) SignInput(index int, tx *wire.MsgTx, userKey *HDPrivateKey,
@@ -46,7 +48,7 @@ func (c *coinSubmarineSwapV2) SignInput(index int, tx *wire.MsgTx, userKey *HDPr
 	}
 
 	sig, err := signNativeSegwitInput(
-		index, tx, userKey, witnessScript, c.Amount)
+		index, tx, c.SigHashes, userKey, witnessScript, c.Amount)
 	if err != nil {
 		return err
 	}
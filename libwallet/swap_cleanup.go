@@ -0,0 +1,102 @@
+package libwallet
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+// abandonedSwapAge is how long after the last part of an incoming swap
+// arrived it's given up on if still not fulfilled. Swap htlcs expire long
+// before that.
+const abandonedSwapAge = 7 * 24 * time.Hour
+
+// abandonedHtlcBlocks is how many blocks past its expiration height a watched
+// htlc is given up on, ~1 week. The swap server may take a while to reclaim
+// it, but a refund that late is no longer worth watching for.
+const abandonedHtlcBlocks = 1008
+
+// SwapCleanupReport is the outcome of CleanupAbandonedSwaps.
+type SwapCleanupReport struct {
+	AbandonedSwaps int64 // invoices marked as abandoned
+	FreedMppParts  int64
+	FreedAmpShards int64
+}
+
+// CleanupAbandonedSwaps frees the multi-part payment parts and amp shards of
+// incoming swaps that received nothing for a week, so swap state doesn't grow
+// unbounded. Their invoices are marked as abandoned unless they were already
+// settled, refunded or cancelled. It's meant to be called periodically, eg
+// along with ExpireOldInvoices.
+func CleanupAbandonedSwaps() (_ *SwapCleanupReport, err error) {
+	defer recordErrors("CleanupAbandonedSwaps", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return cleanupAbandonedSwaps(db, walletNow().Add(-abandonedSwapAge))
+}
+
+func cleanupAbandonedSwaps(db *walletdb.DB, before time.Time) (*SwapCleanupReport, error) {
+	report := &SwapCleanupReport{}
+
+	paymentHashes, err := db.FindStaleMppPayments(before)
+	if err != nil {
+		return report, fmt.Errorf("CleanupAbandonedSwaps: %w", err)
+	}
+	for _, paymentHash := range paymentHashes {
+		abandoned, err := markSwapAbandoned(db, paymentHash)
+		if err != nil {
+			return report, fmt.Errorf("CleanupAbandonedSwaps: %w", err)
+		}
+		if abandoned {
+			report.AbandonedSwaps++
+		}
+
+		freed, err := db.DeleteMppParts(paymentHash)
+		if err != nil {
+			return report, fmt.Errorf("CleanupAbandonedSwaps: %w", err)
+		}
+		report.FreedMppParts += freed
+	}
+
+	// amp invoices can be paid many times, so only the shards are freed
+	setIds, err := db.FindStaleAmpSets(before)
+	if err != nil {
+		return report, fmt.Errorf("CleanupAbandonedSwaps: %w", err)
+	}
+	for _, setId := range setIds {
+		freed, err := db.DeleteAmpShards(setId)
+		if err != nil {
+			return report, fmt.Errorf("CleanupAbandonedSwaps: %w", err)
+		}
+		report.FreedAmpShards += freed
+	}
+
+	return report, nil
+}
+
+// markSwapAbandoned moves the invoice for the payment hash to the abandoned
+// state, and returns whether it did. Invoices that already reached a final
+// state, or are unknown, are left alone.
+func markSwapAbandoned(db *walletdb.DB, paymentHash []byte) (bool, error) {
+	found, err := db.HasInvoice(paymentHash)
+	if err != nil || !found {
+		return false, err
+	}
+	invoice, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return false, err
+	}
+	if invoice.State == walletdb.InvoiceStateAbandoned || !invoice.State.CanTransitionTo(walletdb.InvoiceStateAbandoned) {
+		return false, nil
+	}
+	if err := saveInvoiceState(db, invoice, walletdb.InvoiceStateAbandoned); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,134 @@
+package libwallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/muun/libwallet/walletdb"
+)
+
+func TestCleanupAbandonedSwaps(t *testing.T) {
+	setup()
+
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stale := time.Now().Add(-abandonedSwapAge - time.Hour)
+	withState := func(i int, state walletdb.InvoiceState) []byte {
+		invoice, err := db.FindByPaymentHash(secrets.Get(i).PaymentHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := saveInvoiceState(db, invoice, state); err != nil {
+			t.Fatal(err)
+		}
+		return invoice.PaymentHash
+	}
+	savePart := func(paymentHash []byte, createdAt time.Time) {
+		part := &walletdb.MppPart{
+			PaymentHash: paymentHash,
+			PartId:      randomBytes(32),
+			AmountMsat:  1000,
+			TotalMsat:   3000,
+		}
+		part.CreatedAt = createdAt
+		if err := db.SaveMppPart(part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saveShard := func(setId []byte, createdAt time.Time) {
+		shard := &walletdb.AmpShard{
+			PaymentHash:        randomBytes(32),
+			InvoicePaymentHash: secrets.Get(3).PaymentHash,
+			SetId:              setId,
+			AmountMsat:         1000,
+			TotalMsat:          3000,
+		}
+		shard.CreatedAt = createdAt
+		if err := db.SaveAmpShard(shard); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	abandoned := withState(0, walletdb.InvoiceStateUsed)
+	savePart(abandoned, stale)
+	savePart(abandoned, stale)
+
+	settled := withState(1, walletdb.InvoiceStateSettled)
+	savePart(settled, stale)
+
+	// a part arrived recently, so the swap may still complete
+	recent := withState(2, walletdb.InvoiceStateUsed)
+	savePart(recent, stale)
+	savePart(recent, time.Now())
+
+	staleSet := randomBytes(32)
+	saveShard(staleSet, stale)
+	recentSet := randomBytes(32)
+	saveShard(recentSet, time.Now())
+
+	report, err := CleanupAbandonedSwaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.AbandonedSwaps != 1 || report.FreedMppParts != 3 || report.FreedAmpShards != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	for _, expected := range []struct {
+		paymentHash []byte
+		state       walletdb.InvoiceState
+		parts       int
+	}{
+		{abandoned, walletdb.InvoiceStateAbandoned, 0},
+		{settled, walletdb.InvoiceStateSettled, 0},
+		{recent, walletdb.InvoiceStateUsed, 2},
+	} {
+		invoice, err := db.FindByPaymentHash(expected.paymentHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if invoice.State != expected.state {
+			t.Errorf("expected invoice to be %v, got %v", expected.state, invoice.State)
+		}
+		parts, err := db.ListMppParts(expected.paymentHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parts) != expected.parts {
+			t.Errorf("expected %v parts for %v invoice, got %v", expected.parts, expected.state, len(parts))
+		}
+	}
+
+	if shards, _ := db.ListAmpShards(staleSet); len(shards) != 0 {
+		t.Errorf("expected stale amp shards to be freed, got %v", len(shards))
+	}
+	if shards, _ := db.ListAmpShards(recentSet); len(shards) != 1 {
+		t.Errorf("expected recent amp shards to be kept, got %v", len(shards))
+	}
+
+	// cleaning up again finds nothing
+	report, err = CleanupAbandonedSwaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.AbandonedSwaps != 0 || report.FreedMppParts != 0 || report.FreedAmpShards != 0 {
+		t.Fatalf("expected nothing to clean up, got %+v", report)
+	}
+}
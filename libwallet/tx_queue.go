@@ -0,0 +1,91 @@
+package libwallet
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// TxBroadcaster is implemented by the apps to broadcast transactions through
+// their backends, eg trying each of them in turn.
+type TxBroadcaster interface {
+	// BroadcastTx sends the serialized tx to the network, returning an error
+	// if no backend accepted it.
+	BroadcastTx(rawTx []byte) error
+}
+
+// QueueTransaction stores the signed tx so it's broadcast by
+// BroadcastPendingTransactions, surviving restarts until it's accepted or
+// validForSeconds pass. This lets apps build txs like fulfillments while
+// offline. Queueing a tx again renews its expiry. It returns the tx hash.
+func QueueTransaction(rawTx []byte, validForSeconds int64) (_ string, err error) {
+	defer recordErrors("QueueTransaction", &err)
+
+	if validForSeconds <= 0 {
+		return "", fmt.Errorf("QueueTransaction: invalid validity %v", validForSeconds)
+	}
+
+	tx := wire.NewMsgTx(0)
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return "", fmt.Errorf("QueueTransaction: failed to decode tx: %w", err)
+	}
+	txId := tx.TxHash().String()
+
+	db, err := openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	err = db.SavePendingTransaction(&walletdb.PendingTransaction{
+		TxId:      txId,
+		RawTx:     rawTx,
+		ExpiresAt: walletNow().Add(time.Duration(validForSeconds) * time.Second),
+	})
+	if err != nil {
+		return "", fmt.Errorf("QueueTransaction: %w", err)
+	}
+	return txId, nil
+}
+
+// BroadcastPendingTransactions tries to broadcast every queued tx, meant to be
+// called when connectivity returns. Txs accepted by the broadcaster or past
+// their expiry are removed from the queue, the rest are retried on the next
+// call. It returns the number of txs still queued.
+func BroadcastPendingTransactions(broadcaster TxBroadcaster) (_ int64, err error) {
+	defer recordErrors("BroadcastPendingTransactions", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	return broadcastPendingTransactions(db, broadcaster, walletNow())
+}
+
+func broadcastPendingTransactions(db *walletdb.DB, broadcaster TxBroadcaster, now time.Time) (int64, error) {
+	txs, err := db.ListPendingTransactions()
+	if err != nil {
+		return 0, fmt.Errorf("BroadcastPendingTransactions: %w", err)
+	}
+
+	var pending int64
+	for i := range txs {
+		tx := &txs[i]
+		if now.Before(tx.ExpiresAt) {
+			if err := broadcaster.BroadcastTx(tx.RawTx); err != nil {
+				// Keep it for the next call, the failure may be transient
+				pending++
+				continue
+			}
+		}
+		if err := db.DeletePendingTransaction(tx); err != nil {
+			return 0, fmt.Errorf("BroadcastPendingTransactions: %w", err)
+		}
+	}
+	return pending, nil
+}
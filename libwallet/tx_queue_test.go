@@ -0,0 +1,96 @@
+package libwallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+type recordingBroadcaster struct {
+	broadcast [][]byte
+	err       error
+}
+
+func (b *recordingBroadcaster) BroadcastTx(rawTx []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.broadcast = append(b.broadcast, rawTx)
+	return nil
+}
+
+func newQueuedTx(amount int64) []byte {
+	prevOutHash, _ := chainhash.NewHash(randomBytes(32))
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevOutHash, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(amount, []byte{0x51}))
+	return serializeTx(tx)
+}
+
+func TestBroadcastPendingTransactions(t *testing.T) {
+	setup()
+
+	first := newQueuedTx(1000)
+	second := newQueuedTx(2000)
+
+	txId, err := QueueTransaction(first, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txId) != 64 {
+		t.Fatalf("unexpected tx id %v", txId)
+	}
+	// queueing again only renews the expiry
+	if _, err := QueueTransaction(first, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := QueueTransaction(second, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := QueueTransaction([]byte{1, 2, 3}, 60); err == nil {
+		t.Fatal("expected error for invalid tx")
+	}
+	if _, err := QueueTransaction(first, 0); err == nil {
+		t.Fatal("expected error for invalid validity")
+	}
+
+	pending, err := BroadcastPendingTransactions(&recordingBroadcaster{err: errors.New("offline")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 2 {
+		t.Fatalf("expected 2 txs to remain queued, got %v", pending)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Once the second tx expires only the first one is broadcast
+	broadcaster := &recordingBroadcaster{}
+	pending, err = broadcastPendingTransactions(db, broadcaster, time.Now().Add(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 0 {
+		t.Fatalf("expected no txs to remain queued, got %v", pending)
+	}
+	if len(broadcaster.broadcast) != 1 || !bytes.Equal(broadcaster.broadcast[0], first) {
+		t.Fatalf("expected only the first tx to be broadcast, got %v txs", len(broadcaster.broadcast))
+	}
+
+	txs, err := db.ListPendingTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 0 {
+		t.Fatalf("expected queue to be empty, got %v txs", len(txs))
+	}
+}
@@ -0,0 +1,259 @@
+package libwallet
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/muun/libwallet/addresses"
+)
+
+// Vault addresses keep funds that can only be spent after a delay. Spending
+// them takes two steps: an unvault tx, signed by the user and Muun, moves the
+// funds to an unvault address whose multisig path is locked for a number of
+// blocks. Until the delay passes, the cold key can claw the funds back from
+// either address, so a spend the user didn't intend can be cancelled.
+const (
+	// MinUnvaultDelay is the minimum number of blocks unvaulted funds must
+	// wait before being spent.
+	MinUnvaultDelay = 144
+	// MaxUnvaultDelay is the largest relative lock time in blocks.
+	MaxUnvaultDelay = 0xffff
+)
+
+// CreateVaultAddress returns a P2WSH MuunAddress that can be spent to an
+// unvault address by the user and Muun together, or by the cold key alone.
+// The keys must be derived to the same path.
+func CreateVaultAddress(userKey, muunKey, coldKey *HDPublicKey) (MuunAddress, error) {
+	return addresses.CreateAddressVault(&userKey.key, &muunKey.key, &coldKey.key, userKey.Path, userKey.Network.network)
+}
+
+// CreateUnvaultAddress returns a P2WSH MuunAddress that can be spent by the
+// user and Muun together once delayBlocks passed since it was funded, or by
+// the cold key alone at any time. The keys must be derived to the same path.
+func CreateUnvaultAddress(userKey, muunKey, coldKey *HDPublicKey, delayBlocks int64) (MuunAddress, error) {
+	if delayBlocks < MinUnvaultDelay || delayBlocks > MaxUnvaultDelay {
+		return nil, fmt.Errorf("CreateUnvaultAddress: delay must be between %v and %v blocks, got %v",
+			MinUnvaultDelay, MaxUnvaultDelay, delayBlocks)
+	}
+	return addresses.CreateAddressUnvault(&userKey.key, &muunKey.key, &coldKey.key, delayBlocks, userKey.Path, userKey.Network.network)
+}
+
+// VaultCoin is an output paying to a vault or unvault address.
+type VaultCoin struct {
+	TxId          []byte
+	Index         int64
+	AmountSat     int64
+	KeyPath       string
+	DelayBlocks   int64  // 0 for vault addresses
+	MuunSignature []byte // of the tx spending the coin, not needed to cancel
+}
+
+func (c *VaultCoin) outPoint() (wire.OutPoint, error) {
+	txId, err := chainhash.NewHash(c.TxId)
+	if err != nil {
+		return wire.OutPoint{}, err
+	}
+	return wire.OutPoint{Hash: *txId, Index: uint32(c.Index)}, nil
+}
+
+// witnessScript returns the script of the address of the coin, deriving the
+// keys to its path.
+func (c *VaultCoin) witnessScript(userKey, muunKey, coldKey *HDPublicKey) ([]byte, error) {
+	derivedUserKey, err := userKey.DeriveTo(c.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive user key: %w", err)
+	}
+	derivedMuunKey, err := muunKey.DeriveTo(c.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive muun key: %w", err)
+	}
+	derivedColdKey, err := coldKey.DeriveTo(c.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive cold key: %w", err)
+	}
+
+	if c.DelayBlocks == 0 {
+		return addresses.CreateWitnessScriptVault(&derivedUserKey.key, &derivedMuunKey.key, &derivedColdKey.key)
+	}
+	return addresses.CreateWitnessScriptUnvault(&derivedUserKey.key, &derivedMuunKey.key, &derivedColdKey.key, c.DelayBlocks)
+}
+
+// prevOut returns the output of the coin, paying to the witness script.
+func (c *VaultCoin) prevOut(witnessScript []byte, net *Network) (*wire.TxOut, error) {
+	address, err := btcutil.NewAddressWitnessScriptHash(chainhash.HashB(witnessScript), net.network)
+	if err != nil {
+		return nil, err
+	}
+	script, err := addressToScript(address.EncodeAddress(), net)
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewTxOut(c.AmountSat, script), nil
+}
+
+// InitiateUnvault signs the unvault tx proposed by Muun, which must spend the
+// vault coin to the unvault address with delayBlocks at the same path.
+func InitiateUnvault(rawTx []byte, coin *VaultCoin, userKey *HDPrivateKey, muunKey, coldKey *HDPublicKey, delayBlocks int64) (_ *Transaction, err error) {
+	defer recordErrors("InitiateUnvault", &err)
+
+	if coin.DelayBlocks != 0 {
+		return nil, fmt.Errorf("InitiateUnvault: coin is already unvaulting")
+	}
+
+	tx, err := deserializeVaultSpend(rawTx, coin)
+	if err != nil {
+		return nil, fmt.Errorf("InitiateUnvault: %w", err)
+	}
+
+	derivedUserKey, err := userKey.PublicKey().DeriveTo(coin.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("InitiateUnvault: failed to derive user key: %w", err)
+	}
+	derivedMuunKey, err := muunKey.DeriveTo(coin.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("InitiateUnvault: failed to derive muun key: %w", err)
+	}
+	derivedColdKey, err := coldKey.DeriveTo(coin.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("InitiateUnvault: failed to derive cold key: %w", err)
+	}
+	unvaultAddress, err := CreateUnvaultAddress(derivedUserKey, derivedMuunKey, derivedColdKey, delayBlocks)
+	if err != nil {
+		return nil, fmt.Errorf("InitiateUnvault: %w", err)
+	}
+	unvaultScript, err := addressToScript(unvaultAddress.Address(), userKey.Network)
+	if err != nil {
+		return nil, fmt.Errorf("InitiateUnvault: %w", err)
+	}
+	if len(tx.TxOut) != 1 || !bytes.Equal(tx.TxOut[0].PkScript, unvaultScript) {
+		return nil, fmt.Errorf("InitiateUnvault: expected unvault tx to only pay to the unvault address")
+	}
+
+	if err := signVaultSpend(tx, coin, userKey, muunKey, coldKey); err != nil {
+		return nil, fmt.Errorf("InitiateUnvault: %w", err)
+	}
+	return newTransaction(tx)
+}
+
+// CompleteUnvault signs the tx proposed by Muun spending the unvault coin,
+// which is only valid once the coin delay passed. The input sequence must
+// be at least the delay.
+func CompleteUnvault(rawTx []byte, coin *VaultCoin, userKey *HDPrivateKey, muunKey, coldKey *HDPublicKey) (_ *Transaction, err error) {
+	defer recordErrors("CompleteUnvault", &err)
+
+	if coin.DelayBlocks == 0 {
+		return nil, fmt.Errorf("CompleteUnvault: coin is not unvaulting")
+	}
+
+	tx, err := deserializeVaultSpend(rawTx, coin)
+	if err != nil {
+		return nil, fmt.Errorf("CompleteUnvault: %w", err)
+	}
+
+	if err := signVaultSpend(tx, coin, userKey, muunKey, coldKey); err != nil {
+		return nil, fmt.Errorf("CompleteUnvault: %w", err)
+	}
+	return newTransaction(tx)
+}
+
+// CancelUnvault builds and signs a tx clawing the coin back to the
+// destination address with the cold key, paying feeSat in fees. Vault coins
+// can be cancelled too, before an unvault tx is broadcast. The tx signals
+// replaceability so its fee can be bumped.
+func CancelUnvault(coin *VaultCoin, coldKey *HDPrivateKey, userKey, muunKey *HDPublicKey, destination string, feeSat int64) (_ *Transaction, err error) {
+	defer recordErrors("CancelUnvault", &err)
+
+	net := coldKey.Network
+
+	if feeSat < 0 || coin.AmountSat-feeSat < dustThreshold {
+		return nil, fmt.Errorf("CancelUnvault: can't pay %v sats in fees from %v sats", feeSat, coin.AmountSat)
+	}
+
+	outPoint, err := coin.outPoint()
+	if err != nil {
+		return nil, fmt.Errorf("CancelUnvault: %w", err)
+	}
+	destinationScript, err := addressToScript(destination, net)
+	if err != nil {
+		return nil, fmt.Errorf("CancelUnvault: %w", err)
+	}
+
+	tx := wire.NewMsgTx(2)
+	txIn := wire.NewTxIn(&outPoint, nil, nil)
+	txIn.Sequence = wire.MaxTxInSequenceNum - 2
+	tx.AddTxIn(txIn)
+	tx.AddTxOut(wire.NewTxOut(coin.AmountSat-feeSat, destinationScript))
+
+	witnessScript, err := coin.witnessScript(userKey, muunKey, coldKey.PublicKey())
+	if err != nil {
+		return nil, fmt.Errorf("CancelUnvault: %w", err)
+	}
+	derivedColdKey, err := coldKey.DeriveTo(coin.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("CancelUnvault: failed to derive cold key: %w", err)
+	}
+	sig, err := signNativeSegwitInput(0, tx, nil, derivedColdKey, witnessScript, btcutil.Amount(coin.AmountSat))
+	if err != nil {
+		return nil, fmt.Errorf("CancelUnvault: %w", err)
+	}
+	txIn.Witness = wire.TxWitness{sig, []byte{}, witnessScript}
+
+	prevOut, err := coin.prevOut(witnessScript, net)
+	if err != nil {
+		return nil, fmt.Errorf("CancelUnvault: %w", err)
+	}
+	if err := verifySignedInputs(tx, []*wire.TxOut{prevOut}); err != nil {
+		return nil, fmt.Errorf("CancelUnvault: signed tx is invalid: %w", err)
+	}
+	return newTransaction(tx)
+}
+
+// deserializeVaultSpend parses a tx spending only the coin.
+func deserializeVaultSpend(rawTx []byte, coin *VaultCoin) (*wire.MsgTx, error) {
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, fmt.Errorf("could not deserialize tx: %w", err)
+	}
+	outPoint, err := coin.outPoint()
+	if err != nil {
+		return nil, err
+	}
+	if len(tx.TxIn) != 1 || tx.TxIn[0].PreviousOutPoint != outPoint {
+		return nil, fmt.Errorf("expected tx to only spend the vault coin")
+	}
+	return tx, nil
+}
+
+// signVaultSpend signs the only input of the tx through the multisig path of
+// the coin script, and checks the result is valid.
+func signVaultSpend(tx *wire.MsgTx, coin *VaultCoin, userKey *HDPrivateKey, muunKey, coldKey *HDPublicKey) error {
+	if len(coin.MuunSignature) == 0 {
+		return fmt.Errorf("muun signature must be present")
+	}
+
+	witnessScript, err := coin.witnessScript(userKey.PublicKey(), muunKey, coldKey)
+	if err != nil {
+		return err
+	}
+	derivedUserKey, err := userKey.DeriveTo(coin.KeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to derive user key: %w", err)
+	}
+	sig, err := signNativeSegwitInput(0, tx, nil, derivedUserKey, witnessScript, btcutil.Amount(coin.AmountSat))
+	if err != nil {
+		return err
+	}
+	tx.TxIn[0].Witness = wire.TxWitness{[]byte{}, sig, coin.MuunSignature, []byte{1}, witnessScript}
+
+	prevOut, err := coin.prevOut(witnessScript, userKey.Network)
+	if err != nil {
+		return err
+	}
+	if err := verifySignedInputs(tx, []*wire.TxOut{prevOut}); err != nil {
+		return fmt.Errorf("signed tx is invalid: %w", err)
+	}
+	return nil
+}
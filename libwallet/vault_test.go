@@ -0,0 +1,159 @@
+package libwallet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestVault(t *testing.T) {
+	const (
+		keyPath     = "m/schema:1'/recovery:1'/external:1/0"
+		amount      = int64(100000)
+		delayBlocks = int64(MinUnvaultDelay)
+	)
+
+	newKey := func() *HDPrivateKey {
+		key, _ := NewHDPrivateKey(randomBytes(32), network)
+		key.Path = "m/schema:1'/recovery:1'"
+		return key
+	}
+	userKey := newKey()
+	muunKey := newKey()
+	coldKey := newKey()
+
+	derive := func(key *HDPrivateKey) *HDPublicKey {
+		derived, err := key.PublicKey().DeriveTo(keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return derived
+	}
+	unvaultAddress, err := CreateUnvaultAddress(derive(userKey), derive(muunKey), derive(coldKey), delayBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unvaultScript, err := addressToScript(unvaultAddress.Address(), network)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// muunSign adds the signature Muun would make over the spend of the coin
+	muunSign := func(coin *VaultCoin, rawTx []byte) {
+		tx := wire.MsgTx{}
+		if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+			t.Fatal(err)
+		}
+		script, err := coin.witnessScript(userKey.PublicKey(), muunKey.PublicKey(), coldKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		derivedMuunKey, err := muunKey.DeriveTo(keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		coin.MuunSignature, err = signNativeSegwitInput(0, &tx, nil, derivedMuunKey, script, btcutil.Amount(coin.AmountSat))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spend := func(coin *VaultCoin, sequence uint32, pkScript []byte) []byte {
+		txId, _ := chainhash.NewHash(coin.TxId)
+		tx := wire.NewMsgTx(2)
+		txIn := wire.NewTxIn(&wire.OutPoint{Hash: *txId, Index: uint32(coin.Index)}, nil, nil)
+		txIn.Sequence = sequence
+		tx.AddTxIn(txIn)
+		tx.AddTxOut(wire.NewTxOut(coin.AmountSat-1000, pkScript))
+		return serializeTx(tx)
+	}
+
+	vaultCoin := &VaultCoin{
+		TxId:      randomBytes(32),
+		Index:     1,
+		AmountSat: amount,
+		KeyPath:   keyPath,
+	}
+
+	t.Run("unvault", func(t *testing.T) {
+		coin := *vaultCoin
+		rawTx := spend(&coin, wire.MaxTxInSequenceNum, unvaultScript)
+		muunSign(&coin, rawTx)
+
+		unvaultTx, err := InitiateUnvault(rawTx, &coin, userKey, muunKey.PublicKey(), coldKey.PublicKey(), delayBlocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txId, _ := chainhash.NewHashFromStr(unvaultTx.Hash)
+		unvaultCoin := &VaultCoin{
+			TxId:        txId[:],
+			Index:       0,
+			AmountSat:   amount - 1000,
+			KeyPath:     keyPath,
+			DelayBlocks: delayBlocks,
+		}
+		destination, err := addressToScript("bcrt1qhv0a0uhrt2crdehgfge8e8e6texw3q4has8jh7", network)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		early := spend(unvaultCoin, uint32(delayBlocks-1), destination)
+		muunSign(unvaultCoin, early)
+		if _, err := CompleteUnvault(early, unvaultCoin, userKey, muunKey.PublicKey(), coldKey.PublicKey()); err == nil {
+			t.Fatal("expected spend before the delay to fail")
+		}
+
+		rawTx = spend(unvaultCoin, uint32(delayBlocks), destination)
+		muunSign(unvaultCoin, rawTx)
+		if _, err := CompleteUnvault(rawTx, unvaultCoin, userKey, muunKey.PublicKey(), coldKey.PublicKey()); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = CancelUnvault(unvaultCoin, coldKey, userKey.PublicKey(), muunKey.PublicKey(), "bcrt1qhv0a0uhrt2crdehgfge8e8e6texw3q4has8jh7", 500)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("cancel from vault", func(t *testing.T) {
+		tx, err := CancelUnvault(vaultCoin, coldKey, userKey.PublicKey(), muunKey.PublicKey(), "bcrt1qhv0a0uhrt2crdehgfge8e8e6texw3q4has8jh7", 500)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.Hash == "" {
+			t.Fatal("expected a signed tx")
+		}
+
+		_, err = CancelUnvault(vaultCoin, coldKey, userKey.PublicKey(), muunKey.PublicKey(), "bcrt1qhv0a0uhrt2crdehgfge8e8e6texw3q4has8jh7", amount)
+		if err == nil {
+			t.Fatal("expected fee above the amount to fail")
+		}
+	})
+
+	t.Run("unvault to other address", func(t *testing.T) {
+		coin := *vaultCoin
+		destination, _ := addressToScript("bcrt1qhv0a0uhrt2crdehgfge8e8e6texw3q4has8jh7", network)
+		rawTx := spend(&coin, wire.MaxTxInSequenceNum, destination)
+		muunSign(&coin, rawTx)
+
+		_, err := InitiateUnvault(rawTx, &coin, userKey, muunKey.PublicKey(), coldKey.PublicKey(), delayBlocks)
+		if err == nil {
+			t.Fatal("expected unvault tx paying elsewhere to fail")
+		}
+	})
+
+	t.Run("invalid muun signature", func(t *testing.T) {
+		coin := *vaultCoin
+		rawTx := spend(&coin, wire.MaxTxInSequenceNum, unvaultScript)
+		muunSign(&coin, spend(&coin, 0, unvaultScript))
+
+		_, err := InitiateUnvault(rawTx, &coin, userKey, muunKey.PublicKey(), coldKey.PublicKey(), delayBlocks)
+		if err == nil {
+			t.Fatal("expected signature over another tx to fail")
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package walletdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxOperationLogEntries is the number of operation log entries kept to
+// recognize the heads of devices that are behind.
+const maxOperationLogEntries = 1000
+
+// Operations recorded in the operation log.
+const (
+	OperationCreateInvoice = "create invoice"
+	OperationSaveInvoice   = "save invoice"
+)
+
+// OperationLogEntry is a link of the hash chain over the invoice mutations.
+// Each head hashes the previous one with the mutation, so devices that made
+// the same mutations in the same order have the same head, and comparing
+// heads tells cheaply whether their dbs may have diverged. The chain starts
+// from GenesisHead with the first mutation after it was introduced.
+type OperationLogEntry struct {
+	gorm.Model
+	Sequence    int64 `gorm:"unique_index"`
+	Operation   string
+	PaymentHash []byte
+	Head        []byte
+}
+
+// GenesisHead is the head of an empty operation log.
+var GenesisHead = make([]byte, sha256.Size)
+
+// nextHead returns the head following prev after the mutation of the
+// invoice. Only the fields synced between devices are hashed, so their
+// local timestamps and ids don't make heads differ.
+func nextHead(prev []byte, operation string, invoice *Invoice) []byte {
+	h := sha256.New()
+	h.Write(prev)
+
+	var amount [8]byte
+	binary.BigEndian.PutUint64(amount[:], uint64(invoice.AmountSat))
+
+	for _, field := range [][]byte{
+		[]byte(operation),
+		invoice.PaymentHash,
+		[]byte(invoice.State),
+		amount[:],
+		invoice.Metadata,
+	} {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+		h.Write(length[:])
+		h.Write(field)
+	}
+	return h.Sum(nil)
+}
+
+// appendOperation links the mutation of the invoice to the operation log.
+// It must run in the transaction of the mutation.
+func appendOperation(tx *gorm.DB, operation string, invoice *Invoice) error {
+	latest, err := latestOperation(tx)
+	if err != nil {
+		return err
+	}
+	entry := &OperationLogEntry{
+		Sequence:    1,
+		Operation:   operation,
+		PaymentHash: invoice.PaymentHash,
+		Head:        nextHead(GenesisHead, operation, invoice),
+	}
+	if latest != nil {
+		entry.Sequence = latest.Sequence + 1
+		entry.Head = nextHead(latest.Head, operation, invoice)
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return err
+	}
+
+	return tx.Unscoped().
+		Where("sequence <= ?", entry.Sequence-maxOperationLogEntries).
+		Delete(&OperationLogEntry{}).Error
+}
+
+func latestOperation(tx *gorm.DB) (*OperationLogEntry, error) {
+	var entries []OperationLogEntry
+	if res := tx.Order("sequence desc").Limit(1).Find(&entries); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return &entries[0], nil
+}
+
+// OperationLogHead returns the latest entry of the operation log, or nil if
+// it's empty.
+func (d *DB) OperationLogHead() (*OperationLogEntry, error) {
+	return latestOperation(d.db)
+}
+
+// HasOperationLogHead returns whether the head is one of the kept entries of
+// the operation log, or the genesis head, which means the device it comes
+// from made a prefix of the mutations of this one.
+func (d *DB) HasOperationLogHead(head []byte) (bool, error) {
+	if bytes.Equal(head, GenesisHead) {
+		return true, nil
+	}
+	var count int
+	if res := d.db.Model(&OperationLogEntry{}).Where("head = ?", head).Count(&count); res.Error != nil {
+		return false, res.Error
+	}
+	return count > 0, nil
+}
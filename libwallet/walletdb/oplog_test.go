@@ -0,0 +1,98 @@
+package walletdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestOperationLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+
+	// two devices making the same mutations
+	devices := make([]*DB, 2)
+	for i := range devices {
+		devices[i], err = OpenWithMacKey(path.Join(dir, fmt.Sprintf("device%v.db", i)), randomBytes(32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer devices[i].Close()
+	}
+
+	invoice := Invoice{
+		Preimage:      randomBytes(32),
+		PaymentHash:   randomBytes(32),
+		PaymentSecret: randomBytes(32),
+		KeyPath:       "m/schema:1'/recovery:1'/invoices:4/34/56",
+		State:         InvoiceStateRegistered,
+	}
+	var heads [][]byte
+	for _, db := range devices {
+		head, err := db.OperationLogHead()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head != nil {
+			t.Fatal("expected an empty operation log")
+		}
+
+		invoice := invoice
+		if err := db.CreateInvoice(&invoice); err != nil {
+			t.Fatal(err)
+		}
+		invoice.State = InvoiceStateUsed
+		invoice.AmountSat = 1000
+		if err := db.SaveInvoice(&invoice); err != nil {
+			t.Fatal(err)
+		}
+
+		head, err = db.OperationLogHead()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.Sequence != 2 || head.Operation != OperationSaveInvoice {
+			t.Fatalf("unexpected head %+v", head)
+		}
+		heads = append(heads, head.Head)
+	}
+	if !bytes.Equal(heads[0], heads[1]) {
+		t.Fatal("expected devices with the same mutations to have the same head")
+	}
+
+	// a failed mutation isn't chained
+	duplicate := invoice
+	if err := devices[0].CreateInvoice(&duplicate); err != ErrDuplicatePaymentHash {
+		t.Fatalf("expected duplicate payment hash, got %v", err)
+	}
+	if head, _ := devices[0].OperationLogHead(); head.Sequence != 2 {
+		t.Fatalf("expected the failed mutation not to be chained, got %+v", head)
+	}
+
+	// old entries are pruned
+	saved, err := devices[1].FindByPaymentHash(invoice.PaymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxOperationLogEntries; i++ {
+		if err := devices[1].SaveInvoice(saved); err != nil {
+			t.Fatal(err)
+		}
+	}
+	known, err := devices[1].HasOperationLogHead(heads[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known {
+		t.Fatal("expected the old head to be pruned")
+	}
+	var count int
+	devices[1].db.Model(&OperationLogEntry{}).Count(&count)
+	if count != maxOperationLogEntries {
+		t.Fatalf("expected %v entries, got %v", maxOperationLogEntries, count)
+	}
+}
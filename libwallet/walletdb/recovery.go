@@ -0,0 +1,87 @@
+package walletdb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/jinzhu/gorm"
+	gormigrate "gopkg.in/gormigrate.v1"
+)
+
+// ErrNotWalletDB is returned by OpenForRecovery for files without invoices.
+var ErrNotWalletDB = errors.New("not a wallet db")
+
+// RecoveryDB is a read only view of a wallet db copied from another device,
+// see OpenForRecovery.
+type RecoveryDB struct {
+	db *gorm.DB
+}
+
+// OpenForRecovery opens a copy of a wallet db taken from another device, eg
+// provided by a user to support, to extract the preimages and key paths
+// needed to receive the htlcs pending for its invoices.
+//
+// The file is opened read only and its migrations aren't run, so the copy is
+// never modified whatever version wrote it. Columns unknown to this version
+// are ignored and missing ones are left empty. Invoice macs aren't checked,
+// since the key of the other device is unknown, and neither are key paths,
+// so invoices must be treated as untrusted input.
+func OpenForRecovery(path string) (*RecoveryDB, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?mode=ro"
+	db, err := gorm.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if !db.HasTable(&Invoice{}) {
+		db.Close()
+		return nil, fmt.Errorf("%w: %v has no invoices table", ErrNotWalletDB, path)
+	}
+	return &RecoveryDB{db}, nil
+}
+
+// MigrationStatus returns the number of migrations applied to the copy and
+// the number known to this version. A copy with more applied migrations was
+// written by a newer version. Whether the invoice macs were filled in depends
+// on the device, so that migration isn't counted.
+func (r *RecoveryDB) MigrationStatus() (applied int, total int, err error) {
+	table := gormigrate.DefaultOptions.TableName
+	if !r.db.HasTable(table) {
+		return 0, len(migrations), nil
+	}
+	query := r.db.Table(table).Where("id <> ?", fillInvoiceMacsMigrationID)
+	if res := query.Count(&applied); res.Error != nil {
+		return 0, 0, res.Error
+	}
+	return applied, len(migrations), nil
+}
+
+// ListInvoices returns every invoice in the copy, in insertion order.
+func (r *RecoveryDB) ListInvoices() ([]Invoice, error) {
+	var invoices []Invoice
+	if res := r.db.Order("id asc").Find(&invoices); res.Error != nil {
+		return nil, res.Error
+	}
+	for i := range invoices {
+		invoices[i].ShortChanId = invoices[i].ShortChanId | (1 << 63)
+	}
+	return invoices, nil
+}
+
+// FindByPaymentHash returns the invoice with the given payment hash.
+func (r *RecoveryDB) FindByPaymentHash(hash []byte) (*Invoice, error) {
+	var invoice Invoice
+	if res := r.db.Where(&Invoice{PaymentHash: hash}).First(&invoice); res.Error != nil {
+		return nil, res.Error
+	}
+	invoice.ShortChanId = invoice.ShortChanId | (1 << 63)
+	return &invoice, nil
+}
+
+func (r *RecoveryDB) Close() {
+	err := r.db.Close()
+	if err != nil {
+		log.Printf("error closing the db: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package walletdb
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestOpenForRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+
+	// a db written by another device, with its own mac key
+	original := path.Join(dir, "other device.db")
+	db, err := OpenWithMacKey(original, randomBytes(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	preimage := randomBytes(32)
+	paymentHash := randomBytes(32)
+	err = db.CreateInvoice(&Invoice{
+		Preimage:    preimage,
+		PaymentHash: paymentHash,
+		KeyPath:     "m/schema:1'/recovery:1'/invoices:4/34/56",
+		ShortChanId: 1234,
+		State:       InvoiceStateUsed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	stat, err := os.Stat(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recovery, err := OpenForRecovery(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovery.Close()
+
+	invoices, err := recovery.ListInvoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 1 || !bytes.Equal(invoices[0].Preimage, preimage) {
+		t.Fatalf("unexpected invoices %+v", invoices)
+	}
+	invoice, err := recovery.FindByPaymentHash(paymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invoice.KeyPath != "m/schema:1'/recovery:1'/invoices:4/34/56" || invoice.ShortChanId != 1234|(1<<63) {
+		t.Fatalf("unexpected invoice %+v", invoice)
+	}
+	applied, total, err := recovery.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != total {
+		t.Fatalf("expected every migration applied, got %v of %v", applied, total)
+	}
+
+	// the copy can't be written to
+	if res := recovery.db.Model(&Invoice{}).Update("state", InvoiceStateSettled); res.Error == nil {
+		t.Fatal("expected writing to the copy to fail")
+	}
+	if after, err := os.Stat(original); err != nil || !after.ModTime().Equal(stat.ModTime()) {
+		t.Fatal("expected the copy not to be modified")
+	}
+
+	// other files aren't mistaken for a wallet db
+	if _, err := OpenForRecovery(path.Join(dir, "missing.db")); err == nil {
+		t.Fatal("expected opening a missing file to fail")
+	}
+	empty := path.Join(dir, "empty.db")
+	other, err := Attach(empty, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if _, err := OpenForRecovery(empty); !errors.Is(err, ErrNotWalletDB) {
+		t.Fatalf("expected ErrNotWalletDB, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package walletdb
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SlowQueryOperation is the journal operation slow queries are recorded
+// under, see RecordSlowQueries.
+const SlowQueryOperation = "slow query"
+
+// maxSlowQueryLength is how much of the sql of a slow query is recorded.
+const maxSlowQueryLength = 200
+
+// slowQueryLog is a gorm logger collecting the queries that take longer than
+// the threshold. Every other log line is printed like gorm does by default.
+type slowQueryLog struct {
+	threshold  time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	queries []*slowQuery
+}
+
+// slowQuery aggregates the executions of a statement over the threshold.
+type slowQuery struct {
+	sql     string
+	count   int
+	slowest time.Duration
+}
+
+var gormLogger = gorm.Logger{LogWriter: log.New(os.Stdout, "\r\n", 0)}
+
+// Print receives every gorm log line. Sql lines are ("sql", source,
+// duration, sql, vars, rows affected).
+func (l *slowQueryLog) Print(values ...interface{}) {
+	if len(values) < 4 || values[0] != "sql" {
+		gormLogger.Print(values...)
+		return
+	}
+	duration, _ := values[2].(time.Duration)
+	sql, _ := values[3].(string)
+	if duration < l.threshold {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, query := range l.queries {
+		if query.sql == sql {
+			query.count++
+			if duration > query.slowest {
+				query.slowest = duration
+			}
+			return
+		}
+	}
+	l.queries = append(l.queries, &slowQuery{sql: sql, count: 1, slowest: duration})
+}
+
+func (l *slowQueryLog) take() []*slowQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	queries := l.queries
+	l.queries = nil
+	return queries
+}
+
+// RecordSlowQueries makes the db record the queries that take longer than
+// the threshold in the error journal when it's closed, keeping at most
+// maxEntries entries like AppendJournalEntry. Each statement is recorded once
+// with the number of slow executions and the slowest one. Only the sql with
+// its placeholders is recorded, never the values, since they may be invoice
+// secrets.
+func (d *DB) RecordSlowQueries(threshold time.Duration, maxEntries int) {
+	d.slowQueries = &slowQueryLog{threshold: threshold, maxEntries: maxEntries}
+	d.db.SetLogger(d.slowQueries)
+	d.db.LogMode(true)
+}
+
+// flushSlowQueries appends the slow queries collected so far to the journal.
+// Failing to do so is only logged, like failing to close the db.
+func (d *DB) flushSlowQueries() {
+	if d.slowQueries == nil {
+		return
+	}
+	for _, query := range d.slowQueries.take() {
+		sql := query.sql
+		if len(sql) > maxSlowQueryLength {
+			sql = sql[:maxSlowQueryLength] + "..."
+		}
+		err := d.AppendJournalEntry(&JournalEntry{
+			Level:     JournalLevelError,
+			Operation: SlowQueryOperation,
+			Message:   fmt.Sprintf("took %v (%v times over %v): %v", query.slowest, query.count, d.slowQueries.threshold, sql),
+		}, d.slowQueries.maxEntries)
+		if err != nil {
+			log.Printf("error recording slow query in the journal: %v", err)
+			return
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package walletdb
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/mattn/go-sqlite3"
+	"github.com/muun/libwallet/hdpath"
 	gormigrate "gopkg.in/gormigrate.v1"
 )
 
@@ -15,113 +21,1176 @@ type InvoiceState string
 const (
 	InvoiceStateRegistered InvoiceState = "registered"
 	InvoiceStateUsed       InvoiceState = "used"
+	InvoiceStateSettled    InvoiceState = "settled"
+	// InvoiceStateImported marks settled invoices imported from another node,
+	// kept only as payment history. They have no key path and can't be used
+	// to receive payments.
+	InvoiceStateImported InvoiceState = "imported"
+	// InvoiceStateRefunded marks invoices whose incoming swap htlc expired
+	// and was reclaimed by the swap server, so the payment will never arrive.
+	InvoiceStateRefunded InvoiceState = "refunded"
+	// InvoiceStateCancelled marks invoices the user cancelled before they
+	// were paid. Payments to them are refused.
+	InvoiceStateCancelled InvoiceState = "cancelled"
+	// InvoiceStateExpired marks invoices that were handed out but not paid
+	// before their expiry. Payments to them are refused.
+	InvoiceStateExpired InvoiceState = "expired"
+	// InvoiceStateAccepted marks hold invoices whose payment arrived and
+	// waits for the user to settle or cancel it.
+	InvoiceStateAccepted InvoiceState = "accepted"
+	// InvoiceStatePendingUse marks invoices issued but not yet confirmed as
+	// delivered to the payer. They are released back to registered if the
+	// delivery fails, so their secrets aren't wasted.
+	InvoiceStatePendingUse InvoiceState = "pending"
+	// InvoiceStateAbandoned marks invoices whose incoming swap was given up
+	// on, since its htlc never arrived or expired long ago without being
+	// fulfilled. Payments to them are refused.
+	InvoiceStateAbandoned InvoiceState = "abandoned"
+	// InvoiceStateSuperseded marks secrets replaced by new ones before being
+	// handed out, since they were suspected compromised. Payments to them are
+	// refused.
+	InvoiceStateSuperseded InvoiceState = "superseded"
 )
 
+// invoiceTransitions lists the states each state can move to. Registered
+// invoices can be settled or refunded without being used, since the server
+// knows their payment hashes before they are handed out, and settled ones can
+// still be refunded if the fulfillment tx doesn't confirm in time. Pending use
+// invoices can go back to registered, the only way back. Abandoned invoices
+// can still be refunded once the swap server reclaims the htlc. Imported
+// invoices never change.
+var invoiceTransitions = map[InvoiceState][]InvoiceState{
+	InvoiceStateRegistered: {InvoiceStateUsed, InvoiceStatePendingUse, InvoiceStateSettled, InvoiceStateCancelled, InvoiceStateRefunded, InvoiceStateSuperseded},
+	InvoiceStatePendingUse: {InvoiceStateUsed, InvoiceStateRegistered, InvoiceStateSettled, InvoiceStateExpired, InvoiceStateCancelled, InvoiceStateRefunded},
+	InvoiceStateUsed:       {InvoiceStateSettled, InvoiceStateExpired, InvoiceStateCancelled, InvoiceStateAccepted, InvoiceStateRefunded, InvoiceStateAbandoned},
+	InvoiceStateAccepted:   {InvoiceStateSettled, InvoiceStateCancelled, InvoiceStateRefunded, InvoiceStateAbandoned},
+	InvoiceStateExpired:    {InvoiceStateRefunded, InvoiceStateAbandoned},
+	InvoiceStateAbandoned:  {InvoiceStateRefunded},
+	InvoiceStateSettled:    {InvoiceStateRefunded},
+}
+
+// CanTransitionTo returns whether an invoice in this state can move to the
+// given one. Staying in the same state is always allowed.
+func (s InvoiceState) CanTransitionTo(to InvoiceState) bool {
+	if s == to {
+		return true
+	}
+	for _, state := range invoiceTransitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPaid returns whether invoices in this state received their payment.
+func (s InvoiceState) IsPaid() bool {
+	return s == InvoiceStateSettled || s == InvoiceStateImported
+}
+
 // TODO: probably rename to InvoiceSecrets or similar
 type Invoice struct {
 	gorm.Model
-	Preimage      []byte
-	PaymentHash   []byte
-	PaymentSecret []byte
-	KeyPath       string
-	ShortChanId   uint64
-	AmountSat     int64
-	State         InvoiceState
-	UsedAt        *time.Time
+	Preimage        []byte
+	PaymentHash     []byte
+	PaymentSecret   []byte
+	KeyPath         string
+	ShortChanId     uint64
+	AmountSat       int64
+	FallbackAddress string
+	CltvExpiry      int64 // final cltv expiry delta of the issued invoice
+	FiatValue       float64
+	FiatCurrency    string // empty if the fiat value is unknown
+	Metadata        []byte // opaque app data, see InvoiceOptions
+	State           InvoiceState
+	UsedAt          *time.Time
+	ExpiresAt       *time.Time // nil for invoices issued before it was stored
+	Amp             bool       // payable in shards with their own hashes, see AmpShard
+	Hold            bool       // preimage withheld until the user settles the invoice
+	Network         string     // name of the network of the keys, empty if unknown
+	SettledAt       *time.Time // nil unless settled after it was stored
+	DisplayCurrency string     // currency the amount was shown in, not encoded in the invoice
+	Locale          string     // locale the amount was shown in, not encoded in the invoice
+
+	// value of the amount when the invoice was created, unlike FiatValue
+	CreationFiatAmount   float64
+	CreationFiatCurrency string     // empty if not given
+	RateTimestamp        *time.Time // time of the exchange rate used, nil if unknown
+
+	// largest payment above the overpayment tolerance the user accepted
+	OverpaymentAcceptedSat int64
+
+	// path of the stable node identity key the invoice uses, see NodeIdentity.
+	// Empty if the identity key is derived from KeyPath.
+	IdentityKeyPath string
+
+	Mac []byte // hmac of the secret columns, see OpenWithMacKey
+}
+
+// ErrInvalidTransition is returned when moving an invoice to a state it
+// can't reach from its current one, see InvoiceState.CanTransitionTo.
+var ErrInvalidTransition = errors.New("invalid invoice state transition")
+
+// TransitionTo moves the invoice to the given state, recording now as the
+// settlement time if it's being settled. It doesn't save the invoice.
+func (i *Invoice) TransitionTo(state InvoiceState, now time.Time) error {
+	if !i.State.CanTransitionTo(state) {
+		return fmt.Errorf("%w from %v to %v", ErrInvalidTransition, i.State, state)
+	}
+	if state == InvoiceStateSettled && i.State != InvoiceStateSettled {
+		i.SettledAt = &now
+	}
+	i.State = state
+	return nil
+}
+
+// ErrInvalidMac is returned when reading an invoice whose secret columns don't
+// match its mac, meaning the row was modified outside of libwallet or got
+// corrupted.
+var ErrInvalidMac = errors.New("invoice failed integrity check")
+
+// ErrInvalidKeyPath is returned when storing or reading a row whose key path
+// isn't one the wallet derives keys at, see hdpath.Validate.
+var ErrInvalidKeyPath = errors.New("unknown key path")
+
+// ErrDuplicatePaymentHash is returned when creating an invoice with the
+// payment hash of an existing one. Payment hashes are unique, so incoming
+// payments always match a single invoice.
+var ErrDuplicatePaymentHash = errors.New("an invoice with the same payment hash already exists")
+
+// JournalEntry is an error recorded in the error journal, kept to give
+// support a timeline of failures even when device logs are unavailable.
+type JournalEntry struct {
+	gorm.Model
+	Level     JournalLevel
+	Code      int64
+	Operation string
+	Message   string
+}
+
+// JournalLevel tells failures apart from expected outcomes in the journal.
+type JournalLevel string
+
+const (
+	// JournalLevelError is for operations that failed.
+	JournalLevelError JournalLevel = "error"
+	// JournalLevelInfo is for expected outcomes reported as errors, such as
+	// a payment waiting for the rest of its parts.
+	JournalLevelInfo JournalLevel = "info"
+)
+
+// HistoricalRate is the price of a bitcoin in a currency on a given day,
+// cached to avoid looking it up again.
+type HistoricalRate struct {
+	gorm.Model
+	Currency    string
+	Day         int64 // unix timestamp of the start of the day, in UTC
+	PricePerBtc float64
+}
+
+// PendingTransaction is a signed tx waiting to be broadcast, eg a fulfillment
+// built while offline.
+type PendingTransaction struct {
+	gorm.Model
+	TxId      string `gorm:"unique_index"`
+	RawTx     []byte
+	ExpiresAt time.Time // the tx is dropped if not broadcast by then
+}
+
+type TrackedTxState string
+
+const (
+	TrackedTxStateUnconfirmed TrackedTxState = "unconfirmed"
+	TrackedTxStateConfirmed   TrackedTxState = "confirmed"
+	// TrackedTxStateConflicted marks txs that will never confirm because a
+	// tx spending the same outputs did.
+	TrackedTxStateConflicted TrackedTxState = "conflicted"
+)
+
+// TrackedTransaction is a broadcast wallet tx that is re-announced until it
+// or a conflicting tx confirms.
+type TrackedTransaction struct {
+	gorm.Model
+	TxId            string `gorm:"unique_index"`
+	RawTx           []byte
+	State           TrackedTxState
+	Broadcasts      int64
+	LastBroadcastAt *time.Time
+}
+
+// AmpShard is a shard of an atomic multi-path payment to an amp invoice. Each
+// shard has its own payment hash, whose preimage can only be derived once
+// every shard of the set arrived.
+type AmpShard struct {
+	gorm.Model
+	PaymentHash        []byte `gorm:"unique_index"`
+	InvoicePaymentHash []byte // of the amp invoice paid
+	SetId              []byte `gorm:"index"`
+	ChildIndex         uint32
+	Share              []byte
+	AmountMsat         uint64
+	TotalMsat          uint64 // of the whole set
+}
+
+// MppPart is an htlc paying part of a multi-part payment to an invoice. The
+// preimage is only handed out once the parts received add up to the total.
+type MppPart struct {
+	gorm.Model
+	PaymentHash  []byte `gorm:"index"`
+	PartId       []byte `gorm:"unique_index"` // hash of the sphinx packet of the htlc
+	AmountMsat   uint64
+	TotalMsat    uint64 // of the whole payment
+	ExpiryHeight uint32 // of the htlc, 0 for parts recorded before it was
+}
+
+// RouteHintNodeList is a signed list of the nodes route hints may point to,
+// received from the server.
+type RouteHintNodeList struct {
+	gorm.Model
+	Version   int64  `gorm:"unique_index"`
+	Nodes     string // comma separated node pubkeys
+	Signature []byte
+}
+
+// SecurityCounter counts the occurrences of a security relevant event, such
+// as a payment that failed validation, to detect possible attacks.
+type SecurityCounter struct {
+	gorm.Model
+	Event string `gorm:"unique_index"`
+	Count int64
+}
+
+// NodeIdentity is the stable identity key invoices can share, so payers see
+// the same destination node across them. It's derived once and kept.
+type NodeIdentity struct {
+	gorm.Model
+	KeyPath string
+}
+
+// Setting is a wallet setting, stored as text and parsed by its accessors
+// in libwallet, so apps share one source of their defaults.
+type Setting struct {
+	gorm.Model
+	Key   string `gorm:"unique_index"`
+	Value string
 }
 
 type DB struct {
-	db *gorm.DB
+	db          *gorm.DB
+	macKey      []byte
+	slowQueries *slowQueryLog // nil unless RecordSlowQueries was called
 }
 
 func Open(path string) (*DB, error) {
-	db, err := gorm.Open("sqlite3", path)
+	return OpenWithMacKey(path, nil)
+}
+
+// OpenWithMacKey opens the db authenticating invoice rows with the given key.
+// Every invoice written gets a mac over its preimage, payment hash, payment
+// secret and key paths, which is checked whenever the invoice is read. Rows
+// written before a key was configured get their mac from a migration that
+// runs the first time the db is opened with one, see
+// fillInvoiceMacsMigration. From then on, rows without a mac are rejected
+// like rows with a wrong one. A nil key disables macs.
+func OpenWithMacKey(path string, macKey []byte) (*DB, error) {
+	db, err := Attach(path, macKey)
+	if err != nil {
+		return nil, err
+	}
+	err = db.RunMigrations(nil)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	err = migrate(db)
+	return db, nil
+}
+
+// Attach opens the db without running its migrations, which can take long
+// after big upgrades. Callers must check PendingMigrations and call
+// RunMigrations before using a db returned by Attach.
+func Attach(path string, macKey []byte) (*DB, error) {
+	db, err := gorm.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &DB{db: db, macKey: macKey}, nil
+}
+
+var migrations = []*gormigrate.Migration{
+	{
+		ID: "initial",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage      []byte
+				PaymentHash   []byte
+				PaymentSecret []byte
+				KeyPath       string
+				ShortChanId   uint64
+				State         string
+				UsedAt        *time.Time
+			}
+			// This guard exists because at some point migrations were run outside a
+			// transactional context and a user experimented problems with an invoices
+			// table that was already created but whose migration had not been properly
+			// recorded.
+			if !tx.HasTable(&Invoice{}) {
+				return tx.CreateTable(&Invoice{}).Error
+			}
+			return nil
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("invoices").Error
+		},
+	},
+	{
+		ID: "add amount to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage      []byte
+				PaymentHash   []byte
+				PaymentSecret []byte
+				KeyPath       string
+				ShortChanId   uint64
+				AmountSat     int64
+				State         string
+				UsedAt        *time.Time
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("AmountSat")).Error
+		},
+	},
+	{
+		ID: "add fallback address to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				State           string
+				UsedAt          *time.Time
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("FallbackAddress")).Error
+		},
+	},
+	{
+		ID: "create error journal table",
+		Migrate: func(tx *gorm.DB) error {
+			type JournalEntry struct {
+				gorm.Model
+				Code      int64
+				Operation string
+				Message   string
+			}
+			return tx.CreateTable(&JournalEntry{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("journal_entries").Error
+		},
+	},
+	{
+		ID: "add mac to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				State           string
+				UsedAt          *time.Time
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("Mac")).Error
+		},
+	},
+	{
+		ID: "add unique index on invoices payment hash",
+		Migrate: func(tx *gorm.DB) error {
+			// Secrets that were never handed out can be safely dropped if they
+			// share their payment hash with another invoice. Any other duplicate
+			// makes the migration fail, since we can't know which row is right.
+			res := tx.Exec(`
+				DELETE FROM invoices
+				WHERE state = ? AND payment_hash IN (
+					SELECT payment_hash FROM invoices GROUP BY payment_hash HAVING COUNT(*) > 1
+				)`,
+				InvoiceStateRegistered,
+			)
+			if res.Error != nil {
+				return res.Error
+			}
+			return tx.Table("invoices").AddUniqueIndex("idx_invoices_payment_hash", "payment_hash").Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").RemoveIndex("idx_invoices_payment_hash").Error
+		},
+	},
+	{
+		ID: "add cltv expiry to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				CltvExpiry      int64
+				State           string
+				UsedAt          *time.Time
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("CltvExpiry")).Error
+		},
+	},
+	{
+		ID: "add fiat value to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				CltvExpiry      int64
+				FiatValue       float64
+				FiatCurrency    string
+				State           string
+				UsedAt          *time.Time
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if err := tx.Table("invoices").DropColumn(gorm.ToColumnName("FiatValue")).Error; err != nil {
+				return err
+			}
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("FiatCurrency")).Error
+		},
+	},
+	{
+		ID: "create historical rates table",
+		Migrate: func(tx *gorm.DB) error {
+			type HistoricalRate struct {
+				gorm.Model
+				Currency    string
+				Day         int64
+				PricePerBtc float64
+			}
+			if err := tx.CreateTable(&HistoricalRate{}).Error; err != nil {
+				return err
+			}
+			return tx.Table("historical_rates").AddUniqueIndex("idx_historical_rates_currency_day", "currency", "day").Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("historical_rates").Error
+		},
+	},
+	{
+		ID: "add metadata to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				CltvExpiry      int64
+				FiatValue       float64
+				FiatCurrency    string
+				Metadata        []byte
+				State           string
+				UsedAt          *time.Time
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("Metadata")).Error
+		},
+	},
+	{
+		ID: "add expires at to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				CltvExpiry      int64
+				FiatValue       float64
+				FiatCurrency    string
+				Metadata        []byte
+				State           string
+				UsedAt          *time.Time
+				ExpiresAt       *time.Time
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("ExpiresAt")).Error
+		},
+	},
+	{
+		ID: "create pending transactions table",
+		Migrate: func(tx *gorm.DB) error {
+			type PendingTransaction struct {
+				gorm.Model
+				TxId      string `gorm:"unique_index"`
+				RawTx     []byte
+				ExpiresAt time.Time
+			}
+			return tx.CreateTable(&PendingTransaction{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("pending_transactions").Error
+		},
+	},
+	{
+		ID: "create tracked transactions table",
+		Migrate: func(tx *gorm.DB) error {
+			type TrackedTransaction struct {
+				gorm.Model
+				TxId            string `gorm:"unique_index"`
+				RawTx           []byte
+				State           string
+				Broadcasts      int64
+				LastBroadcastAt *time.Time
+			}
+			return tx.CreateTable(&TrackedTransaction{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("tracked_transactions").Error
+		},
+	},
+	{
+		ID: "add amp to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				CltvExpiry      int64
+				FiatValue       float64
+				FiatCurrency    string
+				Metadata        []byte
+				State           string
+				UsedAt          *time.Time
+				ExpiresAt       *time.Time
+				Amp             bool
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("Amp")).Error
+		},
+	},
+	{
+		ID: "create amp shards table",
+		Migrate: func(tx *gorm.DB) error {
+			type AmpShard struct {
+				gorm.Model
+				PaymentHash        []byte `gorm:"unique_index"`
+				InvoicePaymentHash []byte
+				SetId              []byte `gorm:"index"`
+				ChildIndex         uint32
+				Share              []byte
+				AmountMsat         uint64
+				TotalMsat          uint64
+			}
+			return tx.CreateTable(&AmpShard{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("amp_shards").Error
+		},
+	},
+	{
+		ID: "add hold to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				CltvExpiry      int64
+				FiatValue       float64
+				FiatCurrency    string
+				Metadata        []byte
+				State           string
+				UsedAt          *time.Time
+				ExpiresAt       *time.Time
+				Amp             bool
+				Hold            bool
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("Hold")).Error
+		},
+	},
+	{
+		ID: "create mpp parts table",
+		Migrate: func(tx *gorm.DB) error {
+			type MppPart struct {
+				gorm.Model
+				PaymentHash []byte `gorm:"index"`
+				PartId      []byte `gorm:"unique_index"`
+				AmountMsat  uint64
+				TotalMsat   uint64
+			}
+			return tx.CreateTable(&MppPart{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("mpp_parts").Error
+		},
+	},
+	{
+		ID: "add network to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				CltvExpiry      int64
+				FiatValue       float64
+				FiatCurrency    string
+				Metadata        []byte
+				State           string
+				UsedAt          *time.Time
+				ExpiresAt       *time.Time
+				Amp             bool
+				Hold            bool
+				Network         string
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("Network")).Error
+		},
+	},
+	{
+		ID: "create route hint node lists table",
+		Migrate: func(tx *gorm.DB) error {
+			type RouteHintNodeList struct {
+				gorm.Model
+				Version   int64 `gorm:"unique_index"`
+				Nodes     string
+				Signature []byte
+			}
+			return tx.CreateTable(&RouteHintNodeList{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("route_hint_node_lists").Error
+		},
+	},
+	{
+		ID: "create security counters table",
+		Migrate: func(tx *gorm.DB) error {
+			type SecurityCounter struct {
+				gorm.Model
+				Event string `gorm:"unique_index"`
+				Count int64
+			}
+			return tx.CreateTable(&SecurityCounter{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("security_counters").Error
+		},
+	},
+	{
+		ID: "add settled at to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				CltvExpiry      int64
+				FiatValue       float64
+				FiatCurrency    string
+				Metadata        []byte
+				State           string
+				UsedAt          *time.Time
+				ExpiresAt       *time.Time
+				Amp             bool
+				Hold            bool
+				Network         string
+				SettledAt       *time.Time
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("SettledAt")).Error
+		},
+	},
+	{
+		ID: "add localization to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				ShortChanId     uint64
+				AmountSat       int64
+				FallbackAddress string
+				CltvExpiry      int64
+				FiatValue       float64
+				FiatCurrency    string
+				Metadata        []byte
+				State           string
+				UsedAt          *time.Time
+				ExpiresAt       *time.Time
+				Amp             bool
+				Hold            bool
+				Network         string
+				SettledAt       *time.Time
+				DisplayCurrency string
+				Locale          string
+				Mac             []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if err := tx.Table("invoices").DropColumn(gorm.ToColumnName("DisplayCurrency")).Error; err != nil {
+				return err
+			}
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("Locale")).Error
+		},
+	},
+	{
+		ID: "add creation fiat amount to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage             []byte
+				PaymentHash          []byte
+				PaymentSecret        []byte
+				KeyPath              string
+				ShortChanId          uint64
+				AmountSat            int64
+				FallbackAddress      string
+				CltvExpiry           int64
+				FiatValue            float64
+				FiatCurrency         string
+				Metadata             []byte
+				State                string
+				UsedAt               *time.Time
+				ExpiresAt            *time.Time
+				Amp                  bool
+				Hold                 bool
+				Network              string
+				SettledAt            *time.Time
+				DisplayCurrency      string
+				Locale               string
+				CreationFiatAmount   float64
+				CreationFiatCurrency string
+				RateTimestamp        *time.Time
+				Mac                  []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			for _, column := range []string{"CreationFiatAmount", "CreationFiatCurrency", "RateTimestamp"} {
+				if err := tx.Table("invoices").DropColumn(gorm.ToColumnName(column)).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		ID: "add overpayment accepted to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage               []byte
+				PaymentHash            []byte
+				PaymentSecret          []byte
+				KeyPath                string
+				ShortChanId            uint64
+				AmountSat              int64
+				FallbackAddress        string
+				CltvExpiry             int64
+				FiatValue              float64
+				FiatCurrency           string
+				Metadata               []byte
+				State                  string
+				UsedAt                 *time.Time
+				ExpiresAt              *time.Time
+				Amp                    bool
+				Hold                   bool
+				Network                string
+				SettledAt              *time.Time
+				DisplayCurrency        string
+				Locale                 string
+				CreationFiatAmount     float64
+				CreationFiatCurrency   string
+				RateTimestamp          *time.Time
+				OverpaymentAcceptedSat int64
+				Mac                    []byte
+			}
+			return tx.AutoMigrate(&Invoice{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("OverpaymentAcceptedSat")).Error
+		},
+	},
+	{
+		ID: "add node identity to invoices table",
+		Migrate: func(tx *gorm.DB) error {
+			type Invoice struct {
+				gorm.Model
+				Preimage               []byte
+				PaymentHash            []byte
+				PaymentSecret          []byte
+				KeyPath                string
+				ShortChanId            uint64
+				AmountSat              int64
+				FallbackAddress        string
+				CltvExpiry             int64
+				FiatValue              float64
+				FiatCurrency           string
+				Metadata               []byte
+				State                  string
+				UsedAt                 *time.Time
+				ExpiresAt              *time.Time
+				Amp                    bool
+				Hold                   bool
+				Network                string
+				SettledAt              *time.Time
+				DisplayCurrency        string
+				Locale                 string
+				CreationFiatAmount     float64
+				CreationFiatCurrency   string
+				RateTimestamp          *time.Time
+				OverpaymentAcceptedSat int64
+				IdentityKeyPath        string
+				Mac                    []byte
+			}
+			type NodeIdentity struct {
+				gorm.Model
+				KeyPath string
+			}
+			if err := tx.AutoMigrate(&Invoice{}).Error; err != nil {
+				return err
+			}
+			return tx.CreateTable(&NodeIdentity{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if err := tx.DropTable("node_identities").Error; err != nil {
+				return err
+			}
+			return tx.Table("invoices").DropColumn(gorm.ToColumnName("IdentityKeyPath")).Error
+		},
+	},
+	{
+		ID: "create settings table",
+		Migrate: func(tx *gorm.DB) error {
+			type Setting struct {
+				gorm.Model
+				Key   string `gorm:"unique_index"`
+				Value string
+			}
+			return tx.CreateTable(&Setting{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("settings").Error
+		},
+	},
+	{
+		ID: "create operation log table",
+		Migrate: func(tx *gorm.DB) error {
+			type OperationLogEntry struct {
+				gorm.Model
+				Sequence    int64 `gorm:"unique_index"`
+				Operation   string
+				PaymentHash []byte
+				Head        []byte
+			}
+			return tx.CreateTable(&OperationLogEntry{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.DropTable("operation_log_entries").Error
+		},
+	},
+	{
+		ID: "add expiry height to mpp parts table",
+		Migrate: func(tx *gorm.DB) error {
+			type MppPart struct {
+				gorm.Model
+				PaymentHash  []byte `gorm:"index"`
+				PartId       []byte `gorm:"unique_index"`
+				AmountMsat   uint64
+				TotalMsat    uint64
+				ExpiryHeight uint32
+			}
+			return tx.AutoMigrate(&MppPart{}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("mpp_parts").DropColumn(gorm.ToColumnName("ExpiryHeight")).Error
+		},
+	},
+	{
+		ID: "add level to journal entries table",
+		Migrate: func(tx *gorm.DB) error {
+			type JournalEntry struct {
+				gorm.Model
+				Level     string
+				Code      int64
+				Operation string
+				Message   string
+			}
+			if err := tx.AutoMigrate(&JournalEntry{}).Error; err != nil {
+				return err
+			}
+			// entries recorded so far were all considered errors
+			return tx.Exec("UPDATE journal_entries SET level = ?", JournalLevelError).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Table("journal_entries").DropColumn(gorm.ToColumnName("Level")).Error
+		},
+	},
 }
 
-func migrate(db *gorm.DB) error {
+// RunMigrations applies the pending migrations one at a time, calling
+// progress (if not nil) after each one with the number of migrations applied
+// so far and the total.
+func (d *DB) RunMigrations(progress func(applied, total int)) error {
 	opts := gormigrate.Options{
 		UseTransaction: true,
 	}
-	m := gormigrate.New(db, &opts, []*gormigrate.Migration{
-		{
-			ID: "initial",
-			Migrate: func(tx *gorm.DB) error {
-				type Invoice struct {
-					gorm.Model
-					Preimage      []byte
-					PaymentHash   []byte
-					PaymentSecret []byte
-					KeyPath       string
-					ShortChanId   uint64
-					State         string
-					UsedAt        *time.Time
-				}
-				// This guard exists because at some point migrations were run outside a
-				// transactional context and a user experimented problems with an invoices
-				// table that was already created but whose migration had not been properly
-				// recorded.
-				if !tx.HasTable(&Invoice{}) {
-					return tx.CreateTable(&Invoice{}).Error
-				}
-				return nil
-			},
-			Rollback: func(tx *gorm.DB) error {
-				return tx.DropTable("invoices").Error
-			},
-		},
-		{
-			ID: "add amount to invoices table",
-			Migrate: func(tx *gorm.DB) error {
-				type Invoice struct {
-					gorm.Model
-					Preimage      []byte
-					PaymentHash   []byte
-					PaymentSecret []byte
-					KeyPath       string
-					ShortChanId   uint64
-					AmountSat     int64
-					State         string
-					UsedAt        *time.Time
+	all := d.migrations()
+	m := gormigrate.New(d.db, &opts, all)
+	for i, migration := range all {
+		if err := m.MigrateTo(migration.ID); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(i+1, len(all))
+		}
+	}
+	return nil
+}
+
+// migrations returns the migrations of the db. With a mac key, the last one
+// fills in the macs of the invoices written before it was configured.
+func (d *DB) migrations() []*gormigrate.Migration {
+	if d.macKey == nil {
+		return migrations
+	}
+	all := make([]*gormigrate.Migration, len(migrations), len(migrations)+1)
+	copy(all, migrations)
+	return append(all, d.fillInvoiceMacsMigration())
+}
+
+// fillInvoiceMacsMigrationID is the id of fillInvoiceMacsMigration, which
+// only some dbs have applied.
+const fillInvoiceMacsMigrationID = "fill in invoice macs"
+
+// fillInvoiceMacsMigration returns the migration computing the macs of the
+// invoices without one. It runs once, the first time the db is opened with a
+// mac key, and from then on invoices without a mac are rejected. It always
+// runs after the other migrations, so the invoices table is up to date.
+func (d *DB) fillInvoiceMacsMigration() *gormigrate.Migration {
+	return &gormigrate.Migration{
+		ID: fillInvoiceMacsMigrationID,
+		Migrate: func(tx *gorm.DB) error {
+			var rows []struct {
+				ID              uint
+				Preimage        []byte
+				PaymentHash     []byte
+				PaymentSecret   []byte
+				KeyPath         string
+				IdentityKeyPath string
+			}
+			res := tx.Table("invoices").
+				Select("id, preimage, payment_hash, payment_secret, key_path, identity_key_path").
+				Where("mac IS NULL OR length(mac) = 0").
+				Scan(&rows)
+			if res.Error != nil {
+				return res.Error
+			}
+			for _, row := range rows {
+				mac := d.invoiceMac(&Invoice{
+					Preimage:        row.Preimage,
+					PaymentHash:     row.PaymentHash,
+					PaymentSecret:   row.PaymentSecret,
+					KeyPath:         row.KeyPath,
+					IdentityKeyPath: row.IdentityKeyPath,
+				})
+				res := tx.Table("invoices").Where("id = ?", row.ID).UpdateColumn("mac", mac)
+				if res.Error != nil {
+					return res.Error
 				}
-				return tx.AutoMigrate(&Invoice{}).Error
-			},
-			Rollback: func(tx *gorm.DB) error {
-				return tx.Table("invoices").DropColumn(gorm.ToColumnName("AmountSat")).Error
-			},
+			}
+			return nil
 		},
-	})
-	return m.Migrate()
+		Rollback: func(tx *gorm.DB) error {
+			return nil
+		},
+	}
+}
+
+// MigrationStatus returns the number of migrations recorded as applied in the
+// db and the number of migrations known to this version.
+func (d *DB) MigrationStatus() (applied int, total int, err error) {
+	table := gormigrate.DefaultOptions.TableName
+	total = len(d.migrations())
+	if !d.db.HasTable(table) {
+		return 0, total, nil
+	}
+	query := d.db.Table(table)
+	if d.macKey == nil {
+		query = query.Where("id <> ?", fillInvoiceMacsMigrationID)
+	}
+	if res := query.Count(&applied); res.Error != nil {
+		return 0, 0, res.Error
+	}
+	return applied, total, nil
+}
+
+// PendingMigrations reports whether some migrations still need to run.
+func (d *DB) PendingMigrations() (bool, error) {
+	applied, total, err := d.MigrationStatus()
+	if err != nil {
+		return false, err
+	}
+	return applied < total, nil
 }
 
 func (d *DB) CreateInvoice(invoice *Invoice) error {
+	if err := validateInvoiceKeyPaths(invoice); err != nil {
+		return err
+	}
 	// uint64 values with high bit set are not supported, we will
 	// have to convert back and forth
 	invoice.ShortChanId = invoice.ShortChanId & 0x7FFFFFFFFFFFFFFF
-	res := d.db.Create(invoice)
+	d.signInvoice(invoice)
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(invoice).Error; err != nil {
+			return err
+		}
+		return appendOperation(tx, OperationCreateInvoice, invoice)
+	})
 	invoice.ShortChanId = invoice.ShortChanId | (1 << 63)
-	return res.Error
+	if isUniqueConstraintError(err) {
+		return ErrDuplicatePaymentHash
+	}
+	return err
 }
 
 func (d *DB) SaveInvoice(invoice *Invoice) error {
+	if err := validateInvoiceKeyPaths(invoice); err != nil {
+		return err
+	}
 	// uint64 values with high bit set are not supported, we will
 	// have to convert back and forth
 	invoice.ShortChanId = invoice.ShortChanId & 0x7FFFFFFFFFFFFFFF
-	res := d.db.Save(invoice)
+	d.signInvoice(invoice)
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(invoice).Error; err != nil {
+			return err
+		}
+		return appendOperation(tx, OperationSaveInvoice, invoice)
+	})
 	invoice.ShortChanId = invoice.ShortChanId | (1 << 63)
-	return res.Error
+	return err
 }
 
+// InvoiceOrder defines which unused invoice is picked when creating a new one.
+type InvoiceOrder string
+
+const (
+	// InvoiceOrderFIFO picks the oldest registered invoice first.
+	InvoiceOrderFIFO InvoiceOrder = "fifo"
+	// InvoiceOrderLIFO picks the newest registered invoice first.
+	InvoiceOrderLIFO InvoiceOrder = "lifo"
+	// InvoiceOrderRandom picks any registered invoice.
+	InvoiceOrderRandom InvoiceOrder = "random"
+)
+
+// FindFirstUnusedInvoice returns the oldest registered invoice, or nil if
+// there are none.
 func (d *DB) FindFirstUnusedInvoice() (*Invoice, error) {
+	return d.FindUnusedInvoice(InvoiceOrderFIFO)
+}
+
+// FindUnusedInvoice returns a registered invoice picked according to order,
+// or nil if there are none.
+func (d *DB) FindUnusedInvoice(order InvoiceOrder) (*Invoice, error) {
+	var orderBy string
+	switch order {
+	case InvoiceOrderFIFO:
+		orderBy = "id asc"
+	case InvoiceOrderLIFO:
+		orderBy = "id desc"
+	case InvoiceOrderRandom:
+		orderBy = "random()"
+	default:
+		return nil, fmt.Errorf("unknown invoice order %q", order)
+	}
+
 	var invoice Invoice
-	if res := d.db.Where(&Invoice{State: InvoiceStateRegistered}).First(&invoice); res.Error != nil {
+	res := d.db.Where(&Invoice{State: InvoiceStateRegistered}).Order(orderBy).Limit(1).Find(&invoice)
+	if res.Error != nil {
 
 		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -129,6 +1198,9 @@ func (d *DB) FindFirstUnusedInvoice() (*Invoice, error) {
 
 		return nil, res.Error
 	}
+	if err := d.verifyInvoice(&invoice); err != nil {
+		return nil, err
+	}
 	invoice.ShortChanId = invoice.ShortChanId | (1 << 63)
 	return &invoice, nil
 }
@@ -146,11 +1218,471 @@ func (d *DB) FindByPaymentHash(hash []byte) (*Invoice, error) {
 	if res := d.db.Where(&Invoice{PaymentHash: hash}).First(&invoice); res.Error != nil {
 		return nil, res.Error
 	}
+	if err := d.verifyInvoice(&invoice); err != nil {
+		return nil, err
+	}
 	invoice.ShortChanId = invoice.ShortChanId | (1 << 63)
 	return &invoice, nil
 }
 
+// HasInvoice returns whether there's an invoice with the payment hash.
+func (d *DB) HasInvoice(hash []byte) (bool, error) {
+	var count int
+	if res := d.db.Model(&Invoice{}).Where(&Invoice{PaymentHash: hash}).Count(&count); res.Error != nil {
+		return false, res.Error
+	}
+	return count > 0, nil
+}
+
+// ListInvoices returns every invoice stored in the db, in insertion order.
+func (d *DB) ListInvoices() ([]Invoice, error) {
+	var invoices []Invoice
+	if res := d.db.Order("id asc").Find(&invoices); res.Error != nil {
+		return nil, res.Error
+	}
+	for i := range invoices {
+		if err := d.verifyInvoice(&invoices[i]); err != nil {
+			return nil, err
+		}
+		invoices[i].ShortChanId = invoices[i].ShortChanId | (1 << 63)
+	}
+	return invoices, nil
+}
+
+// FindInvoices returns up to limit invoices in the given state, or in any
+// state if empty, skipping the first offset ones. The most recent come first.
+func (d *DB) FindInvoices(state InvoiceState, offset, limit int) ([]Invoice, error) {
+	var invoices []Invoice
+	res := d.db.Where(&Invoice{State: state}).Order("id desc").Offset(offset).Limit(limit).Find(&invoices)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	for i := range invoices {
+		if err := d.verifyInvoice(&invoices[i]); err != nil {
+			return nil, err
+		}
+		invoices[i].ShortChanId = invoices[i].ShortChanId | (1 << 63)
+	}
+	return invoices, nil
+}
+
+// CountInvoices returns the number of invoices in any of the given states.
+func (d *DB) CountInvoices(states ...InvoiceState) (int, error) {
+	var count int
+	if res := d.db.Model(&Invoice{}).Where("state in (?)", states).Count(&count); res.Error != nil {
+		return 0, res.Error
+	}
+	return count, nil
+}
+
+// FindLastUsedInvoice returns the handed out invoice updated most recently,
+// or nil if there are none.
+func (d *DB) FindLastUsedInvoice() (*Invoice, error) {
+	var invoices []Invoice
+	res := d.db.Where("used_at is not null").Order("updated_at desc, id desc").Limit(1).Find(&invoices)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if len(invoices) == 0 {
+		return nil, nil
+	}
+	invoice := &invoices[0]
+	if err := d.verifyInvoice(invoice); err != nil {
+		return nil, err
+	}
+	invoice.ShortChanId = invoice.ShortChanId | (1 << 63)
+	return invoice, nil
+}
+
+// FindAmpInvoices returns the amp invoices handed out and not yet expired or
+// cancelled, which can still receive payments.
+func (d *DB) FindAmpInvoices() ([]Invoice, error) {
+	var invoices []Invoice
+	res := d.db.Where(&Invoice{State: InvoiceStateUsed, Amp: true}).Order("id asc").Find(&invoices)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	for i := range invoices {
+		if err := d.verifyInvoice(&invoices[i]); err != nil {
+			return nil, err
+		}
+		invoices[i].ShortChanId = invoices[i].ShortChanId | (1 << 63)
+	}
+	return invoices, nil
+}
+
+// Transaction runs fn with a DB whose operations are committed only if fn
+// returns nil.
+func (d *DB) Transaction(fn func(tx *DB) error) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&DB{db: tx, macKey: d.macKey})
+	})
+}
+
+func isUniqueConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// validateInvoiceKeyPaths checks the key paths of the invoice are wallet
+// paths. Only imported invoices, which can't receive payments, have none.
+func validateInvoiceKeyPaths(invoice *Invoice) error {
+	if invoice.KeyPath == "" {
+		if invoice.State == InvoiceStateImported {
+			return nil
+		}
+		return fmt.Errorf("%w: empty key path", ErrInvalidKeyPath)
+	}
+	if err := hdpath.Validate(invoice.KeyPath); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKeyPath, err)
+	}
+	if invoice.IdentityKeyPath == "" {
+		return nil
+	}
+	if err := hdpath.Validate(invoice.IdentityKeyPath); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKeyPath, err)
+	}
+	return nil
+}
+
+func (d *DB) invoiceMac(invoice *Invoice) []byte {
+	mac := hmac.New(sha256.New, d.macKey)
+	fields := [][]byte{
+		invoice.Preimage,
+		invoice.PaymentHash,
+		invoice.PaymentSecret,
+		[]byte(invoice.KeyPath),
+	}
+	// left out when empty, so macs of invoices from before it still match
+	if invoice.IdentityKeyPath != "" {
+		fields = append(fields, []byte(invoice.IdentityKeyPath))
+	}
+	for _, field := range fields {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+		mac.Write(length[:])
+		mac.Write(field)
+	}
+	return mac.Sum(nil)
+}
+
+func (d *DB) signInvoice(invoice *Invoice) {
+	if d.macKey == nil {
+		return
+	}
+	invoice.Mac = d.invoiceMac(invoice)
+}
+
+func (d *DB) verifyInvoice(invoice *Invoice) error {
+	if err := validateInvoiceKeyPaths(invoice); err != nil {
+		return err
+	}
+	if d.macKey == nil {
+		return nil
+	}
+	// rows without one got it when the key was configured, see
+	// fillInvoiceMacsMigration, so a missing mac was removed
+	if len(invoice.Mac) == 0 || !hmac.Equal(invoice.Mac, d.invoiceMac(invoice)) {
+		return ErrInvalidMac
+	}
+	return nil
+}
+
+// AppendJournalEntry records a new entry in the error journal, discarding the
+// oldest entries so that at most maxEntries are kept.
+func (d *DB) AppendJournalEntry(entry *JournalEntry, maxEntries int) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+
+		var oldest []JournalEntry
+		res := tx.Order("id desc").Offset(maxEntries).Limit(1).Find(&oldest)
+		if res.Error != nil {
+			return res.Error
+		}
+		if len(oldest) == 0 {
+			return nil
+		}
+
+		return tx.Unscoped().Where("id <= ?", oldest[0].ID).Delete(&JournalEntry{}).Error
+	})
+}
+
+// RecentJournalEntries returns up to limit entries of the error journal, the
+// most recent first.
+func (d *DB) RecentJournalEntries(limit int) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	if res := d.db.Order("id desc").Limit(limit).Find(&entries); res.Error != nil {
+		return nil, res.Error
+	}
+	return entries, nil
+}
+
+// FindHistoricalRate returns the cached rate of the currency for the day, or
+// nil if there's none.
+func (d *DB) FindHistoricalRate(currency string, day int64) (*HistoricalRate, error) {
+	var rates []HistoricalRate
+	res := d.db.Where(&HistoricalRate{Currency: currency, Day: day}).Limit(1).Find(&rates)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if len(rates) == 0 {
+		return nil, nil
+	}
+	return &rates[0], nil
+}
+
+// SaveHistoricalRate caches the rate of a currency for a day.
+func (d *DB) SaveHistoricalRate(rate *HistoricalRate) error {
+	return d.db.Create(rate).Error
+}
+
+// SavePendingTransaction stores a tx to broadcast. Storing a tx that's already
+// pending updates its expiry.
+func (d *DB) SavePendingTransaction(tx *PendingTransaction) error {
+	var existing PendingTransaction
+	res := d.db.Where(&PendingTransaction{TxId: tx.TxId}).First(&existing)
+	if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return res.Error
+	}
+	if res.Error == nil {
+		tx.ID = existing.ID
+		tx.CreatedAt = existing.CreatedAt
+	}
+	return d.db.Save(tx).Error
+}
+
+// ListPendingTransactions returns the txs waiting to be broadcast, oldest
+// first.
+func (d *DB) ListPendingTransactions() ([]PendingTransaction, error) {
+	var txs []PendingTransaction
+	if res := d.db.Order("id asc").Find(&txs); res.Error != nil {
+		return nil, res.Error
+	}
+	return txs, nil
+}
+
+// DeletePendingTransaction removes a tx from the ones waiting to be broadcast.
+func (d *DB) DeletePendingTransaction(tx *PendingTransaction) error {
+	return d.db.Unscoped().Delete(tx).Error
+}
+
+// FindTrackedTransaction returns the tracked tx with the given hash, or nil if
+// it's not tracked.
+func (d *DB) FindTrackedTransaction(txId string) (*TrackedTransaction, error) {
+	var txs []TrackedTransaction
+	res := d.db.Where(&TrackedTransaction{TxId: txId}).Limit(1).Find(&txs)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if len(txs) == 0 {
+		return nil, nil
+	}
+	return &txs[0], nil
+}
+
+// SaveTrackedTransaction stores a tracked tx, creating it if it's new.
+func (d *DB) SaveTrackedTransaction(tx *TrackedTransaction) error {
+	return d.db.Save(tx).Error
+}
+
+// ListTrackedTransactions returns every tracked tx, oldest first.
+func (d *DB) ListTrackedTransactions() ([]TrackedTransaction, error) {
+	var txs []TrackedTransaction
+	if res := d.db.Order("id asc").Find(&txs); res.Error != nil {
+		return nil, res.Error
+	}
+	return txs, nil
+}
+
+// SaveAmpShard stores a shard of an amp payment. Saving a shard twice is a
+// no-op.
+func (d *DB) SaveAmpShard(shard *AmpShard) error {
+	res := d.db.Create(shard)
+	if isUniqueConstraintError(res.Error) {
+		return nil
+	}
+	return res.Error
+}
+
+// ListAmpShards returns the shards of the amp payment set received so far,
+// oldest first.
+func (d *DB) ListAmpShards(setId []byte) ([]AmpShard, error) {
+	var shards []AmpShard
+	if res := d.db.Where(&AmpShard{SetId: setId}).Order("id asc").Find(&shards); res.Error != nil {
+		return nil, res.Error
+	}
+	return shards, nil
+}
+
+// ListInvoiceAmpShards returns the shards received for the amp invoice with
+// the given payment hash, of every set, oldest first.
+func (d *DB) ListInvoiceAmpShards(invoicePaymentHash []byte) ([]AmpShard, error) {
+	var shards []AmpShard
+	res := d.db.Where("invoice_payment_hash = ?", invoicePaymentHash).Order("id asc").Find(&shards)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return shards, nil
+}
+
+// SaveMppPart stores a part of a multi-part payment. Saving a part twice is a
+// no-op.
+func (d *DB) SaveMppPart(part *MppPart) error {
+	res := d.db.Create(part)
+	if isUniqueConstraintError(res.Error) {
+		return nil
+	}
+	return res.Error
+}
+
+// ListMppParts returns the parts of the multi-part payment for the payment
+// hash received so far, oldest first.
+func (d *DB) ListMppParts(paymentHash []byte) ([]MppPart, error) {
+	var parts []MppPart
+	if res := d.db.Where(&MppPart{PaymentHash: paymentHash}).Order("id asc").Find(&parts); res.Error != nil {
+		return nil, res.Error
+	}
+	return parts, nil
+}
+
+// FindStaleMppPayments returns the payment hashes of the multi-part payments
+// whose last part was received before the given time.
+func (d *DB) FindStaleMppPayments(before time.Time) ([][]byte, error) {
+	var hashes [][]byte
+	res := d.db.Model(&MppPart{}).
+		Group("payment_hash").
+		Having("MAX(created_at) < ?", before).
+		Pluck("payment_hash", &hashes)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return hashes, nil
+}
+
+// DeleteMppParts deletes the parts of the multi-part payment for the payment
+// hash and returns how many there were.
+func (d *DB) DeleteMppParts(paymentHash []byte) (int64, error) {
+	if len(paymentHash) == 0 {
+		return 0, nil
+	}
+	res := d.db.Unscoped().Where(&MppPart{PaymentHash: paymentHash}).Delete(&MppPart{})
+	return res.RowsAffected, res.Error
+}
+
+// FindStaleAmpSets returns the set ids of the amp payments whose last shard
+// was received before the given time.
+func (d *DB) FindStaleAmpSets(before time.Time) ([][]byte, error) {
+	var setIds [][]byte
+	res := d.db.Model(&AmpShard{}).
+		Group("set_id").
+		Having("MAX(created_at) < ?", before).
+		Pluck("set_id", &setIds)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return setIds, nil
+}
+
+// DeleteAmpShards deletes the shards of the amp payment set and returns how
+// many there were.
+func (d *DB) DeleteAmpShards(setId []byte) (int64, error) {
+	if len(setId) == 0 {
+		return 0, nil
+	}
+	res := d.db.Unscoped().Where(&AmpShard{SetId: setId}).Delete(&AmpShard{})
+	return res.RowsAffected, res.Error
+}
+
+// SaveRouteHintNodeList stores a list of route hint nodes.
+func (d *DB) SaveRouteHintNodeList(list *RouteHintNodeList) error {
+	return d.db.Save(list).Error
+}
+
+// FindLatestRouteHintNodeList returns the route hint node list with the
+// highest version, or nil if there are none.
+func (d *DB) FindLatestRouteHintNodeList() (*RouteHintNodeList, error) {
+	var lists []RouteHintNodeList
+	if res := d.db.Order("version desc").Limit(1).Find(&lists); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(lists) == 0 {
+		return nil, nil
+	}
+	return &lists[0], nil
+}
+
+// IncrementSecurityCounter adds one to the counter of the event, creating it
+// if needed.
+func (d *DB) IncrementSecurityCounter(event string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var counter SecurityCounter
+		if err := tx.Where(SecurityCounter{Event: event}).FirstOrInit(&counter).Error; err != nil {
+			return err
+		}
+		counter.Count++
+		return tx.Save(&counter).Error
+	})
+}
+
+// ListSecurityCounters returns the counters of every event that occurred at
+// least once, sorted by event.
+func (d *DB) ListSecurityCounters() ([]SecurityCounter, error) {
+	var counters []SecurityCounter
+	if res := d.db.Order("event asc").Find(&counters); res.Error != nil {
+		return nil, res.Error
+	}
+	return counters, nil
+}
+
+// FindNodeIdentity returns the stable node identity, or nil if none was
+// saved yet.
+func (d *DB) FindNodeIdentity() (*NodeIdentity, error) {
+	var identities []NodeIdentity
+	if res := d.db.Order("id asc").Limit(1).Find(&identities); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(identities) == 0 {
+		return nil, nil
+	}
+	return &identities[0], nil
+}
+
+// SaveNodeIdentity stores the stable node identity.
+func (d *DB) SaveNodeIdentity(identity *NodeIdentity) error {
+	if err := hdpath.Validate(identity.KeyPath); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKeyPath, err)
+	}
+	return d.db.Save(identity).Error
+}
+
+// FindSetting returns the value of the setting, and whether it was set.
+func (d *DB) FindSetting(key string) (string, bool, error) {
+	var settings []Setting
+	if res := d.db.Where(&Setting{Key: key}).Limit(1).Find(&settings); res.Error != nil {
+		return "", false, res.Error
+	}
+	if len(settings) == 0 {
+		return "", false, nil
+	}
+	return settings[0].Value, true, nil
+}
+
+// SaveSetting sets the value of the setting, creating it if needed.
+func (d *DB) SaveSetting(key, value string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var setting Setting
+		if err := tx.Where(Setting{Key: key}).FirstOrInit(&setting).Error; err != nil {
+			return err
+		}
+		setting.Value = value
+		return tx.Save(&setting).Error
+	})
+}
+
 func (d *DB) Close() {
+	d.flushSlowQueries()
 	err := d.db.Close()
 	if err != nil {
 		log.Printf("error closing the db: %v", err)
@@ -3,10 +3,14 @@ package walletdb
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"path"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestOpen(t *testing.T) {
@@ -41,7 +45,7 @@ func TestInvoices(t *testing.T) {
 		Preimage:      randomBytes(32),
 		PaymentHash:   paymentHash,
 		PaymentSecret: randomBytes(32),
-		KeyPath:       "34/56",
+		KeyPath:       "m/schema:1'/recovery:1'/invoices:4/34/56",
 		ShortChanId:   shortChanId,
 		State:         InvoiceStateRegistered,
 	})
@@ -85,6 +89,108 @@ func TestInvoices(t *testing.T) {
 	}
 }
 
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := Open(path.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const maxEntries = 3
+	for i := 0; i < 5; i++ {
+		err = db.AppendJournalEntry(&JournalEntry{
+			Code:      int64(i),
+			Operation: "test",
+			Message:   "failed",
+		}, maxEntries)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := db.RecentJournalEntries(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != maxEntries {
+		t.Fatalf("expected %v entries, got %v", maxEntries, len(entries))
+	}
+	if entries[0].Code != 4 || entries[maxEntries-1].Code != 2 {
+		t.Fatal("expected journal to keep the most recent entries, newest first")
+	}
+
+	entries, err = db.RecentJournalEntries(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", len(entries))
+	}
+}
+
+func TestRecordSlowQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+	dbPath := path.Join(dir, "test.db")
+
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every query is slow
+	db.RecordSlowQueries(0, 10)
+
+	preimage := randomBytes(32)
+	invoice := &Invoice{
+		Preimage:    preimage,
+		PaymentHash: randomBytes(32),
+		KeyPath:     "m/schema:1'/recovery:1'/invoices:4/1/2",
+		State:       InvoiceStateRegistered,
+	}
+	if err := db.CreateInvoice(invoice); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.FindByPaymentHash(invoice.PaymentHash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db, err = Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	entries, err := db.RecentJournalEntries(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lookups int
+	for _, entry := range entries {
+		if entry.Operation != SlowQueryOperation {
+			t.Fatalf("unexpected journal entry %v", entry.Operation)
+		}
+		if strings.Contains(entry.Message, string(preimage)) || strings.Contains(entry.Message, fmt.Sprintf("%x", preimage)) {
+			t.Fatal("expected query values not to be recorded")
+		}
+		if strings.Contains(entry.Message, "(3 times") {
+			lookups++
+		}
+	}
+	if len(entries) == 0 || lookups != 1 {
+		t.Fatalf("expected repeated lookups to be recorded once, got %v", entries)
+	}
+}
+
 func randomBytes(count int) []byte {
 	buf := make([]byte, count)
 	_, err := rand.Read(buf)
@@ -93,3 +199,319 @@ func randomBytes(count int) []byte {
 	}
 	return buf
 }
+
+func TestInvoiceMacs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+	dbPath := path.Join(dir, "test.db")
+
+	// rows written before enabling macs get one when the key is set
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyHash := randomBytes(32)
+	err = db.CreateInvoice(&Invoice{
+		Preimage:    randomBytes(32),
+		PaymentHash: legacyHash,
+		KeyPath:     "m/schema:1'/recovery:1'/invoices:4/34/56",
+		State:       InvoiceStateRegistered,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = OpenWithMacKey(dbPath, randomBytes(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	legacy, err := db.FindByPaymentHash(legacyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(legacy.Mac) == 0 {
+		t.Fatal("expected legacy invoice to have a mac")
+	}
+
+	paymentHash := randomBytes(32)
+	err = db.CreateInvoice(&Invoice{
+		Preimage:    randomBytes(32),
+		PaymentHash: paymentHash,
+		KeyPath:     "m/schema:1'/recovery:1'/invoices:4/34/57",
+		State:       InvoiceStateRegistered,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inv, err := db.FindByPaymentHash(paymentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Mac) == 0 {
+		t.Fatal("expected invoice to have a mac")
+	}
+
+	// tamper with the key path behind the db's back
+	res := db.db.Model(&Invoice{}).Where("id = ?", inv.ID).Update("key_path", "m/schema:1'/recovery:1'/invoices:4/34/58")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	if _, err := db.FindByPaymentHash(paymentHash); err != ErrInvalidMac {
+		t.Fatalf("expected ErrInvalidMac, got %v", err)
+	}
+	if _, err := db.ListInvoices(); err != ErrInvalidMac {
+		t.Fatalf("expected ErrInvalidMac, got %v", err)
+	}
+
+	// a row without a mac is rejected once the key is set
+	res = db.db.Model(&Invoice{}).Where("id = ?", legacy.ID).Update("mac", []byte{})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if _, err := db.FindByPaymentHash(legacyHash); err != ErrInvalidMac {
+		t.Fatalf("expected ErrInvalidMac, got %v", err)
+	}
+	res = db.db.Model(&Invoice{}).Where("id = ?", legacy.ID).Update("mac", legacy.Mac)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// a db opened with another key rejects the rows too
+	other, err := OpenWithMacKey(dbPath, randomBytes(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	inv, err = db.FindFirstUnusedInvoice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SaveInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.FindByPaymentHash(legacyHash); err != ErrInvalidMac {
+		t.Fatalf("expected ErrInvalidMac, got %v", err)
+	}
+}
+
+func TestInvalidKeyPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := Open(path.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.CreateInvoice(&Invoice{
+		Preimage:    randomBytes(32),
+		PaymentHash: randomBytes(32),
+		KeyPath:     "m/schema:1'/recovery:1'/invoice:4/34/56",
+		State:       InvoiceStateRegistered,
+	})
+	if !errors.Is(err, ErrInvalidKeyPath) {
+		t.Fatalf("expected ErrInvalidKeyPath, got %v", err)
+	}
+
+	err = db.CreateInvoice(&Invoice{
+		Preimage:    randomBytes(32),
+		PaymentHash: randomBytes(32),
+		State:       InvoiceStateRegistered,
+	})
+	if !errors.Is(err, ErrInvalidKeyPath) {
+		t.Fatalf("expected ErrInvalidKeyPath for an empty key path, got %v", err)
+	}
+
+	paymentHash := randomBytes(32)
+	err = db.CreateInvoice(&Invoice{
+		Preimage:    randomBytes(32),
+		PaymentHash: paymentHash,
+		KeyPath:     "m/schema:1'/recovery:1'/invoices:4/34/56",
+		State:       InvoiceStateRegistered,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rows written outside of libwallet are rejected when read
+	res := db.db.Model(&Invoice{}).Where("payment_hash = ?", paymentHash).Update("key_path", "m/schema:1'/recovery:1'/invoices:5/34/56")
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if _, err := db.FindByPaymentHash(paymentHash); !errors.Is(err, ErrInvalidKeyPath) {
+		t.Fatalf("expected ErrInvalidKeyPath, got %v", err)
+	}
+}
+
+func TestDuplicatePaymentHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := Open(path.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	paymentHash := randomBytes(32)
+	newInvoice := func() *Invoice {
+		return &Invoice{
+			Preimage:    randomBytes(32),
+			PaymentHash: paymentHash,
+			KeyPath:     "m/schema:1'/recovery:1'/invoices:4/34/56",
+			State:       InvoiceStateRegistered,
+		}
+	}
+
+	if err := db.CreateInvoice(newInvoice()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateInvoice(newInvoice()); err != ErrDuplicatePaymentHash {
+		t.Fatalf("expected ErrDuplicatePaymentHash, got %v", err)
+	}
+
+	count, err := db.CountUnusedInvoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 invoice, got %v", count)
+	}
+}
+
+func TestFindUnusedInvoiceOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libwallet")
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := Open(path.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var hashes [][]byte
+	for i := 0; i < 3; i++ {
+		hash := randomBytes(32)
+		hashes = append(hashes, hash)
+		err := db.CreateInvoice(&Invoice{
+			Preimage:    randomBytes(32),
+			PaymentHash: hash,
+			KeyPath:     "m/schema:1'/recovery:1'/invoices:4/34/56",
+			State:       InvoiceStateRegistered,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		order InvoiceOrder
+		want  []byte
+	}{
+		{InvoiceOrderFIFO, hashes[0]},
+		{InvoiceOrderLIFO, hashes[2]},
+	}
+	for _, tt := range tests {
+		inv, err := db.FindUnusedInvoice(tt.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(inv.PaymentHash, tt.want) {
+			t.Fatalf("unexpected invoice for order %v", tt.order)
+		}
+	}
+
+	inv, err := db.FindUnusedInvoice(InvoiceOrderRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv == nil || inv.State != InvoiceStateRegistered {
+		t.Fatal("expected a registered invoice")
+	}
+
+	if _, err := db.FindUnusedInvoice("sorted"); err == nil {
+		t.Fatal("expected error for unknown order")
+	}
+
+	for _, hash := range hashes {
+		inv, err := db.FindByPaymentHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inv.State = InvoiceStateUsed
+		if err := db.SaveInvoice(inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inv, err = db.FindUnusedInvoice(InvoiceOrderLIFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv != nil {
+		t.Fatal("expected no unused invoices")
+	}
+}
+
+func TestInvoiceTransitions(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	invoice := &Invoice{State: InvoiceStateRegistered}
+
+	for _, state := range []InvoiceState{InvoiceStateUsed, InvoiceStateUsed, InvoiceStateAccepted} {
+		if err := invoice.TransitionTo(state, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if invoice.SettledAt != nil {
+		t.Fatal("expected no settlement time before settling")
+	}
+
+	if err := invoice.TransitionTo(InvoiceStateSettled, now); err != nil {
+		t.Fatal(err)
+	}
+	settledAt := invoice.SettledAt
+	if settledAt == nil || !settledAt.Equal(now) || !invoice.State.IsPaid() {
+		t.Fatal("expected settled invoice to be paid and have a settlement time")
+	}
+	if err := invoice.TransitionTo(InvoiceStateSettled, now); err != nil || invoice.SettledAt != settledAt {
+		t.Fatalf("expected settling twice to keep the settlement time, got %v", err)
+	}
+
+	for _, state := range []InvoiceState{InvoiceStateUsed, InvoiceStateCancelled, InvoiceStateExpired} {
+		err := invoice.TransitionTo(state, now)
+		if !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("expected invalid transition from settled to %v, got %v", state, err)
+		}
+	}
+	if invoice.State != InvoiceStateSettled {
+		t.Fatalf("expected invalid transitions to keep the state, got %v", invoice.State)
+	}
+
+	pending := &Invoice{State: InvoiceStateRegistered}
+	for _, state := range []InvoiceState{InvoiceStatePendingUse, InvoiceStateRegistered, InvoiceStatePendingUse, InvoiceStateUsed} {
+		if err := pending.TransitionTo(state, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pending.TransitionTo(InvoiceStateRegistered, now); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected used invoices not to go back to registered, got %v", err)
+	}
+
+	imported := &Invoice{State: InvoiceStateImported}
+	if err := imported.TransitionTo(InvoiceStateRefunded, now); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected imported invoices not to change, got %v", err)
+	}
+}
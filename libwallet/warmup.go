@@ -0,0 +1,128 @@
+package libwallet
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/muun/libwallet/walletdb"
+)
+
+// maxCachedKeys bounds the identity keys kept in memory by the key cache.
+const maxCachedKeys = 2 * MaxUnusedSecretsPoolSize
+
+// identityKeyCache keeps the identity keys derived by WarmUp, so the first
+// invoice created after launch doesn't pay for the derivation. Keys are
+// cached by wallet context, and dropped when it changes, so the private keys
+// of a wallet don't outlive its use.
+var identityKeyCache = struct {
+	sync.Mutex
+	keys map[[32]byte]*btcec.PrivateKey
+}{keys: make(map[[32]byte]*btcec.PrivateKey)}
+
+// pubKeyCache keeps parsed route hint pubkeys.
+var pubKeyCache = struct {
+	sync.Mutex
+	keys map[string]*btcec.PublicKey
+}{keys: make(map[string]*btcec.PublicKey)}
+
+// WarmUp prepares the state used to create invoices, cutting the latency of
+// the first CreateInvoice call after the app is launched. It opens the db,
+// running any pending migrations unless they are deferred, derives the
+// identity keys of the unused invoice secrets and parses the route hints
+// pubkey. It's safe to skip it or call it more than once.
+func WarmUp(userKey *HDPrivateKey, routeHints *RouteHints) (err error) {
+	defer recordErrors("WarmUp", &err)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	invoices, err := db.ListInvoices()
+	if err != nil {
+		return fmt.Errorf("WarmUp: %w", err)
+	}
+
+	for _, invoice := range invoices {
+		if invoice.State != walletdb.InvoiceStateRegistered {
+			continue
+		}
+		identityKeyPath := invoiceIdentityKeyPath(&invoice)
+		if _, err := deriveIdentityKey(userKey, identityKeyPath.String()); err != nil {
+			return fmt.Errorf("WarmUp: %w", err)
+		}
+	}
+
+	if routeHints != nil {
+		nodeURI, err := ParseNodeURI(routeHints.Pubkey)
+		if err != nil {
+			return fmt.Errorf("WarmUp: can't parse route hint pubkey: %w", err)
+		}
+		if _, err := parseCachedPubKey(nodeURI.PublicKey); err != nil {
+			return fmt.Errorf("WarmUp: can't parse route hint pubkey: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deriveIdentityKey derives the private key at keyPath, reusing the result of
+// previous derivations.
+func deriveIdentityKey(userKey *HDPrivateKey, keyPath string) (*btcec.PrivateKey, error) {
+	cacheKey := sha256.Sum256([]byte(ActiveWalletContext() + "/" + userKey.String() + "/" + keyPath))
+
+	identityKeyCache.Lock()
+	key, ok := identityKeyCache.keys[cacheKey]
+	identityKeyCache.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	identityHDKey, err := userKey.DeriveTo(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err = identityHDKey.key.ECPrivKey()
+	if err != nil {
+		return nil, fmt.Errorf("can't obtain identity privkey: %w", err)
+	}
+
+	identityKeyCache.Lock()
+	if len(identityKeyCache.keys) >= maxCachedKeys {
+		identityKeyCache.keys = make(map[[32]byte]*btcec.PrivateKey)
+	}
+	identityKeyCache.keys[cacheKey] = key
+	identityKeyCache.Unlock()
+
+	return key, nil
+}
+
+// clearIdentityKeyCache drops every cached identity key.
+func clearIdentityKeyCache() {
+	identityKeyCache.Lock()
+	defer identityKeyCache.Unlock()
+
+	identityKeyCache.keys = make(map[[32]byte]*btcec.PrivateKey)
+}
+
+// parseCachedPubKey works like parsePubKey, reusing previously parsed keys.
+func parseCachedPubKey(s string) (*btcec.PublicKey, error) {
+	pubKeyCache.Lock()
+	defer pubKeyCache.Unlock()
+
+	if key, ok := pubKeyCache.keys[s]; ok {
+		return key, nil
+	}
+	key, err := parsePubKey(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(pubKeyCache.keys) >= maxCachedKeys {
+		pubKeyCache.keys = make(map[string]*btcec.PublicKey)
+	}
+	pubKeyCache.keys[s] = key
+	return key, nil
+}
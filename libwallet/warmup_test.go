@@ -0,0 +1,69 @@
+package libwallet
+
+import (
+	"testing"
+)
+
+func TestWarmUp(t *testing.T) {
+	setup()
+
+	network := Regtest()
+	userKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	userKey.Path = "m/schema:1'/recovery:1'"
+	muunKey, _ := NewHDPrivateKey(randomBytes(32), network)
+	muunKey.Path = "m/schema:1'/recovery:1'"
+
+	routeHints := &RouteHints{
+		Pubkey:                    "03c48d1ff96fa32e2776f71bba02102ffc2a1b91e2136586418607d32e762869fd",
+		FeeBaseMsat:               1000,
+		FeeProportionalMillionths: 1000,
+		CltvExpiryDelta:           8,
+	}
+
+	// warming up an empty wallet is fine
+	if err := WarmUp(userKey, routeHints); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := GenerateInvoiceSecrets(userKey.PublicKey(), muunKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PersistInvoiceSecrets(secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	clearIdentityKeyCache()
+
+	if err := WarmUp(userKey, routeHints); err != nil {
+		t.Fatal(err)
+	}
+
+	identityKeyCache.Lock()
+	cached := len(identityKeyCache.keys)
+	identityKeyCache.Unlock()
+	if cached != secrets.Length() {
+		t.Fatalf("expected %v cached keys, got %v", secrets.Length(), cached)
+	}
+
+	// switching wallets drops the keys of the previous one
+	ResetWalletContext()
+	identityKeyCache.Lock()
+	cached = len(identityKeyCache.keys)
+	identityKeyCache.Unlock()
+	if cached != 0 {
+		t.Fatalf("expected no cached keys after a wallet switch, got %v", cached)
+	}
+
+	invoice, err := CreateInvoice(network, userKey, routeHints, &InvoiceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseInvoice(invoice, network); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WarmUp(userKey, &RouteHints{Pubkey: "not a key"}); err == nil {
+		t.Fatal("expected error for invalid route hints")
+	}
+}
@@ -0,0 +1,151 @@
+package libwallet
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// Zero-conf risk levels, from safest to riskiest.
+const (
+	ZeroConfRiskLow    = "low"
+	ZeroConfRiskMedium = "medium"
+	ZeroConfRiskHigh   = "high"
+)
+
+// zeroConfFeeTarget is the confirmation target incoming tx fee rates are
+// compared against.
+const zeroConfFeeTarget = 6
+
+// ZeroConfInput is an output spent by an incoming tx.
+type ZeroConfInput struct {
+	AmountSat     int64
+	Confirmations int64 // 0 if the tx creating it is unconfirmed
+}
+
+// ZeroConfChainInfo is implemented by the apps to provide the chain and
+// mempool data needed to score unconfirmed incoming txs, since libwallet
+// doesn't follow the chain.
+type ZeroConfChainInfo interface {
+	// SpentOutput returns the output at the given index of the tx, spent by
+	// the incoming tx.
+	SpentOutput(txId string, index int64) (*ZeroConfInput, error)
+	// HasConflicts returns whether a tx spending the same outputs as the
+	// given one was seen.
+	HasConflicts(txId string) (bool, error)
+}
+
+// ZeroConfRisk is the risk of an unconfirmed incoming tx never confirming,
+// for the apps to decide when to show its funds as spendable.
+type ZeroConfRisk struct {
+	TxId              string
+	Score             int64  // from 0, the safest, to 100
+	Level             string // one of the ZeroConfRisk constants
+	FeeRate           float64
+	SignalsRbf        bool // can be replaced by a tx paying elsewhere
+	UnconfirmedInputs int64
+	ConflictSeen      bool
+}
+
+// ScoreIncomingTransaction returns the risk of the unconfirmed incoming tx,
+// based on its fee rate compared to the configured FeeEstimator estimate,
+// whether it signals replaceability, how many of its inputs are unconfirmed
+// and whether conflicting spends were seen. The fee rate isn't scored if no
+// estimator is configured.
+func ScoreIncomingTransaction(rawTx []byte, chain ZeroConfChainInfo) (_ *ZeroConfRisk, err error) {
+	defer recordErrors("ScoreIncomingTransaction", &err)
+
+	tx := wire.NewMsgTx(0)
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, fmt.Errorf("ScoreIncomingTransaction: failed to decode tx: %w", err)
+	}
+	risk := &ZeroConfRisk{TxId: tx.TxHash().String()}
+
+	var inputsSat int64
+	for _, in := range tx.TxIn {
+		if in.Sequence < wire.MaxTxInSequenceNum-1 {
+			risk.SignalsRbf = true
+		}
+
+		spent, err := chain.SpentOutput(in.PreviousOutPoint.Hash.String(), int64(in.PreviousOutPoint.Index))
+		if err != nil {
+			return nil, fmt.Errorf("ScoreIncomingTransaction: failed to get spent output: %w", err)
+		}
+		if spent == nil {
+			return nil, fmt.Errorf("ScoreIncomingTransaction: unknown spent output %v", in.PreviousOutPoint)
+		}
+		if spent.Confirmations == 0 {
+			risk.UnconfirmedInputs++
+		}
+		inputsSat += spent.AmountSat
+	}
+
+	var outputsSat int64
+	for _, out := range tx.TxOut {
+		outputsSat += out.Value
+	}
+	if inputsSat < outputsSat {
+		return nil, fmt.Errorf("ScoreIncomingTransaction: tx spends %v sats but pays %v sats", inputsSat, outputsSat)
+	}
+
+	weight := blockchain.GetTransactionWeight(btcutil.NewTx(tx))
+	vsize := (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor
+	risk.FeeRate = float64(inputsSat-outputsSat) / float64(vsize)
+
+	risk.ConflictSeen, err = chain.HasConflicts(risk.TxId)
+	if err != nil {
+		return nil, fmt.Errorf("ScoreIncomingTransaction: failed to check conflicts: %w", err)
+	}
+
+	risk.Score = risk.score(estimateZeroConfFeeRate())
+	switch {
+	case risk.Score < 30:
+		risk.Level = ZeroConfRiskLow
+	case risk.Score < 60:
+		risk.Level = ZeroConfRiskMedium
+	default:
+		risk.Level = ZeroConfRiskHigh
+	}
+	return risk, nil
+}
+
+// score adds up the risk of each factor. A conflicting spend means the tx is
+// being double spent, so it's the riskiest.
+func (r *ZeroConfRisk) score(estimatedFeeRate float64) int64 {
+	if r.ConflictSeen {
+		return 100
+	}
+
+	var score int64
+	if r.SignalsRbf {
+		score += 30
+	}
+	if r.UnconfirmedInputs > 0 {
+		score += 20
+	}
+	if estimatedFeeRate > 0 {
+		switch {
+		case r.FeeRate < estimatedFeeRate/2:
+			score += 40
+		case r.FeeRate < estimatedFeeRate:
+			score += 20
+		}
+	}
+	return score
+}
+
+// estimateZeroConfFeeRate returns the fee rate estimate of the configured
+// FeeEstimator, or 0 if there's none or it fails.
+func estimateZeroConfFeeRate() float64 {
+	if cfg.FeeEstimator == nil {
+		return 0
+	}
+	feeRate, err := cfg.FeeEstimator.EstimateFeeRate(zeroConfFeeTarget)
+	if err != nil {
+		return 0
+	}
+	return feeRate
+}
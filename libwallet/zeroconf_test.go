@@ -0,0 +1,90 @@
+package libwallet
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+type fakeZeroConfChain struct {
+	inputs    map[wire.OutPoint]*ZeroConfInput
+	conflicts bool
+}
+
+func (c *fakeZeroConfChain) SpentOutput(txId string, index int64) (*ZeroConfInput, error) {
+	hash, err := chainhash.NewHashFromStr(txId)
+	if err != nil {
+		return nil, err
+	}
+	return c.inputs[wire.OutPoint{Hash: *hash, Index: uint32(index)}], nil
+}
+
+func (c *fakeZeroConfChain) HasConflicts(txId string) (bool, error) {
+	return c.conflicts, nil
+}
+
+func TestScoreIncomingTransaction(t *testing.T) {
+	setup()
+	defer setup()
+
+	outPoint := wire.OutPoint{Hash: chainhash.HashH(randomBytes(32)), Index: 1}
+	pkScript, _ := addressToScript("bcrt1qhv0a0uhrt2crdehgfge8e8e6texw3q4has8jh7", network)
+
+	newTx := func(sequence uint32) []byte {
+		tx := wire.NewMsgTx(2)
+		tx.AddTxIn(wire.NewTxIn(&outPoint, nil, nil))
+		tx.TxIn[0].Sequence = sequence
+		tx.AddTxOut(wire.NewTxOut(100000, pkScript))
+		return serializeTx(tx)
+	}
+
+	tests := []struct {
+		desc          string
+		sequence      uint32
+		confirmations int64
+		fee           int64
+		conflicts     bool
+		estimator     FeeEstimator
+		level         string
+	}{
+		{"final", wire.MaxTxInSequenceNum, 6, 10000, false, nil, ZeroConfRiskLow},
+		{"rbf", wire.MaxTxInSequenceNum - 2, 6, 10000, false, nil, ZeroConfRiskMedium},
+		{"unconfirmed parent", wire.MaxTxInSequenceNum, 0, 10000, false, nil, ZeroConfRiskLow},
+		{"low fee", wire.MaxTxInSequenceNum, 6, 100, false, &fixedFeeEstimator{feeRate: 10}, ZeroConfRiskMedium},
+		{"rbf with low fee", wire.MaxTxInSequenceNum - 2, 0, 100, false, &fixedFeeEstimator{feeRate: 10}, ZeroConfRiskHigh},
+		{"enough fee", wire.MaxTxInSequenceNum, 6, 10000, false, &fixedFeeEstimator{feeRate: 10}, ZeroConfRiskLow},
+		{"conflicted", wire.MaxTxInSequenceNum, 6, 10000, true, nil, ZeroConfRiskHigh},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg.FeeEstimator = tt.estimator
+			chain := &fakeZeroConfChain{
+				inputs: map[wire.OutPoint]*ZeroConfInput{
+					outPoint: {AmountSat: 100000 + tt.fee, Confirmations: tt.confirmations},
+				},
+				conflicts: tt.conflicts,
+			}
+
+			risk, err := ScoreIncomingTransaction(newTx(tt.sequence), chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if risk.Level != tt.level {
+				t.Fatalf("expected %v risk, got %v with score %v", tt.level, risk.Level, risk.Score)
+			}
+			if risk.FeeRate <= 0 || risk.SignalsRbf != (tt.sequence < wire.MaxTxInSequenceNum-1) {
+				t.Fatalf("unexpected fee rate %v or rbf signaling %v", risk.FeeRate, risk.SignalsRbf)
+			}
+		})
+	}
+
+	t.Run("overspend", func(t *testing.T) {
+		chain := &fakeZeroConfChain{
+			inputs: map[wire.OutPoint]*ZeroConfInput{outPoint: {AmountSat: 1000, Confirmations: 6}},
+		}
+		if _, err := ScoreIncomingTransaction(newTx(wire.MaxTxInSequenceNum), chain); err == nil {
+			t.Fatal("expected tx paying more than it spends to fail")
+		}
+	})
+}
@@ -20,18 +20,23 @@ mkdir -p "$(dirname $libwallet)"
 mkdir -p "$build_dir/android"
 mkdir -p "$build_dir/pkg"
 
+# Stamp the build info, including the flags the build is run with
+source "$repo_root/tools/libwallet-stamp.sh"
+build_flags="-target=android -trimpath"
+stamp=$(libwallet_stamp "$build_flags")
+
 # Line by line explanation
 # 1. Use a shared dependency cache between iOS and Android by setting GOMODCACHE
 # 2. Run gomobile bind using the version pinned by the go.mod file
-# 3. Set output flags
+# 3. Set the target, opt in to reproducible builds and set output flags
 # 4. Use a fixed build cache location
-# 5. Opt in to reproducible builds
+# 5. Stamp the build info
 
 GOMODCACHE="$build_dir/pkg" \
     go run golang.org/x/mobile/cmd/gomobile bind \
-    -target="android" -o "$libwallet" \
+    $build_flags -o "$libwallet" \
     -cache "$build_dir/android" \
-    -trimpath -ldflags="-buildid=. -v" \
+    -ldflags="-buildid=. -v $stamp" \
     .
 
 st=$?
@@ -0,0 +1,37 @@
+#!/bin/bash
+
+set -e
+
+repo_root=$(git rev-parse --show-toplevel)
+build_dir="$repo_root/libwallet/.build"
+
+# The binaries are written to the given folder, or to the build folder
+out_dir="$1"
+if [[ -z "$1" ]]; then
+    out_dir="$build_dir/desktop"
+fi
+mkdir -p "$out_dir"
+out_dir=$(cd "$out_dir" && pwd)
+
+mkdir -p "$build_dir/pkg"
+
+# Stamp the build info, including the flags the build is run with
+source "$repo_root/tools/libwallet-stamp.sh"
+build_flags="-trimpath"
+stamp=$(libwallet_stamp "$build_flags")
+
+# Build each command from its own module, since the grpc server has a
+# separate go.mod. The dependency cache is shared with the mobile builds.
+build() {
+    local module="$1"
+    local cmd="$2"
+
+    (cd "$repo_root/libwallet/$module" && GOMODCACHE="$build_dir/pkg" \
+        go build $build_flags -ldflags="-buildid= $stamp" \
+        -o "$out_dir/$(basename $cmd)" "./$cmd")
+}
+
+build . cmd/apollo-recover
+build grpcserver cmd/libwallet-grpc
+
+echo "built apollo-recover and libwallet-grpc to $out_dir"
@@ -0,0 +1,44 @@
+#!/bin/bash
+
+set -e
+
+repo_root=$(git rev-parse --show-toplevel)
+build_dir="$repo_root/libwallet/.build"
+
+# The iOS app lives in another repo, so we receive the framework path as param
+libwallet="$1"
+if [[ -z "$1" ]]; then
+    libwallet="$build_dir/ios/Libwallet.framework"
+fi
+
+
+cd "$repo_root/libwallet"
+
+mkdir -p "$(dirname $libwallet)"
+
+# Create the cache folders
+mkdir -p "$build_dir/ios"
+mkdir -p "$build_dir/pkg"
+
+# Stamp the build info, including the flags the build is run with
+source "$repo_root/tools/libwallet-stamp.sh"
+build_flags="-target=ios -trimpath"
+stamp=$(libwallet_stamp "$build_flags")
+
+# Line by line explanation
+# 1. Use a shared dependency cache between iOS and Android by setting GOMODCACHE
+# 2. Run gomobile bind using the version pinned by the go.mod file
+# 3. Set the target, opt in to reproducible builds and set output flags
+# 4. Use a fixed build cache location
+# 5. Stamp the build info
+
+GOMODCACHE="$build_dir/pkg" \
+    go run golang.org/x/mobile/cmd/gomobile bind \
+    $build_flags -o "$libwallet" \
+    -cache "$build_dir/ios" \
+    -ldflags="-buildid=. -v $stamp" \
+    .
+
+st=$?
+echo "rebuilt gomobile with status $? to $libwallet"
+exit $st
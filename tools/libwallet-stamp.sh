@@ -0,0 +1,22 @@
+#!/bin/bash
+
+# Sourced by the libwallet build scripts to stamp the build info reported by
+# GetBuildInfo. Only values derived from the source tree and the build command
+# are used so the build stays reproducible.
+#
+# Usage: libwallet_stamp "<go build flags>"
+# Prints the -X linker flags to pass along with the given build flags.
+
+libwallet_stamp() {
+    local version commit
+    version=$(git describe --tags --always --dirty)
+    commit=$(git rev-parse HEAD)
+
+    # -X values can't contain spaces, so the build flags are joined by commas
+    local flags="$1"
+    flags="${flags// /,}"
+
+    echo "-X github.com/muun/libwallet.buildVersion=$version" \
+        "-X github.com/muun/libwallet.buildCommit=$commit" \
+        "-X github.com/muun/libwallet.buildFlags=$flags"
+}